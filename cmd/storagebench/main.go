@@ -0,0 +1,161 @@
+// storagebench compares the SQLite and segment storage engines on insert rate, size on disk and
+// query latency. Run it with: go run ./cmd/storagebench -rows 200000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	queryparser "github.com/lauritsbonde/LogLite/src/queryParser"
+)
+
+var benchQueries = []string{
+	`| limit 50`,
+	`level:ERROR | limit 50`,
+	`source:payments level:WARN | limit 50`,
+	`"order 4242" | limit 50`,
+	`needle-not-present | limit 50`,
+	`since:1h | count by level`,
+	`metadata.status>=500 | count`,
+}
+
+var (
+	levels  = []string{"INFO", "INFO", "INFO", "DEBUG", "WARN", "ERROR"}
+	sources = []string{"api", "payments", "auth", "worker", "scheduler"}
+	actions = []string{"created", "updated", "shipped", "cancelled", "refunded"}
+)
+
+// generate returns rows spread evenly over the given span, newest last
+func generate(n int, span time.Duration, now time.Time) []map[string]interface{} {
+	random := rand.New(rand.NewSource(1))
+	rows := make([]map[string]interface{}, n)
+	for i := range rows {
+		ts := now.Add(-span + time.Duration(i)*(span/time.Duration(n)))
+		message := fmt.Sprintf("order %d %s by user %d in %dms", random.Intn(100000), actions[random.Intn(len(actions))], random.Intn(5000), random.Intn(900))
+		rows[i] = map[string]interface{}{
+			"timestamp": ts.Format(dbhandler.TimestampLayout),
+			"level":     levels[random.Intn(len(levels))],
+			"source":    sources[random.Intn(len(sources))],
+			"message":   message,
+			"length":    len(message),
+			"metadata":  fmt.Sprintf(`{"status":%d,"trace_id":"%016x"}`, []int{200, 200, 201, 404, 500}[random.Intn(5)], random.Int63()),
+		}
+	}
+	return rows
+}
+
+// dirSize sums the size of every file below path
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func bench(name string, db dbhandler.DBHandler, path string, rows []map[string]interface{}, batch int) {
+	start := time.Now()
+	for i := 0; i < len(rows); i += batch {
+		end := min(i+batch, len(rows))
+		if err := dbhandler.PutBatch(db, "logs", rows[i:end]); err != nil {
+			log.Fatalf("%s: insert: %v", name, err)
+		}
+	}
+	insert := time.Since(start)
+
+	// Flush buffered rows so the size on disk is comparable
+	if err := db.Close(); err != nil {
+		log.Fatalf("%s: close: %v", name, err)
+	}
+	fmt.Printf("%s\n  insert: %v (%.0f rows/s)\n  size:   %.1f MB\n", name, insert.Round(time.Millisecond),
+		float64(len(rows))/insert.Seconds(), float64(dirSize(path))/1e6)
+}
+
+func measureQueries(name string, db dbhandler.DBHandler, now time.Time, repeat int) {
+	fmt.Printf("%s queries (median of %d)\n", name, repeat)
+	for _, input := range benchQueries {
+		query, err := queryparser.Compile(input, now)
+		if err != nil {
+			log.Fatalf("compile %q: %v", input, err)
+		}
+		durations := make([]time.Duration, repeat)
+		var results int
+		for i := range durations {
+			start := time.Now()
+			rows, err := db.Query(query)
+			durations[i] = time.Since(start)
+			if err != nil {
+				log.Fatalf("%s: %q: %v", name, input, err)
+			}
+			results = len(rows)
+		}
+		fmt.Printf("  %-40s %10v  %d rows\n", input, median(durations).Round(time.Microsecond), results)
+	}
+}
+
+func median(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+func main() {
+	count := flag.Int("rows", 200000, "Number of logs to insert")
+	span := flag.Duration("span", 7*24*time.Hour, "Time span the logs are spread over")
+	batch := flag.Int("batch", 1000, "Rows per insert batch")
+	repeat := flag.Int("repeat", 5, "Runs per query")
+	dir := flag.String("dir", "", "Directory for the databases (default a temporary directory)")
+	flag.Parse()
+
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", "storagebench")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		*dir = tmp
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	rows := generate(*count, *span, now)
+	fmt.Printf("%d rows over %v\n\n", *count, *span)
+
+	sqlitePath := filepath.Join(*dir, "bench.db")
+	sqlite, err := dbhandler.NewSQLiteHandler(sqlitePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bench("SQLite", sqlite, sqlitePath, rows, *batch)
+
+	segmentDir := filepath.Join(*dir, "segments")
+	segments, err := dbhandler.NewSegmentHandler(segmentDir, "hour")
+	if err != nil {
+		log.Fatal(err)
+	}
+	bench("Segment", segments, segmentDir, rows, *batch)
+	fmt.Println()
+
+	// Reopen both so queries read from disk rather than from buffers
+	if sqlite, err = dbhandler.NewSQLiteHandler(sqlitePath); err != nil {
+		log.Fatal(err)
+	}
+	defer sqlite.Close()
+	measureQueries("SQLite", sqlite, now, *repeat)
+	if segments, err = dbhandler.NewSegmentHandler(segmentDir, "hour"); err != nil {
+		log.Fatal(err)
+	}
+	defer segments.Close()
+	measureQueries("Segment", segments, now, *repeat)
+}
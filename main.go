@@ -1,27 +1,54 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/lauritsbonde/LogLite/src/appmanager"
 	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
 	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
 	demodata "github.com/lauritsbonde/LogLite/src/demoIngestor"
 	"github.com/lauritsbonde/LogLite/src/ingestor"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
 	webapp "github.com/lauritsbonde/LogLite/src/webApp"
 )
 
 var loadedConfig confighandler.Config
+var configPath string // Where the configuration is loaded from and saved to
+
+// drainTimeout is how long applying a configuration waits for the requests on the previous
+// database before closing it
+const drainTimeout = 30 * time.Second
 
 func init(){
 	// Command-line flag for config file
 	configPathFlag := flag.String("config", "./etc/config.yaml", "Path to the configuration file")
+	migrateDryRunFlag := flag.Bool("migrate-dry-run", false, "Print the database migrations that would run and exit")
+	restoreFlag := flag.String("restore", "", "Replace the SQLite database with this backup and exit, LogLite must not be running")
+	rebuildRollupsFlag := flag.Bool("rebuild-rollups", false, "Recount the SQLite rollups from the stored logs and exit, safe while LogLite runs")
+	generateKeyFlag := flag.String("generate-key", "", "Print a new encryption key with this id and exit")
+	rekeyFlag := flag.Bool("rekey", false, "Encrypt the stored logs with the first encryption key and exit, safe while LogLite runs")
 	flag.Parse()
 
+	if *generateKeyFlag != "" {
+		key, err := dbhandler.GenerateKey(*generateKeyFlag)
+		if err != nil {
+			log.Fatalf("Error generating key: %v\n", err)
+		}
+		fmt.Println(key)
+		os.Exit(0)
+	}
+
 	configpath := *configPathFlag
+	configPath = configpath
 
 	if len(configpath) == 0 {
 		println("No config provided")
@@ -42,72 +69,275 @@ func init(){
 		log.Fatalf("Invalid configuration: %v\n", err)
 	}
 
+	if *migrateDryRunFlag {
+		printMigrationPlan(&config)
+		os.Exit(0)
+	}
+
+	if *restoreFlag != "" {
+		restoreBackup(&config, *restoreFlag)
+		os.Exit(0)
+	}
+
+	if *rebuildRollupsFlag {
+		start := time.Now()
+		days, err := dbhandler.RebuildRollups(&config)
+		if err != nil {
+			log.Fatalf("Error rebuilding rollups: %v\n", err)
+		}
+		fmt.Printf("Rebuilt the rollups of %d days in %s\n", days, time.Since(start).Round(time.Millisecond))
+		os.Exit(0)
+	}
+
+	if *rekeyFlag {
+		rekey(&config)
+		os.Exit(0)
+	}
+
 	loadedConfig = config
 }
 
-func messageHandler(webApp *webapp.WebApp, appManager *appmanager.AppManager, ingestorReady chan ingestor.Ingestor) {
+// rekey re-encrypts the stored logs that are plain or use an older key with the primary key
+func rekey(config *confighandler.Config) {
+	if !config.Database.Encryption.Enabled() {
+		log.Fatalf("Encryption is not configured\n")
+	}
+	db, err := dbhandler.NewDBHandler(config)
+	if err != nil {
+		log.Fatalf("Error opening database: %v\n", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	changed, err := db.(*dbhandler.EncryptedHandler).Rekey(context.Background())
+	if err != nil {
+		log.Fatalf("Error re-encrypting logs after %d were done: %v\n", changed, err)
+	}
+	fmt.Printf("Re-encrypted %d logs in %s\n", changed, time.Since(start).Round(time.Millisecond))
+}
+
+// printMigrationPlan lists the migrations the database still needs without applying them
+func printMigrationPlan(config *confighandler.Config) {
+	statuses, err := dbhandler.PlanMigrations(config)
+	for _, status := range statuses {
+		fmt.Printf("%s: schema version %d of %d\n", status.Database, status.Current, status.Latest)
+		for _, m := range status.Pending {
+			fmt.Printf("  would apply %d: %s\n", m.Version, m.Description)
+			for _, statement := range m.Statements {
+				fmt.Printf("    %s\n", strings.Join(strings.Fields(statement), " "))
+			}
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error checking migrations: %v\n", err)
+	}
+	if len(statuses) == 0 {
+		fmt.Printf("%s storage has no schema to migrate\n", config.Database.Type)
+	}
+}
+
+// restoreBackup validates a backup and swaps it in for the configured SQLite database
+func restoreBackup(config *confighandler.Config, snapshot string) {
+	if config.Database.Type != "SQLite" {
+		log.Fatalf("Restoring is only supported with the SQLite database type\n")
+	}
+	previous, err := dbhandler.RestoreSQLite(context.Background(), snapshot, config.Database.SQLiteFilepath)
+	if err != nil {
+		log.Fatalf("Error restoring backup: %v\n", err)
+	}
+	fmt.Printf("Restored %s to %s\n", snapshot, config.Database.SQLiteFilepath)
+	if previous != "" {
+		fmt.Printf("The previous database was kept as %s\n", previous)
+	}
+}
+
+// messageHandler applies the configurations sent by the web app, one at a time
+func messageHandler(webApp *webapp.WebApp, appManager *appmanager.AppManager, ingestorReady chan ingestor.Ingestor, tail *livetail.Broadcaster) {
 	for msg := range webApp.SettingsChan {
 		log.Println("Main thread: Received new configuration")
+		if err := applyConfig(webApp, appManager, ingestorReady, tail, msg.NewConfig); err != nil {
+			log.Printf("Error applying configuration: %v\n", err)
+			msg.ResponseCh <- fmt.Sprintf("Error %v", err)
+			continue
+		}
+
+		// Respond to the sender
+		msg.ResponseCh <- "Configuration applied successfully"
+		println("Configuration applied successfully")
+	}
+}
+
+// applyConfig replaces the running setup with a new configuration. Everything is created
+// before anything is stopped, and the database and ingestor are only replaced when their
+// settings changed, so a failure leaves the previous setup running.
+func applyConfig(webApp *webapp.WebApp, appManager *appmanager.AppManager, ingestorReady chan ingestor.Ingestor, tail *livetail.Broadcaster, config *confighandler.Config) error {
+	appManager.Lock()
+	defer appManager.Unlock()
+
+	if err := confighandler.ValidateConfig(*config); err != nil {
+		return fmt.Errorf("validating the configuration: %w", err)
+	}
+	if config.LogHandler.Mode == "scrape" {
+		return fmt.Errorf("scrape ingestor not implemented")
+	}
+
+	// Retention and backups run beside the database, changing them does not reopen it
+	storageChanged, handlerChanged := false, false
+	for _, change := range confighandler.Diff(*webApp.State().Configuration, *config) {
+		switch {
+		case strings.HasPrefix(change.Key, "database.retention."), strings.HasPrefix(change.Key, "database.backup."):
+		case strings.HasPrefix(change.Key, "database."):
+			storageChanged = true
+		case strings.HasPrefix(change.Key, "log_handler."):
+			handlerChanged = true
+		}
+	}
+
+	// Apply the appropriate DBHandler
+	dbHandler := appManager.DBHandler
+	newDB := dbHandler == nil || storageChanged
+	if newDB {
 		var err error
+		dbHandler, err = dbhandler.NewDBHandler(config)
+		if err != nil {
+			return fmt.Errorf("initializing DBHandler: %w", err)
+		}
+		dbHandler = livetail.NewPublishingHandler(dbHandler, tail)
+	}
+	discard := func() {
+		if newDB {
+			dbHandler.Close()
+		}
+	}
 
-		// Validate the configuration
-		err = confighandler.ValidateConfig(*msg.NewConfig)
+	// Apply the appropriate Ingestor using the NewIngestor function
+	ing := appManager.Ingestor
+	newIngestor := ing == nil || newDB || handlerChanged
+	if newIngestor {
+		var err error
+		ing, err = ingestor.NewIngestor(config, dbHandler)
 		if err != nil {
-			log.Printf("main thread could not validate the configuration: %v\n", err)
-			msg.ResponseCh <- fmt.Sprintf("Validation error: %v", err)
-			continue
+			discard()
+			return fmt.Errorf("initializing Ingestor: %w", err)
 		}
+	}
 
-		// Apply the appropriate DBHandler
-		var dbHandler dbhandler.DBHandler
-		switch msg.NewConfig.Database.Type {
-		case "SQLite":
-			dbHandler, err = dbhandler.NewSQLiteHandler(msg.NewConfig.Database.SQLiteFilepath)
-			if err != nil {
-				log.Printf("Error initializing SQLite handler: %v\n", err)
-				msg.ResponseCh <- fmt.Sprintf("Error initializing SQLite handler: %v", err)
-				continue
+	retention, err := dbhandler.NewRetentionManager(dbHandler, config.Database.Retention)
+	if err != nil {
+		discard()
+		return fmt.Errorf("starting retention: %w", err)
+	}
+	backups, err := dbhandler.NewBackupManager(dbHandler, config.Database.Backup)
+	if err != nil {
+		discard()
+		return fmt.Errorf("starting backups: %w", err)
+	}
+
+	// A new ingestor replaces the running one, which may listen on the same port
+	if newIngestor {
+		previous := appManager.Ingestor
+		if previous != nil {
+			if err := previous.Stop(); err != nil {
+				log.Printf("Error stopping ingestor: %v\n", err)
 			}
-		default:
-			msg.ResponseCh <- "Error: Unsupported database type"
-			continue
 		}
-
-		// Apply the appropriate Ingestor using the NewIngestor function
-		var ing ingestor.Ingestor
-		switch msg.NewConfig.LogHandler.Mode {
-		case "send":
-			ing, err = ingestor.NewIngestor(msg.NewConfig, dbHandler)
-			if err != nil {
-				println("Error initializing Ingestor")
-				msg.ResponseCh <- fmt.Sprintf("Error initializing Ingestor: %v", err)
-				continue
+		if err := ing.Start(); err != nil {
+			if previous != nil {
+				if err := previous.Start(); err != nil {
+					log.Printf("Error restarting the previous ingestor: %v\n", err)
+				}
 			}
-		case "scrape":
-			// Handle scrape-specific cases (if required)
-			println("Scrape Ingestor not implemented")
-			msg.ResponseCh <- "Scrape Ingestor not implemented"
-			continue
-		default:
-			println("Error: Unsupported collect type")
-			msg.ResponseCh <- "Error: Unsupported collect type"
-			continue
+			discard()
+			return fmt.Errorf("starting ingestor: %w", err)
 		}
+	}
 
-		// Dynamically bind the DBHandler and Ingestor to the AppManager
-		appManager.DBHandler = dbHandler
-		appManager.Ingestor = ing
+	// Replace the pruner and backups of the previous database
+	if appManager.Retention != nil {
+		appManager.Retention.Stop()
+	}
+	if appManager.Backups != nil {
+		appManager.Backups.Stop()
+	}
+	if retention != nil {
+		retention.Start()
+	}
+	if backups != nil {
+		backups.Start()
+	}
+
+	previousDB := appManager.DBHandler
+	firstIngestor := appManager.Ingestor == nil
 
+	// Dynamically bind the DBHandler and Ingestor to the AppManager
+	appManager.DBHandler = dbHandler
+	appManager.Ingestor = ing
+	appManager.Retention = retention
+	appManager.Backups = backups
+
+	if firstIngestor {
 		ingestorReady <- ing
+	}
 
-		// Update the WebApp configuration
-		webApp.Configuration = msg.NewConfig
-		webApp.DBHandler = dbHandler
+	// The web app switches over, its live streams on the previous database reconnect to the new one
+	_, drained := webApp.SetState(webapp.State{Configuration: config, DBHandler: dbHandler, Retention: retention, Backups: backups}, drainTimeout)
 
-		// Respond to the sender
-		msg.ResponseCh <- "Configuration applied successfully"
-		println("Configuration applied successfully")
+	if newDB && previousDB != nil {
+		if !drained {
+			log.Printf("Closing the previous database while requests still use it, they fail\n")
+		}
+		if err := previousDB.Close(); err != nil {
+			log.Printf("Error closing the previous database: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// startRetention runs the background pruner for handlers that support it
+func startRetention(config *confighandler.Config, dbHandler dbhandler.DBHandler) (*dbhandler.RetentionManager, error) {
+	retention, err := dbhandler.NewRetentionManager(dbHandler, config.Database.Retention)
+	if err != nil {
+		return nil, err
+	}
+	if retention != nil {
+		retention.Start()
 	}
+	return retention, nil
+}
+
+// startBackups schedules snapshots for handlers that support them
+func startBackups(config *confighandler.Config, dbHandler dbhandler.DBHandler) (*dbhandler.BackupManager, error) {
+	backups, err := dbhandler.NewBackupManager(dbHandler, config.Database.Backup)
+	if err != nil {
+		return nil, err
+	}
+	if backups != nil {
+		backups.Start()
+	}
+	return backups, nil
+}
+
+// closeOnSignal closes the database on Ctrl+C or SIGTERM so handlers can flush their state,
+// e.g. the in-memory handler writes its snapshot
+func closeOnSignal(appManager *appmanager.AppManager) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Println("Shutting down")
+	appManager.Lock()
+	if appManager.Retention != nil {
+		appManager.Retention.Stop()
+	}
+	if appManager.Backups != nil {
+		appManager.Backups.Stop()
+	}
+	if appManager.DBHandler != nil {
+		if err := appManager.DBHandler.Close(); err != nil {
+			log.Printf("Error closing database: %v\n", err)
+		}
+	}
+	os.Exit(0)
 }
 
 func main() {
@@ -117,6 +347,7 @@ func main() {
 	confighandler.PrintConfigTable(loadedConfig)
 
 	appManager := appmanager.NewAppManager()
+	go closeOnSignal(appManager)
 
 	// ingestor ready channel
 	ingestorReady := make(chan ingestor.Ingestor)
@@ -124,38 +355,55 @@ func main() {
 	// var dbHandler dbhandler.DBHandler
 	log.Printf("version: %d\n", len(loadedConfig.Version))
 
-	go func() {
-		defer wg.Done()
-
-		ing := <- ingestorReady
-		println("Starting ingestor")
-		if err := ing.Start(); err != nil {
-			log.Fatalf("Error starting ingestor: %v", err)
-		} else {
-			log.Println("Ingestor started")
-			demodata.IngestDemoData(appManager.DBHandler, 10)
-		}
-	}()
+	// Every stored log is pushed to the live table through the broadcaster
+	tail := livetail.NewBroadcaster()
 
 	// adding the webapp
 	wg.Add(1)
 	webApp := &webapp.WebApp{
-		DBHandler: nil,
+		Tail: tail,
 		SettingsChan: make(chan webapp.ConfigMessage, 1),
-		Configuration: &confighandler.Config{},
+		ConfigPath: configPath,
 	}
 
-	if len(loadedConfig.Version) == 0 {
-		go messageHandler(webApp, appManager, ingestorReady)
-	} else {
-		webApp.Configuration = &loadedConfig
+	// Demo data flows once the first ingestor runs
+	go func() {
+		defer wg.Done()
+
+		<- ingestorReady
+		log.Println("Ingestor started")
+		demodata.IngestDemoData(func(table string, data map[string]interface{}) error {
+			state, release := webApp.Acquire()
+			defer release()
+			if state.DBHandler == nil {
+				return fmt.Errorf("no database configured")
+			}
+			return state.DBHandler.Put(table, data)
+		}, 10)
+	}()
+
+	// Configurations from the setup and settings pages are applied while LogLite runs
+	go messageHandler(webApp, appManager, ingestorReady, tail)
+
+	if len(loadedConfig.Version) != 0 {
 		// Apply the appropriate DBHandler
 		dbhandler, err := dbhandler.NewDBHandler(&loadedConfig)
 		if err != nil {
 			log.Fatalf("Error initializing DBHandler: %v\n", err)
 		}
+		dbhandler = livetail.NewPublishingHandler(dbhandler, tail)
 
-		webApp.DBHandler = dbhandler
+		retention, err := startRetention(&loadedConfig, dbhandler)
+		if err != nil {
+			log.Fatalf("Error starting retention: %v\n", err)
+		}
+		appManager.Retention = retention
+
+		backups, err := startBackups(&loadedConfig, dbhandler)
+		if err != nil {
+			log.Fatalf("Error starting backups: %v\n", err)
+		}
+		appManager.Backups = backups
 
 		// Apply the appropriate Ingestor using the NewIngestor function
 		ingestor, err := ingestor.NewIngestor(&loadedConfig, dbhandler)
@@ -163,9 +411,15 @@ func main() {
 			log.Fatalf("Error initializing Ingestor: %v\n", err)
 		}
 
+		println("Starting ingestor")
+		if err := ingestor.Start(); err != nil {
+			log.Fatalf("Error starting ingestor: %v", err)
+		}
+
 		// Dynamically bind the DBHandler and Ingestor to the AppManager
 		appManager.DBHandler = dbhandler
 		appManager.Ingestor = ingestor
+		webApp.SetState(webapp.State{Configuration: &loadedConfig, DBHandler: dbhandler, Retention: retention, Backups: backups}, 0)
 
 		ingestorReady <- ingestor
 	}
@@ -173,7 +427,7 @@ func main() {
 	go func() {
 		defer wg.Done()
 		if err := webApp.RunWebApp(); err != nil {
-			fmt.Printf("Error starting web server: %v\n", err)
+			log.Fatalf("Error starting web server: %v", err)
 		}
 	}()
 
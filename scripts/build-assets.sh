@@ -0,0 +1,30 @@
+#!/usr/bin/env sh
+# Fetches htmx and its WebSocket extension and builds the Tailwind and daisyUI stylesheet into
+# src/webApp/assets/vendor, which is embedded into the binary. Needs network access and npm,
+# commit the results so LogLite builds and runs offline.
+# Usage: scripts/build-assets.sh
+set -e
+
+HTMX_VERSION="2.0.4"
+HTMX_WS_VERSION="2.0.1"
+TAILWIND_VERSION="3.4.17"
+DAISYUI_VERSION="4.12.23"
+
+ROOT="$(cd "$(dirname "$0")/.." && pwd)"
+ASSETS="$ROOT/src/webApp/assets"
+VENDOR="$ASSETS/vendor"
+mkdir -p "$VENDOR"
+
+curl -fsSL -o "$VENDOR/htmx.min.js" "https://unpkg.com/htmx.org@$HTMX_VERSION/dist/htmx.min.js"
+curl -fsSL -o "$VENDOR/ws.js" "https://unpkg.com/htmx-ext-ws@$HTMX_WS_VERSION/ws.js"
+
+# Tailwind and daisyUI are installed into a throwaway directory, the config is copied next to
+# them so it finds the plugin. Content paths are relative to the repository root.
+BUILD="$(mktemp -d)"
+trap 'rm -rf "$BUILD"' EXIT
+npm install --silent --no-save --prefix "$BUILD" "tailwindcss@$TAILWIND_VERSION" "daisyui@$DAISYUI_VERSION"
+cp "$ASSETS/tailwind.config.js" "$BUILD/"
+cd "$ROOT"
+"$BUILD/node_modules/.bin/tailwindcss" -c "$BUILD/tailwind.config.js" -i "$ASSETS/tailwind.css" -o "$VENDOR/app.css" --minify
+
+ls -l "$VENDOR"
@@ -0,0 +1,29 @@
+#!/usr/bin/env sh
+# Starts or stops a throwaway PostgreSQL server for trying out and testing the PostgreSQL backend.
+# Usage: scripts/postgres-local.sh start|stop
+set -e
+
+DATA_DIR="${PGDATA_DIR:-./db/postgres}"
+PORT="${PGPORT:-54329}"
+USER_NAME="loglite"
+DB_NAME="loglite"
+
+case "$1" in
+  start)
+    if [ ! -f "$DATA_DIR/PG_VERSION" ]; then
+      mkdir -p "$DATA_DIR"
+      initdb -D "$DATA_DIR" -U "$USER_NAME" --auth=trust > /dev/null
+    fi
+    pg_ctl -D "$DATA_DIR" -o "-p $PORT -k /tmp -c listen_addresses=localhost" -l "$DATA_DIR/server.log" -w start
+    psql -h localhost -p "$PORT" -U "$USER_NAME" -d postgres -tAc "SELECT 1 FROM pg_database WHERE datname = '$DB_NAME'" | grep -q 1 \
+      || createdb -h localhost -p "$PORT" -U "$USER_NAME" "$DB_NAME"
+    echo "postgres_url: 'postgres://$USER_NAME@localhost:$PORT/$DB_NAME'"
+    ;;
+  stop)
+    pg_ctl -D "$DATA_DIR" -w stop
+    ;;
+  *)
+    echo "usage: $0 start|stop" >&2
+    exit 1
+    ;;
+esac
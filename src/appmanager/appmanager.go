@@ -1,13 +1,20 @@
 package appmanager
 
 import (
+	"sync"
+
 	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
 	"github.com/lauritsbonde/LogLite/src/ingestor"
 )
 
+// AppManager holds what runs for the current configuration. Lock it to read or replace the
+// fields while LogLite runs, applying a configuration holds it until it is done.
 type AppManager struct {
+	sync.Mutex
 	DBHandler dbhandler.DBHandler
 	Ingestor  ingestor.Ingestor
+	Retention *dbhandler.RetentionManager
+	Backups   *dbhandler.BackupManager
 }
 
 func NewAppManager() *AppManager {
@@ -29,4 +36,18 @@ func BindIngestor(v ingestor.Ingestor) Option {
     return func(a *AppManager) {
         a.Ingestor = v
     }
-}
\ No newline at end of file
+}
+
+// BindRetention is a self-referential function that injects a RetentionManager into AppManager
+func BindRetention(v *dbhandler.RetentionManager) Option {
+    return func(a *AppManager) {
+        a.Retention = v
+    }
+}
+
+// BindBackups is a self-referential function that injects a BackupManager into AppManager
+func BindBackups(v *dbhandler.BackupManager) Option {
+    return func(a *AppManager) {
+        a.Backups = v
+    }
+}
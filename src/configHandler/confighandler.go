@@ -2,8 +2,11 @@ package confighandler
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -34,8 +37,74 @@ type Scrape struct {
 }
 
 type Database struct {
-	Type           string `mapstructure:"type"`            // Currently only "SQLite"
-	SQLiteFilepath string `mapstructure:"sqlite_filepath"` // Required if Type is "SQLite"
+	Type             string     `mapstructure:"type"`               // "SQLite", "PartitionedSQLite", "PostgreSQL", "Memory" or "Segment"
+	SQLiteFilepath   string     `mapstructure:"sqlite_filepath"`    // Required if Type is "SQLite"
+	PartitionDir     string     `mapstructure:"partition_dir"`      // Required if Type is "PartitionedSQLite"
+	PartitionBy      string     `mapstructure:"partition_by"`       // "day" or "hour"
+	PostgresURL      string     `mapstructure:"postgres_url"`       // Required if Type is "PostgreSQL"
+	PostgresMaxConns int        `mapstructure:"postgres_max_conns"` // Size of the connection pool, 0 uses the driver default
+	MemoryCapacity   int        `mapstructure:"memory_capacity"`    // Logs kept if Type is "Memory", the oldest are dropped
+	MemorySnapshot   string     `mapstructure:"memory_snapshot"`    // File the in-memory logs are saved to on shutdown, empty disables it
+	SegmentDir       string     `mapstructure:"segment_dir"`        // Required if Type is "Segment"
+	SegmentWindow    string     `mapstructure:"segment_window"`     // "hour" or "day", the time span of a segment
+	PromotedFields   []string   `mapstructure:"promoted_fields"`    // Metadata fields to index, e.g. "trace_id" or "request.status"
+	Retention        Retention  `mapstructure:"retention"`          // When to prune old logs
+	Archive          Archive    `mapstructure:"archive"`            // When to move old logs out of SQLite
+	Backup           Backup     `mapstructure:"backup"`             // When to snapshot the SQLite database
+	Encryption       Encryption `mapstructure:"encryption"`         // Keys the message and metadata are encrypted with
+}
+
+// Encryption encrypts the message and metadata of logs before they are stored. Keys are
+// written as <id>:<base64 of 32 bytes>, the first one encrypts and all of them decrypt.
+type Encryption struct {
+	KeyFile string `mapstructure:"key_file"` // File with one key per line, empty when the keys come from KeyEnv
+	KeyEnv  string `mapstructure:"key_env"`  // Environment variable holding the keys separated by commas
+}
+
+// Enabled reports whether logs are encrypted
+func (e Encryption) Enabled() bool {
+	return e.KeyFile != "" || e.KeyEnv != ""
+}
+
+// Backup takes snapshots of the SQLite database on a schedule
+type Backup struct {
+	Dir      string `mapstructure:"dir"`      // Directory the snapshots are written to
+	Interval string `mapstructure:"interval"` // Duration such as "1d", empty disables scheduled backups
+	Keep     int    `mapstructure:"keep"`     // Number of snapshots kept, 0 keeps all of them
+}
+
+// Archive moves logs older than After from SQLite into compressed files that queries still read
+type Archive struct {
+	Dir      string `mapstructure:"dir"`      // Directory of the archive files and their manifest
+	After    string `mapstructure:"after"`    // Duration such as "30d", empty disables archiving
+	Interval string `mapstructure:"interval"` // How often old logs are moved
+}
+
+// Retention limits how much log data is kept. Empty values mean no limit.
+type Retention struct {
+	MaxAge    string          `mapstructure:"max_age"`    // Duration such as "30d" or "12h"
+	MaxRows   int64           `mapstructure:"max_rows"`   // Maximum number of rows in the logs table
+	MaxBytes  string          `mapstructure:"max_bytes"`  // Size such as "500MB" or "2GB"
+	Interval  string          `mapstructure:"interval"`   // How often the pruner runs
+	BatchSize int             `mapstructure:"batch_size"` // Rows deleted per statement
+	Rules     []RetentionRule `mapstructure:"rules"`      // Overrides of max_age per level and/or source
+}
+
+// RetentionRule keeps logs matching a level and/or source for a different duration. The first
+// rule that matches a log applies.
+type RetentionRule struct {
+	Level  string `mapstructure:"level"`
+	Source string `mapstructure:"source"`
+	MaxAge string `mapstructure:"max_age"`
+}
+
+// validMetadataPath matches promoted field paths such as "trace_id" or "metadata.request.status"
+var validMetadataPath = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// PromotedColumn is the name of the SQLite column a promoted metadata field is stored in, the
+// path with . and - as _, e.g. meta_request_status for "request.status" or "metadata.request-status"
+func PromotedColumn(field string) string {
+	return "meta_" + strings.NewReplacer(".", "_", "-", "_").Replace(strings.TrimPrefix(field, "metadata."))
 }
 
 // LoadConfig loads the configuration from a file and applies defaults
@@ -51,18 +120,7 @@ func LoadConfig(configPath string) (Config, error) {
 	viper.SetConfigType("yaml")     // Specify file type
 
 	// Set default values for the config
-	viper.SetDefault("version", "1.0.0")
-	viper.SetDefault("log_level", "DEBUG")
-	viper.SetDefault("log_file", "logs/app.log")
-	viper.SetDefault("max_connections", 10)
-
-	viper.SetDefault("log_handler.mode", "send") // Default to "send" mode
-	viper.SetDefault("log_handler.send.protocol", "UDP")
-	viper.SetDefault("log_handler.send.port", 2020)
-	viper.SetDefault("log_handler.scrape.type", "pure_docker")
-
-	viper.SetDefault("database.type", "SQLite")
-	viper.SetDefault("database.sqlite_filepath", "./myDB.db")
+	setDefaults(viper.GetViper())
 
 	// Read the config file
 	if err := viper.ReadInConfig(); err != nil {
@@ -77,23 +135,69 @@ func LoadConfig(configPath string) (Config, error) {
 	return config, nil
 }
 
-// ValidateConfig validates the loaded configuration
+// setDefaults sets the values used for settings the config file leaves out
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("version", "1.0.0")
+	v.SetDefault("log_level", "DEBUG")
+	v.SetDefault("log_file", "logs/app.log")
+	v.SetDefault("max_connections", 10)
+
+	v.SetDefault("log_handler.mode", "send") // Default to "send" mode
+	v.SetDefault("log_handler.send.protocol", "UDP")
+	v.SetDefault("log_handler.send.port", 2020)
+	v.SetDefault("log_handler.scrape.type", "pure_docker")
+
+	v.SetDefault("database.type", "SQLite")
+	v.SetDefault("database.sqlite_filepath", "./myDB.db")
+	v.SetDefault("database.partition_dir", "./db/partitions")
+	v.SetDefault("database.partition_by", "day")
+	v.SetDefault("database.postgres_max_conns", 10)
+	v.SetDefault("database.memory_capacity", 100000)
+	v.SetDefault("database.segment_dir", "./db/segments")
+	v.SetDefault("database.segment_window", "hour")
+	v.SetDefault("database.promoted_fields", []string{})
+	v.SetDefault("database.retention.interval", "5m")
+	v.SetDefault("database.retention.batch_size", 500)
+	v.SetDefault("database.archive.dir", "./db/archive")
+	v.SetDefault("database.archive.interval", "1h")
+	v.SetDefault("database.backup.dir", "./db/backups")
+	v.SetDefault("database.backup.keep", 7)
+}
+
+// DefaultConfig is the configuration of an empty config file, the starting point of the setup
+func DefaultConfig() Config {
+	v := viper.New()
+	setDefaults(v)
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		panic("confighandler: invalid defaults: " + err.Error())
+	}
+	return config
+}
+
+// ValidateConfig validates the loaded configuration. The error is a ValidationErrors listing
+// every invalid setting.
 func ValidateConfig(config Config) error {
+	v := &validation{}
+
 	// Validate log level
 	validLogLevels := map[string]bool{"ALL": true, "ERROR": true, "WARNING": true, "DEBUG": true, "NONE": true}
 	if !validLogLevels[config.LogLevel] {
-		return fmt.Errorf("invalid log_level: %s (must be one of ALL, ERROR, WARNING, DEBUG, NONE)", config.LogLevel)
+		v.fail("log_level", "invalid log_level: %s (must be one of ALL, ERROR, WARNING, DEBUG, NONE)", config.LogLevel)
 	}
 
 	// Validate LogHandler mode
 	if config.LogHandler.Mode != "send" && config.LogHandler.Mode != "scrape" {
-		return fmt.Errorf("invalid log_handler mode: %s (must be send or scrape)", config.LogHandler.Mode)
+		v.fail("log_handler.mode", "invalid log_handler mode: %s (must be send or scrape)", config.LogHandler.Mode)
 	}
 
-	// Validate send protocol
+	// Validate send protocol and port
 	if config.LogHandler.Mode == "send" {
 		if config.LogHandler.Send.Protocol != "UDP" && config.LogHandler.Send.Protocol != "HTTP" {
-			return fmt.Errorf("invalid protocol: %s (must be UDP or HTTP)", config.LogHandler.Send.Protocol)
+			v.fail("log_handler.send.protocol", "invalid protocol: %s (must be UDP or HTTP)", config.LogHandler.Send.Protocol)
+		}
+		if config.LogHandler.Send.Port < 1 || config.LogHandler.Send.Port > 65535 {
+			v.fail("log_handler.send.port", "invalid port: %d (must be from 1 to 65535)", config.LogHandler.Send.Port)
 		}
 	}
 
@@ -101,26 +205,86 @@ func ValidateConfig(config Config) error {
 	if config.LogHandler.Mode == "scrape" {
 		validScrapeTypes := map[string]bool{"pure_docker": true, "docker_swarm": true, "kubernetes": true}
 		if !validScrapeTypes[config.LogHandler.Scrape.Type] {
-			return fmt.Errorf("invalid scrape type: %s (must be pure_docker, docker_swarm, or kubernetes)", config.LogHandler.Scrape.Type)
+			v.fail("log_handler.scrape.type", "invalid scrape type: %s (must be pure_docker, docker_swarm, or kubernetes)", config.LogHandler.Scrape.Type)
 		}
 	}
 
 	// Validate max connections
 	if config.MaxConnections <= 0 {
-		return fmt.Errorf("max_connections must be greater than 0")
+		v.fail("max_connections", "max_connections must be greater than 0")
 	}
 
 	// Validate database type
-	if config.Database.Type != "SQLite" {
-		return fmt.Errorf("unsupported database type: %s (only SQLite is supported for now)", config.Database.Type)
+	validDatabaseTypes := map[string]bool{"SQLite": true, "PartitionedSQLite": true, "PostgreSQL": true, "Memory": true, "Segment": true}
+	if !validDatabaseTypes[config.Database.Type] {
+		v.fail("database.type", "unsupported database type: %s (must be SQLite, PartitionedSQLite, PostgreSQL, Memory or Segment)", config.Database.Type)
 	}
 
 	// Validate SQLite filepath
 	if config.Database.Type == "SQLite" && config.Database.SQLiteFilepath == "" {
-		return fmt.Errorf("sqlite_filepath cannot be empty")
+		v.fail("database.sqlite_filepath", "sqlite_filepath cannot be empty")
 	}
 
-	return nil
+	// Validate partitioning
+	if config.Database.Type == "PartitionedSQLite" {
+		if config.Database.PartitionDir == "" {
+			v.fail("database.partition_dir", "partition_dir cannot be empty")
+		}
+		if config.Database.PartitionBy != "day" && config.Database.PartitionBy != "hour" {
+			v.fail("database.partition_by", "invalid partition_by: %s (must be day or hour)", config.Database.PartitionBy)
+		}
+	}
+
+	// Validate PostgreSQL connection
+	if config.Database.Type == "PostgreSQL" {
+		if config.Database.PostgresURL == "" {
+			v.fail("database.postgres_url", "postgres_url cannot be empty")
+		}
+		if config.Database.PostgresMaxConns < 0 {
+			v.fail("database.postgres_max_conns", "postgres_max_conns must not be negative")
+		}
+	}
+
+	// Validate in-memory capacity
+	if config.Database.Type == "Memory" && config.Database.MemoryCapacity < 0 {
+		v.fail("database.memory_capacity", "memory_capacity must not be negative")
+	}
+
+	// Validate segment store
+	if config.Database.Type == "Segment" {
+		if config.Database.SegmentDir == "" {
+			v.fail("database.segment_dir", "segment_dir cannot be empty")
+		}
+		if config.Database.SegmentWindow != "hour" && config.Database.SegmentWindow != "day" {
+			v.fail("database.segment_window", "invalid segment_window: %s (must be hour or day)", config.Database.SegmentWindow)
+		}
+	}
+
+	// Validate promoted metadata fields. Their columns are named after them with . and - as _,
+	// so "request.status" and "request_status" cannot both be promoted.
+	columns := map[string]string{}
+	for _, field := range config.Database.PromotedFields {
+		if !validMetadataPath.MatchString(field) {
+			v.fail("database.promoted_fields", "invalid promoted field: %s (must be a dotted path of letters, digits, _ or -)", field)
+			continue
+		}
+		path := strings.TrimPrefix(field, "metadata.")
+		column := PromotedColumn(path)
+		switch other, ok := columns[column]; {
+		case ok && other == path:
+			v.fail("database.promoted_fields", "promoted field %s is listed twice", path)
+		case ok:
+			v.fail("database.promoted_fields", "promoted fields %s and %s would share the column %s, promote only one of them", other, path, column)
+		}
+		columns[column] = path
+	}
+
+	validateRetention(v, config.Database.Retention)
+	validateArchive(v, config.Database)
+	validateBackup(v, config.Database)
+	validateEncryption(v, config.Database)
+
+	return v.err()
 }
 
 // PrintConfigTable prints the loaded configuration in a human-readable format
@@ -142,7 +306,67 @@ func PrintConfigTable(config Config) {
 
 	fmt.Println("  Database:")
 	fmt.Printf("    Type           : %s\n", config.Database.Type)
-	fmt.Printf("    SQLite Filepath: %s\n", config.Database.SQLiteFilepath)
+	switch config.Database.Type {
+	case "SQLite":
+		fmt.Printf("    SQLite Filepath: %s\n", config.Database.SQLiteFilepath)
+	case "PartitionedSQLite":
+		fmt.Printf("    Partition Dir  : %s\n", config.Database.PartitionDir)
+		fmt.Printf("    Partition By   : %s\n", config.Database.PartitionBy)
+	case "PostgreSQL":
+		fmt.Printf("    Postgres URL   : %s\n", RedactURL(config.Database.PostgresURL))
+		fmt.Printf("    Max Conns      : %d\n", config.Database.PostgresMaxConns)
+	case "Memory":
+		fmt.Printf("    Capacity       : %d\n", config.Database.MemoryCapacity)
+		if config.Database.MemorySnapshot != "" {
+			fmt.Printf("    Snapshot       : %s\n", config.Database.MemorySnapshot)
+		}
+	case "Segment":
+		fmt.Printf("    Segment Dir    : %s\n", config.Database.SegmentDir)
+		fmt.Printf("    Segment Window : %s\n", config.Database.SegmentWindow)
+	}
+	if len(config.Database.PromotedFields) > 0 {
+		fmt.Printf("    Promoted Fields: %s\n", strings.Join(config.Database.PromotedFields, ", "))
+	}
+
+	retention := config.Database.Retention
+	fmt.Println("  Retention:")
+	fmt.Printf("    Max Age        : %s\n", orUnlimited(retention.MaxAge))
+	fmt.Printf("    Max Rows       : %s\n", orUnlimited(retention.MaxRows))
+	fmt.Printf("    Max Bytes      : %s\n", orUnlimited(retention.MaxBytes))
+	for _, rule := range retention.Rules {
+		fmt.Printf("    Rule           : level=%s source=%s max_age=%s\n", orAny(rule.Level), orAny(rule.Source), rule.MaxAge)
+	}
+
+	if archive := config.Database.Archive; archive.After != "" {
+		fmt.Println("  Archive:")
+		fmt.Printf("    After          : %s\n", archive.After)
+		fmt.Printf("    Dir            : %s\n", archive.Dir)
+	}
+
+	if backup := config.Database.Backup; backup.Interval != "" {
+		fmt.Println("  Backup:")
+		fmt.Printf("    Interval       : %s\n", backup.Interval)
+		fmt.Printf("    Dir            : %s\n", backup.Dir)
+		fmt.Printf("    Keep           : %s\n", orUnlimited(int64(backup.Keep)))
+	}
+
+	if encryption := config.Database.Encryption; encryption.Enabled() {
+		fmt.Println("  Encryption:")
+		if encryption.KeyFile != "" {
+			fmt.Printf("    Key File       : %s\n", encryption.KeyFile)
+		} else {
+			fmt.Printf("    Key Env        : %s\n", encryption.KeyEnv)
+		}
+	}
+}
+
+// RedactURL hides the password of a connection url so it can be printed
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
 }
 
 func SaveConfig(config Config, filePath string) error {
@@ -177,6 +401,41 @@ func SaveConfig(config Config, filePath string) error {
 
 	viper.Set("database.type", config.Database.Type)
 	viper.Set("database.sqlite_filepath", config.Database.SQLiteFilepath)
+	viper.Set("database.partition_dir", config.Database.PartitionDir)
+	viper.Set("database.partition_by", config.Database.PartitionBy)
+	viper.Set("database.postgres_url", config.Database.PostgresURL)
+	viper.Set("database.postgres_max_conns", config.Database.PostgresMaxConns)
+	viper.Set("database.memory_capacity", config.Database.MemoryCapacity)
+	viper.Set("database.memory_snapshot", config.Database.MemorySnapshot)
+	viper.Set("database.segment_dir", config.Database.SegmentDir)
+	viper.Set("database.segment_window", config.Database.SegmentWindow)
+	viper.Set("database.promoted_fields", config.Database.PromotedFields)
+
+	retention := config.Database.Retention
+	viper.Set("database.retention.max_age", retention.MaxAge)
+	viper.Set("database.retention.max_rows", retention.MaxRows)
+	viper.Set("database.retention.max_bytes", retention.MaxBytes)
+	viper.Set("database.retention.interval", retention.Interval)
+	viper.Set("database.retention.batch_size", retention.BatchSize)
+	rules := make([]map[string]string, len(retention.Rules))
+	for i, rule := range retention.Rules {
+		rules[i] = map[string]string{"level": rule.Level, "source": rule.Source, "max_age": rule.MaxAge}
+	}
+	viper.Set("database.retention.rules", rules)
+
+	archive := config.Database.Archive
+	viper.Set("database.archive.dir", archive.Dir)
+	viper.Set("database.archive.after", archive.After)
+	viper.Set("database.archive.interval", archive.Interval)
+
+	backup := config.Database.Backup
+	viper.Set("database.backup.dir", backup.Dir)
+	viper.Set("database.backup.interval", backup.Interval)
+	viper.Set("database.backup.keep", backup.Keep)
+
+	encryption := config.Database.Encryption
+	viper.Set("database.encryption.key_file", encryption.KeyFile)
+	viper.Set("database.encryption.key_env", encryption.KeyEnv)
 
 	// Write the config file
 	if err := viper.WriteConfigAs(filePath); err != nil {
@@ -0,0 +1,34 @@
+package confighandler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePromotedFields(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   string
+	}{
+		{[]string{"trace_id", "request.status"}, ""},
+		{[]string{"request status"}, "invalid promoted field"},
+		{[]string{"trace_id", "metadata.trace_id"}, "promoted field trace_id is listed twice"},
+		{[]string{"request.status", "request_status"}, "request.status and request_status would share the column meta_request_status"},
+		{[]string{"request-status", "metadata.request.status"}, "request-status and request.status would share"},
+	}
+	for _, tt := range tests {
+		config := DefaultConfig()
+		config.Database.PromotedFields = tt.fields
+		err := ValidateConfig(config)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%v: %v", tt.fields, err)
+			}
+			continue
+		}
+		errs, _ := err.(ValidationErrors)
+		if msg := errs.For("database.promoted_fields"); !strings.Contains(msg, tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.fields, msg, tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package confighandler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ParseDuration extends time.ParseDuration with days (d) and weeks (w), e.g. "30d"
+func ParseDuration(text string) (time.Duration, error) {
+	if n := len(text); n > 1 {
+		unit := time.Duration(0)
+		switch text[n-1] {
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		}
+		if unit != 0 {
+			count, err := strconv.Atoi(text[:n-1])
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", text)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(text)
+}
+
+// ParseSize parses sizes such as "500MB" or "2GiB" into bytes
+func ParseSize(text string) (int64, error) {
+	size, err := humanize.ParseBytes(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", text, err)
+	}
+	return int64(size), nil
+}
+
+// validateRetention checks that every retention limit can be parsed
+func validateRetention(v *validation, retention Retention) {
+	if retention.MaxAge != "" {
+		if d, err := ParseDuration(retention.MaxAge); err != nil || d <= 0 {
+			v.fail("database.retention.max_age", "invalid retention max_age: %s (use a duration like 30d or 12h)", retention.MaxAge)
+		}
+	}
+	if retention.MaxRows < 0 {
+		v.fail("database.retention.max_rows", "retention max_rows must not be negative")
+	}
+	if retention.MaxBytes != "" {
+		if _, err := ParseSize(retention.MaxBytes); err != nil {
+			v.fail("database.retention.max_bytes", "invalid retention max_bytes: %s (use a size like 500MB or 2GB)", retention.MaxBytes)
+		}
+	}
+	if retention.Interval != "" {
+		if d, err := ParseDuration(retention.Interval); err != nil || d <= 0 {
+			v.fail("database.retention.interval", "invalid retention interval: %s", retention.Interval)
+		}
+	}
+	if retention.BatchSize < 0 {
+		v.fail("database.retention.batch_size", "retention batch_size must not be negative")
+	}
+	for _, rule := range retention.Rules {
+		if rule.Level == "" && rule.Source == "" {
+			v.fail("database.retention.rules", "retention rules need a level or a source")
+			continue
+		}
+		if d, err := ParseDuration(rule.MaxAge); err != nil || d <= 0 {
+			v.fail("database.retention.rules", "invalid max_age %q in retention rule for level=%s source=%s", rule.MaxAge, rule.Level, rule.Source)
+		}
+	}
+}
+
+// validateArchive checks the archive settings, archiving is only supported on top of SQLite
+func validateArchive(v *validation, database Database) {
+	archive := database.Archive
+	if archive.After == "" {
+		return
+	}
+	if database.Type != "SQLite" {
+		v.fail("database.archive.after", "archive is only supported with the SQLite database type")
+	}
+	if d, err := ParseDuration(archive.After); err != nil || d <= 0 {
+		v.fail("database.archive.after", "invalid archive after: %s (use a duration like 30d)", archive.After)
+	}
+	if archive.Dir == "" {
+		v.fail("database.archive.dir", "archive dir cannot be empty")
+	}
+	if archive.Interval != "" {
+		if d, err := ParseDuration(archive.Interval); err != nil || d <= 0 {
+			v.fail("database.archive.interval", "invalid archive interval: %s", archive.Interval)
+		}
+	}
+}
+
+// validateBackup checks the backup settings, only the SQLite database can be backed up
+func validateBackup(v *validation, database Database) {
+	backup := database.Backup
+	if backup.Interval == "" {
+		return
+	}
+	if database.Type != "SQLite" {
+		v.fail("database.backup.interval", "backup is only supported with the SQLite database type")
+	}
+	if d, err := ParseDuration(backup.Interval); err != nil || d <= 0 {
+		v.fail("database.backup.interval", "invalid backup interval: %s (use a duration like 1d or 6h)", backup.Interval)
+	}
+	if backup.Dir == "" {
+		v.fail("database.backup.dir", "backup dir cannot be empty")
+	}
+	if backup.Keep < 0 {
+		v.fail("database.backup.keep", "backup keep must not be negative")
+	}
+}
+
+// validateEncryption checks that the keys come from one place. Promoted fields index the
+// metadata inside the database, which only ever sees it encrypted.
+func validateEncryption(v *validation, database Database) {
+	encryption := database.Encryption
+	if !encryption.Enabled() {
+		return
+	}
+	if encryption.KeyFile != "" && encryption.KeyEnv != "" {
+		v.fail("database.encryption.key_env", "encryption takes either key_file or key_env, not both")
+	}
+	if len(database.PromotedFields) > 0 {
+		v.fail("database.promoted_fields", "promoted_fields cannot be used with encryption, the database cannot read encrypted metadata")
+	}
+}
+
+func orUnlimited(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return v
+		}
+	case int64:
+		if v > 0 {
+			return strconv.FormatInt(v, 10)
+		}
+	}
+	return "unlimited"
+}
+
+func orAny(value string) string {
+	if value == "" {
+		return "*"
+	}
+	return value
+}
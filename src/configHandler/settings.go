@@ -0,0 +1,274 @@
+package confighandler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a problem with one setting, Field is its key in the config file such as
+// "database.retention.max_age"
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e FieldError) Error() string {
+	return e.Msg
+}
+
+// ValidationErrors lists every invalid setting of a configuration
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Msg
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// For returns the problems with a setting, empty when it is valid
+func (e ValidationErrors) For(field string) string {
+	var msgs []string
+	for _, fe := range e {
+		if fe.Field == field {
+			msgs = append(msgs, fe.Msg)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validation collects the problems found while validating a configuration
+type validation struct {
+	errs ValidationErrors
+}
+
+func (v *validation) fail(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validation) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Setting is one configuration value written as text, keyed like the config file
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// setting reads and writes one configuration value as text
+type setting struct {
+	key string
+	get func(c *Config) string
+	set func(c *Config, text string) error
+}
+
+func stringSetting(key string, field func(c *Config) *string) setting {
+	return setting{
+		key: key,
+		get: func(c *Config) string { return *field(c) },
+		set: func(c *Config, text string) error {
+			*field(c) = text
+			return nil
+		},
+	}
+}
+
+func intSetting(key string, field func(c *Config) *int) setting {
+	return setting{
+		key: key,
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, text string) error {
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number", key)
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+// settings are the editable settings in the order of the config file. The version is not one
+// of them.
+var settings = []setting{
+	stringSetting("log_level", func(c *Config) *string { return &c.LogLevel }),
+	stringSetting("log_file", func(c *Config) *string { return &c.LogFile }),
+	intSetting("max_connections", func(c *Config) *int { return &c.MaxConnections }),
+
+	stringSetting("log_handler.mode", func(c *Config) *string { return &c.LogHandler.Mode }),
+	stringSetting("log_handler.send.protocol", func(c *Config) *string { return &c.LogHandler.Send.Protocol }),
+	intSetting("log_handler.send.port", func(c *Config) *int { return &c.LogHandler.Send.Port }),
+	stringSetting("log_handler.scrape.type", func(c *Config) *string { return &c.LogHandler.Scrape.Type }),
+
+	stringSetting("database.type", func(c *Config) *string { return &c.Database.Type }),
+	stringSetting("database.sqlite_filepath", func(c *Config) *string { return &c.Database.SQLiteFilepath }),
+	stringSetting("database.partition_dir", func(c *Config) *string { return &c.Database.PartitionDir }),
+	stringSetting("database.partition_by", func(c *Config) *string { return &c.Database.PartitionBy }),
+	stringSetting("database.postgres_url", func(c *Config) *string { return &c.Database.PostgresURL }),
+	intSetting("database.postgres_max_conns", func(c *Config) *int { return &c.Database.PostgresMaxConns }),
+	intSetting("database.memory_capacity", func(c *Config) *int { return &c.Database.MemoryCapacity }),
+	stringSetting("database.memory_snapshot", func(c *Config) *string { return &c.Database.MemorySnapshot }),
+	stringSetting("database.segment_dir", func(c *Config) *string { return &c.Database.SegmentDir }),
+	stringSetting("database.segment_window", func(c *Config) *string { return &c.Database.SegmentWindow }),
+	{
+		key: "database.promoted_fields",
+		get: func(c *Config) string { return strings.Join(c.Database.PromotedFields, ", ") },
+		set: func(c *Config, text string) error {
+			c.Database.PromotedFields = []string{}
+			for _, field := range strings.Split(text, ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					c.Database.PromotedFields = append(c.Database.PromotedFields, field)
+				}
+			}
+			return nil
+		},
+	},
+
+	stringSetting("database.retention.max_age", func(c *Config) *string { return &c.Database.Retention.MaxAge }),
+	{
+		key: "database.retention.max_rows",
+		get: func(c *Config) string { return strconv.FormatInt(c.Database.Retention.MaxRows, 10) },
+		set: func(c *Config, text string) error {
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return fmt.Errorf("database.retention.max_rows must be a whole number")
+			}
+			c.Database.Retention.MaxRows = n
+			return nil
+		},
+	},
+	stringSetting("database.retention.max_bytes", func(c *Config) *string { return &c.Database.Retention.MaxBytes }),
+	stringSetting("database.retention.interval", func(c *Config) *string { return &c.Database.Retention.Interval }),
+	intSetting("database.retention.batch_size", func(c *Config) *int { return &c.Database.Retention.BatchSize }),
+	{
+		key: "database.retention.rules",
+		get: func(c *Config) string { return FormatRetentionRules(c.Database.Retention.Rules) },
+		set: func(c *Config, text string) error {
+			rules, err := ParseRetentionRules(text)
+			if err != nil {
+				return err
+			}
+			c.Database.Retention.Rules = rules
+			return nil
+		},
+	},
+
+	stringSetting("database.archive.after", func(c *Config) *string { return &c.Database.Archive.After }),
+	stringSetting("database.archive.dir", func(c *Config) *string { return &c.Database.Archive.Dir }),
+	stringSetting("database.archive.interval", func(c *Config) *string { return &c.Database.Archive.Interval }),
+
+	stringSetting("database.backup.interval", func(c *Config) *string { return &c.Database.Backup.Interval }),
+	stringSetting("database.backup.dir", func(c *Config) *string { return &c.Database.Backup.Dir }),
+	intSetting("database.backup.keep", func(c *Config) *int { return &c.Database.Backup.Keep }),
+
+	stringSetting("database.encryption.key_file", func(c *Config) *string { return &c.Database.Encryption.KeyFile }),
+	stringSetting("database.encryption.key_env", func(c *Config) *string { return &c.Database.Encryption.KeyEnv }),
+}
+
+// Settings lists the editable settings of a configuration as text
+func Settings(config Config) []Setting {
+	list := make([]Setting, len(settings))
+	for i, s := range settings {
+		list[i] = Setting{Key: s.key, Value: s.get(&config)}
+	}
+	return list
+}
+
+// ApplySettings changes the settings given as text, keyed like the config file, and validates
+// the result. Settings that are not given keep their value, as does a PostgreSQL url given the
+// way RedactURL shows it. Slices are replaced rather than changed, so config keeps its values.
+func ApplySettings(config Config, values map[string]string) (Config, error) {
+	v := &validation{}
+	for _, s := range settings {
+		text, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		if s.key == "database.postgres_url" && text == RedactURL(config.Database.PostgresURL) {
+			continue
+		}
+		if err := s.set(&config, strings.TrimSpace(text)); err != nil {
+			v.fail(s.key, "%s", err.Error())
+		}
+	}
+	if err := ValidateConfig(config); err != nil {
+		v.errs = append(v.errs, err.(ValidationErrors)...)
+	}
+	return config, v.err()
+}
+
+// FormatRetentionRules writes retention rules one per line, e.g. "level=ERROR max_age=90d"
+func FormatRetentionRules(rules []RetentionRule) string {
+	lines := make([]string, len(rules))
+	for i, rule := range rules {
+		var parts []string
+		if rule.Level != "" {
+			parts = append(parts, "level="+rule.Level)
+		}
+		if rule.Source != "" {
+			parts = append(parts, "source="+rule.Source)
+		}
+		parts = append(parts, "max_age="+rule.MaxAge)
+		lines[i] = strings.Join(parts, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseRetentionRules reads rules written by FormatRetentionRules, blank lines are skipped
+func ParseRetentionRules(text string) ([]RetentionRule, error) {
+	rules := []RetentionRule{}
+	for i, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var rule RetentionRule
+		for _, part := range strings.Fields(line) {
+			key, value, ok := strings.Cut(part, "=")
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("retention rule on line %d: %q is not key=value", i+1, part)
+			case key == "level":
+				rule.Level = value
+			case key == "source":
+				rule.Source = value
+			case key == "max_age":
+				rule.MaxAge = value
+			default:
+				return nil, fmt.Errorf("retention rule on line %d: unknown key %q (use level, source and max_age)", i+1, key)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+// Diff lists the settings that differ between two configurations. The password of the
+// PostgreSQL url is hidden.
+func Diff(old, new Config) []Change {
+	var changes []Change
+	newSettings := Settings(new)
+	for i, o := range Settings(old) {
+		n := newSettings[i]
+		if o.Value == n.Value {
+			continue
+		}
+		if o.Key == "database.postgres_url" {
+			o.Value, n.Value = RedactURL(o.Value), RedactURL(n.Value)
+		}
+		changes = append(changes, Change{Key: o.Key, Old: o.Value, New: n.Value})
+	}
+	return changes
+}
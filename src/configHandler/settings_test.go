@@ -0,0 +1,116 @@
+package confighandler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplySettings(t *testing.T) {
+	base := DefaultConfig()
+	base.Database.PromotedFields = []string{"trace_id"}
+	base.Database.PostgresURL = "postgres://loglite:secret@db:5432/logs"
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		check  func(c Config) bool
+		errs   map[string]string // Setting key to part of its error
+	}{
+		{"nothing", map[string]string{}, func(c Config) bool { return reflect.DeepEqual(c, base) }, nil},
+		{"text", map[string]string{"log_level": " ERROR "}, func(c Config) bool { return c.LogLevel == "ERROR" }, nil},
+		{"number", map[string]string{"log_handler.send.port": "9000"}, func(c Config) bool { return c.LogHandler.Send.Port == 9000 }, nil},
+		{"promoted fields", map[string]string{"database.promoted_fields": "user.id, ,region,"}, func(c Config) bool {
+			return reflect.DeepEqual(c.Database.PromotedFields, []string{"user.id", "region"})
+		}, nil},
+		{"retention rules", map[string]string{"database.retention.rules": "level=ERROR max_age=90d\n\nsource=api max_age=7d"}, func(c Config) bool {
+			return reflect.DeepEqual(c.Database.Retention.Rules, []RetentionRule{{Level: "ERROR", MaxAge: "90d"}, {Source: "api", MaxAge: "7d"}})
+		}, nil},
+		{"redacted url", map[string]string{"database.postgres_url": RedactURL(base.Database.PostgresURL)}, func(c Config) bool {
+			return c.Database.PostgresURL == base.Database.PostgresURL
+		}, nil},
+		{"new url", map[string]string{"database.postgres_url": "postgres://other/logs"}, func(c Config) bool {
+			return c.Database.PostgresURL == "postgres://other/logs"
+		}, nil},
+		{"not a number", map[string]string{"log_handler.send.port": "high"}, nil,
+			map[string]string{"log_handler.send.port": "must be a whole number"}},
+		{"invalid value", map[string]string{"log_handler.send.port": "70000"}, nil,
+			map[string]string{"log_handler.send.port": "invalid port: 70000"}},
+		{"invalid rule", map[string]string{"database.retention.rules": "level=ERROR forever"}, nil,
+			map[string]string{"database.retention.rules": "line 1"}},
+		{"every problem", map[string]string{"log_level": "LOUD", "max_connections": "0", "database.backup.keep": "some"}, nil, map[string]string{
+			"log_level":            "invalid log_level: LOUD",
+			"max_connections":      "greater than 0",
+			"database.backup.keep": "must be a whole number",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplySettings(base, tt.values)
+			if tt.errs == nil {
+				if err != nil {
+					t.Fatalf("ApplySettings: %v", err)
+				}
+				if !tt.check(got) {
+					t.Errorf("unexpected config %+v", got)
+				}
+				return
+			}
+			errs, ok := err.(ValidationErrors)
+			if !ok {
+				t.Fatalf("got error %v, want ValidationErrors", err)
+			}
+			for key, want := range tt.errs {
+				if msg := errs.For(key); !strings.Contains(msg, want) {
+					t.Errorf("%s: got %q, want it to contain %q", key, msg, want)
+				}
+			}
+		})
+	}
+
+	// The config passed in keeps its values
+	if !reflect.DeepEqual(base.Database.PromotedFields, []string{"trace_id"}) {
+		t.Errorf("promoted fields of the original changed to %v", base.Database.PromotedFields)
+	}
+}
+
+// Every setting reads back the value it was written with
+func TestSettingsRoundTrip(t *testing.T) {
+	config := DefaultConfig()
+	config.Database.PromotedFields = []string{"trace_id", "request.status"}
+	config.Database.Retention.Rules = []RetentionRule{{Level: "DEBUG", Source: "api", MaxAge: "1d"}}
+	config.Database.Backup.Keep = 3
+
+	values := map[string]string{}
+	for _, s := range Settings(config) {
+		values[s.Key] = s.Value
+	}
+	got, err := ApplySettings(DefaultConfig(), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(config, got); len(changes) != 0 {
+		t.Errorf("settings did not round trip: %+v", changes)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := DefaultConfig()
+	old.Database.PostgresURL = "postgres://loglite:secret@db/logs"
+	new := old
+	new.LogLevel = "ERROR"
+	new.Database.PostgresURL = "postgres://loglite:other@db/logs"
+	new.Database.PromotedFields = []string{"trace_id"}
+
+	want := []Change{
+		{Key: "log_level", Old: old.LogLevel, New: "ERROR"},
+		{Key: "database.postgres_url", Old: "postgres://loglite:xxxxx@db/logs", New: "postgres://loglite:xxxxx@db/logs"},
+		{Key: "database.promoted_fields", Old: "", New: "trace_id"},
+	}
+	if got := Diff(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+	if got := Diff(old, old); len(got) != 0 {
+		t.Errorf("diff of a config with itself: %+v", got)
+	}
+}
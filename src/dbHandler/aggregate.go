@@ -0,0 +1,223 @@
+package dbhandler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AggregateFunc is a statistic of a numeric field, computed per group of a count query
+type AggregateFunc string
+
+const (
+	AggCount AggregateFunc = "count" // Logs where the field holds a number
+	AggSum   AggregateFunc = "sum"
+	AggMin   AggregateFunc = "min"
+	AggMax   AggregateFunc = "max"
+	AggAvg   AggregateFunc = "avg"
+)
+
+// BucketColumn is the result column holding the start of a row's time bucket
+const BucketColumn = "bucket"
+
+// Aggregate computes Func over the numeric values of Field. Logs where the field is
+// missing or not a number are left out, like SQL aggregates leave out NULLs.
+type Aggregate struct {
+	Func  AggregateFunc
+	Field string
+}
+
+// Name is the result column of the aggregate, e.g. "avg(length)"
+func (a Aggregate) Name() string {
+	return fmt.Sprintf("%s(%s)", a.Func, a.Field)
+}
+
+// ParseAggregate reads an aggregate written like its Name, e.g. "max(metadata.duration_ms)".
+// The function and field are checked when the query is validated.
+func ParseAggregate(text string) (Aggregate, error) {
+	open := strings.IndexByte(text, '(')
+	if open <= 0 || !strings.HasSuffix(text, ")") {
+		return Aggregate{}, fmt.Errorf("invalid aggregate %q (use e.g. avg(length))", text)
+	}
+	return Aggregate{
+		Func:  AggregateFunc(strings.ToLower(strings.TrimSpace(text[:open]))),
+		Field: strings.TrimSpace(text[open+1 : len(text)-1]),
+	}, nil
+}
+
+func (a Aggregate) validate() error {
+	switch a.Func {
+	case AggCount, AggSum, AggMin, AggMax, AggAvg:
+	default:
+		return fmt.Errorf("unknown aggregate function: %s", a.Func)
+	}
+	if a.Field != "length" && a.Field != "id" && !IsMetadataField(a.Field) {
+		return fmt.Errorf("%s needs a numeric field such as length or a metadata path, not %s", a.Func, a.Field)
+	}
+	return ValidateField(a.Field)
+}
+
+// bucketSteps are the bucket sizes BucketFor picks from
+var bucketSteps = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// BucketFor picks the smallest usual bucket size that splits span into at most n buckets
+func BucketFor(span time.Duration, n int) time.Duration {
+	if n <= 0 {
+		n = 1
+	}
+	for _, step := range bucketSteps {
+		if span <= step*time.Duration(n) {
+			return step
+		}
+	}
+	// Beyond weeks, whole days
+	days := (span/time.Duration(n) + 24*time.Hour - 1) / (24 * time.Hour)
+	return days * 24 * time.Hour
+}
+
+// BucketStart returns the start of the bucket holding t. Buckets are aligned to the Unix
+// epoch in UTC, like the SQL backends compute them.
+func BucketStart(t time.Time, size time.Duration) time.Time {
+	seconds := int64(size / time.Second)
+	unix := t.Unix()
+	start := unix - unix%seconds
+	if unix%seconds < 0 {
+		start -= seconds
+	}
+	return time.Unix(start, 0).UTC()
+}
+
+// mergeableQuery rewrites the aggregates of q into parts that can be added up across the
+// results of several queries: an average of averages is wrong, sums and counts are not
+func mergeableQuery(q Query) Query {
+	fields := aggregateFields(q.Aggregates)
+	q.Limit, q.Offset, q.OrderBy = 0, 0, ""
+	q.Aggregates = nil
+	for _, field := range fields {
+		for _, fn := range []AggregateFunc{AggCount, AggSum, AggMin, AggMax} {
+			q.Aggregates = append(q.Aggregates, Aggregate{fn, field})
+		}
+	}
+	return q
+}
+
+// aggregateFields lists the distinct fields aggregated by a query
+func aggregateFields(aggregates []Aggregate) []string {
+	fields := []string{}
+	seen := map[string]bool{}
+	for _, a := range aggregates {
+		if !seen[a.Field] {
+			seen[a.Field] = true
+			fields = append(fields, a.Field)
+		}
+	}
+	return fields
+}
+
+// numericValue accepts the values aggregates are computed over
+func numericValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case int64, float64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	}
+	return nil, false
+}
+
+// fieldStats accumulates the numeric values of one field within a group. Sums stay integers
+// until a fraction shows up, like SQL's SUM.
+type fieldStats struct {
+	n        int64
+	sumInt   int64
+	sumFloat float64
+	fraction bool
+	min, max interface{}
+}
+
+func (s *fieldStats) add(value interface{}) {
+	v, ok := numericValue(value)
+	if !ok {
+		return
+	}
+	s.n++
+	s.addSum(v)
+	if s.min == nil || compareValues(v, s.min) < 0 {
+		s.min = v
+	}
+	if s.max == nil || compareValues(v, s.max) > 0 {
+		s.max = v
+	}
+}
+
+func (s *fieldStats) addSum(v interface{}) {
+	switch n := v.(type) {
+	case int64:
+		s.sumInt += n
+	case float64:
+		s.sumFloat += n
+		s.fraction = true
+	}
+}
+
+// merge adds the stats of a row returned by a mergeableQuery
+func (s *fieldStats) merge(row map[string]interface{}, field string) {
+	n, _ := numericValue(row[Aggregate{AggCount, field}.Name()])
+	if n == nil || toFloat(n) == 0 {
+		return
+	}
+	s.n += int64(toFloat(n))
+	if sum, ok := numericValue(row[Aggregate{AggSum, field}.Name()]); ok {
+		s.addSum(sum)
+	}
+	if min, ok := numericValue(row[Aggregate{AggMin, field}.Name()]); ok && (s.min == nil || compareValues(min, s.min) < 0) {
+		s.min = min
+	}
+	if max, ok := numericValue(row[Aggregate{AggMax, field}.Name()]); ok && (s.max == nil || compareValues(max, s.max) > 0) {
+		s.max = max
+	}
+}
+
+// result returns the aggregate, nil when no value was numeric (except for count)
+func (s *fieldStats) result(fn AggregateFunc) interface{} {
+	if fn == AggCount {
+		return s.n
+	}
+	if s.n == 0 {
+		return nil
+	}
+	sum := interface{}(s.sumInt)
+	if s.fraction {
+		sum = float64(s.sumInt) + s.sumFloat
+	}
+	switch fn {
+	case AggSum:
+		return sum
+	case AggMin:
+		return s.min
+	case AggMax:
+		return s.max
+	case AggAvg:
+		return toFloat(sum) / float64(s.n)
+	}
+	return nil
+}
+
+// parseBuckets turns the bucket text SQLite returns into times, like the timestamp column
+func parseBuckets(q Query, rows []map[string]interface{}) {
+	if q.Bucket == 0 {
+		return
+	}
+	for _, row := range rows {
+		if text, ok := row[BucketColumn].(string); ok {
+			row[BucketColumn] = parseStoredTimestamp(text)
+		}
+	}
+}
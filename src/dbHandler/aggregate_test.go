@@ -0,0 +1,177 @@
+package dbhandler
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucketFor(t *testing.T) {
+	tests := []struct {
+		span time.Duration
+		n    int
+		want time.Duration
+	}{
+		{time.Minute, 60, time.Second},
+		{time.Minute, 10, 10 * time.Second},
+		{15 * time.Minute, 60, 15 * time.Second},
+		{time.Hour, 60, time.Minute},
+		{time.Hour, 50, 5 * time.Minute},
+		{24 * time.Hour, 60, 30 * time.Minute},
+		{7 * 24 * time.Hour, 60, 3 * time.Hour},
+		{90 * 24 * time.Hour, 60, 7 * 24 * time.Hour},
+		{365 * 24 * time.Hour, 30, 13 * 24 * time.Hour},
+		{time.Hour, 0, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := BucketFor(tt.span, tt.n); got != tt.want {
+			t.Errorf("BucketFor(%s, %d) = %s, want %s", tt.span, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		size time.Duration
+		want time.Time
+	}{
+		{testStart.Add(17 * time.Second), 15 * time.Second, testStart.Add(15 * time.Second)},
+		{testStart.Add(59 * time.Minute), time.Hour, testStart},
+		{testStart, 24 * time.Hour, time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)},
+		// Weeks start on a Thursday, like the epoch
+		{testStart, 7 * 24 * time.Hour, time.Date(2025, 1, 30, 0, 0, 0, 0, time.UTC)},
+		// Other zones land in the same UTC buckets
+		{testStart.Add(90 * time.Minute).In(time.FixedZone("UTC+2", 2*3600)), time.Hour, testStart.Add(time.Hour)},
+		{time.Date(1969, 12, 31, 23, 59, 30, 0, time.UTC), time.Minute, time.Date(1969, 12, 31, 23, 59, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := BucketStart(tt.t, tt.size); !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("BucketStart(%s, %s) = %s, want %s", tt.t, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestParseAggregate(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Aggregate
+		wantErr string
+	}{
+		{"avg(length)", Aggregate{AggAvg, "length"}, ""},
+		{" MAX( metadata.duration_ms )", Aggregate{AggMax, "metadata.duration_ms"}, ""},
+		{"count(id)", Aggregate{AggCount, "id"}, ""},
+		{"avg", Aggregate{}, "invalid aggregate"},
+		{"(length)", Aggregate{}, "invalid aggregate"},
+		{"median(length)", Aggregate{}, "unknown aggregate function: median"},
+		{"sum(level)", Aggregate{}, "sum needs a numeric field"},
+		{"min(metadata.a..b)", Aggregate{}, "invalid metadata path"},
+	}
+	for _, tt := range tests {
+		a, err := ParseAggregate(strings.TrimSpace(tt.text))
+		if err == nil {
+			err = a.validate()
+		}
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: got error %v, want %q", tt.text, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || a != tt.want {
+			t.Errorf("%q: got %v, error %v, want %v", tt.text, a, err, tt.want)
+		}
+	}
+}
+
+func TestMergeCounts(t *testing.T) {
+	ms := "metadata.ms"
+	q := Query{Count: true, GroupBy: []string{"level"}, Aggregates: []Aggregate{{AggAvg, ms}, {AggMax, ms}, {AggCount, ms}}}
+	merge := mergeableQuery(q)
+	if len(merge.Aggregates) != 4 {
+		t.Fatalf("mergeable aggregates %v", merge.Aggregates)
+	}
+
+	part := func(level string, count int64, n int64, sum, min, max interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"level": level, "count": count,
+			"count(metadata.ms)": n, "sum(metadata.ms)": sum, "min(metadata.ms)": min, "max(metadata.ms)": max,
+		}
+	}
+	rows := mergeCounts(q,
+		[]map[string]interface{}{part("INFO", 3, 3, int64(6), int64(1), int64(3)), part("ERROR", 1, 0, nil, nil, nil)},
+		[]map[string]interface{}{part("INFO", 2, 1, int64(10), int64(10), int64(10))},
+		[]map[string]interface{}{part("ERROR", 2, 2, 3.5, 1.5, 2.0)},
+	)
+	// The average is over every value, not the average of the parts' averages
+	want := "avg(metadata.ms)=1.75 count=3 count(metadata.ms)=2 level=ERROR max(metadata.ms)=2\n" +
+		"avg(metadata.ms)=4 count=5 count(metadata.ms)=4 level=INFO max(metadata.ms)=10"
+	if got := describeGroups(rows, false); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestAggregatesAgree runs bucketed counts and statistics on the backends that compute them in
+// SQL and in Go and expects the same groups
+func TestAggregatesAgree(t *testing.T) {
+	memory, err := NewMemoryHandler(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := NewSQLiteHandler(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	for i := 0; i < 40; i++ {
+		metadata := fmt.Sprintf(`{"ms": %d}`, i*7%30)
+		if i%5 == 0 {
+			metadata = `{"ms": "slow"}`
+		} else if i%7 == 0 {
+			metadata = fmt.Sprintf(`{"ms": %d.5}`, i)
+		}
+		row := map[string]interface{}{
+			"timestamp": testStart.Add(time.Duration(i) * 40 * time.Second),
+			"level":     []string{"INFO", "WARN", "ERROR"}[i%3],
+			"source":    []string{"api", "auth"}[i%2],
+			"message":   fmt.Sprintf("log %d", i),
+			"length":    i * 3,
+			"metadata":  metadata,
+		}
+		for _, db := range []DBHandler{memory, sqlite} {
+			if err := db.Put("logs", row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ms := "metadata.ms"
+	tests := []struct {
+		name string
+		q    Query
+	}{
+		{"buckets", Query{Count: true, Bucket: 5 * time.Minute}},
+		{"buckets by level", Query{Count: true, GroupBy: []string{"level"}, Bucket: 10 * time.Minute}},
+		{"since inside a bucket", Query{Count: true, Bucket: 10 * time.Minute, Since: testStart.Add(7 * time.Minute)}},
+		{"length", Query{Count: true, GroupBy: []string{"source"}, Aggregates: []Aggregate{{AggAvg, "length"}, {AggSum, "length"}}}},
+		{"metadata skips text", Query{Count: true, GroupBy: []string{"level"}, Aggregates: []Aggregate{{AggCount, ms}, {AggSum, ms}, {AggMin, ms}, {AggMax, ms}}}},
+		{"statistics per bucket", Query{Count: true, Bucket: 15 * time.Minute, Aggregates: []Aggregate{{AggMax, ms}, {AggAvg, "length"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := sqlite.Query(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := memory.Query(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if describeGroups(got, false) != describeGroups(want, false) {
+				t.Errorf("memory\n%s\nSQLite\n%s", describeGroups(got, false), describeGroups(want, false))
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package dbhandler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	archiveManifestName = "manifest.json"
+	archiveFilePrefix   = "logs-"
+	archiveFileSuffix   = ".ndjson.zst"
+	archiveDayLayout    = "2006-01-02"
+)
+
+// archiveFile describes one compressed archive file. Files hold the logs of a single day,
+// a day can have several files when logs arrive after the day was archived.
+type archiveFile struct {
+	Name    string
+	Day     string    // The UTC day, in archiveDayLayout, of every log in the file
+	From    time.Time // Oldest timestamp in the file
+	To      time.Time // Newest timestamp in the file
+	Rows    int64
+	Bytes   int64
+	MaxID   int64 // Rows of Day with an id up to MaxID were moved here
+	Levels  []string
+	Sources []string
+}
+
+// archiveManifest lists the archive files, oldest day first
+type archiveManifest struct {
+	Files   []*archiveFile
+	NextSeq int
+}
+
+// mightMatch uses the manifest entry to rule a file out without reading it
+func (f *archiveFile) mightMatch(q Query) bool {
+	if !q.Since.IsZero() && f.To.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !f.From.Before(q.Until) {
+		return false
+	}
+	if q.cursorRulesOut(f.From, f.To) {
+		return false
+	}
+	for _, c := range q.Conditions {
+		if c.Negate || c.Op != OpEq && c.Op != OpIn {
+			continue
+		}
+		values := []interface{}{c.Value}
+		if c.Op == OpIn {
+			values = c.Value.([]interface{})
+		}
+		if c.Field == "level" && !containsAny(f.Levels, values) || c.Field == "source" && !containsAny(f.Sources, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadArchiveManifest reads the manifest in dir and removes files it does not list. Those are
+// left over from a move that failed before the manifest was saved, so their rows are still in SQLite.
+func loadArchiveManifest(dir string) (*archiveManifest, error) {
+	manifest := &archiveManifest{NextSeq: 1}
+	content, err := os.ReadFile(filepath.Join(dir, archiveManifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse archive manifest: %w", err)
+		}
+	}
+
+	listed := map[string]bool{archiveManifestName: true}
+	for _, file := range manifest.Files {
+		listed[file.Name] = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		stray := strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, archiveFilePrefix) && strings.HasSuffix(name, archiveFileSuffix)
+		if !entry.IsDir() && stray && !listed[name] {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+	return manifest, nil
+}
+
+// save replaces the manifest file, renaming a temporary file over it so it is never half written
+func (m *archiveManifest) save(dir string) error {
+	sort.SliceStable(m.Files, func(i, j int) bool { return m.Files[i].Day < m.Files[j].Day })
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode archive manifest: %w", err)
+	}
+	path := filepath.Join(dir, archiveManifestName)
+	if err := os.WriteFile(path+".tmp", content, 0644); err != nil {
+		return fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	return nil
+}
+
+// newFileName reserves the name of a new archive file for day
+func (m *archiveManifest) newFileName(day string) string {
+	name := fmt.Sprintf("%s%s-%06d%s", archiveFilePrefix, day, m.NextSeq, archiveFileSuffix)
+	m.NextSeq++
+	return name
+}
+
+// archiveWriter streams rows into a zstd compressed file with one JSON object per line
+type archiveWriter struct {
+	path    string
+	file    *os.File
+	zw      *zstd.Encoder
+	buf     *bufio.Writer
+	entry   *archiveFile
+	levels  map[string]bool
+	sources map[string]bool
+}
+
+func newArchiveWriter(dir, name, day string) (*archiveWriter, error) {
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive file: %w", err)
+	}
+	zw, err := zstd.NewWriter(file, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create archive file: %w", err)
+	}
+	return &archiveWriter{
+		path:    path,
+		file:    file,
+		zw:      zw,
+		buf:     bufio.NewWriter(zw),
+		entry:   &archiveFile{Name: name, Day: day},
+		levels:  map[string]bool{},
+		sources: map[string]bool{},
+	}, nil
+}
+
+// write appends a row, with its times in the layout the logs table uses
+func (w *archiveWriter) write(row map[string]interface{}) error {
+	ts, _ := row["timestamp"].(time.Time)
+	line := make(map[string]interface{}, len(LogColumns))
+	for _, col := range LogColumns {
+		if v := row[col]; v != nil {
+			if t, ok := v.(time.Time); ok && isTimeColumn(col) {
+				v = t.UTC().Format(TimestampLayout)
+			}
+			line[col] = v
+		}
+	}
+	line["timestamp"] = ts.UTC().Format(TimestampLayout)
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("failed to encode archived log: %w", err)
+	}
+	w.buf.Write(encoded)
+	if err := w.buf.WriteByte('\n'); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	e := w.entry
+	if e.Rows == 0 || ts.Before(e.From) {
+		e.From = ts
+	}
+	if ts.After(e.To) {
+		e.To = ts
+	}
+	if id, ok := row["id"].(int64); ok && id > e.MaxID {
+		e.MaxID = id
+	}
+	e.Rows++
+	if level, ok := row["level"].(string); ok && !w.levels[level] {
+		w.levels[level] = true
+		e.Levels = append(e.Levels, level)
+	}
+	if source, ok := row["source"].(string); ok && !w.sources[source] {
+		w.sources[source] = true
+		e.Sources = append(e.Sources, source)
+	}
+	return nil
+}
+
+// finish syncs the file and moves it in place
+func (w *archiveWriter) finish() (*archiveFile, error) {
+	err := w.buf.Flush()
+	if err == nil {
+		err = w.zw.Close()
+	}
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.path+".tmp", w.path)
+	}
+	if err != nil {
+		os.Remove(w.path + ".tmp")
+		return nil, fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write archive file: %w", err)
+	}
+	w.entry.Bytes = info.Size()
+	return w.entry, nil
+}
+
+// abort removes the unfinished file
+func (w *archiveWriter) abort() {
+	w.zw.Close()
+	w.file.Close()
+	os.Remove(w.path + ".tmp")
+}
+
+// readArchiveFile decompresses a file into rows with the same types SQLite returns
+func readArchiveFile(dir string, file *archiveFile) ([]*memoryRow, error) {
+	f, err := os.Open(filepath.Join(dir, file.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive file %s: %w", file.Name, err)
+	}
+	defer zr.Close()
+
+	rows := make([]*memoryRow, 0, file.Rows)
+	decoder := json.NewDecoder(bufio.NewReader(zr))
+	decoder.UseNumber()
+	for decoder.More() {
+		var data map[string]interface{}
+		if err := decoder.Decode(&data); err != nil {
+			return nil, fmt.Errorf("failed to read archive file %s: %w", file.Name, err)
+		}
+		number, _ := data["id"].(json.Number)
+		id, _ := number.Int64()
+		delete(data, "id")
+		row, err := newMemoryRow(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive file %s: %w", file.Name, err)
+		}
+		row.values["id"] = id
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,145 @@
+package dbhandler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Files the manifest does not list are left over from a move that failed, loading removes them
+func TestLoadArchiveManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := &archiveManifest{NextSeq: 4, Files: []*archiveFile{
+		{Name: "logs-2025-01-31-000003.ndjson.zst", Day: "2025-01-31"},
+		{Name: "logs-2025-01-30-000001.ndjson.zst", Day: "2025-01-30"},
+	}}
+	if err := manifest.save(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"logs-2025-01-30-000001.ndjson.zst",
+		"logs-2025-01-31-000003.ndjson.zst",
+		"logs-2025-01-30-000002.ndjson.zst", // Written but never listed
+		"logs-2025-01-31-000004.ndjson.zst.tmp",
+		"manifest.json.tmp",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "logs-old.ndjson.zst"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadArchiveManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.NextSeq != 4 || len(loaded.Files) != 2 || loaded.Files[0].Day != "2025-01-30" {
+		t.Errorf("loaded %+v", loaded)
+	}
+	if name := loaded.newFileName("2025-02-01"); name != "logs-2025-02-01-000004.ndjson.zst" {
+		t.Errorf("new file name %s", name)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"logs-2025-01-30-000001.ndjson.zst", "logs-2025-01-31-000003.ndjson.zst", "logs-old.ndjson.zst", "manifest.json", "notes.txt"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("left %v, want %v", names, want)
+	}
+}
+
+func TestLoadArchiveManifestInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, archiveManifestName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadArchiveManifest(dir); err == nil || !strings.Contains(err.Error(), "failed to parse archive manifest") {
+		t.Errorf("got %v", err)
+	}
+
+	empty, err := loadArchiveManifest(t.TempDir())
+	if err != nil || empty.NextSeq != 1 || len(empty.Files) != 0 {
+		t.Errorf("without a manifest: %+v, %v", empty, err)
+	}
+}
+
+func TestTieredArchive(t *testing.T) {
+	hot, err := NewSQLiteHandler(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().UTC().Add(-72 * time.Hour).Truncate(24 * time.Hour).Add(time.Hour)
+	for i, at := range []time.Time{old, old.Add(time.Minute), time.Now().UTC()} {
+		err := hot.Put("logs", map[string]interface{}{"timestamp": at, "level": "INFO", "message": "log " + string(rune('0'+i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(t.TempDir(), "archive")
+	h, err := NewTieredHandler(hot, dir, 24*time.Hour, WithArchiveInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, err := h.Archive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	archived := func() []*archiveFile {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return append([]*archiveFile{}, h.manifest.Files...)
+	}
+	all := func() string {
+		t.Helper()
+		rows, err := h.Query(Query{OrderBy: "timestamp", Ascending: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messages(rows)
+	}
+	if got := all(); got != "log 0,log 1,log 2" {
+		t.Errorf("after archiving: %q", got)
+	}
+	if rows, _ := hot.Query(Query{}); messages(rows) != "log 2" {
+		t.Errorf("SQLite kept %q", messages(rows))
+	}
+	if files := archived(); len(files) != 1 || files[0].Rows != 2 || files[0].MaxID != 2 {
+		t.Errorf("archived %v", files)
+	}
+
+	// A run that saved the manifest but failed to delete the archived rows left them in SQLite,
+	// the next run deletes them instead of archiving them twice
+	for i, at := range []time.Time{old, old.Add(time.Minute)} {
+		_, err := hot.db.Exec("INSERT INTO logs (id, timestamp, level, message) VALUES (?, ?, 'INFO', ?)",
+			i+1, at.Format(TimestampLayout), "log "+string(rune('0'+i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.Archive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := all(); got != "log 0,log 1,log 2" {
+		t.Errorf("after reconciling: %q", got)
+	}
+	if files := archived(); len(files) != 1 {
+		t.Errorf("archived again into %d files", len(files))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "logs-*"))
+	sort.Strings(files)
+	if len(files) != 1 {
+		t.Errorf("archive files %v", files)
+	}
+}
@@ -0,0 +1,225 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+)
+
+const (
+	backupFilePrefix = "loglite-"
+	backupFileSuffix = ".db"
+	backupTimeLayout = "20060102-150405"
+	// Backups are named to the microsecond so a manual one next to a scheduled one gets its own
+	// file, List still parses names written with whole seconds
+	backupNameLayout = "20060102-150405.000000"
+)
+
+// Backupper is implemented by handlers that can write a consistent snapshot of their logs
+// to a file while logs keep being ingested
+type Backupper interface {
+	// Backup writes the snapshot to path, which must not exist yet
+	Backup(ctx context.Context, path string) error
+}
+
+// BackupResult describes a single backup run
+type BackupResult struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	Path      string
+	Bytes     int64
+	Removed   int // Old backups deleted to stay within the configured number
+	Err       error
+}
+
+// BackupFile is one snapshot in the backup directory
+type BackupFile struct {
+	Name      string
+	Bytes     int64
+	CreatedAt time.Time
+}
+
+// BackupManager takes snapshots of a handler on a schedule, keeping the newest few
+type BackupManager struct {
+	handler  Backupper
+	dir      string
+	interval time.Duration // 0 disables scheduled backups
+	keep     int           // 0 keeps every backup
+
+	run      sync.Mutex // Held while a backup runs, so two never pick the same name
+	mu       sync.Mutex
+	last     *BackupResult
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+// NewBackupManager returns nil when the handler does not support backups
+func NewBackupManager(handler DBHandler, config confighandler.Backup) (*BackupManager, error) {
+	backupper, ok := Capability[Backupper](handler)
+	if !ok {
+		return nil, nil
+	}
+
+	var interval time.Duration
+	if config.Interval != "" {
+		var err error
+		if interval, err = confighandler.ParseDuration(config.Interval); err != nil {
+			return nil, fmt.Errorf("invalid backup interval: %w", err)
+		}
+	}
+	return &BackupManager{handler: backupper, dir: config.Dir, interval: interval, keep: config.Keep}, nil
+}
+
+// Interval returns how often backups are taken, 0 when they are not scheduled
+func (m *BackupManager) Interval() time.Duration {
+	return m.interval
+}
+
+// Start takes a backup every interval until Stop is called. It does nothing without an interval.
+func (m *BackupManager) Start() {
+	if m.interval == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.cancel = cancel
+	m.finished = make(chan struct{})
+	m.mu.Unlock()
+
+	go func() {
+		defer close(m.finished)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		// Unlike the pruner the first backup waits an interval, restarts should not pile up snapshots
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+// Stop cancels a running backup and waits for the background loop to exit
+func (m *BackupManager) Stop() {
+	m.mu.Lock()
+	cancel, finished := m.cancel, m.finished
+	m.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-finished
+	}
+}
+
+// RunOnce takes a backup immediately, removes the ones beyond the configured number and
+// records the result
+func (m *BackupManager) RunOnce(ctx context.Context) BackupResult {
+	m.run.Lock()
+	defer m.run.Unlock()
+
+	result := BackupResult{StartedAt: time.Now().UTC()}
+	result.Path = m.backupPath(result.StartedAt)
+
+	result.Err = m.backup(ctx, &result)
+	result.Duration = time.Since(result.StartedAt)
+	if result.Err != nil {
+		log.Printf("Error backing up logs: %v\n", result.Err)
+	} else {
+		log.Printf("Backed up logs to %s in %s\n", result.Path, result.Duration)
+	}
+
+	m.mu.Lock()
+	m.last = &result
+	m.mu.Unlock()
+	return result
+}
+
+// backupPath names the backup taken at, moving past any backup that already has the name
+func (m *BackupManager) backupPath(at time.Time) string {
+	for {
+		path := filepath.Join(m.dir, backupFilePrefix+at.Format(backupNameLayout)+backupFileSuffix)
+		if _, err := os.Stat(path); err != nil {
+			return path
+		}
+		at = at.Add(time.Microsecond)
+	}
+}
+
+func (m *BackupManager) backup(ctx context.Context, result *BackupResult) error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := m.handler.Backup(ctx, result.Path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(result.Path); err == nil {
+		result.Bytes = info.Size()
+	}
+
+	if m.keep == 0 {
+		return nil
+	}
+	files, err := m.List()
+	if err != nil {
+		return err
+	}
+	for _, file := range files[min(m.keep, len(files)):] {
+		if err := os.Remove(filepath.Join(m.dir, file.Name)); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		result.Removed++
+	}
+	return nil
+}
+
+// List returns the backups in the backup directory, newest first
+func (m *BackupManager) List() ([]BackupFile, error) {
+	entries, err := os.ReadDir(m.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	files := []BackupFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, backupFilePrefix)
+		if !ok || !strings.HasSuffix(stamp, backupFileSuffix) {
+			continue
+		}
+		created, err := time.Parse(backupTimeLayout, strings.TrimSuffix(stamp, backupFileSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, BackupFile{Name: name, Bytes: info.Size(), CreatedAt: created})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt.After(files[j].CreatedAt)
+	})
+	return files, nil
+}
+
+// LastResult returns the result of the most recent backup, or nil if none ran yet
+func (m *BackupManager) LastResult() *BackupResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
@@ -0,0 +1,72 @@
+package dbhandler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fileBackupper writes an empty backup, failing like VACUUM INTO when the file exists
+type fileBackupper struct{}
+
+func (fileBackupper) Backup(ctx context.Context, path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func TestBackupsInTheSameSecond(t *testing.T) {
+	m := &BackupManager{handler: fileBackupper{}, dir: t.TempDir()}
+	for i := 0; i < 3; i++ {
+		if result := m.RunOnce(context.Background()); result.Err != nil {
+			t.Fatalf("backup %d: %v", i, result.Err)
+		}
+	}
+	files, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("listed %d backups, want 3", len(files))
+	}
+}
+
+func TestBackupList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"loglite-20250131-120000.db",
+		"loglite-20250131-120000.500000.db",
+		"loglite-20250130-120000.db",
+		"loglite-notatime.db",
+		"other.db",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := (&BackupManager{dir: dir}).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name string
+		at   time.Time
+	}{
+		{"loglite-20250131-120000.500000.db", time.Date(2025, 1, 31, 12, 0, 0, 500000000, time.UTC)},
+		{"loglite-20250131-120000.db", time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"loglite-20250130-120000.db", time.Date(2025, 1, 30, 12, 0, 0, 0, time.UTC)},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("got %v", files)
+	}
+	for i, w := range want {
+		if files[i].Name != w.name || !files[i].CreatedAt.Equal(w.at) {
+			t.Errorf("file %d: got %s at %s, want %s at %s", i, files[i].Name, files[i].CreatedAt, w.name, w.at)
+		}
+	}
+}
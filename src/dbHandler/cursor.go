@@ -0,0 +1,104 @@
+package dbhandler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursor is a position in the logs ordered by timestamp, ties broken by id. Paging with
+// Query.After and Query.Before continues exactly where the last page ended, even while new
+// logs arrive, and does not get slower the deeper it goes like an offset does.
+type Cursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// String encodes the cursor for URLs, ParseCursor reads it back
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d_%d", c.Timestamp.UnixNano(), c.ID)
+}
+
+// ParseCursor reads a cursor written by Cursor.String
+func ParseCursor(text string) (Cursor, error) {
+	ts, id, ok := strings.Cut(text, "_")
+	nanos, err1 := strconv.ParseInt(ts, 10, 64)
+	n, err2 := strconv.ParseInt(id, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", text)
+	}
+	return Cursor{Timestamp: time.Unix(0, nanos).UTC(), ID: n}, nil
+}
+
+// CursorOf returns the position of a log row returned by a query
+func CursorOf(row map[string]interface{}) (Cursor, bool) {
+	var ts time.Time
+	switch t := row["timestamp"].(type) {
+	case time.Time:
+		ts = t
+	case string:
+		ts = parseStoredTimestamp(t)
+	}
+	id, ok := numericValue(row["id"])
+	if ts.IsZero() || !ok {
+		return Cursor{}, false
+	}
+	n, ok := id.(int64)
+	if !ok {
+		n = int64(id.(float64))
+	}
+	return Cursor{Timestamp: ts.UTC(), ID: n}, true
+}
+
+// passedBy reports whether a row comes after the cursor in timestamp order
+func (c Cursor) passedBy(get fieldGetter, ascending bool) bool {
+	ts, ok := get("timestamp").(time.Time)
+	if !ok {
+		return false
+	}
+	cmp := ts.Compare(c.Timestamp)
+	if cmp == 0 {
+		cmp = compareValues(get("id"), c.ID)
+	}
+	if ascending {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// cursorRulesOut reports whether none of the logs from first to last (both included) can
+// come after the query's cursor, so a file or partition holding them need not be read
+func (q Query) cursorRulesOut(first, last time.Time) bool {
+	if q.After == nil {
+		return false
+	}
+	if q.Ascending {
+		return last.Before(q.After.Timestamp)
+	}
+	return first.After(q.After.Timestamp)
+}
+
+// cursorOperator compares (timestamp, id) with a cursor in SQL to keep the rows after it
+func cursorOperator(ascending bool) string {
+	if ascending {
+		return ">"
+	}
+	return "<"
+}
+
+// keysetQuery turns a query for the page before a cursor into the query for the page after
+// it in the opposite order, so backends only implement After. The rows it returns have to be
+// reversed back into the order of the original query.
+func keysetQuery(q Query) Query {
+	q.After, q.Before = q.Before, nil
+	q.Ascending = !q.Ascending
+	return q
+}
+
+// reverseRows reverses rows in place
+func reverseRows(rows []map[string]interface{}) {
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+}
@@ -0,0 +1,63 @@
+package dbhandler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []Cursor{
+		{Timestamp: testStart, ID: 1},
+		{Timestamp: testStart.Add(123456789), ID: 42},
+		{Timestamp: time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC), ID: 7},
+		{Timestamp: time.Unix(0, 0).UTC(), ID: 0},
+	} {
+		text := c.String()
+		got, err := ParseCursor(text)
+		if err != nil {
+			t.Errorf("ParseCursor(%q): %v", text, err)
+			continue
+		}
+		if !got.Timestamp.Equal(c.Timestamp) || got.ID != c.ID {
+			t.Errorf("ParseCursor(%q) = %+v, want %+v", text, got, c)
+		}
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	for _, text := range []string{"", "_", "123", "123_", "_4", "abc_4", "123_x", "1.5_4", "123_4_5"} {
+		if got, err := ParseCursor(text); err == nil {
+			t.Errorf("ParseCursor(%q) = %+v, want an error", text, got)
+		}
+	}
+}
+
+func TestCursorOf(t *testing.T) {
+	tests := []struct {
+		name string
+		row  map[string]interface{}
+		want Cursor
+		ok   bool
+	}{
+		{"time", map[string]interface{}{"timestamp": testStart, "id": int64(3)}, Cursor{testStart, 3}, true},
+		{"local time", map[string]interface{}{"timestamp": testStart.In(time.FixedZone("CET", 3600)), "id": int64(3)}, Cursor{testStart, 3}, true},
+		{"stored text", map[string]interface{}{"timestamp": "2025-01-31 12:00:00", "id": int64(3)}, Cursor{testStart, 3}, true},
+		{"RFC3339 text", map[string]interface{}{"timestamp": "2025-01-31T12:00:00.5Z", "id": 3}, Cursor{testStart.Add(500 * time.Millisecond), 3}, true},
+		{"float id", map[string]interface{}{"timestamp": testStart, "id": float64(3)}, Cursor{testStart, 3}, true},
+		{"no id", map[string]interface{}{"timestamp": testStart}, Cursor{}, false},
+		{"text id", map[string]interface{}{"timestamp": testStart, "id": "3"}, Cursor{}, false},
+		{"no timestamp", map[string]interface{}{"id": int64(3)}, Cursor{}, false},
+		{"bad timestamp", map[string]interface{}{"timestamp": "yesterday", "id": int64(3)}, Cursor{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := CursorOf(tt.row)
+			if ok != tt.ok || !got.Timestamp.Equal(tt.want.Timestamp) || got.ID != tt.want.ID {
+				t.Errorf("CursorOf(%v) = %+v, %v, want %+v, %v", tt.row, got, ok, tt.want, tt.ok)
+			}
+			if ok && got.Timestamp.Location() != time.UTC {
+				t.Errorf("CursorOf(%v) is not in UTC", tt.row)
+			}
+		})
+	}
+}
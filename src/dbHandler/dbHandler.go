@@ -9,20 +9,126 @@ import (
 type DBHandler interface {
 	Put(table string, data map[string]interface{}) error
 	Get(table string, conditions map[string]interface{}) ([]map[string]interface{}, error)
+	Query(q Query) ([]map[string]interface{}, error)
 	Close() error
 }
 
+// BatchPutter is implemented by handlers that insert many rows faster together than one by one
+type BatchPutter interface {
+	PutBatch(table string, rows []map[string]interface{}) error
+}
+
+// PutBatch inserts rows with the handler's bulk insert when it has one
+func PutBatch(db DBHandler, table string, rows []map[string]interface{}) error {
+	if batcher, ok := db.(BatchPutter); ok {
+		return batcher.PutBatch(table, rows)
+	}
+	for _, row := range rows {
+		if err := db.Put(table, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wrapper is implemented by handlers that add behaviour on top of another handler, like
+// encryption. Optional capabilities such as Retainer are looked up on the handler underneath.
+type Wrapper interface {
+	Unwrap() DBHandler
+}
+
+// Capability returns the handler, or the first handler it wraps, as an optional capability
+func Capability[T any](db DBHandler) (T, bool) {
+	for db != nil {
+		if c, ok := db.(T); ok {
+			return c, true
+		}
+		wrapper, ok := db.(Wrapper)
+		if !ok {
+			break
+		}
+		db = wrapper.Unwrap()
+	}
+	var none T
+	return none, false
+}
+
+// newArchivingHandler puts the archive configured in the config behind a SQLite handler
+func newArchivingHandler(sqlite *SQLiteHandler, archive confighandler.Archive) (*TieredHandler, error) {
+	after, err := confighandler.ParseDuration(archive.After)
+	if err != nil {
+		return nil, err
+	}
+	var opts []TieredOption
+	if archive.Interval != "" {
+		interval, err := confighandler.ParseDuration(archive.Interval)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithArchiveInterval(interval))
+	}
+	return NewTieredHandler(sqlite, archive.Dir, after, opts...)
+}
+
+// sqliteOptions are the options the configured SQLite database is opened with
+func sqliteOptions(config *confighandler.Config) []SQLiteOption {
+	return []SQLiteOption{WithPromotedFields(config.Database.PromotedFields...), WithWriteAheadLog(), WithRollups()}
+}
+
 func NewDBHandler(config *confighandler.Config) (DBHandler, error) {
 	var err error
 	var dbHandler DBHandler
+	promoted := WithPromotedFields(config.Database.PromotedFields...)
 	switch config.Database.Type {
 	case "SQLite":
-		dbHandler, err = NewSQLiteHandler(config.Database.SQLiteFilepath)
+		var sqlite *SQLiteHandler
+		sqlite, err = NewSQLiteHandler(config.Database.SQLiteFilepath, sqliteOptions(config)...)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing SQLite handler: %v", err)
 		}
+		dbHandler = sqlite
+		if config.Database.Archive.After != "" {
+			dbHandler, err = newArchivingHandler(sqlite, config.Database.Archive)
+			if err != nil {
+				sqlite.Close()
+				return nil, fmt.Errorf("error initializing archive: %v", err)
+			}
+		}
+	case "PartitionedSQLite":
+		dbHandler, err = NewPartitionedSQLiteHandler(config.Database.PartitionDir, config.Database.PartitionBy, promoted)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing partitioned SQLite handler: %v", err)
+		}
+	case "PostgreSQL":
+		dbHandler, err = NewPostgresHandler(config.Database.PostgresURL, WithMaxConns(config.Database.PostgresMaxConns))
+		if err != nil {
+			return nil, fmt.Errorf("error initializing PostgreSQL handler: %v", err)
+		}
+	case "Memory":
+		var opts []MemoryOption
+		if config.Database.MemorySnapshot != "" {
+			opts = append(opts, WithSnapshot(config.Database.MemorySnapshot))
+		}
+		dbHandler, err = NewMemoryHandler(config.Database.MemoryCapacity, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing in-memory handler: %v", err)
+		}
+	case "Segment":
+		dbHandler, err = NewSegmentHandler(config.Database.SegmentDir, config.Database.SegmentWindow)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing segment handler: %v", err)
+		}
 	default:
 		return nil, fmt.Errorf("unsupported database type %s", config.Database.Type)
 	}
+
+	if config.Database.Encryption.Enabled() {
+		keys, err := LoadKeyring(config.Database.Encryption)
+		if err != nil {
+			dbHandler.Close()
+			return nil, fmt.Errorf("error initializing encryption: %v", err)
+		}
+		dbHandler = NewEncryptedHandler(dbHandler, keys)
+	}
 	return dbHandler, nil
 }
\ No newline at end of file
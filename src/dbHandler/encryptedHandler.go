@@ -0,0 +1,253 @@
+package dbhandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// encryptedScanPage is how many logs a query that filters on encrypted fields decrypts at a time
+const encryptedScanPage = 1000
+
+// LogRewriter is implemented by handlers that can change stored logs in place
+type LogRewriter interface {
+	// RewriteLogs passes the columns of every log to rewrite and stores the values it returns,
+	// it returns how many logs changed
+	RewriteLogs(ctx context.Context, columns []string, rewrite func(values map[string]interface{}) (map[string]interface{}, error)) (int64, error)
+}
+
+// EncryptedHandler encrypts the message and metadata of logs before another handler stores
+// them and decrypts them when they are read. Everything the handler underneath writes, from
+// its write-ahead log to archives, snapshots and backups, only holds the encrypted values.
+// Filters on encrypted fields cannot run in the database, so those queries read the logs in
+// the time range and evaluate the filters here.
+type EncryptedHandler struct {
+	inner DBHandler
+	keys  *Keyring
+}
+
+// NewEncryptedHandler encrypts the logs stored in inner with the keys
+func NewEncryptedHandler(inner DBHandler, keys *Keyring) *EncryptedHandler {
+	return &EncryptedHandler{inner: inner, keys: keys}
+}
+
+// Unwrap returns the handler the logs are stored in
+func (h *EncryptedHandler) Unwrap() DBHandler {
+	return h.inner
+}
+
+// encryptRow returns a copy of data with the encrypted fields sealed
+func (h *EncryptedHandler) encryptRow(data map[string]interface{}) (map[string]interface{}, error) {
+	row := make(map[string]interface{}, len(data))
+	for col, val := range data {
+		row[col] = val
+	}
+	for _, field := range encryptedFields {
+		val := row[field]
+		if val == nil {
+			continue
+		}
+		text, ok := storedText(val)
+		if !ok {
+			encoded, err := json.Marshal(val)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %s: %w", field, err)
+			}
+			text = string(encoded)
+		}
+		sealed, err := h.keys.encrypt(field, text)
+		if err != nil {
+			return nil, err
+		}
+		row[field] = sealed
+	}
+	return row, nil
+}
+
+// decryptRows opens the encrypted fields of rows in place
+func (h *EncryptedHandler) decryptRows(rows []map[string]interface{}) error {
+	for _, row := range rows {
+		for _, field := range encryptedFields {
+			text, ok := storedText(row[field])
+			if !ok {
+				continue
+			}
+			plain, err := h.keys.decrypt(field, text)
+			if err != nil {
+				return fmt.Errorf("failed to read log %v: %w", row["id"], err)
+			}
+			row[field] = plain
+		}
+	}
+	return nil
+}
+
+func (h *EncryptedHandler) Put(table string, data map[string]interface{}) error {
+	row, err := h.encryptRow(data)
+	if err != nil {
+		return err
+	}
+	return h.inner.Put(table, row)
+}
+
+func (h *EncryptedHandler) PutBatch(table string, rows []map[string]interface{}) error {
+	sealed := make([]map[string]interface{}, len(rows))
+	for i, data := range rows {
+		row, err := h.encryptRow(data)
+		if err != nil {
+			return err
+		}
+		sealed[i] = row
+	}
+	return PutBatch(h.inner, table, sealed)
+}
+
+func (h *EncryptedHandler) Get(table string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	return h.Query(queryFromConditions(table, conditions))
+}
+
+// Query runs queries that only use plain fields in the database, others are evaluated on
+// the decrypted logs
+func (h *EncryptedHandler) Query(q Query) ([]map[string]interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	if q.Before != nil {
+		q = keysetQuery(q)
+		rows, err := h.Query(q)
+		reverseRows(rows)
+		return rows, err
+	}
+	if !readsEncrypted(q) {
+		rows, err := h.inner.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		return rows, h.decryptRows(rows)
+	}
+	return h.scan(q)
+}
+
+// readsEncrypted reports whether a query filters, groups, sorts or aggregates on an
+// encrypted field
+func readsEncrypted(q Query) bool {
+	if len(q.Text) > 0 || isEncryptedField(q.OrderBy) {
+		return true
+	}
+	for _, c := range q.Conditions {
+		if isEncryptedField(c.Field) {
+			return true
+		}
+	}
+	for _, field := range q.GroupBy {
+		if isEncryptedField(field) {
+			return true
+		}
+	}
+	for _, a := range q.Aggregates {
+		if isEncryptedField(a.Field) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan lets the database select logs on the plain conditions and time range, reads them page
+// by page in timestamp order and evaluates the whole query on the decrypted logs. Queries for
+// the newest or oldest logs stop as soon as their page is full.
+func (h *EncryptedHandler) scan(q Query) ([]map[string]interface{}, error) {
+	base := Query{Table: q.Table, Since: q.Since, Until: q.Until, OrderBy: "timestamp", Limit: encryptedScanPage}
+	for _, c := range q.Conditions {
+		if !isEncryptedField(c.Field) {
+			base.Conditions = append(base.Conditions, c)
+		}
+	}
+	ordered := !q.IsAggregate() && q.OrderBy == "timestamp"
+	if ordered {
+		base.Ascending, base.After = q.Ascending, q.After
+	}
+
+	var counter *groupCounter
+	if q.IsAggregate() {
+		counter = newGroupCounter(q)
+	}
+	matches := []map[string]interface{}{}
+	for {
+		rows, err := h.inner.Query(base)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.decryptRows(rows); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			get := rowGetter(row)
+			if !matchQuery(q, get) {
+				continue
+			}
+			if counter != nil {
+				counter.add(get)
+			} else {
+				matches = append(matches, row)
+			}
+		}
+
+		if len(rows) < base.Limit || (ordered && q.Limit > 0 && len(matches) >= q.Offset+q.Limit) {
+			break
+		}
+		last, ok := CursorOf(rows[len(rows)-1])
+		if !ok {
+			return nil, errors.New("failed to page through encrypted logs: missing timestamp or id")
+		}
+		base.After = &last
+	}
+
+	if counter != nil {
+		return counter.rows(), nil
+	}
+	if !ordered {
+		// Without an order, rows come back in insertion order like SQLite's rowid order
+		field, ascending := q.OrderBy, q.Ascending
+		if field == "" {
+			field, ascending = "id", true
+		}
+		sortRows(matches, field, ascending)
+	}
+	return pageRows(matches, q.Limit, q.Offset), nil
+}
+
+// Rekey encrypts every stored log that is plain or uses an older key with the primary key,
+// after which the older keys can be removed from the keyring. Logs already moved to an archive
+// keep their key, so keep it until the archive files expire.
+func (h *EncryptedHandler) Rekey(ctx context.Context) (int64, error) {
+	rewriter, ok := Capability[LogRewriter](h.inner)
+	if !ok {
+		return 0, errors.New("re-encrypting stored logs is only supported with the SQLite database type, keep the older keys in the keyring instead")
+	}
+	primary := h.keys.PrimaryKeyID()
+	return rewriter.RewriteLogs(ctx, encryptedFields, func(values map[string]interface{}) (map[string]interface{}, error) {
+		changed := map[string]interface{}{}
+		for _, field := range encryptedFields {
+			text, ok := storedText(values[field])
+			if !ok {
+				continue
+			}
+			if id, encrypted := keyOf(text); encrypted && id == primary {
+				continue
+			}
+			plain, err := h.keys.decrypt(field, text)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read log %v: %w", values["id"], err)
+			}
+			if changed[field], err = h.keys.encrypt(field, plain); err != nil {
+				return nil, err
+			}
+		}
+		return changed, nil
+	})
+}
+
+func (h *EncryptedHandler) Close() error {
+	return h.inner.Close()
+}
@@ -0,0 +1,187 @@
+package dbhandler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+)
+
+// encryptedPrefix starts every encrypted value, followed by the key id and the sealed value.
+// Values without it were stored before encryption was enabled and are read as they are.
+const encryptedPrefix = "enc:v1:"
+
+// encryptedFields are the columns that hold customer data and are encrypted
+var encryptedFields = []string{"message", "metadata"}
+
+// validKeyID matches the ids keys are named by in a keyring
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// isEncryptedField reports whether the database only holds a field encrypted, so it cannot
+// filter, group or sort on it
+func isEncryptedField(field string) bool {
+	return field == "message" || field == "metadata" || IsMetadataField(field)
+}
+
+type encryptionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring holds the AES-256-GCM keys logs are encrypted with. The first key encrypts new
+// values and every key decrypts, so a key is rotated by putting a new one first and keeping
+// the old one until no stored value uses it.
+type Keyring struct {
+	keys []encryptionKey
+	byID map[string]cipher.AEAD
+}
+
+// ParseKeyring reads keys written as <id>:<base64 of 32 bytes>, separated by newlines or
+// commas. Blank lines and lines starting with # are skipped.
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{byID: map[string]cipher.AEAD{}}
+	entries := strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || !validKeyID.MatchString(id) {
+			return nil, fmt.Errorf("invalid encryption key %d (use <id>:<base64 of 32 bytes>)", len(k.keys)+1)
+		}
+		if _, ok := k.byID[id]; ok {
+			return nil, fmt.Errorf("encryption key %s is listed twice", id)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(secret) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes encoded as base64", id)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key %s: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key %s: %w", id, err)
+		}
+		k.keys = append(k.keys, encryptionKey{id: id, aead: aead})
+		k.byID[id] = aead
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("no encryption keys found")
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the keys from the key file or environment variable in the config
+func LoadKeyring(config confighandler.Encryption) (*Keyring, error) {
+	var text string
+	switch {
+	case config.KeyFile != "":
+		data, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		text = string(data)
+	case config.KeyEnv != "":
+		text = os.Getenv(config.KeyEnv)
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("encryption key variable %s is not set", config.KeyEnv)
+		}
+	default:
+		return nil, errors.New("no encryption key file or variable configured")
+	}
+
+	keys, err := ParseKeyring(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	return keys, nil
+}
+
+// GenerateKey returns a new random key as a keyring line
+func GenerateKey(id string) (string, error) {
+	if !validKeyID.MatchString(id) {
+		return "", fmt.Errorf("invalid key id %q (use letters, digits, _ or -)", id)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(secret), nil
+}
+
+// PrimaryKeyID returns the id of the key new values are encrypted with
+func (k *Keyring) PrimaryKeyID() string {
+	return k.keys[0].id
+}
+
+// encrypt seals a value of a field with the primary key. The field is authenticated too, so
+// an encrypted message cannot be passed off as metadata.
+func (k *Keyring) encrypt(field, value string) (string, error) {
+	key := k.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return encryptedPrefix + key.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// keyOf returns the id of the key a stored value is encrypted with, false for plain values
+func keyOf(value string) (string, bool) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	return id, ok
+}
+
+// decrypt opens a value encrypted by encrypt, plain values are returned unchanged
+func (k *Keyring) decrypt(field, value string) (string, error) {
+	id, ok := keyOf(value)
+	if !ok {
+		return value, nil
+	}
+	aead, ok := k.byID[id]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with key %s, which is not in the keyring", field, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(value[len(encryptedPrefix)+len(id)+1:])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("failed to decrypt %s: malformed value", field)
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s with key %s: %w", field, id, err)
+	}
+	return string(plain), nil
+}
+
+// storedText returns the text of an encrypted field as it is read back from the database.
+// PostgreSQL keeps metadata as JSONB, where the encrypted value is a JSON string.
+func storedText(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, `"`+encryptedPrefix) {
+			var unquoted string
+			if err := json.Unmarshal([]byte(v), &unquoted); err == nil {
+				return unquoted, true
+			}
+		}
+		return v, true
+	case []byte:
+		return storedText(string(v))
+	}
+	return "", false
+}
@@ -0,0 +1,144 @@
+package dbhandler
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testKeyA = "a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	testKeyB = "b:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	// Same id as testKeyA, different secret
+	testKeyAOther = "a:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+)
+
+func keyring(t *testing.T, text string) *Keyring {
+	t.Helper()
+	keys, err := ParseKeyring(text)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	return keys
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		primary string
+		err     string
+	}{
+		{"one", testKeyA, "a", ""},
+		{"lines", "# keys\n" + testKeyB + "\n\n" + testKeyA + "\n", "b", ""},
+		{"commas", testKeyA + ", " + testKeyB, "a", ""},
+		{"empty", "\n# none\n", "", "no encryption keys found"},
+		{"no id", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "", "invalid encryption key 1"},
+		{"bad id", "a b:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "", "invalid encryption key 1"},
+		{"twice", testKeyA + "\n" + testKeyAOther, "", "listed twice"},
+		{"short", "a:AAAA", "", "must be 32 bytes"},
+		{"not base64", "a:not base64!", "", "must be 32 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeyring(tt.text)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys.PrimaryKeyID() != tt.primary {
+				t.Errorf("primary key %s, want %s", keys.PrimaryKeyID(), tt.primary)
+			}
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys := keyring(t, testKeyA)
+	sealed, err := keys.encrypt("message", "card 4242")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, encryptedPrefix+"a:") || strings.Contains(sealed, "4242") {
+		t.Fatalf("sealed value %q", sealed)
+	}
+	again, _ := keys.encrypt("message", "card 4242")
+	if again == sealed {
+		t.Error("encrypting twice gave the same value, the nonce is not random")
+	}
+
+	tests := []struct {
+		name  string
+		keys  string
+		field string
+		value string
+		want  string
+		err   string
+	}{
+		{"same key", testKeyA, "message", sealed, "card 4242", ""},
+		{"rotated", testKeyB + "\n" + testKeyA, "message", sealed, "card 4242", ""},
+		{"plain value", testKeyA, "message", "stored before encryption", "stored before encryption", ""},
+		{"missing key", testKeyB, "message", sealed, "", "key a, which is not in the keyring"},
+		{"wrong key", testKeyAOther, "message", sealed, "", "failed to decrypt message with key a"},
+		{"other field", testKeyA, "metadata", sealed, "", "failed to decrypt metadata with key a"},
+		{"tampered", testKeyA, "message", sealed[:len(sealed)-2] + "AA", "", "failed to decrypt message"},
+		{"truncated", testKeyA, "message", encryptedPrefix + "a:AAAA", "", "malformed value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keyring(t, tt.keys).decrypt(tt.field, tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got %q, error %v, want an error containing %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, error %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// The handler underneath only sees encrypted values, reads through the keyring get them back
+func TestEncryptedHandlerRoundTrip(t *testing.T) {
+	inner, _ := NewMemoryHandler(10)
+	db := NewEncryptedHandler(inner, keyring(t, testKeyA))
+	putLogs(t, db, "INFO", "ERROR")
+
+	stored, _ := inner.Query(Query{OrderBy: "id", Ascending: true})
+	for _, row := range stored {
+		for _, field := range encryptedFields {
+			if text, _ := row[field].(string); !strings.HasPrefix(text, encryptedPrefix) {
+				t.Errorf("%s stored as %v", field, row[field])
+			}
+		}
+	}
+
+	rows, err := db.Query(Query{OrderBy: "id", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(rows); got != "log 0,log 1" {
+		t.Errorf("got %q", got)
+	}
+	if rows[1]["metadata"] != `{"n":1}` {
+		t.Errorf("metadata %v", rows[1]["metadata"])
+	}
+
+	// Filters on encrypted fields run on the decrypted logs
+	rows, err = db.Query(Query{Text: []string{"log 1"}})
+	if err != nil || messages(rows) != "log 1" {
+		t.Errorf("text search: %v, %v", rows, err)
+	}
+
+	// Without the key the logs cannot be read
+	_, err = NewEncryptedHandler(inner, keyring(t, testKeyB)).Query(Query{})
+	if err == nil || !strings.Contains(err.Error(), "not in the keyring") {
+		t.Errorf("reading with another key: %v", err)
+	}
+}
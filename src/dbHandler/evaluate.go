@@ -0,0 +1,296 @@
+package dbhandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// fieldGetter returns the value of a column or metadata path of a row, nil when it is missing
+type fieldGetter func(field string) interface{}
+
+// parseMetadata decodes stored metadata so paths can be looked up, nil if it is not a JSON object
+func parseMetadata(value interface{}) map[string]interface{} {
+	var text []byte
+	switch v := value.(type) {
+	case string:
+		text = []byte(v)
+	case []byte:
+		text = v
+	default:
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	var meta map[string]interface{}
+	if err := decoder.Decode(&meta); err != nil {
+		return nil
+	}
+	return meta
+}
+
+// metadataLookup finds a metadata path and converts the value the way SQLite's json_extract does:
+// integers stay integers, booleans become 1 or 0 and objects and arrays become JSON text
+func metadataLookup(meta map[string]interface{}, field string) interface{} {
+	var value interface{} = meta
+	for _, key := range metadataKeys(field) {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if value, ok = object[key]; !ok {
+			return nil
+		}
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case map[string]interface{}, []interface{}:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+	return value
+}
+
+// rowGetter looks fields up in a row as returned by a query, parsing the metadata on first use
+func rowGetter(row map[string]interface{}) fieldGetter {
+	var meta map[string]interface{}
+	parsed := false
+	return func(field string) interface{} {
+		if !IsMetadataField(field) {
+			return row[field]
+		}
+		if !parsed {
+			meta, parsed = parseMetadata(row["metadata"]), true
+		}
+		return metadataLookup(meta, field)
+	}
+}
+
+// comparable converts a query value so it compares against stored values of the field.
+// Timestamps are held as time.Time, so text such as "2024-01-02" is parsed.
+func comparable(field string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case time.Time:
+		return v.UTC()
+	case string:
+		if isTimeColumn(field) {
+			for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	return value
+}
+
+// textOf renders a value as SQLite would when it is used as text, e.g. in LIKE
+func textOf(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.UTC().Format(TimestampLayout)
+	}
+	return fmt.Sprint(value)
+}
+
+// containsFold is SQLite's LIKE '%term%', which ignores case for ASCII letters
+func containsFold(value interface{}, term string) bool {
+	return strings.Contains(strings.ToLower(textOf(value)), strings.ToLower(term))
+}
+
+// matchCondition evaluates one condition. Comparisons with NULL never match, so negating them does.
+func matchCondition(c Condition, get fieldGetter) bool {
+	value := get(c.Field)
+	matched := false
+	if value != nil {
+		switch c.Op {
+		case OpEq, OpNotEq, OpGt, OpGte, OpLt, OpLte:
+			cmp := compareValues(value, comparable(c.Field, c.Value))
+			switch c.Op {
+			case OpEq:
+				matched = cmp == 0
+			case OpNotEq:
+				matched = cmp != 0
+			case OpGt:
+				matched = cmp > 0
+			case OpGte:
+				matched = cmp >= 0
+			case OpLt:
+				matched = cmp < 0
+			case OpLte:
+				matched = cmp <= 0
+			}
+		case OpContains:
+			matched = containsFold(value, fmt.Sprint(c.Value))
+		case OpIn:
+			for _, v := range c.Value.([]interface{}) {
+				if compareValues(value, comparable(c.Field, v)) == 0 {
+					matched = true
+					break
+				}
+			}
+		}
+	}
+	if c.Negate {
+		return !matched
+	}
+	return matched
+}
+
+// matchQuery reports whether a row passes the filters of a query
+func matchQuery(q Query, get fieldGetter) bool {
+	for _, c := range q.Conditions {
+		if !matchCondition(c, get) {
+			return false
+		}
+	}
+	if len(q.Text) > 0 {
+		message := get("message")
+		for _, term := range q.Text {
+			if message == nil || !containsFold(message, term) {
+				return false
+			}
+		}
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		ts, ok := get("timestamp").(time.Time)
+		if !ok {
+			return false
+		}
+		if !q.Since.IsZero() && ts.Before(q.Since) {
+			return false
+		}
+		if !q.Until.IsZero() && !ts.Before(q.Until) {
+			return false
+		}
+	}
+	if q.After != nil && !q.After.passedBy(get, q.Ascending) {
+		return false
+	}
+	return true
+}
+
+// Matches reports whether a log row passes the filters of a query, for logs that are checked
+// outside a handler such as the ones pushed to the live tail
+func Matches(q Query, row map[string]interface{}) bool {
+	return matchQuery(q, rowGetter(row))
+}
+
+// groupCounter counts rows per distinct combination of the query's GroupBy values and time
+// bucket, and computes the query's aggregates per group
+type groupCounter struct {
+	q      Query
+	fields []string // Fields the aggregates read
+	groups map[string]*countGroup
+	order  []string
+}
+
+// countGroup is one result row being counted
+type countGroup struct {
+	row   map[string]interface{}
+	stats map[string]*fieldStats
+}
+
+func newGroupCounter(q Query) *groupCounter {
+	return &groupCounter{q: q, fields: aggregateFields(q.Aggregates), groups: map[string]*countGroup{}}
+}
+
+// group finds or creates the group with the given GroupBy values and bucket
+func (g *groupCounter) group(values []interface{}, bucket interface{}) *countGroup {
+	keyParts := make([]string, len(values), len(values)+1)
+	for i, value := range values {
+		keyParts[i] = fmt.Sprintf("%T:%v", value, value)
+	}
+	if g.q.Bucket > 0 {
+		keyParts = append(keyParts, fmt.Sprint(bucket))
+	}
+	key := strings.Join(keyParts, "\x00")
+
+	group, ok := g.groups[key]
+	if !ok {
+		group = &countGroup{row: map[string]interface{}{"count": int64(0)}, stats: map[string]*fieldStats{}}
+		for i, field := range g.q.GroupBy {
+			group.row[field] = values[i]
+		}
+		if g.q.Bucket > 0 {
+			group.row[BucketColumn] = bucket
+		}
+		for _, field := range g.fields {
+			group.stats[field] = &fieldStats{}
+		}
+		g.groups[key] = group
+		g.order = append(g.order, key)
+	}
+	return group
+}
+
+// add counts one log, get returns its fields
+func (g *groupCounter) add(get fieldGetter) {
+	values := make([]interface{}, len(g.q.GroupBy))
+	for i, field := range g.q.GroupBy {
+		values[i] = get(field)
+	}
+	var bucket interface{}
+	if g.q.Bucket > 0 {
+		ts, ok := get("timestamp").(time.Time)
+		if !ok {
+			return
+		}
+		bucket = BucketStart(ts, g.q.Bucket)
+	}
+
+	group := g.group(values, bucket)
+	group.row["count"] = group.row["count"].(int64) + 1
+	for _, field := range g.fields {
+		group.stats[field].add(get(field))
+	}
+}
+
+// addCounts adds a row returned by the mergeableQuery of the counter's query, which already
+// holds the count and aggregate parts of a group
+func (g *groupCounter) addCounts(row map[string]interface{}) {
+	values := make([]interface{}, len(g.q.GroupBy))
+	for i, field := range g.q.GroupBy {
+		// Count rows hold the group values under the field names, metadata paths included
+		values[i] = row[field]
+	}
+
+	group := g.group(values, row[BucketColumn])
+	group.row["count"] = group.row["count"].(int64) + int64(toFloat(row["count"]))
+	for _, field := range g.fields {
+		group.stats[field].merge(row, field)
+	}
+}
+
+// rows returns the counts sorted and paged like the SQL query would. Like SQL, a count
+// without groups returns a single row even when nothing matched.
+func (g *groupCounter) rows() []map[string]interface{} {
+	if len(g.q.GroupBy) == 0 && g.q.Bucket == 0 && len(g.order) == 0 {
+		g.group(nil, nil)
+	}
+	rows := make([]map[string]interface{}, 0, len(g.order))
+	for _, key := range g.order {
+		group := g.groups[key]
+		for _, a := range g.q.Aggregates {
+			group.row[a.Name()] = group.stats[a.Field].result(a.Func)
+		}
+		rows = append(rows, group.row)
+	}
+	return finishCounts(g.q, rows)
+}
@@ -0,0 +1,484 @@
+package dbhandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMemoryCapacity is how many logs the in-memory handler keeps when no capacity is configured
+const DefaultMemoryCapacity = 100_000
+
+// MemoryHandler keeps the newest logs in a fixed size ring buffer, evicting the oldest when full.
+// Nothing is written to disk unless a snapshot file is configured.
+type MemoryHandler struct {
+	mu       sync.RWMutex
+	capacity int
+	ring     []*memoryRow
+	head     int   // Slot of the oldest row
+	size     int   // Rows currently held
+	nextID   int64 // Ids are consecutive, so the oldest row has id nextID-size
+
+	byLevel  map[string]*queue[int64] // Ids in insertion order
+	bySource map[string]*queue[int64]
+	byTime   queue[timeEntry] // Sorted by timestamp, then id
+
+	snapshot string
+	closed   bool
+}
+
+// memoryRow is a stored log with its metadata parsed once for filtering
+type memoryRow struct {
+	values map[string]interface{}
+	meta   map[string]interface{}
+}
+
+func (r *memoryRow) get(field string) interface{} {
+	if IsMetadataField(field) {
+		return metadataLookup(r.meta, field)
+	}
+	return r.values[field]
+}
+
+type timeEntry struct {
+	ts time.Time
+	id int64
+}
+
+func (e timeEntry) before(other timeEntry) bool {
+	if e.ts.Equal(other.ts) {
+		return e.id < other.id
+	}
+	return e.ts.Before(other.ts)
+}
+
+// queue is a slice that is mostly removed from at the front. Removed items leave a gap at
+// the start of the backing array, which is closed once it is as long as the items left.
+type queue[T any] struct {
+	items []T
+	start int // Index of the first item
+}
+
+// all returns the items, only valid until the queue changes
+func (q *queue[T]) all() []T {
+	if q == nil {
+		return nil
+	}
+	return q.items[q.start:]
+}
+
+func (q *queue[T]) push(item T) {
+	q.items = append(q.items, item)
+}
+
+// remove drops the i-th item
+func (q *queue[T]) remove(i int) {
+	var zero T
+	if i > 0 {
+		items := q.all()
+		copy(items[i:], items[i+1:])
+		q.items[len(q.items)-1] = zero
+		q.items = q.items[:len(q.items)-1]
+		return
+	}
+	q.items[q.start] = zero
+	q.start++
+	if left := len(q.items) - q.start; q.start >= left {
+		copy(q.items, q.items[q.start:])
+		clear(q.items[left:])
+		q.items, q.start = q.items[:left], 0
+	}
+}
+
+// MemoryOption is a function that configures a MemoryHandler
+type MemoryOption func(*MemoryHandler)
+
+// WithSnapshot loads the logs in path on start and writes the buffer back to it on Close
+func WithSnapshot(path string) MemoryOption {
+	return func(h *MemoryHandler) {
+		h.snapshot = path
+	}
+}
+
+// NewMemoryHandler returns an empty handler holding at most capacity logs
+func NewMemoryHandler(capacity int, opts ...MemoryOption) (*MemoryHandler, error) {
+	if capacity <= 0 {
+		capacity = DefaultMemoryCapacity
+	}
+	h := &MemoryHandler{
+		capacity: capacity,
+		ring:     make([]*memoryRow, capacity),
+		nextID:   1,
+		byLevel:  map[string]*queue[int64]{},
+		bySource: map[string]*queue[int64]{},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.snapshot != "" {
+		if err := h.loadSnapshot(); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// newMemoryRow validates a row and converts its values to the types SQLite would return
+func newMemoryRow(data map[string]interface{}) (*memoryRow, error) {
+	values := make(map[string]interface{}, len(LogColumns))
+	for _, col := range LogColumns {
+		values[col] = nil
+	}
+
+	for col, val := range data {
+		if _, ok := values[col]; !ok || col == "id" {
+			return nil, fmt.Errorf("unknown column %s", col)
+		}
+		switch v := val.(type) {
+		case nil:
+			continue
+		case int:
+			val = int64(v)
+		case int32:
+			val = int64(v)
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				val = i
+			} else {
+				val = v.String()
+			}
+		}
+
+		switch col {
+		case "timestamp", "received_at":
+			t, ok := timeOf(val)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s %v", col, val)
+			}
+			val = t
+		case "metadata":
+			switch m := val.(type) {
+			case string:
+			case []byte:
+				val = string(m)
+			default:
+				encoded, err := json.Marshal(m)
+				if err != nil {
+					return nil, fmt.Errorf("failed to encode metadata: %w", err)
+				}
+				val = string(encoded)
+			}
+		case "length":
+		default:
+			// The remaining columns are text
+			if _, ok := val.(string); !ok {
+				val = fmt.Sprint(val)
+			}
+		}
+		values[col] = val
+	}
+
+	for _, col := range []string{"level", "message"} {
+		if values[col] == nil {
+			return nil, fmt.Errorf("%s is required", col)
+		}
+	}
+	if values["timestamp"] == nil {
+		values["timestamp"] = time.Now().UTC()
+	}
+
+	return &memoryRow{values: values, meta: parseMetadata(values["metadata"])}, nil
+}
+
+// Put inserts a log, evicting the oldest one when the buffer is full
+func (h *MemoryHandler) Put(table string, data map[string]interface{}) error {
+	if table != "logs" {
+		return fmt.Errorf("failed to insert data into %s: no such table", table)
+	}
+	row, err := newMemoryRow(data)
+	if err != nil {
+		return fmt.Errorf("failed to insert data into %s: %w", table, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return fmt.Errorf("failed to insert data into %s: handler is closed", table)
+	}
+	h.insert(row)
+	return nil
+}
+
+func (h *MemoryHandler) insert(row *memoryRow) {
+	if h.size == h.capacity {
+		h.evict()
+	}
+
+	id := h.nextID
+	h.nextID++
+	row.values["id"] = id
+	h.ring[(h.head+h.size)%h.capacity] = row
+	h.size++
+
+	addID(h.byLevel, row.values["level"].(string), id)
+	if source, ok := row.values["source"].(string); ok {
+		addID(h.bySource, source, id)
+	}
+
+	entry := timeEntry{ts: row.values["timestamp"].(time.Time), id: id}
+	times := h.byTime.all()
+	n := len(times)
+	if n == 0 || !entry.before(times[n-1]) {
+		h.byTime.push(entry)
+		return
+	}
+	// Logs mostly arrive in order, only late ones need to be moved into place
+	i := sort.Search(n, func(i int) bool { return entry.before(times[i]) })
+	h.byTime.push(timeEntry{})
+	times = h.byTime.all()
+	copy(times[i+1:], times[i:])
+	times[i] = entry
+}
+
+func addID(index map[string]*queue[int64], key string, id int64) {
+	ids, ok := index[key]
+	if !ok {
+		ids = &queue[int64]{}
+		index[key] = ids
+	}
+	ids.push(id)
+}
+
+// evict removes the oldest row. It is the first id in every index it appears in.
+func (h *MemoryHandler) evict() {
+	row := h.ring[h.head]
+	id := row.values["id"].(int64)
+	h.ring[h.head] = nil
+	h.head = (h.head + 1) % h.capacity
+	h.size--
+
+	dropFirst(h.byLevel, row.values["level"].(string))
+	if source, ok := row.values["source"].(string); ok {
+		dropFirst(h.bySource, source)
+	}
+
+	entry := timeEntry{ts: row.values["timestamp"].(time.Time), id: id}
+	times := h.byTime.all()
+	if i := sort.Search(len(times), func(i int) bool { return !times[i].before(entry) }); i < len(times) {
+		h.byTime.remove(i)
+	}
+}
+
+func dropFirst(index map[string]*queue[int64], key string) {
+	if ids := index[key]; len(ids.all()) > 1 {
+		ids.remove(0)
+	} else {
+		delete(index, key)
+	}
+}
+
+// row returns the row with the given id, or nil if it was evicted
+func (h *MemoryHandler) row(id int64) *memoryRow {
+	oldest := h.nextID - int64(h.size)
+	if id < oldest || id >= h.nextID {
+		return nil
+	}
+	return h.ring[(h.head+int(id-oldest))%h.capacity]
+}
+
+// Get supports the same equality conditions, limit, offset and orderBy as the SQLite handler
+func (h *MemoryHandler) Get(table string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	return h.Query(queryFromConditions(table, conditions))
+}
+
+// indexedCandidates returns the ids the level or source index narrows the query down to.
+// The smallest list wins, ok is false when no condition can use an index.
+func (h *MemoryHandler) indexedCandidates(q Query) ([]int64, bool) {
+	var best []int64
+	found := false
+	for _, c := range q.Conditions {
+		var index map[string]*queue[int64]
+		switch {
+		case c.Negate:
+			continue
+		case c.Field == "level":
+			index = h.byLevel
+		case c.Field == "source":
+			index = h.bySource
+		default:
+			continue
+		}
+
+		var ids []int64
+		switch c.Op {
+		case OpEq:
+			value, _ := c.Value.(string)
+			ids = index[value].all()
+		case OpIn:
+			for _, v := range c.Value.([]interface{}) {
+				value, _ := v.(string)
+				ids = append(ids, index[value].all()...)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		default:
+			continue
+		}
+		if !found || len(ids) < len(best) {
+			best, found = ids, true
+		}
+	}
+	return best, found
+}
+
+// timeRange returns the part of the time index inside the query's since, until and cursor
+func (h *MemoryHandler) timeRange(q Query) (int, int) {
+	times := h.byTime.all()
+	lo, hi := 0, len(times)
+	if !q.Since.IsZero() {
+		lo = sort.Search(len(times), func(i int) bool { return !times[i].ts.Before(q.Since) })
+	}
+	if !q.Until.IsZero() {
+		hi = sort.Search(len(times), func(i int) bool { return !times[i].ts.Before(q.Until) })
+	}
+	if q.After != nil {
+		cursor := timeEntry{ts: q.After.Timestamp, id: q.After.ID}
+		if q.Ascending {
+			lo = max(lo, sort.Search(len(times), func(i int) bool { return cursor.before(times[i]) }))
+		} else {
+			hi = min(hi, sort.Search(len(times), func(i int) bool { return !times[i].before(cursor) }))
+		}
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// Query runs a log query against the buffer with the same semantics as the SQLite handler
+func (h *MemoryHandler) Query(q Query) ([]map[string]interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	if q.Before != nil {
+		q = keysetQuery(q)
+		rows, err := h.Query(q)
+		reverseRows(rows)
+		return rows, err
+	}
+	if q.Table != "" && q.Table != "logs" {
+		return nil, fmt.Errorf("failed to run query: no such table %s", q.Table)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return nil, fmt.Errorf("failed to run query: handler is closed")
+	}
+
+	if ids, ok := h.indexedCandidates(q); ok {
+		return h.collect(q, ids), nil
+	}
+
+	times := h.byTime.all()
+	lo, hi := h.timeRange(q)
+	if q.IsAggregate() || q.OrderBy != "timestamp" {
+		ids := make([]int64, 0, hi-lo)
+		for _, entry := range times[lo:hi] {
+			ids = append(ids, entry.id)
+		}
+		return h.collect(q, ids), nil
+	}
+
+	// The time index is already in the requested order, so stop as soon as the page is full
+	results := []map[string]interface{}{}
+	skipped := 0
+	for i := 0; i < hi-lo; i++ {
+		entry := times[lo+i]
+		if !q.Ascending {
+			entry = times[hi-1-i]
+		}
+		row := h.row(entry.id)
+		if !matchQuery(q, row.get) {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		results = append(results, maps.Clone(row.values))
+		if q.Limit > 0 && len(results) == q.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// collect filters the candidate rows, then counts or sorts and pages them
+func (h *MemoryHandler) collect(q Query, ids []int64) []map[string]interface{} {
+	matches := []*memoryRow{}
+	for _, id := range ids {
+		if row := h.row(id); row != nil && matchQuery(q, row.get) {
+			matches = append(matches, row)
+		}
+	}
+
+	if q.IsAggregate() {
+		counter := newGroupCounter(q)
+		for _, row := range matches {
+			counter.add(row.get)
+		}
+		return counter.rows()
+	}
+
+	// Without an order, rows come back in insertion order like SQLite's rowid order
+	field, ascending := q.OrderBy, q.Ascending
+	if field == "" {
+		field, ascending = "id", true
+	}
+	keys := sortKeys(len(matches), func(i int) fieldGetter { return matches[i].get }, field)
+	order := sortedOrder(keys, ascending)
+
+	results := []map[string]interface{}{}
+	for i := q.Offset; i < len(order); i++ {
+		if q.Limit > 0 && len(results) == q.Limit {
+			break
+		}
+		results = append(results, maps.Clone(matches[order[i]].values))
+	}
+	return results
+}
+
+// StorageStats reports how many logs the buffer holds and their time range. Bytes stays zero
+// since nothing is on disk.
+func (h *MemoryHandler) StorageStats() (StorageStats, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := StorageStats{Rows: int64(h.size)}
+	if times := h.byTime.all(); len(times) > 0 {
+		stats.Oldest = times[0].ts
+		stats.Newest = times[len(times)-1].ts
+	}
+	return stats, nil
+}
+
+// Close writes the snapshot if one is configured. Puts and queries fail afterwards.
+func (h *MemoryHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	if h.snapshot != "" {
+		if err := h.writeSnapshot(); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+	return nil
+}
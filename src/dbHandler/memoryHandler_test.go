@@ -0,0 +1,233 @@
+package dbhandler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+
+// putLogs stores one log per level, a second apart, with messages "log 0", "log 1", ...
+func putLogs(t *testing.T, db DBHandler, levels ...string) {
+	t.Helper()
+	for i, level := range levels {
+		err := db.Put("logs", map[string]interface{}{
+			"timestamp": testStart.Add(time.Duration(i) * time.Second),
+			"level":     level,
+			"message":   "log " + string(rune('0'+i)),
+			"source":    "api",
+			"metadata":  map[string]interface{}{"n": i},
+		})
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+}
+
+func messages(rows []map[string]interface{}) string {
+	var msgs []string
+	for _, row := range rows {
+		msgs = append(msgs, row["message"].(string))
+	}
+	return strings.Join(msgs, ",")
+}
+
+func TestMemoryPutQuery(t *testing.T) {
+	db, err := NewMemoryHandler(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putLogs(t, db, "INFO", "ERROR", "INFO", "ERROR")
+
+	tests := []struct {
+		name string
+		q    Query
+		want string
+	}{
+		{"all newest first", Query{OrderBy: "timestamp"}, "log 3,log 2,log 1,log 0"},
+		{"ascending", Query{OrderBy: "timestamp", Ascending: true}, "log 0,log 1,log 2,log 3"},
+		{"level", Query{Conditions: []Condition{{Field: "level", Op: OpEq, Value: "ERROR"}}, OrderBy: "timestamp"}, "log 3,log 1"},
+		{"negated level", Query{Conditions: []Condition{{Field: "level", Op: OpEq, Value: "ERROR", Negate: true}}, OrderBy: "timestamp"}, "log 2,log 0"},
+		{"metadata", Query{Conditions: []Condition{{Field: "metadata.n", Op: OpGte, Value: int64(2)}}, OrderBy: "timestamp"}, "log 3,log 2"},
+		{"text", Query{Text: []string{"OG 2"}}, "log 2"},
+		{"since", Query{Since: testStart.Add(2 * time.Second), OrderBy: "timestamp"}, "log 3,log 2"},
+		{"limit and offset", Query{OrderBy: "timestamp", Limit: 2, Offset: 1}, "log 2,log 1"},
+		{"after cursor", Query{OrderBy: "timestamp", After: &Cursor{Timestamp: testStart.Add(2 * time.Second), ID: 3}}, "log 1,log 0"},
+		{"before cursor", Query{OrderBy: "timestamp", Limit: 1, Before: &Cursor{Timestamp: testStart.Add(time.Second), ID: 2}}, "log 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.Query(tt.q)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if got := messages(rows); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	rows, err := db.Query(Query{Count: true, GroupBy: []string{"level"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("count by level: got %v", rows)
+	}
+}
+
+func TestMemoryPutInvalid(t *testing.T) {
+	db, _ := NewMemoryHandler(10)
+	for _, data := range []map[string]interface{}{
+		{"message": "no level"},
+		{"level": "INFO"},
+		{"level": "INFO", "message": "x", "colour": "red"},
+		{"level": "INFO", "message": "x", "timestamp": "yesterday"},
+	} {
+		if err := db.Put("logs", data); err == nil {
+			t.Errorf("Put(%v) succeeded", data)
+		}
+	}
+	if err := db.Put("other", map[string]interface{}{"level": "INFO", "message": "x"}); err == nil {
+		t.Error("Put into an unknown table succeeded")
+	}
+}
+
+// The memory handler's retention is its capacity, the oldest logs make room for new ones
+func TestMemoryEvictsOldest(t *testing.T) {
+	db, _ := NewMemoryHandler(3)
+	putLogs(t, db, "INFO", "ERROR", "INFO", "ERROR", "DEBUG")
+
+	rows, err := db.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(rows); got != "log 2,log 3,log 4" {
+		t.Errorf("got %q", got)
+	}
+	if id := rows[0]["id"]; id != int64(3) {
+		t.Errorf("oldest id %v, want 3", id)
+	}
+
+	// Evicted logs are gone from the indexes too
+	rows, _ = db.Query(Query{Conditions: []Condition{{Field: "level", Op: OpEq, Value: "ERROR"}}})
+	if got := messages(rows); got != "log 3" {
+		t.Errorf("ERROR logs: got %q", got)
+	}
+
+	stats, _ := db.StorageStats()
+	if stats.Rows != 3 || !stats.Oldest.Equal(testStart.Add(2*time.Second)) || !stats.Newest.Equal(testStart.Add(4*time.Second)) {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+// Evicting many logs keeps the indexes as large as the buffer, also with logs that arrive late
+func TestMemoryEvictionReclaims(t *testing.T) {
+	const capacity = 50
+	db, _ := NewMemoryHandler(capacity)
+	for i := 0; i < 100*capacity; i++ {
+		at := testStart.Add(time.Duration(i) * time.Second)
+		if i%7 == 0 {
+			at = at.Add(-5 * time.Second)
+		}
+		err := db.Put("logs", map[string]interface{}{"timestamp": at, "level": []string{"INFO", "ERROR"}[i%2], "source": "api", "message": fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(db.byTime.all()); n != capacity || cap(db.byTime.items) > 4*capacity {
+		t.Errorf("time index holds %d entries in an array of %d", n, cap(db.byTime.items))
+	}
+	if ids := db.bySource["api"]; len(ids.all()) != capacity || cap(ids.items) > 4*capacity {
+		t.Errorf("source index holds %d ids in an array of %d", len(ids.all()), cap(ids.items))
+	}
+	times := db.byTime.all()
+	for i := 1; i < len(times); i++ {
+		if times[i].before(times[i-1]) {
+			t.Fatalf("time index out of order at %d: %v", i, times)
+		}
+	}
+	rows, err := db.Query(Query{Conditions: []Condition{{Field: "level", Op: OpEq, Value: "ERROR"}}, OrderBy: "id"})
+	if err != nil || len(rows) != capacity/2 || rows[0]["id"] != int64(100*capacity) {
+		t.Errorf("ERROR logs: %d, newest %v, error %v", len(rows), rows[0]["id"], err)
+	}
+}
+
+func TestMemoryClosed(t *testing.T) {
+	db, _ := NewMemoryHandler(10)
+	putLogs(t, db, "INFO")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("logs", map[string]interface{}{"level": "INFO", "message": "late"}); err == nil || !strings.Contains(err.Error(), "handler is closed") {
+		t.Errorf("Put after Close: %v", err)
+	}
+	if _, err := db.Query(Query{}); err == nil || !strings.Contains(err.Error(), "handler is closed") {
+		t.Errorf("Query after Close: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("closing twice: %v", err)
+	}
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap", "logs.ndjson")
+	db, err := NewMemoryHandler(3, WithSnapshot(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	putLogs(t, db, "INFO", "ERROR", "INFO", "WARNING")
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	loaded, err := NewMemoryHandler(3, WithSnapshot(path))
+	if err != nil {
+		t.Fatalf("loading the snapshot: %v", err)
+	}
+	rows, _ := loaded.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if got := messages(rows); got != "log 1,log 2,log 3" {
+		t.Errorf("got %q", got)
+	}
+	if rows[0]["id"] != int64(2) || !rows[0]["timestamp"].(time.Time).Equal(testStart.Add(time.Second)) {
+		t.Errorf("first row %v", rows[0])
+	}
+
+	// New logs continue the ids of the snapshot
+	putLogs(t, loaded, "INFO")
+	rows, _ = loaded.Query(Query{OrderBy: "id", Ascending: true})
+	if len(rows) != 3 || rows[0]["id"] != int64(3) || rows[2]["id"] != int64(5) {
+		t.Errorf("after a put: %v", rows)
+	}
+}
+
+func TestMemorySnapshotMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing id", `{"level":"INFO","message":"x"}`, "line 1: missing or invalid id"},
+		{"text id", `{"id":1,"level":"INFO","message":"x"}` + "\n" + `{"id":"two","level":"INFO","message":"x"}`, "line 2: missing or invalid id"},
+		{"fractional id", `{"id":1.5,"level":"INFO","message":"x"}`, "line 1: missing or invalid id"},
+		{"not json", `{"id":1,`, "line 1:"},
+		{"unknown column", `{"id":1,"level":"INFO","message":"x","colour":"red"}`, "line 1: unknown column colour"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "logs.ndjson")
+			if err := os.WriteFile(path, []byte(tt.content+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := NewMemoryHandler(3, WithSnapshot(path))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package dbhandler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeSnapshot stores the buffer as one JSON object per line, oldest first. The file is
+// written next to the old one and renamed over it so a crash never leaves half a snapshot.
+func (h *MemoryHandler) writeSnapshot() error {
+	if err := os.MkdirAll(filepath.Dir(h.snapshot), 0755); err != nil {
+		return err
+	}
+
+	tmp := h.snapshot + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for i := 0; i < h.size; i++ {
+		if err := encoder.Encode(h.ring[(h.head+i)%h.capacity].values); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.snapshot)
+}
+
+// loadSnapshot fills the buffer from the snapshot file, keeping the ids the rows had
+func (h *MemoryHandler) loadSnapshot() error {
+	content, err := os.ReadFile(h.snapshot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	first := true
+	for line := 1; scanner.Scan(); line++ {
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		var data map[string]interface{}
+		if err := decoder.Decode(&data); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		// Ids in a snapshot are consecutive, continuing from the first one keeps them
+		number, ok := data["id"].(json.Number)
+		if !ok {
+			return fmt.Errorf("line %d: missing or invalid id", line)
+		}
+		id, err := number.Int64()
+		if err != nil {
+			return fmt.Errorf("line %d: missing or invalid id", line)
+		}
+		delete(data, "id")
+		if first {
+			h.nextID = id
+			first = false
+		}
+
+		row, err := newMemoryRow(data)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		h.insert(row)
+	}
+	return scanner.Err()
+}
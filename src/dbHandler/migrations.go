@@ -0,0 +1,125 @@
+package dbhandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+)
+
+// ErrSchemaTooNew is returned when a database was migrated by a newer version of LogLite
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of LogLite supports")
+
+// Migration is one versioned change to a backend's schema. Migrations are only ever added,
+// a released migration must not change since databases record that they applied it.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string // Run in order, in a single transaction with recording the version
+}
+
+// MigrationStatus describes the schema of one database
+type MigrationStatus struct {
+	Database string
+	Current  int // Highest applied version, 0 for a new database
+	Latest   int // Highest version this build knows
+	Pending  []Migration
+}
+
+// migrationStore is implemented by backends whose schema is managed with migrations.
+// Applied versions are kept in a schema_migrations table.
+type migrationStore interface {
+	// appliedMigrations returns the applied versions, none when schema_migrations does not exist yet
+	appliedMigrations(ctx context.Context) (map[int]bool, error)
+	// applyMigration runs a migration and records its version in one transaction,
+	// creating schema_migrations first if needed
+	applyMigration(ctx context.Context, m Migration) error
+}
+
+// migrationStatus compares the applied versions with the known migrations
+func migrationStatus(ctx context.Context, store migrationStore, migrations []Migration) (MigrationStatus, error) {
+	status := MigrationStatus{}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return status, fmt.Errorf("migration %q has version %d, expected %d", m.Description, m.Version, i+1)
+		}
+	}
+	status.Latest = len(migrations)
+
+	applied, err := store.appliedMigrations(ctx)
+	if err != nil {
+		return status, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for version := range applied {
+		if version > status.Current {
+			status.Current = version
+		}
+	}
+	if status.Current > status.Latest {
+		return status, fmt.Errorf("%w: the schema is at version %d, this build knows up to %d", ErrSchemaTooNew, status.Current, status.Latest)
+	}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+	return status, nil
+}
+
+// migrate applies the pending migrations in order. It refuses to touch a database whose
+// schema is newer than the migrations this build knows.
+func migrate(ctx context.Context, store migrationStore, migrations []Migration) error {
+	status, err := migrationStatus(ctx, store, migrations)
+	if err != nil {
+		return err
+	}
+	for _, m := range status.Pending {
+		if err := store.applyMigration(ctx, m); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		// A new database applies every migration, only upgrades are worth logging
+		if status.Current > 0 {
+			log.Printf("Applied database migration %d: %s\n", m.Version, m.Description)
+		}
+	}
+	return nil
+}
+
+// PlanMigrations reports the migrations the configured database still needs, for a dry run.
+// It does not create or change anything. Backends without a schema (Memory and Segment)
+// return no statuses.
+func PlanMigrations(config *confighandler.Config) ([]MigrationStatus, error) {
+	ctx := context.Background()
+	switch config.Database.Type {
+	case "SQLite":
+		status, err := planSQLiteMigrations(ctx, config.Database.SQLiteFilepath)
+		return []MigrationStatus{status}, err
+	case "PartitionedSQLite":
+		paths, err := filepath.Glob(filepath.Join(config.Database.PartitionDir, partitionFilePrefix+"*"+partitionFileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		statuses := []MigrationStatus{}
+		for _, path := range paths {
+			status, err := planSQLiteMigrations(ctx, path)
+			statuses = append(statuses, status)
+			if err != nil {
+				return statuses, err
+			}
+		}
+		return statuses, nil
+	case "PostgreSQL":
+		pool, err := connectPostgres(ctx, config.Database.PostgresURL, 1)
+		if err != nil {
+			return nil, err
+		}
+		defer pool.Close()
+		status, err := migrationStatus(ctx, postgresMigrationStore{pool}, postgresMigrations)
+		status.Database = "PostgreSQL"
+		return []MigrationStatus{status}, err
+	}
+	return nil, nil
+}
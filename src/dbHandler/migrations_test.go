@@ -0,0 +1,194 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMigrationStore records the migrations applied to it
+type fakeMigrationStore struct {
+	applied []int
+	fail    int // Version that fails to apply
+}
+
+func (s *fakeMigrationStore) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	versions := map[int]bool{}
+	for _, v := range s.applied {
+		versions[v] = true
+	}
+	return versions, nil
+}
+
+func (s *fakeMigrationStore) applyMigration(ctx context.Context, m Migration) error {
+	if m.Version == s.fail {
+		return errors.New("syntax error")
+	}
+	s.applied = append(s.applied, m.Version)
+	return nil
+}
+
+var testMigrations = []Migration{
+	{Version: 1, Description: "one"},
+	{Version: 2, Description: "two"},
+	{Version: 3, Description: "three"},
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name       string
+		applied    []int
+		migrations []Migration
+		fail       int
+		want       []int // Applied afterwards, in order
+		wantErr    string
+	}{
+		{"new database", nil, testMigrations, 0, []int{1, 2, 3}, ""},
+		{"upgrade", []int{1}, testMigrations, 0, []int{1, 2, 3}, ""},
+		{"up to date", []int{1, 2, 3}, testMigrations, 0, []int{1, 2, 3}, ""},
+		{"newer schema", []int{1, 2, 3, 4}, testMigrations, 0, []int{1, 2, 3, 4}, "newer than this version"},
+		{"out of order", nil, []Migration{testMigrations[0], testMigrations[2]}, 0, nil, `migration "three" has version 3, expected 2`},
+		{"failed migration stops", nil, testMigrations, 2, []int{1}, "failed to apply migration 2 (two): syntax error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeMigrationStore{applied: tt.applied, fail: tt.fail}
+			err := migrate(context.Background(), store, tt.migrations)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if fmt.Sprint(store.applied) != fmt.Sprint(tt.want) {
+				t.Errorf("applied %v, want %v", store.applied, tt.want)
+			}
+		})
+	}
+
+	_, err := migrationStatus(context.Background(), &fakeMigrationStore{applied: []int{4}}, testMigrations)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("a newer schema gave %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func schemaVersions(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	db, err := NewSQLiteHandler(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := schemaVersions(t, db.db); n != len(sqliteMigrations) {
+		t.Errorf("%d migrations recorded, want %d", n, len(sqliteMigrations))
+	}
+	db.Close()
+
+	// Opening it again applies nothing twice
+	db, err = NewSQLiteHandler(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	if n := schemaVersions(t, db.db); n != len(sqliteMigrations) {
+		t.Errorf("%d migrations recorded after reopening, want %d", n, len(sqliteMigrations))
+	}
+
+	// A newer LogLite migrated it further, this one refuses to use it
+	if _, err := db.db.Exec("INSERT INTO schema_migrations (version, description) VALUES (?, 'from the future')", len(sqliteMigrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := NewSQLiteHandler(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("opening a newer schema gave %v, want ErrSchemaTooNew", err)
+	}
+}
+
+// baselineSchema is the logs table as LogLite created it before there were migrations
+const baselineSchema = `CREATE TABLE IF NOT EXISTS logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	level TEXT NOT NULL,
+	message TEXT NOT NULL,
+	source TEXT,
+	method TEXT,
+	address TEXT,
+	length INTEGER,
+	metadata TEXT,
+	label TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level);
+CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp);
+CREATE INDEX IF NOT EXISTS idx_logs_source ON logs(source);
+INSERT INTO logs (timestamp, level, message, source) VALUES ('2025-01-31 12:00:00', 'INFO', 'log 0', 'api');
+INSERT INTO logs (timestamp, level, message, source) VALUES ('2025-01-31 12:00:01', 'ERROR', 'log 1', 'api');`
+
+func TestSQLiteMigratesBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	// The dry run lists every migration and changes nothing
+	status, err := planSQLiteMigrations(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != 0 || status.Latest != len(sqliteMigrations) || len(status.Pending) != len(sqliteMigrations) {
+		t.Errorf("planned %+v", status)
+	}
+	if status, _ := planSQLiteMigrations(context.Background(), path); len(status.Pending) != len(sqliteMigrations) {
+		t.Errorf("the dry run applied migrations: %+v", status)
+	}
+
+	db, err := NewSQLiteHandler(path)
+	if err != nil {
+		t.Fatalf("upgrading the baseline schema: %v", err)
+	}
+	defer db.Close()
+
+	// The old logs are kept and still found by time, received_at is there for new ones
+	rows, err := db.Query(Query{Since: testStart.Add(time.Second), OrderBy: "timestamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages(rows) != "log 1" || !rows[0]["timestamp"].(time.Time).Equal(testStart.Add(time.Second)) {
+		t.Errorf("old logs after upgrading: %v", rows)
+	}
+	received := testStart.Add(time.Minute)
+	if err := db.Put("logs", map[string]interface{}{"timestamp": testStart.Add(2 * time.Second), "received_at": received, "level": "INFO", "message": "log 2", "label": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = db.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages(rows) != "log 0,log 1,log 2" {
+		t.Errorf("logs after upgrading: %q", messages(rows))
+	}
+	if at, ok := rows[2]["received_at"].(time.Time); !ok || !at.Equal(received) {
+		t.Errorf("received_at %v", rows[2]["received_at"])
+	}
+
+	var index int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_logs_label'").Scan(&index); err != nil || index != 1 {
+		t.Errorf("label index: %d, error %v", index, err)
+	}
+	if status, err := planSQLiteMigrations(context.Background(), path); err != nil || status.Current != len(sqliteMigrations) || len(status.Pending) != 0 {
+		t.Errorf("after upgrading: %+v, error %v", status, err)
+	}
+}
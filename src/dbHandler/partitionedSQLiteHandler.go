@@ -0,0 +1,490 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAttachedPartitions is SQLite's default limit on attached databases per connection
+const maxAttachedPartitions = 10
+
+// partitionIDSpan is the id range reserved for each partition so ids stay unique across files
+const partitionIDSpan = 1_000_000_000
+
+const partitionFilePrefix = "logs-"
+const partitionFileSuffix = ".db"
+
+// PartitionedSQLiteHandler stores logs in one SQLite file per day (or hour). Queries attach
+// only the partitions their time range needs and retention drops whole files.
+type PartitionedSQLiteHandler struct {
+	dir         string
+	granularity time.Duration
+	layout      string
+	opts        []SQLiteOption
+
+	mu         sync.Mutex
+	partitions map[string]*SQLiteHandler // Open partitions by key
+
+	reader  *sql.DB // In-memory database that partitions are attached to for reading
+	builder sqliteQueryBuilder
+}
+
+// NewPartitionedSQLiteHandler opens the partitions in dir. partitionBy is "day" or "hour".
+func NewPartitionedSQLiteHandler(dir string, partitionBy string, opts ...SQLiteOption) (*PartitionedSQLiteHandler, error) {
+	h := &PartitionedSQLiteHandler{dir: dir, opts: opts, partitions: map[string]*SQLiteHandler{}}
+	switch partitionBy {
+	case "", "day":
+		h.granularity = 24 * time.Hour
+		h.layout = "2006-01-02"
+	case "hour":
+		h.granularity = time.Hour
+		h.layout = "2006-01-02T15"
+	default:
+		return nil, fmt.Errorf("unsupported partition_by %q (must be day or hour)", partitionBy)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create partition directory: %w", err)
+	}
+
+	reader, err := sql.Open("sqlite", "file::memory:?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partition reader: %w", err)
+	}
+	h.reader = reader
+
+	// Open every existing partition once so its schema and promoted columns are up to date
+	keys, err := h.partitionKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if _, err := h.partition(key); err != nil {
+			return nil, err
+		}
+	}
+
+	// The builder only needs the promoted column names, which are the same in every partition
+	probe := &SQLiteHandler{}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	h.builder.promoted = map[string]string{}
+	for _, f := range probe.promotedFields {
+		field := NormalizeMetadataField(f)
+		h.builder.promoted[field] = promotedColumn(field)
+	}
+
+	return h, nil
+}
+
+func (h *PartitionedSQLiteHandler) partitionPath(key string) string {
+	return filepath.Join(h.dir, partitionFilePrefix+key+partitionFileSuffix)
+}
+
+// partitionKeys lists the partitions on disk, oldest first
+func (h *PartitionedSQLiteHandler) partitionKeys() ([]string, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	keys := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, partitionFilePrefix) || !strings.HasSuffix(name, partitionFileSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(name, partitionFilePrefix), partitionFileSuffix)
+		if _, err := time.Parse(h.layout, key); err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// partitionStart returns the first instant covered by a partition
+func (h *PartitionedSQLiteHandler) partitionStart(key string) time.Time {
+	t, _ := time.Parse(h.layout, key)
+	return t
+}
+
+// partition returns the open handler for a partition, creating the file if needed
+func (h *PartitionedSQLiteHandler) partition(key string) (*SQLiteHandler, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if p, ok := h.partitions[key]; ok {
+		return p, nil
+	}
+
+	p, err := NewSQLiteHandler(h.partitionPath(key), h.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partition %s: %w", key, err)
+	}
+	p.db.SetMaxIdleConns(1)
+
+	// Start the ids of a new partition in its own range so ids never collide across files
+	firstID := h.partitionStart(key).Unix() / int64(h.granularity/time.Second) * partitionIDSpan
+	_, err = p.db.Exec(`INSERT INTO sqlite_sequence (name, seq)
+		SELECT 'logs', ? WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'logs')`, firstID)
+	if err != nil {
+		p.Close()
+		return nil, fmt.Errorf("failed to seed ids of partition %s: %w", key, err)
+	}
+
+	h.partitions[key] = p
+	return p, nil
+}
+
+// timestampOf returns the timestamp a row will be stored with
+func timestampOf(data map[string]interface{}) (time.Time, bool) {
+	return timeOf(data["timestamp"])
+}
+
+// Put writes the row into the partition of its timestamp
+func (h *PartitionedSQLiteHandler) Put(table string, data map[string]interface{}) error {
+	ts, ok := timestampOf(data)
+	if !ok {
+		// Fix the timestamp here so the row and its partition agree at boundaries
+		ts = time.Now().UTC()
+		row := make(map[string]interface{}, len(data)+1)
+		for k, v := range data {
+			row[k] = v
+		}
+		row["timestamp"] = ts.Format(TimestampLayout)
+		data = row
+	}
+
+	p, err := h.partition(ts.Truncate(h.granularity).Format(h.layout))
+	if err != nil {
+		return err
+	}
+	return p.Put(table, data)
+}
+
+// Get supports the same equality conditions, limit, offset and orderBy as the SQLite handler
+func (h *PartitionedSQLiteHandler) Get(table string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	q := queryFromConditions(table, conditions)
+	return h.Query(q)
+}
+
+// partitionsFor returns the partitions overlapping the query's time range
+func (h *PartitionedSQLiteHandler) partitionsFor(q Query) ([]string, error) {
+	keys, err := h.partitionKeys()
+	if err != nil {
+		return nil, err
+	}
+	selected := []string{}
+	for _, key := range keys {
+		start := h.partitionStart(key)
+		end := start.Add(h.granularity)
+		if !q.Since.IsZero() && !end.After(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && !start.Before(q.Until) {
+			continue
+		}
+		if q.cursorRulesOut(start, end.Add(-time.Nanosecond)) {
+			continue
+		}
+		selected = append(selected, key)
+	}
+	return selected, nil
+}
+
+// Query attaches the partitions in the query's time range, at most maxAttachedPartitions at a time,
+// and merges the results of each group of partitions
+func (h *PartitionedSQLiteHandler) Query(q Query) ([]map[string]interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	if q.Before != nil {
+		q = keysetQuery(q)
+		rows, err := h.Query(q)
+		reverseRows(rows)
+		return rows, err
+	}
+	keys, err := h.partitionsFor(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	// Rows sorted by time can be read newest (or oldest) partition first, stopping once enough rows are found
+	byTime := !q.IsAggregate() && (q.OrderBy == "" || q.OrderBy == "timestamp")
+	if byTime && !q.Ascending {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	// Each group returns enough rows to satisfy the limit on its own, the final page is cut after merging
+	groupQuery := q
+	if !q.IsAggregate() && q.Limit > 0 {
+		groupQuery.Limit = q.Limit + q.Offset
+		groupQuery.Offset = 0
+	}
+	if q.IsAggregate() {
+		groupQuery = mergeableQuery(q)
+	}
+
+	parts := [][]map[string]interface{}{}
+	found := 0
+	for start := 0; start < len(keys); start += maxAttachedPartitions {
+		end := start + maxAttachedPartitions
+		if end > len(keys) {
+			end = len(keys)
+		}
+		rows, err := h.queryGroup(keys[start:end], groupQuery)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, rows)
+		found += len(rows)
+		if byTime && groupQuery.Limit > 0 && found >= groupQuery.Limit {
+			break
+		}
+	}
+
+	if q.IsAggregate() {
+		return mergeCounts(q, parts...), nil
+	}
+
+	rows := []map[string]interface{}{}
+	for _, part := range parts {
+		rows = append(rows, part...)
+	}
+	orderBy := q.OrderBy
+	if orderBy == "" {
+		orderBy = "timestamp"
+	}
+	sortRows(rows, orderBy, q.Ascending)
+	return pageRows(rows, q.Limit, q.Offset), nil
+}
+
+// queryGroup attaches a group of partitions to one reader connection and queries their union
+func (h *PartitionedSQLiteHandler) queryGroup(keys []string, q Query) ([]map[string]interface{}, error) {
+	ctx := context.Background()
+	conn, err := h.reader.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reader connection: %w", err)
+	}
+	defer conn.Close()
+
+	columns := strings.Join(LogColumns, ", ")
+	for _, column := range h.builder.promoted {
+		columns += ", " + column
+	}
+
+	selects := []string{}
+	for i, key := range keys {
+		alias := fmt.Sprintf("p%d", i)
+		if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+alias, h.partitionPath(key)); err != nil {
+			return nil, fmt.Errorf("failed to attach partition %s: %w", key, err)
+		}
+		defer conn.ExecContext(ctx, "DETACH DATABASE "+alias)
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s.logs", columns, alias))
+	}
+
+	builder := h.builder
+	builder.from = "(" + strings.Join(selects, " UNION ALL ") + ")"
+	query, args, err := builder.build(q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	results, err := scanRows(rows)
+	parseBuckets(q, results)
+	return results, err
+}
+
+// dropPartition closes a partition and deletes its file
+func (h *PartitionedSQLiteHandler) dropPartition(key string) error {
+	h.mu.Lock()
+	p, ok := h.partitions[key]
+	delete(h.partitions, key)
+	h.mu.Unlock()
+
+	if ok {
+		if err := p.Close(); err != nil {
+			return err
+		}
+	}
+	path := h.partitionPath(key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove partition %s: %w", key, err)
+	}
+	os.Remove(path + "-journal")
+	log.Printf("Dropped log partition %s\n", key)
+	return nil
+}
+
+// Prune drops partitions that are entirely older than every age limit, drops the oldest partitions
+// while the row or size limit is exceeded, and applies per-level or per-source rules inside the
+// partitions that remain
+func (h *PartitionedSQLiteHandler) Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error) {
+	result := PruneResult{StartedAt: time.Now()}
+	err := h.prune(ctx, policy, &result)
+	result.Duration = time.Since(result.StartedAt)
+	return result, err
+}
+
+func (h *PartitionedSQLiteHandler) prune(ctx context.Context, policy RetentionPolicy, result *PruneResult) error {
+	keys, err := h.partitionKeys()
+	if err != nil {
+		return err
+	}
+	current := time.Now().UTC().Truncate(h.granularity).Format(h.layout)
+
+	drop := func(key string) error {
+		stats, err := h.partitionStats(key)
+		if err != nil {
+			return err
+		}
+		if err := h.dropPartition(key); err != nil {
+			return err
+		}
+		result.Deleted += stats.Rows
+		result.ReclaimedBytes += stats.Bytes
+		return nil
+	}
+
+	// A partition can go once it is older than the longest age anything in it is kept for
+	if policy.MaxAge > 0 {
+		longest := policy.MaxAge
+		for _, rule := range policy.Rules {
+			if rule.MaxAge > longest {
+				longest = rule.MaxAge
+			}
+		}
+		cutoff := time.Now().UTC().Add(-longest)
+		remaining := []string{}
+		for _, key := range keys {
+			if key != current && !h.partitionStart(key).Add(h.granularity).After(cutoff) {
+				if err := drop(key); err != nil {
+					return err
+				}
+				continue
+			}
+			remaining = append(remaining, key)
+		}
+		keys = remaining
+	}
+
+	// Row and size limits drop the oldest partitions, but never the one being written to
+	if policy.MaxRows > 0 || policy.MaxBytes > 0 {
+		var rows, bytes int64
+		for _, key := range keys {
+			stats, err := h.partitionStats(key)
+			if err != nil {
+				return err
+			}
+			rows += stats.Rows
+			bytes += stats.Bytes
+		}
+		for len(keys) > 1 && keys[0] != current &&
+			((policy.MaxRows > 0 && rows > policy.MaxRows) || (policy.MaxBytes > 0 && bytes > policy.MaxBytes)) {
+			stats, err := h.partitionStats(keys[0])
+			if err != nil {
+				return err
+			}
+			if err := drop(keys[0]); err != nil {
+				return err
+			}
+			rows -= stats.Rows
+			bytes -= stats.Bytes
+			keys = keys[1:]
+		}
+	}
+
+	// Shorter ages (the default or a rule) still delete rows inside the remaining partitions
+	inner := RetentionPolicy{MaxAge: policy.MaxAge, Rules: policy.Rules, BatchSize: policy.BatchSize}
+	if inner.IsEmpty() {
+		return nil
+	}
+	for _, key := range keys {
+		p, err := h.partition(key)
+		if err != nil {
+			return err
+		}
+		r, err := p.Prune(ctx, inner)
+		result.Deleted += r.Deleted
+		result.ReclaimedBytes += r.ReclaimedBytes
+		if r.Note != "" {
+			result.Note = r.Note
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *PartitionedSQLiteHandler) partitionStats(key string) (StorageStats, error) {
+	p, err := h.partition(key)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	return p.StorageStats()
+}
+
+// StorageStats adds up the usage of every partition
+func (h *PartitionedSQLiteHandler) StorageStats() (StorageStats, error) {
+	var total StorageStats
+	keys, err := h.partitionKeys()
+	if err != nil {
+		return total, err
+	}
+	for _, key := range keys {
+		stats, err := h.partitionStats(key)
+		if err != nil {
+			return total, err
+		}
+		total.Rows += stats.Rows
+		total.Bytes += stats.Bytes
+		total.FreeBytes += stats.FreeBytes
+		if !stats.Oldest.IsZero() && (total.Oldest.IsZero() || stats.Oldest.Before(total.Oldest)) {
+			total.Oldest = stats.Oldest
+		}
+		if stats.Newest.After(total.Newest) {
+			total.Newest = stats.Newest
+		}
+	}
+	return total, nil
+}
+
+// Close closes every open partition and the reader
+func (h *PartitionedSQLiteHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var firstErr error
+	for key, p := range h.partitions {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(h.partitions, key)
+	}
+	if err := h.reader.Close(); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("failed to close partition reader: %w", err)
+	}
+	return firstErr
+}
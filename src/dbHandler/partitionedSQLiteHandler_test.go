@@ -0,0 +1,314 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// partitionHours is more than two groups of maxAttachedPartitions, so queries over every
+// partition attach them in three groups
+const partitionHours = 2*maxAttachedPartitions + 5
+
+// putPartitionLogs stores an INFO and an ERROR log in each of partitionHours hours from
+// testStart, in db and in every other handler given
+func putPartitionLogs(t *testing.T, dbs ...DBHandler) {
+	t.Helper()
+	for h := 0; h < partitionHours; h++ {
+		for i, level := range []string{"INFO", "ERROR"} {
+			row := map[string]interface{}{
+				"timestamp": testStart.Add(time.Duration(h)*time.Hour + time.Duration(i)*time.Minute),
+				"level":     level,
+				"source":    []string{"api", "auth"}[h%2],
+				"message":   fmt.Sprintf("log %02d %s", h, level),
+				"metadata":  fmt.Sprintf(`{"ms": %d}`, h*10+i),
+			}
+			for _, db := range dbs {
+				if err := db.Put("logs", row); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func newPartitioned(t *testing.T) *PartitionedSQLiteHandler {
+	t.Helper()
+	h, err := NewPartitionedSQLiteHandler(t.TempDir(), "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestPartitionSelection(t *testing.T) {
+	h := newPartitioned(t)
+	putPartitionLogs(t, h)
+
+	files, _ := filepath.Glob(filepath.Join(h.dir, partitionFilePrefix+"*"+partitionFileSuffix))
+	if len(files) != partitionHours {
+		t.Fatalf("%d partition files, want one per hour", len(files))
+	}
+
+	key := func(hour int) string { return testStart.Add(time.Duration(hour) * time.Hour).Format(h.layout) }
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"since", Query{Since: testStart.Add(23*time.Hour + 30*time.Minute)}, []string{key(23), key(24)}},
+		{"until", Query{Until: testStart.Add(time.Hour)}, []string{key(0)}},
+		{"range", Query{Since: testStart.Add(2 * time.Hour), Until: testStart.Add(4*time.Hour + time.Second)}, []string{key(2), key(3), key(4)}},
+		{"before every partition", Query{Until: testStart}, []string{}},
+		{"after cursor", Query{OrderBy: "timestamp", After: &Cursor{Timestamp: testStart.Add(90 * time.Minute), ID: 1}}, []string{key(0), key(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := h.partitionsFor(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("got %v, want %v", keys, tt.want)
+			}
+		})
+	}
+
+	rows, err := h.Query(Query{Since: testStart.Add(2 * time.Hour), Until: testStart.Add(3 * time.Hour), OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(rows); got != "log 02 INFO,log 02 ERROR" {
+		t.Errorf("rows of one hour: %q", got)
+	}
+}
+
+func TestPartitionIDs(t *testing.T) {
+	h := newPartitioned(t)
+	putPartitionLogs(t, h)
+
+	// Every partition hands out ids from its own range, the first hour's start in hours
+	seen := map[int64]bool{}
+	rows, err := h.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2*partitionHours {
+		t.Fatalf("%d rows", len(rows))
+	}
+	for i, row := range rows {
+		id := row["id"].(int64)
+		if seen[id] {
+			t.Errorf("id %d is used twice", id)
+		}
+		seen[id] = true
+		start := testStart.Add(time.Duration(i/2)*time.Hour).Unix() / 3600 * partitionIDSpan
+		if want := start + int64(i%2) + 1; id != want {
+			t.Errorf("%s has id %d, want %d", row["message"], id, want)
+		}
+	}
+
+	// Reopened partitions continue their own range
+	dir := h.dir
+	h.Close()
+	h, err = NewPartitionedSQLiteHandler(dir, "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.Put("logs", map[string]interface{}{"timestamp": testStart.Add(30 * time.Minute), "level": "INFO", "message": "log again"}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = h.Query(Query{Conditions: []Condition{{Field: "message", Op: OpEq, Value: "log again"}}})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("%v, error %v", rows, err)
+	}
+	if want := testStart.Unix()/3600*partitionIDSpan + 3; rows[0]["id"] != want {
+		t.Errorf("id after reopening %v, want %d", rows[0]["id"], want)
+	}
+}
+
+// TestPartitionGroups runs queries over every partition, which are attached in groups, and
+// compares the merged results with a single SQLite file holding the same logs
+func TestPartitionGroups(t *testing.T) {
+	h := newPartitioned(t)
+	single, err := NewSQLiteHandler(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer single.Close()
+	putPartitionLogs(t, h, single)
+
+	level := []Condition{{Field: "level", Op: OpEq, Value: "ERROR"}}
+	tests := []struct {
+		name string
+		q    Query
+	}{
+		{"newest", Query{OrderBy: "timestamp", Limit: 5}},
+		{"oldest", Query{OrderBy: "timestamp", Ascending: true, Limit: 5}},
+		{"page across groups", Query{OrderBy: "timestamp", Limit: 6, Offset: 18}},
+		{"filtered", Query{Conditions: level, OrderBy: "timestamp", Limit: 30}},
+		{"by message", Query{OrderBy: "message", Ascending: true, Limit: 4}},
+		{"after cursor", Query{OrderBy: "timestamp", Limit: 3, After: &Cursor{Timestamp: testStart.Add(20 * time.Hour), ID: 1}}},
+		{"before cursor", Query{OrderBy: "timestamp", Limit: 3, Before: &Cursor{Timestamp: testStart.Add(5 * time.Hour), ID: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.Query(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := single.Query(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messages(got) != messages(want) {
+				t.Errorf("got %q, want %q", messages(got), messages(want))
+			}
+		})
+	}
+}
+
+func TestPartitionAggregates(t *testing.T) {
+	h := newPartitioned(t)
+	single, err := NewSQLiteHandler(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer single.Close()
+	putPartitionLogs(t, h, single)
+
+	ms := "metadata.ms"
+	tests := []struct {
+		name string
+		q    Query
+	}{
+		{"count", Query{Count: true}},
+		{"by level", Query{Count: true, GroupBy: []string{"level"}}},
+		{"by level and source", Query{Count: true, GroupBy: []string{"level", "source"}}},
+		{"top source", Query{Count: true, GroupBy: []string{"source"}, OrderBy: "count", Limit: 1}},
+		{"buckets", Query{Count: true, Bucket: 6 * time.Hour}},
+		{"buckets by level", Query{Count: true, GroupBy: []string{"level"}, Bucket: 12 * time.Hour}},
+		{"statistics", Query{Count: true, GroupBy: []string{"level"}, Aggregates: []Aggregate{{AggAvg, ms}, {AggMin, ms}, {AggMax, ms}, {AggSum, ms}, {AggCount, ms}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.Query(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := single.Query(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Groups come in no particular order without one
+			ordered := tt.q.OrderBy != ""
+			if describeGroups(got, ordered) != describeGroups(want, ordered) {
+				t.Errorf("got\n%s\nwant\n%s", describeGroups(got, ordered), describeGroups(want, ordered))
+			}
+		})
+	}
+}
+
+// describeGroups prints count rows in a stable form, numbers as float64 since backends
+// differ in whether sums are integers. Unordered groups are sorted.
+func describeGroups(rows []map[string]interface{}, ordered bool) string {
+	lines := []string{}
+	for _, row := range rows {
+		fields := []string{}
+		for _, col := range sortedColumns(row) {
+			val := row[col]
+			switch v := val.(type) {
+			case int64:
+				val = float64(v)
+			case time.Time:
+				val = v.UTC().Format(time.RFC3339)
+			}
+			fields = append(fields, fmt.Sprintf("%s=%v", col, val))
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	if !ordered {
+		sort.Strings(lines)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sortedColumns(row map[string]interface{}) []string {
+	cols := []string{}
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+func TestPartitionRetention(t *testing.T) {
+	h, err := NewPartitionedSQLiteHandler(t.TempDir(), "day")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	now := time.Now().UTC()
+	for days := 5; days >= 0; days-- {
+		for i, level := range []string{"INFO", "ERROR"} {
+			err := h.Put("logs", map[string]interface{}{
+				"timestamp": now.Add(-time.Duration(days)*24*time.Hour - time.Duration(1-i)*time.Second),
+				"level":     level,
+				"message":   fmt.Sprintf("%d days %s", days, level),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	day := func(days int) string { return now.Add(-time.Duration(days) * 24 * time.Hour).Format(h.layout) }
+	keys := func() []string {
+		keys, err := h.partitionKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	// Days that ended before the longest age go as whole files, the default age and the rule
+	// on errors delete rows inside the ones that are left
+	policy := RetentionPolicy{MaxAge: 36 * time.Hour, Rules: []RetentionRule{{Level: "ERROR", MaxAge: 3*24*time.Hour + time.Hour}}, BatchSize: 10}
+	result, err := h.Prune(context.Background(), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whether the file of 4 days ago has ended an hour before that depends on the time of day
+	if left := strings.Join(keys(), ","); strings.Contains(left, day(5)) || !strings.HasSuffix(left, strings.Join([]string{day(3), day(2), day(1), day(0)}, ",")) {
+		t.Errorf("partitions left: %s", left)
+	}
+	rows, err := h.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(rows); got != "3 days ERROR,2 days ERROR,1 days INFO,1 days ERROR,0 days INFO,0 days ERROR" || result.Deleted != 6 {
+		t.Errorf("kept %q and deleted %d", got, result.Deleted)
+	}
+
+	// Row limits drop the oldest files but never the current one
+	if _, err := h.Prune(context.Background(), RetentionPolicy{MaxRows: 1, BatchSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if left := keys(); len(left) != 1 || left[0] != day(0) {
+		t.Errorf("partitions left: %v, want today's", left)
+	}
+	if _, err := os.Stat(h.partitionPath(day(0))); err != nil {
+		t.Errorf("today's partition: %v", err)
+	}
+	if rows, _ := h.Query(Query{}); len(rows) != 2 {
+		t.Errorf("%d logs left, want today's 2", len(rows))
+	}
+}
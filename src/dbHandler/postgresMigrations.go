@@ -0,0 +1,120 @@
+package dbhandler
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresMigrations are the versions of the PostgreSQL logs schema, append new ones at the end
+var postgresMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create the logs table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS logs (
+				id BIGSERIAL PRIMARY KEY,
+				timestamp TIMESTAMPTZ DEFAULT now(),
+				level TEXT NOT NULL,
+				message TEXT NOT NULL,
+				source TEXT,
+				method TEXT,
+				address TEXT,
+				length BIGINT,
+				metadata JSONB,
+				label TEXT
+			);`,
+			"CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level);",
+			"CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp);",
+			"CREATE INDEX IF NOT EXISTS idx_logs_source ON logs(source);",
+			// jsonb_path_ops serves metadata @> lookups with a smaller index than the default
+			"CREATE INDEX IF NOT EXISTS idx_logs_metadata ON logs USING GIN (metadata jsonb_path_ops);",
+			// Free text matches any part of the message like on the other backends, which a
+			// tsvector of whole words cannot serve, so the message gets a trigram index instead.
+			// pg_trgm ships with PostgreSQL but may not be installed or allowed, searches still
+			// work without the index, only slower.
+			`DO $$
+			BEGIN
+				CREATE EXTENSION IF NOT EXISTS pg_trgm;
+				CREATE INDEX IF NOT EXISTS idx_logs_message_trgm ON logs USING GIN (message gin_trgm_ops);
+			EXCEPTION WHEN OTHERS THEN
+				RAISE WARNING 'pg_trgm is not available, searching the message is not indexed: %', SQLERRM;
+			END
+			$$;`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "Index the label column",
+		Statements: []string{
+			"CREATE INDEX IF NOT EXISTS idx_logs_label ON logs(label);",
+		},
+	},
+	{
+		Version:     3,
+		Description: "Index logs by timestamp and id for cursor paging",
+		Statements: []string{
+			// SQLite indexes carry the rowid, PostgreSQL needs the id in the index to page without sorting
+			"CREATE INDEX IF NOT EXISTS idx_logs_timestamp_id ON logs(timestamp, id);",
+			"DROP INDEX IF EXISTS idx_logs_timestamp;",
+		},
+	},
+	{
+		Version:     4,
+		Description: "Add the received_at column",
+		Statements: []string{
+			"ALTER TABLE logs ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ;",
+		},
+	},
+}
+
+// postgresMigrationStore keeps the applied migrations of a PostgreSQL database
+type postgresMigrationStore struct {
+	pool *pgxpool.Pool
+}
+
+func (s postgresMigrationStore) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	applied := map[int]bool{}
+	var exists bool
+	err := s.pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := s.pool.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (s postgresMigrationStore) applyMigration(ctx context.Context, m Migration) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	statements := append([]string{`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMPTZ DEFAULT now()
+	);`}, m.Statements...)
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, description) VALUES ($1, $2)", m.Version, m.Description); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
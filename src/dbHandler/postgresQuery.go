@@ -0,0 +1,254 @@
+package dbhandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// postgresQueryBuilder compiles Query values into statements against a PostgreSQL logs table
+type postgresQueryBuilder struct {
+	args []interface{}
+}
+
+// bind adds an argument and returns its $n placeholder
+func (b *postgresQueryBuilder) bind(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// metadataKeys turns "metadata.request.status" into the path array used by #> and #>>
+func metadataKeys(field string) []string {
+	return strings.Split(strings.TrimPrefix(field, MetadataPrefix), ".")
+}
+
+// metadataContains builds the JSON document that metadata @> matches for an equality,
+// e.g. {"request": {"status": 200}}. @> can use the GIN index on metadata.
+func metadataContains(field string, value interface{}) (string, error) {
+	keys := metadataKeys(field)
+	doc := value
+	for i := len(keys) - 1; i >= 0; i-- {
+		doc = map[string]interface{}{keys[i]: doc}
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata value: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// field returns the SQL expression for a field. Metadata paths are extracted as text.
+func (b *postgresQueryBuilder) field(field string) string {
+	if IsMetadataField(field) {
+		return fmt.Sprintf("(metadata #>> %s::text[])", b.bind(metadataKeys(field)))
+	}
+	return field
+}
+
+// numeric returns the SQL expression for a field that is NULL unless the value is a number,
+// so aggregates skip text the same way for every backend
+func (b *postgresQueryBuilder) numeric(field string) string {
+	if IsMetadataField(field) {
+		path := b.bind(metadataKeys(field))
+		return fmt.Sprintf("CASE WHEN jsonb_typeof(metadata #> %s::text[]) = 'number' THEN (metadata #>> %s::text[])::float8 END", path, path)
+	}
+	return field
+}
+
+// condition compiles a single condition to a WHERE fragment
+func (b *postgresQueryBuilder) condition(c Condition) (string, error) {
+	var clause string
+	metadata := IsMetadataField(c.Field)
+	switch {
+	case metadata && c.Op == OpEq:
+		doc, err := metadataContains(c.Field, c.Value)
+		if err != nil {
+			return "", err
+		}
+		clause = fmt.Sprintf("metadata @> %s::jsonb", b.bind(doc))
+	case metadata && c.Op == OpIn:
+		alternatives := []string{}
+		for _, v := range c.Value.([]interface{}) {
+			doc, err := metadataContains(c.Field, v)
+			if err != nil {
+				return "", err
+			}
+			alternatives = append(alternatives, fmt.Sprintf("metadata @> %s::jsonb", b.bind(doc)))
+		}
+		clause = "(" + strings.Join(alternatives, " OR ") + ")"
+	case metadata && c.Op != OpContains:
+		// Compare as jsonb so numbers order numerically and mixed types never fail a cast
+		value, err := json.Marshal(c.Value)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode metadata value: %w", err)
+		}
+		path := b.bind(metadataKeys(c.Field))
+		clause = fmt.Sprintf("(metadata #> %s::text[]) %s %s::jsonb", path, c.Op, b.bind(string(value)))
+	case c.Op == OpEq, c.Op == OpNotEq, c.Op == OpGt, c.Op == OpGte, c.Op == OpLt, c.Op == OpLte:
+		clause = fmt.Sprintf("%s %s %s", c.Field, c.Op, b.bind(c.Value))
+	case c.Op == OpContains:
+		expr := b.field(c.Field)
+		if !metadata {
+			expr += "::text"
+		}
+		clause = fmt.Sprintf("%s ILIKE %s", expr, b.bind("%"+escapeLike(fmt.Sprint(c.Value))+"%"))
+	case c.Op == OpIn:
+		placeholders := []string{}
+		for _, v := range c.Value.([]interface{}) {
+			placeholders = append(placeholders, b.bind(v))
+		}
+		clause = fmt.Sprintf("%s IN (%s)", c.Field, strings.Join(placeholders, ", "))
+	default:
+		return "", fmt.Errorf("unsupported operator: %s", c.Op)
+	}
+	if c.Negate {
+		// NULLs should count as "not matching" rather than dropping the row
+		clause = fmt.Sprintf("NOT coalesce(%s, false)", clause)
+	}
+	return clause, nil
+}
+
+// where compiles the filtering part of a query
+func (b *postgresQueryBuilder) where(q Query) (string, error) {
+	clauses := []string{}
+
+	for _, c := range q.Conditions {
+		clause, err := b.condition(c)
+		if err != nil {
+			return "", err
+		}
+		clauses = append(clauses, clause)
+	}
+
+	// Free text matches part of the message ignoring case, like the other backends. The
+	// trigram index serves these patterns when pg_trgm is available.
+	for _, term := range q.Text {
+		clauses = append(clauses, "message ILIKE "+b.bind("%"+escapeLike(term)+"%"))
+	}
+
+	if !q.Since.IsZero() {
+		clauses = append(clauses, "timestamp >= "+b.bind(q.Since.UTC()))
+	}
+	if !q.Until.IsZero() {
+		clauses = append(clauses, "timestamp < "+b.bind(q.Until.UTC()))
+	}
+	if q.After != nil {
+		clauses = append(clauses, fmt.Sprintf("(timestamp, id) %s (%s, %s)", cursorOperator(q.Ascending), b.bind(q.After.Timestamp.UTC()), b.bind(q.After.ID)))
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), nil
+}
+
+// postgresSelectList names the columns like SQLite returns them, with metadata as JSON text
+var postgresSelectList = "id, timestamp, level, message, source, method, address, length, metadata::text AS metadata, label, received_at"
+
+// BuildPostgresQuery compiles a Query to a PostgreSQL statement and its arguments
+func BuildPostgresQuery(q Query) (string, []interface{}, error) {
+	return (&postgresQueryBuilder{}).build(q)
+}
+
+func (b *postgresQueryBuilder) build(q Query) (string, []interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return "", nil, err
+	}
+	table := q.Table
+	if table == "" {
+		table = "logs"
+	}
+
+	// Arguments are numbered in the order they appear, so build the clauses front to back
+	selectList := postgresSelectList
+	groupBy := ""
+	if q.IsAggregate() {
+		selects := []string{}
+		groups := []string{}
+		for i, g := range q.GroupBy {
+			selects = append(selects, fmt.Sprintf("%s AS %q", b.field(g), g))
+			groups = append(groups, fmt.Sprint(i+1))
+		}
+		if q.Bucket > 0 {
+			seconds := int64(q.Bucket / time.Second)
+			selects = append(selects, fmt.Sprintf("to_timestamp(floor(extract(epoch FROM timestamp) / %d) * %d) AS %s", seconds, seconds, BucketColumn))
+			groups = append(groups, fmt.Sprint(len(groups)+1))
+		}
+		selects = append(selects, "COUNT(*) AS count")
+		for _, a := range q.Aggregates {
+			expr := b.numeric(a.Field)
+			switch a.Func {
+			case AggAvg:
+				// AVG and SUM of integers are numeric, which would not scan like SQLite's values
+				expr = fmt.Sprintf("AVG(%s)::float8", expr)
+			case AggSum:
+				if IsMetadataField(a.Field) {
+					expr = fmt.Sprintf("SUM(%s)", expr)
+				} else {
+					expr = fmt.Sprintf("SUM(%s)::bigint", expr)
+				}
+			default:
+				expr = fmt.Sprintf("%s(%s)", strings.ToUpper(string(a.Func)), expr)
+			}
+			selects = append(selects, fmt.Sprintf("%s AS %q", expr, a.Name()))
+		}
+		selectList = strings.Join(selects, ", ")
+		if len(groups) > 0 {
+			groupBy = " GROUP BY " + strings.Join(groups, ", ")
+		}
+	}
+
+	where, err := b.where(q)
+	if err != nil {
+		return "", nil, err
+	}
+
+	orderBy := ""
+	direction := "DESC"
+	if q.Ascending {
+		direction = "ASC"
+	}
+	switch {
+	case q.IsAggregate() && (q.OrderBy == "" || q.OrderBy == "count"):
+		if len(q.GroupBy) > 0 || q.Bucket > 0 {
+			orderBy = " ORDER BY count " + direction
+		}
+	case q.IsAggregate() && q.isResultColumn(q.OrderBy):
+		orderBy = fmt.Sprintf(" ORDER BY %q %s", q.OrderBy, direction)
+	case q.IsAggregate():
+		// Grouped columns can only be ordered through their position in the select list
+		for i, g := range q.GroupBy {
+			if g == q.OrderBy {
+				orderBy = fmt.Sprintf(" ORDER BY %d %s", i+1, direction)
+			}
+		}
+	case IsMetadataField(q.OrderBy):
+		// Order the jsonb value so numbers sort numerically
+		orderBy = fmt.Sprintf(" ORDER BY (metadata #> %s::text[]) %s", b.bind(metadataKeys(q.OrderBy)), direction)
+	case q.OrderBy != "":
+		orderBy = fmt.Sprintf(" ORDER BY %s %s", q.OrderBy, direction)
+	}
+	// SQLite puts NULLs first in ascending order, match it so both backends page alike
+	if orderBy != "" {
+		if q.Ascending {
+			orderBy += " NULLS FIRST"
+		} else {
+			orderBy += " NULLS LAST"
+		}
+	}
+	if !q.IsAggregate() && q.OrderBy == "timestamp" {
+		orderBy += ", id " + direction
+	}
+
+	limit := ""
+	if q.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", q.Limit)
+		if q.Offset > 0 {
+			limit += fmt.Sprintf(" OFFSET %d", q.Offset)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s%s%s%s", selectList, table, where, groupBy, orderBy, limit)
+	return query, b.args, nil
+}
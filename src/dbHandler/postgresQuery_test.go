@@ -0,0 +1,187 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestPostgresTextIsSubstring(t *testing.T) {
+	statement, args, err := BuildPostgresQuery(Query{Text: []string{"err", "50%_off"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(statement, "message ILIKE $1 AND message ILIKE $2") {
+		t.Errorf("statement %s", statement)
+	}
+	if len(args) != 2 || args[0] != "%err%" || args[1] != `%50\%\_off%` {
+		t.Errorf("args %q", args)
+	}
+}
+
+// Rows read from PostgreSQL have every column the other backends return
+func TestPostgresSelectsEveryColumn(t *testing.T) {
+	selected := map[string]bool{}
+	for _, item := range strings.Split(postgresSelectList, ",") {
+		fields := strings.Fields(item)
+		selected[fields[len(fields)-1]] = true
+	}
+	for _, col := range LogColumns {
+		if !selected[col] {
+			t.Errorf("%s is not selected", col)
+		}
+	}
+}
+
+// postgresTestHandler connects to the server at LOGLITE_TEST_POSTGRES_URL, skipping the test
+// without one. Start a server with scripts/postgres-local.sh and set the variable to the url
+// it prints. Each test uses its own schema, dropped when it ends.
+func postgresTestHandler(t *testing.T) *PostgresHandler {
+	t.Helper()
+	url := os.Getenv("LOGLITE_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("LOGLITE_TEST_POSTGRES_URL is not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close(ctx) })
+	schema := fmt.Sprintf("loglite_test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE") })
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	pg, err := NewPostgresHandler(url + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pg.Close() })
+	return pg
+}
+
+// TestPostgresRoundTrip stores a log with every column and reads each value back
+func TestPostgresRoundTrip(t *testing.T) {
+	pg := postgresTestHandler(t)
+	received := testStart.Add(1500 * time.Millisecond)
+	err := pg.Put("logs", map[string]interface{}{
+		"timestamp":   testStart.Add(123456 * time.Microsecond),
+		"level":       "ERROR",
+		"message":     "payment failed",
+		"source":      "api",
+		"method":      "POST",
+		"address":     "10.0.0.1:1234",
+		"length":      int64(42),
+		"metadata":    `{"status": 500}`,
+		"label":       "checkout",
+		"received_at": received,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := pg.Query(Query{})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("got %v, %v", rows, err)
+	}
+	row := rows[0]
+	want := map[string]interface{}{
+		"id":          int64(1),
+		"timestamp":   testStart.Add(123456 * time.Microsecond),
+		"level":       "ERROR",
+		"message":     "payment failed",
+		"source":      "api",
+		"method":      "POST",
+		"address":     "10.0.0.1:1234",
+		"length":      int64(42),
+		"metadata":    `{"status": 500}`,
+		"label":       "checkout",
+		"received_at": received,
+	}
+	if len(row) != len(LogColumns) {
+		t.Errorf("got columns %v, want %v", row, LogColumns)
+	}
+	for col, value := range want {
+		got := row[col]
+		if t1, ok := value.(time.Time); ok {
+			if t2, ok := got.(time.Time); !ok || !t1.Equal(t2) {
+				t.Errorf("%s: got %v, want %v", col, got, value)
+			}
+			continue
+		}
+		if got != value {
+			t.Errorf("%s: got %#v, want %#v", col, got, value)
+		}
+	}
+}
+
+// TestPostgresMatchesMemory runs queries against a real PostgreSQL server and the memory
+// handler and expects the same logs from both
+func TestPostgresMatchesMemory(t *testing.T) {
+	pg := postgresTestHandler(t)
+	mem, _ := NewMemoryHandler(100)
+
+	logs := []struct{ level, message, metadata string }{
+		{"ERROR", "connection error", `{"status": 500, "user": "ann"}`},
+		{"INFO", "slow query", `{"status": 200, "user": "bob"}`},
+		{"INFO", "100% done", `{"status": 200}`},
+		{"WARNING", "under_score Errors", `{"status": 404, "user": "ann"}`},
+	}
+	for i, l := range logs {
+		data := map[string]interface{}{
+			"timestamp": testStart.Add(time.Duration(i) * time.Second),
+			"level":     l.level,
+			"message":   l.message,
+			"metadata":  l.metadata,
+		}
+		for _, db := range []DBHandler{pg, mem} {
+			if err := db.Put("logs", data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	queries := map[string]Query{
+		"text part of a word":  {Text: []string{"err"}},
+		"text ignores case":    {Text: []string{"ERROR"}},
+		"text percent":         {Text: []string{"100%"}},
+		"text underscore":      {Text: []string{"_"}},
+		"two terms":            {Text: []string{"err", "under"}},
+		"level":                {Conditions: []Condition{{Field: "level", Op: OpIn, Value: []interface{}{"ERROR", "WARNING"}}}},
+		"metadata equal":       {Conditions: []Condition{{Field: "metadata.user", Op: OpEq, Value: "ann"}}},
+		"metadata compare":     {Conditions: []Condition{{Field: "metadata.status", Op: OpGte, Value: int64(400)}}},
+		"metadata missing":     {Conditions: []Condition{{Field: "metadata.user", Op: OpEq, Value: "ann", Negate: true}}},
+		"message contains":     {Conditions: []Condition{{Field: "message", Op: OpContains, Value: "QUERY"}}},
+		"since":                {Since: testStart.Add(2 * time.Second)},
+		"after cursor":         {After: &Cursor{Timestamp: testStart.Add(2 * time.Second), ID: 3}},
+		"limit ascending page": {Ascending: true, Limit: 2, Offset: 1},
+	}
+	for name, q := range queries {
+		t.Run(name, func(t *testing.T) {
+			q.OrderBy = "timestamp"
+			want, err := mem.Query(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := pg.Query(q)
+			if err != nil {
+				t.Fatalf("PostgreSQL: %v", err)
+			}
+			if messages(got) != messages(want) {
+				t.Errorf("PostgreSQL returned %q, memory %q", messages(got), messages(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rebind numbers the '?' placeholders of a clause from $start onwards
+func rebind(clause string, start int) string {
+	var b strings.Builder
+	n := start
+	for _, r := range clause {
+		if r == '?' {
+			fmt.Fprintf(&b, "$%d", n)
+			n++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// deleteInBatches deletes the rows matching where, oldest first
+func (h *PostgresHandler) deleteInBatches(ctx context.Context, where string, args []interface{}, batch int, limit int64) (int64, error) {
+	query := fmt.Sprintf(
+		"DELETE FROM logs WHERE id IN (SELECT id FROM logs WHERE %s ORDER BY timestamp, id LIMIT $%d)",
+		rebind(where, 1), len(args)+1,
+	)
+	return batchDelete(ctx, batch, limit, func(size int64) (int64, error) {
+		tag, err := h.pool.Exec(ctx, query, append(append([]interface{}{}, args...), size)...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete batch: %w", err)
+		}
+		return tag.RowsAffected(), nil
+	})
+}
+
+// Prune deletes logs that fall outside the policy in small batches. PostgreSQL's
+// autovacuum makes the space available again, the files rarely shrink.
+func (h *PostgresHandler) Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error) {
+	result := PruneResult{StartedAt: time.Now()}
+	err := pruneRows(ctx, h, policy, &result)
+	if result.Deleted > 0 {
+		result.Note = "freed space is reused for new logs after autovacuum"
+	}
+	result.Duration = time.Since(result.StartedAt)
+	return result, err
+}
+
+// StorageStats reports the number of rows, the size of the table with its indexes and the time range held
+func (h *PostgresHandler) StorageStats() (StorageStats, error) {
+	var stats StorageStats
+	ctx := context.Background()
+
+	if err := h.pool.QueryRow(ctx, "SELECT pg_total_relation_size('logs')").Scan(&stats.Bytes); err != nil {
+		return stats, fmt.Errorf("failed to read table size: %w", err)
+	}
+
+	// Dead tuples are the closest thing to SQLite's free pages, estimate their share of the size
+	var live, dead int64
+	row := h.pool.QueryRow(ctx, "SELECT coalesce(n_live_tup, 0), coalesce(n_dead_tup, 0) FROM pg_stat_user_tables WHERE relname = 'logs'")
+	if err := row.Scan(&live, &dead); err == nil && live+dead > 0 {
+		stats.FreeBytes = stats.Bytes * dead / (live + dead)
+	}
+
+	var oldest, newest *time.Time
+	row = h.pool.QueryRow(ctx, "SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM logs")
+	if err := row.Scan(&stats.Rows, &oldest, &newest); err != nil {
+		return stats, fmt.Errorf("failed to read logs range: %w", err)
+	}
+	if oldest != nil {
+		stats.Oldest = oldest.UTC()
+	}
+	if newest != nil {
+		stats.Newest = newest.UTC()
+	}
+	return stats, nil
+}
@@ -0,0 +1,184 @@
+package dbhandler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimestampLayout is the layout the logs table stores timestamps in. The fraction has a fixed
+// width so that comparing the text compares the times.
+const TimestampLayout = "2006-01-02 15:04:05.000000000"
+
+// LogColumns are the columns of the logs table that queries may reference directly
+var LogColumns = []string{"id", "timestamp", "level", "message", "source", "method", "address", "length", "metadata", "label", "received_at"}
+
+// MetadataPrefix marks a field as a path into the metadata JSON, e.g. "metadata.user_id"
+const MetadataPrefix = "metadata."
+
+// Operator is the comparison a Condition applies to a field
+type Operator string
+
+const (
+	OpEq       Operator = "="
+	OpNotEq    Operator = "!="
+	OpGt       Operator = ">"
+	OpGte      Operator = ">="
+	OpLt       Operator = "<"
+	OpLte      Operator = "<="
+	OpContains Operator = "contains" // Case-insensitive substring match
+	OpIn       Operator = "in"       // Value is a []interface{}
+)
+
+// Condition filters on a single field, either a column or a metadata path
+type Condition struct {
+	Field  string
+	Op     Operator
+	Value  interface{}
+	Negate bool
+}
+
+// Query is the backend independent description of a log query.
+// The query language compiles to it and every DBHandler knows how to run it.
+type Query struct {
+	Table      string
+	Conditions []Condition
+	Text       []string      // Terms that must all appear in the message
+	Since      time.Time     // Zero means unbounded
+	Until      time.Time     // Zero means unbounded
+	GroupBy    []string      // Fields to group by when Count is set
+	Count      bool          // Return counts instead of rows
+	Bucket     time.Duration // Also group counts by time buckets of this size, see BucketStart
+	Aggregates []Aggregate   // Statistics of numeric fields computed per group when Count is set
+	OrderBy    string
+	Ascending  bool
+	Limit      int
+	Offset     int
+	After      *Cursor // Only logs after this position in the query's timestamp order
+	Before     *Cursor // Only the logs right before this position, still in the query's order
+}
+
+// queryFromConditions turns the conditions accepted by Get (equality on columns plus
+// limit, offset and orderBy) into a Query
+func queryFromConditions(table string, conditions map[string]interface{}) Query {
+	q := Query{Table: table}
+	for col, val := range conditions {
+		switch strings.ToLower(col) {
+		case "limit":
+			q.Limit, _ = val.(int)
+		case "offset":
+			q.Offset, _ = val.(int)
+		case "orderby":
+			q.OrderBy = fmt.Sprint(val)
+		default:
+			q.Conditions = append(q.Conditions, Condition{Field: col, Op: OpEq, Value: val})
+		}
+	}
+	return q
+}
+
+// IsAggregate reports whether the query returns groups instead of log rows
+func (q Query) IsAggregate() bool {
+	return q.Count
+}
+
+// isResultColumn reports whether name is a column only aggregate results have, the bucket
+// or the name of one of the aggregates
+func (q Query) isResultColumn(name string) bool {
+	if q.Bucket > 0 && name == BucketColumn {
+		return true
+	}
+	for _, a := range q.Aggregates {
+		if a.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMetadataField reports whether the field is a path into the metadata JSON
+func IsMetadataField(field string) bool {
+	return strings.HasPrefix(field, MetadataPrefix) && len(field) > len(MetadataPrefix)
+}
+
+// MetadataPath turns "metadata.request.status" into the JSON path "$.request.status".
+// Keys that are not plain identifiers, such as "trace-id", are quoted.
+func MetadataPath(field string) string {
+	parts := strings.Split(strings.TrimPrefix(field, MetadataPrefix), ".")
+	for i, part := range parts {
+		if strings.ContainsRune(part, '-') {
+			parts[i] = `"` + part + `"`
+		}
+	}
+	return "$." + strings.Join(parts, ".")
+}
+
+// ValidateField checks that a field is a known column or a well formed metadata path
+func ValidateField(field string) error {
+	if IsMetadataField(field) {
+		for _, part := range strings.Split(strings.TrimPrefix(field, MetadataPrefix), ".") {
+			if part == "" {
+				return fmt.Errorf("invalid metadata path: %s", field)
+			}
+			for _, r := range part {
+				if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+					return fmt.Errorf("invalid character %q in metadata path: %s", r, field)
+				}
+			}
+		}
+		return nil
+	}
+	for _, col := range LogColumns {
+		if col == field {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown field: %s", field)
+}
+
+// Validate checks every field referenced by the query
+func (q Query) Validate() error {
+	for _, c := range q.Conditions {
+		if err := ValidateField(c.Field); err != nil {
+			return err
+		}
+		if c.Op == OpIn {
+			if _, ok := c.Value.([]interface{}); !ok {
+				return fmt.Errorf("operator in on %s needs a list value", c.Field)
+			}
+		}
+	}
+	for _, g := range q.GroupBy {
+		if err := ValidateField(g); err != nil {
+			return err
+		}
+	}
+	if q.Bucket < 0 || q.Bucket%time.Second != 0 {
+		return fmt.Errorf("bucket must be a whole number of seconds")
+	}
+	if (q.Bucket > 0 || len(q.Aggregates) > 0) && !q.IsAggregate() {
+		return fmt.Errorf("buckets and aggregates need a count query")
+	}
+	for _, a := range q.Aggregates {
+		if err := a.validate(); err != nil {
+			return err
+		}
+	}
+	if q.OrderBy != "" && !(q.IsAggregate() && (q.OrderBy == "count" || q.isResultColumn(q.OrderBy))) {
+		if err := ValidateField(q.OrderBy); err != nil {
+			return err
+		}
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return fmt.Errorf("limit and offset must not be negative")
+	}
+	if q.After != nil || q.Before != nil {
+		if q.After != nil && q.Before != nil {
+			return fmt.Errorf("a query pages either after or before a cursor, not both")
+		}
+		if q.IsAggregate() || q.OrderBy != "timestamp" || q.Offset > 0 {
+			return fmt.Errorf("cursors page through logs sorted by timestamp, without an offset")
+		}
+	}
+	return nil
+}
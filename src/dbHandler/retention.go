@@ -0,0 +1,328 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+)
+
+// pruneBatchPause gives writers a chance to take the lock between delete batches
+const pruneBatchPause = 10 * time.Millisecond
+
+// RetentionRule keeps logs of a level and/or source for a different duration than MaxAge.
+// When rules overlap, the first one in the list that matches a log decides its age.
+type RetentionRule struct {
+	Level  string
+	Source string
+	MaxAge time.Duration
+}
+
+// RetentionPolicy is the parsed form of the retention configuration. Zero values mean no limit.
+type RetentionPolicy struct {
+	MaxAge    time.Duration
+	MaxRows   int64
+	MaxBytes  int64
+	BatchSize int
+	Rules     []RetentionRule
+}
+
+// IsEmpty reports whether the policy never deletes anything
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.MaxAge == 0 && p.MaxRows == 0 && p.MaxBytes == 0 && len(p.Rules) == 0
+}
+
+// PruneResult describes a single run of the pruner
+type PruneResult struct {
+	StartedAt      time.Time
+	Duration       time.Duration
+	Deleted        int64
+	ReclaimedBytes int64
+	Note           string
+	Err            error
+}
+
+// StorageStats describes how much data a handler currently holds
+type StorageStats struct {
+	Rows      int64
+	Bytes     int64 // Size on disk
+	FreeBytes int64 // Part of Bytes that is unused and can be vacuumed
+	Oldest    time.Time
+	Newest    time.Time
+}
+
+// Retainer is implemented by handlers that can prune old logs and report their usage
+type Retainer interface {
+	Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error)
+	StorageStats() (StorageStats, error)
+}
+
+// sqlPruner is implemented by the SQL backends so they share the row based pruning logic
+type sqlPruner interface {
+	// deleteInBatches deletes rows matching where ('?' placeholders), oldest first, at most
+	// batch rows per statement and at most limit rows in total (0 means no limit)
+	deleteInBatches(ctx context.Context, where string, args []interface{}, batch int, limit int64) (int64, error)
+	StorageStats() (StorageStats, error)
+}
+
+// batchDelete calls del with batch sizes until it deletes fewer rows than asked for or limit
+// rows are gone (0 means no limit), pausing between batches so writers are not starved
+func batchDelete(ctx context.Context, batch int, limit int64, del func(size int64) (int64, error)) (int64, error) {
+	var total int64
+	for limit == 0 || total < limit {
+		size := int64(batch)
+		if limit > 0 && limit-total < size {
+			size = limit - total
+		}
+
+		n, err := del(size)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < size {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(pruneBatchPause):
+		}
+	}
+	return total, nil
+}
+
+// ruleClause matches the rows a retention rule applies to. IS NOT DISTINCT FROM compares NULL safely.
+func ruleClause(rule RetentionRule) (string, []interface{}) {
+	clauses := []string{}
+	args := []interface{}{}
+	if rule.Level != "" {
+		clauses = append(clauses, "level IS NOT DISTINCT FROM ?")
+		args = append(args, rule.Level)
+	}
+	if rule.Source != "" {
+		clauses = append(clauses, "source IS NOT DISTINCT FROM ?")
+		args = append(args, rule.Source)
+	}
+	return "(" + strings.Join(clauses, " AND ") + ")", args
+}
+
+// expired reports whether a log with the given level, source and timestamp falls outside the
+// policy's age limits, for handlers that filter rows themselves instead of with SQL. The first
+// matching rule decides, logs no rule matches use MaxAge.
+func (p RetentionPolicy) expired(level, source interface{}, ts time.Time, now time.Time) bool {
+	for _, rule := range p.Rules {
+		if rule.Level != "" && level != rule.Level || rule.Source != "" && source != rule.Source {
+			continue
+		}
+		return ts.Before(now.Add(-rule.MaxAge))
+	}
+	return p.MaxAge > 0 && ts.Before(now.Add(-p.MaxAge))
+}
+
+// pruneRows deletes the rows that fall outside the policy
+func pruneRows(ctx context.Context, p sqlPruner, policy RetentionPolicy, result *PruneResult) error {
+	now := time.Now().UTC()
+
+	// Rules first, they override the default max age for the rows they match. A row matched by
+	// an earlier rule is left to that one, like expired does.
+	for i, rule := range policy.Rules {
+		clause, args := ruleClause(rule)
+		where := []string{clause}
+		for _, earlier := range policy.Rules[:i] {
+			earlierClause, earlierArgs := ruleClause(earlier)
+			where = append(where, "NOT "+earlierClause)
+			args = append(args, earlierArgs...)
+		}
+		where = append(where, "timestamp < ?")
+		args = append(args, now.Add(-rule.MaxAge).Format(TimestampLayout))
+		n, err := p.deleteInBatches(ctx, strings.Join(where, " AND "), args, policy.BatchSize, 0)
+		result.Deleted += n
+		if err != nil {
+			return err
+		}
+	}
+
+	if policy.MaxAge > 0 {
+		where := []string{"timestamp < ?"}
+		args := []interface{}{now.Add(-policy.MaxAge).Format(TimestampLayout)}
+		for _, rule := range policy.Rules {
+			clause, ruleArgs := ruleClause(rule)
+			where = append(where, "NOT "+clause)
+			args = append(args, ruleArgs...)
+		}
+		n, err := p.deleteInBatches(ctx, strings.Join(where, " AND "), args, policy.BatchSize, 0)
+		result.Deleted += n
+		if err != nil {
+			return err
+		}
+	}
+
+	if policy.MaxRows == 0 && policy.MaxBytes == 0 {
+		return nil
+	}
+
+	stats, err := p.StorageStats()
+	if err != nil {
+		return err
+	}
+	keep := stats.Rows
+	if policy.MaxRows > 0 && keep > policy.MaxRows {
+		keep = policy.MaxRows
+	}
+	// Assume rows are roughly the same size to estimate how many fit in MaxBytes
+	used := stats.Bytes - stats.FreeBytes
+	if policy.MaxBytes > 0 && used > policy.MaxBytes {
+		if fit := stats.Rows * policy.MaxBytes / used; fit < keep {
+			keep = fit
+		}
+	}
+	if keep < stats.Rows {
+		n, err := p.deleteInBatches(ctx, "1 = 1", nil, policy.BatchSize, stats.Rows-keep)
+		result.Deleted += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewRetentionPolicy parses the retention section of the config
+func NewRetentionPolicy(config confighandler.Retention) (RetentionPolicy, error) {
+	policy := RetentionPolicy{MaxRows: config.MaxRows, BatchSize: config.BatchSize}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 500
+	}
+
+	var err error
+	if config.MaxAge != "" {
+		if policy.MaxAge, err = confighandler.ParseDuration(config.MaxAge); err != nil {
+			return policy, fmt.Errorf("invalid retention max_age: %w", err)
+		}
+	}
+	if config.MaxBytes != "" {
+		if policy.MaxBytes, err = confighandler.ParseSize(config.MaxBytes); err != nil {
+			return policy, fmt.Errorf("invalid retention max_bytes: %w", err)
+		}
+	}
+	for _, rule := range config.Rules {
+		maxAge, err := confighandler.ParseDuration(rule.MaxAge)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retention rule max_age: %w", err)
+		}
+		policy.Rules = append(policy.Rules, RetentionRule{Level: rule.Level, Source: rule.Source, MaxAge: maxAge})
+	}
+	return policy, nil
+}
+
+// RetentionManager runs the pruner of a handler in the background and remembers the last result
+type RetentionManager struct {
+	handler  Retainer
+	policy   RetentionPolicy
+	interval time.Duration
+
+	mu       sync.Mutex
+	last     *PruneResult
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+// NewRetentionManager returns nil when the handler does not support retention
+func NewRetentionManager(handler DBHandler, config confighandler.Retention) (*RetentionManager, error) {
+	retainer, ok := Capability[Retainer](handler)
+	if !ok {
+		return nil, nil
+	}
+
+	policy, err := NewRetentionPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := 5 * time.Minute
+	if config.Interval != "" {
+		if interval, err = confighandler.ParseDuration(config.Interval); err != nil {
+			return nil, fmt.Errorf("invalid retention interval: %w", err)
+		}
+	}
+
+	return &RetentionManager{handler: retainer, policy: policy, interval: interval}, nil
+}
+
+// Policy returns the policy the manager enforces
+func (m *RetentionManager) Policy() RetentionPolicy {
+	return m.policy
+}
+
+// Start runs the pruner every interval until Stop is called
+func (m *RetentionManager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.cancel = cancel
+	m.finished = make(chan struct{})
+	m.mu.Unlock()
+
+	go func() {
+		defer close(m.finished)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			m.RunOnce(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels a running prune and waits for the background loop to exit
+func (m *RetentionManager) Stop() {
+	m.mu.Lock()
+	cancel, finished := m.cancel, m.finished
+	m.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-finished
+	}
+}
+
+// RunOnce prunes immediately and records the result
+func (m *RetentionManager) RunOnce(ctx context.Context) PruneResult {
+	if m.policy.IsEmpty() {
+		return PruneResult{}
+	}
+
+	result, err := m.handler.Prune(ctx, m.policy)
+	result.Err = err
+	if err != nil {
+		log.Printf("Error pruning logs: %v\n", err)
+	} else if result.Deleted > 0 {
+		log.Printf("Pruned %d logs in %s\n", result.Deleted, result.Duration)
+	}
+
+	m.mu.Lock()
+	m.last = &result
+	m.mu.Unlock()
+	return result
+}
+
+// LastResult returns the result of the most recent prune, or nil if none ran yet
+func (m *RetentionManager) LastResult() *PruneResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Stats returns the current storage usage of the handler
+func (m *RetentionManager) Stats() (StorageStats, error) {
+	return m.handler.StorageStats()
+}
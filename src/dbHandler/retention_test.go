@@ -0,0 +1,241 @@
+package dbhandler
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// retentionLogs are stored relative to now, so the rules of the tests below expire some of them
+var retentionLogs = []struct {
+	message string
+	level   string
+	source  string
+	age     time.Duration
+}{
+	{"old auth error", "ERROR", "auth", 10 * 24 * time.Hour},
+	{"old auth info", "INFO", "auth", 2 * 24 * time.Hour},
+	{"new auth info", "INFO", "auth", time.Hour},
+	{"old api info", "INFO", "api", 11 * 24 * time.Hour},
+	{"api info", "INFO", "api", 3 * 24 * time.Hour},
+	{"ancient api error", "ERROR", "api", 100 * 24 * time.Hour},
+}
+
+var retentionTests = []struct {
+	name   string
+	policy RetentionPolicy
+	want   string // Messages kept, oldest first
+}{
+	{
+		"default max age",
+		RetentionPolicy{MaxAge: 7 * 24 * time.Hour},
+		"api info,old auth info,new auth info",
+	},
+	{
+		"rules without a default keep unmatched logs",
+		RetentionPolicy{Rules: []RetentionRule{{Source: "auth", MaxAge: 24 * time.Hour}}},
+		"ancient api error,old api info,api info,new auth info",
+	},
+	{
+		// The error rule comes first, so errors from auth are kept for 90 days although the
+		// auth rule is shorter
+		"first matching rule applies",
+		RetentionPolicy{MaxAge: 7 * 24 * time.Hour, Rules: []RetentionRule{
+			{Level: "ERROR", MaxAge: 90 * 24 * time.Hour},
+			{Source: "auth", MaxAge: 24 * time.Hour},
+		}},
+		"old auth error,api info,new auth info",
+	},
+	{
+		"first matching rule applies in reverse",
+		RetentionPolicy{MaxAge: 7 * 24 * time.Hour, Rules: []RetentionRule{
+			{Source: "auth", MaxAge: 24 * time.Hour},
+			{Level: "ERROR", MaxAge: 90 * 24 * time.Hour},
+		}},
+		"api info,new auth info",
+	},
+	{
+		"rule on level and source",
+		RetentionPolicy{MaxAge: 24 * time.Hour, Rules: []RetentionRule{
+			{Level: "INFO", Source: "api", MaxAge: 5 * 24 * time.Hour},
+		}},
+		"api info,new auth info",
+	},
+}
+
+func TestRetentionExpired(t *testing.T) {
+	now := time.Now().UTC()
+	for _, tt := range retentionTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kept []string
+			for _, l := range retentionLogs {
+				if !tt.policy.expired(l.level, l.source, now.Add(-l.age), now) {
+					kept = append(kept, l.message)
+				}
+			}
+			if got := sortedByAge(kept); got != tt.want {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// sortedByAge orders the messages of retentionLogs oldest first and joins them
+func sortedByAge(kept []string) string {
+	age := map[string]time.Duration{}
+	for _, l := range retentionLogs {
+		age[l.message] = l.age
+	}
+	for i := 1; i < len(kept); i++ {
+		for j := i; j > 0 && age[kept[j]] > age[kept[j-1]]; j-- {
+			kept[j], kept[j-1] = kept[j-1], kept[j]
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+// retainers opens every handler with its own pruner, empty
+var retainers = []struct {
+	name string
+	open func(t *testing.T) (DBHandler, Retainer)
+}{
+	{"SQLite", func(t *testing.T) (DBHandler, Retainer) {
+		h, err := NewSQLiteHandler(filepath.Join(t.TempDir(), "logs.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		return h, h
+	}},
+	{"Segment", func(t *testing.T) (DBHandler, Retainer) {
+		h, err := NewSegmentHandler(t.TempDir(), "hour")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		return h, h
+	}},
+}
+
+// prepare writes the logs to segments, which is what the segment pruner works on
+func prepare(t *testing.T, db DBHandler) {
+	t.Helper()
+	if h, ok := db.(*SegmentHandler); ok {
+		if err := h.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPruneRules(t *testing.T) {
+	for _, retainer := range retainers {
+		for _, tt := range retentionTests {
+			t.Run(retainer.name+"/"+tt.name, func(t *testing.T) {
+				db, r := retainer.open(t)
+				now := time.Now().UTC()
+				for _, l := range retentionLogs {
+					err := db.Put("logs", map[string]interface{}{"timestamp": now.Add(-l.age), "level": l.level, "source": l.source, "message": l.message})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				prepare(t, db)
+
+				tt.policy.BatchSize = 2
+				result, err := r.Prune(context.Background(), tt.policy)
+				if err != nil {
+					t.Fatalf("Prune: %v", err)
+				}
+				rows, err := db.Query(Query{OrderBy: "timestamp", Ascending: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := messages(rows); got != tt.want {
+					t.Errorf("kept %q, want %q", got, tt.want)
+				}
+				if want := int64(len(retentionLogs) - len(rows)); result.Deleted != want {
+					t.Errorf("deleted %d, want %d", result.Deleted, want)
+				}
+			})
+		}
+	}
+}
+
+// putHours stores n logs in each of the hours before now, oldest first, and returns how many
+func putHours(t *testing.T, db DBHandler, hours, n int) int {
+	t.Helper()
+	start := time.Now().UTC().Truncate(time.Hour).Add(-time.Duration(hours) * time.Hour)
+	for h := 0; h < hours; h++ {
+		for i := 0; i < n; i++ {
+			err := db.Put("logs", map[string]interface{}{
+				"timestamp": start.Add(time.Duration(h)*time.Hour + time.Duration(i)*time.Second),
+				"level":     "INFO",
+				"message":   strings.Repeat("a log that takes up some room ", 4) + string(rune('a'+h)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	prepare(t, db)
+	return hours * n
+}
+
+func TestPruneMaxRows(t *testing.T) {
+	for _, retainer := range retainers {
+		t.Run(retainer.name, func(t *testing.T) {
+			db, r := retainer.open(t)
+			total := putHours(t, db, 3, 10)
+
+			// Segments are dropped whole, the oldest ones until at most MaxRows are left
+			result, err := r.Prune(context.Background(), RetentionPolicy{MaxRows: 20, BatchSize: 3})
+			if err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			stats, err := r.StorageStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Rows != 20 || result.Deleted != int64(total-20) {
+				t.Errorf("kept %d and deleted %d of %d, want 20 kept", stats.Rows, result.Deleted, total)
+			}
+			rows, err := db.Query(Query{OrderBy: "timestamp", Ascending: true, Limit: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 || !strings.HasSuffix(rows[0]["message"].(string), "b") {
+				t.Errorf("the oldest log left is %v, want one of the second hour", rows)
+			}
+		})
+	}
+}
+
+func TestPruneMaxBytes(t *testing.T) {
+	for _, retainer := range retainers {
+		t.Run(retainer.name, func(t *testing.T) {
+			db, r := retainer.open(t)
+			total := putHours(t, db, 4, 200)
+			before, err := r.StorageStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			limit := (before.Bytes - before.FreeBytes) / 2
+			if _, err := r.Prune(context.Background(), RetentionPolicy{MaxBytes: limit, BatchSize: 100}); err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			after, err := r.StorageStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Rows == 0 || after.Rows >= int64(total) {
+				t.Fatalf("kept %d of %d logs", after.Rows, total)
+			}
+			if !after.Oldest.After(before.Oldest) || !after.Newest.Equal(before.Newest) {
+				t.Errorf("kept logs from %s to %s, want the newest of %s to %s", after.Oldest, after.Newest, before.Oldest, before.Newest)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package dbhandler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// compareValues orders two column values the way SQLite would: NULL first, then numbers, then text
+func compareValues(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case int, int64, float64, bool:
+			return 1
+		case time.Time:
+			return 2
+		default:
+			return 3
+		}
+	}
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch ra {
+	case 0:
+		return 0
+	case 1:
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case 2:
+		return a.(time.Time).Compare(b.(time.Time))
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case bool:
+		if n {
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortRows orders rows by a column or metadata path, breaking ties by id so the order is stable
+func sortRows(rows []map[string]interface{}, field string, ascending bool) {
+	keys := sortKeys(len(rows), func(i int) fieldGetter { return rowGetter(rows[i]) }, field)
+	sorted := make([]map[string]interface{}, len(rows))
+	for i, j := range sortedOrder(keys, ascending) {
+		sorted[i] = rows[j]
+	}
+	copy(rows, sorted)
+}
+
+// sortKeys looks up the sort field and id of every row once, instead of on every comparison
+func sortKeys(n int, getter func(i int) fieldGetter, field string) [][2]interface{} {
+	keys := make([][2]interface{}, n)
+	for i := range keys {
+		get := getter(i)
+		keys[i] = [2]interface{}{get(field), get("id")}
+	}
+	return keys
+}
+
+// sortedOrder returns the positions of the keys in sorted order
+func sortedOrder(keys [][2]interface{}, ascending bool) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ka, kb := keys[order[a]], keys[order[b]]
+		c := compareValues(ka[0], kb[0])
+		if c == 0 {
+			c = compareValues(ka[1], kb[1])
+		}
+		if ascending {
+			return c < 0
+		}
+		return c > 0
+	})
+	return order
+}
+
+// pageRows applies offset and limit to rows that are already sorted
+func pageRows(rows []map[string]interface{}, limit, offset int) []map[string]interface{} {
+	if offset >= len(rows) {
+		return nil
+	}
+	rows = rows[offset:]
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// mergeCounts adds up count rows that share the same group values and bucket, as returned
+// by running the mergeableQuery of q over several parts of the data
+func mergeCounts(q Query, parts ...[]map[string]interface{}) []map[string]interface{} {
+	counter := newGroupCounter(q)
+	for _, rows := range parts {
+		for _, row := range rows {
+			counter.addCounts(row)
+		}
+	}
+	return counter.rows()
+}
+
+// finishCounts sorts and pages merged count rows like the SQL query would have
+func finishCounts(q Query, rows []map[string]interface{}) []map[string]interface{} {
+	if len(q.GroupBy) > 0 || q.Bucket > 0 {
+		field := q.OrderBy
+		if field == "" {
+			field = "count"
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			c := compareValues(rows[i][field], rows[j][field])
+			if q.Ascending {
+				return c < 0
+			}
+			return c > 0
+		})
+	}
+	return pageRows(rows, q.Limit, q.Offset)
+}
@@ -0,0 +1,83 @@
+package dbhandler
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// bloomHashes is the number of bit positions set per token, good for a ~1% false positive rate
+const bloomHashes = 7
+
+// bloomFilter answers "might this segment contain the token" without reading the segment
+type bloomFilter struct {
+	Bits []byte
+	K    int
+}
+
+// newBloomFilter sizes a filter for n tokens at a 1% false positive rate
+func newBloomFilter(n int) *bloomFilter {
+	m := int(math.Ceil(-float64(n) * math.Log(0.01) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	return &bloomFilter{Bits: make([]byte, (m+7)/8), K: bloomHashes}
+}
+
+// positions derives K bit positions from two halves of a 64 bit hash (Kirsch-Mitzenmacher)
+func (f *bloomFilter) positions(token string, visit func(bit uint64) bool) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(token))
+	sum := hash.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	m := uint64(len(f.Bits) * 8)
+	for i := 0; i < f.K; i++ {
+		if !visit((h1 + uint64(i)*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(token string) {
+	f.positions(token, func(bit uint64) bool {
+		f.Bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+func (f *bloomFilter) mayContain(token string) bool {
+	if len(f.Bits) == 0 {
+		return true
+	}
+	return f.positions(token, func(bit uint64) bool {
+		return f.Bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// trigrams returns the distinct three byte tokens of the lower cased text. Indexing trigrams
+// instead of words keeps substring search exact: a message containing a term contains every
+// trigram of it, so a segment missing any of them cannot match.
+func trigrams(text string) []string {
+	text = strings.ToLower(text)
+	seen := map[string]bool{}
+	tokens := []string{}
+	for i := 0; i+3 <= len(text); i++ {
+		if token := text[i : i+3]; !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// mayContainText reports whether any message in the segment could contain the term.
+// Terms shorter than a trigram cannot be ruled out.
+func (f *bloomFilter) mayContainText(term string) bool {
+	for _, token := range trigrams(term) {
+		if !f.mayContain(token) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,466 @@
+package dbhandler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A segment file holds the logs of one time window, sorted by timestamp and stored column
+// by column so each column compresses well and queries only decompress what they touch:
+//
+//	magic | zstd column blocks... | footer JSON | footer length (uint32) | magic
+//
+// The footer holds the row count, time and id ranges, the distinct levels and sources and a
+// bloom filter over message trigrams, so most segments can be ruled out without reading them.
+var segmentMagic = []byte("LLSEG001")
+
+const segmentFileSuffix = ".lls"
+
+// Column encodings, applied before zstd compression
+const (
+	encodingDelta      = "delta"      // Zigzag varint deltas of int64 values, used for ids and timestamps
+	encodingDictionary = "dictionary" // A list of distinct strings and one varint index per row
+	encodingStrings    = "strings"    // Length prefixed strings
+	encodingInt        = "int"        // Zigzag varints
+)
+
+// segmentColumns maps each column to its encoding. Low cardinality text is dictionary encoded.
+var segmentColumns = map[string]string{
+	"id":        encodingDelta,
+	"timestamp": encodingDelta,
+	"level":     encodingDictionary,
+	"source":    encodingDictionary,
+	"method":    encodingDictionary,
+	"label":     encodingDictionary,
+	"address":   encodingStrings,
+	"message":   encodingStrings,
+	"metadata":  encodingStrings,
+	"length":    encodingInt,
+	// NULL is written as 0, which is never a time a log was received at
+	"received_at": encodingDelta,
+}
+
+type blockRef struct {
+	Offset int64
+	Length int64
+}
+
+type segmentFooter struct {
+	Window  string
+	Rows    int
+	MinTime int64 // Unix nanoseconds
+	MaxTime int64
+	MinID   int64
+	MaxID   int64
+	Levels  []string // Distinct values, used to skip segments on level and source filters
+	Sources []string
+	Bloom   bloomFilter
+	Columns map[string]blockRef
+}
+
+// segment is an immutable segment file and its footer
+type segment struct {
+	path   string
+	size   int64
+	footer segmentFooter
+}
+
+func (s *segment) minTime() time.Time { return time.Unix(0, s.footer.MinTime).UTC() }
+func (s *segment) maxTime() time.Time { return time.Unix(0, s.footer.MaxTime).UTC() }
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodec returns the shared encoder and decoder, EncodeAll and DecodeAll are safe for concurrent use
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+func putZigzag(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+// encodeColumn encodes the values of one column. NULLs are written as 0 and values shifted by one.
+func encodeColumn(encoding string, values []interface{}) []byte {
+	var buf bytes.Buffer
+	switch encoding {
+	case encodingDelta:
+		var prev int64
+		for _, v := range values {
+			var n int64
+			switch t := v.(type) {
+			case int64:
+				n = t
+			case time.Time:
+				n = t.UnixNano()
+			}
+			putZigzag(&buf, n-prev)
+			prev = n
+		}
+	case encodingDictionary:
+		index := map[string]uint64{}
+		dict := []string{}
+		codes := make([]uint64, len(values))
+		for i, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			code, ok := index[s]
+			if !ok {
+				dict = append(dict, s)
+				code = uint64(len(dict))
+				index[s] = code
+			}
+			codes[i] = code
+		}
+		putUvarint(&buf, uint64(len(dict)))
+		for _, s := range dict {
+			putUvarint(&buf, uint64(len(s)))
+			buf.WriteString(s)
+		}
+		for _, code := range codes {
+			putUvarint(&buf, code)
+		}
+	case encodingStrings:
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				putUvarint(&buf, 0)
+				continue
+			}
+			putUvarint(&buf, uint64(len(s))+1)
+			buf.WriteString(s)
+		}
+	case encodingInt:
+		for _, v := range values {
+			n, ok := v.(int64)
+			if !ok {
+				putUvarint(&buf, 0)
+				continue
+			}
+			putUvarint(&buf, uint64(n<<1^n>>63)+1)
+		}
+	}
+	return buf.Bytes()
+}
+
+var errCorruptSegment = errors.New("corrupt segment column")
+
+// decodeColumn reverses encodeColumn
+func decodeColumn(column, encoding string, data []byte, rows int) ([]interface{}, error) {
+	r := bytes.NewReader(data)
+	values := make([]interface{}, rows)
+	readString := func(n uint64) (string, error) {
+		if n > uint64(r.Len()) {
+			return "", errCorruptSegment
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return string(b), err
+	}
+
+	switch encoding {
+	case encodingDelta:
+		var prev int64
+		for i := range values {
+			delta, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, errCorruptSegment
+			}
+			prev += delta
+			switch {
+			case column == "received_at" && prev == 0:
+			case isTimeColumn(column):
+				values[i] = time.Unix(0, prev).UTC()
+			default:
+				values[i] = prev
+			}
+		}
+	case encodingDictionary:
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > uint64(len(data)) {
+			return nil, errCorruptSegment
+		}
+		dict := make([]string, size)
+		for i := range dict {
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errCorruptSegment
+			}
+			if dict[i], err = readString(n); err != nil {
+				return nil, errCorruptSegment
+			}
+		}
+		for i := range values {
+			code, err := binary.ReadUvarint(r)
+			if err != nil || code > size {
+				return nil, errCorruptSegment
+			}
+			if code > 0 {
+				values[i] = dict[code-1]
+			}
+		}
+	case encodingStrings:
+		for i := range values {
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errCorruptSegment
+			}
+			if n > 0 {
+				if values[i], err = readString(n - 1); err != nil {
+					return nil, errCorruptSegment
+				}
+			}
+		}
+	case encodingInt:
+		for i := range values {
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errCorruptSegment
+			}
+			if n > 0 {
+				u := n - 1
+				values[i] = int64(u>>1) ^ -int64(u&1)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown column encoding %s", encoding)
+	}
+	return values, nil
+}
+
+// writeSegment writes rows to a new segment file. Rows are sorted by timestamp and id first.
+func writeSegment(path, window string, rows []map[string]interface{}) (*segment, error) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i]["timestamp"].(time.Time), rows[j]["timestamp"].(time.Time)
+		if a.Equal(b) {
+			return rows[i]["id"].(int64) < rows[j]["id"].(int64)
+		}
+		return a.Before(b)
+	})
+
+	footer := segmentFooter{Window: window, Rows: len(rows), Columns: map[string]blockRef{}}
+	levels, sources := map[string]bool{}, map[string]bool{}
+	grams := map[string]bool{}
+	for i, row := range rows {
+		ts := row["timestamp"].(time.Time).UnixNano()
+		id := row["id"].(int64)
+		if i == 0 || ts < footer.MinTime {
+			footer.MinTime = ts
+		}
+		if i == 0 || ts > footer.MaxTime {
+			footer.MaxTime = ts
+		}
+		if i == 0 || id < footer.MinID {
+			footer.MinID = id
+		}
+		if i == 0 || id > footer.MaxID {
+			footer.MaxID = id
+		}
+		if level, ok := row["level"].(string); ok && !levels[level] {
+			levels[level] = true
+			footer.Levels = append(footer.Levels, level)
+		}
+		if source, ok := row["source"].(string); ok && !sources[source] {
+			sources[source] = true
+			footer.Sources = append(footer.Sources, source)
+		}
+		if message, ok := row["message"].(string); ok {
+			for _, gram := range trigrams(message) {
+				grams[gram] = true
+			}
+		}
+	}
+	bloom := newBloomFilter(len(grams))
+	for gram := range grams {
+		bloom.add(gram)
+	}
+	footer.Bloom = *bloom
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %w", err)
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	encoder, _ := zstdCodec()
+	var out bytes.Buffer
+	out.Write(segmentMagic)
+	values := make([]interface{}, len(rows))
+	for _, column := range LogColumns {
+		for i, row := range rows {
+			values[i] = row[column]
+		}
+		block := encoder.EncodeAll(encodeColumn(segmentColumns[column], values), nil)
+		footer.Columns[column] = blockRef{Offset: int64(out.Len()), Length: int64(len(block))}
+		out.Write(block)
+	}
+
+	footerJSON, err := json.Marshal(footer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode segment footer: %w", err)
+	}
+	out.Write(footerJSON)
+	binary.Write(&out, binary.LittleEndian, uint32(len(footerJSON)))
+	out.Write(segmentMagic)
+
+	if _, err := file.Write(out.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close segment: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("failed to rename segment: %w", err)
+	}
+	return &segment{path: path, size: int64(out.Len()), footer: footer}, nil
+}
+
+// openSegment reads the footer of a segment file
+func openSegment(path string) (*segment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	trailerSize := int64(4 + len(segmentMagic))
+	if info.Size() < int64(len(segmentMagic))+trailerSize {
+		return nil, fmt.Errorf("segment %s is truncated", path)
+	}
+
+	trailer := make([]byte, trailerSize)
+	if _, err := file.ReadAt(trailer, info.Size()-trailerSize); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(trailer[4:], segmentMagic) {
+		return nil, fmt.Errorf("segment %s has no valid trailer", path)
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(trailer[:4]))
+	if footerLen > info.Size()-trailerSize {
+		return nil, fmt.Errorf("segment %s has a corrupt footer", path)
+	}
+
+	footerJSON := make([]byte, footerLen)
+	if _, err := file.ReadAt(footerJSON, info.Size()-trailerSize-footerLen); err != nil {
+		return nil, err
+	}
+	s := &segment{path: path, size: info.Size()}
+	if err := json.Unmarshal(footerJSON, &s.footer); err != nil {
+		return nil, fmt.Errorf("segment %s has a corrupt footer: %w", path, err)
+	}
+	return s, nil
+}
+
+// readColumn decompresses and decodes one column of the segment
+func (s *segment) readColumn(column string) ([]interface{}, error) {
+	ref, ok := s.footer.Columns[column]
+	if !ok {
+		return make([]interface{}, s.footer.Rows), nil
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer file.Close()
+
+	block := make([]byte, ref.Length)
+	if _, err := file.ReadAt(block, ref.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read segment column %s: %w", column, err)
+	}
+	_, decoder := zstdCodec()
+	data, err := decoder.DecodeAll(block, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress segment column %s: %w", column, err)
+	}
+	values, err := decodeColumn(column, segmentColumns[column], data, s.footer.Rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode segment column %s of %s: %w", column, s.path, err)
+	}
+	return values, nil
+}
+
+// segmentView reads the columns of a segment on first use while a query runs
+type segmentView struct {
+	seg     *segment
+	columns map[string][]interface{}
+	metas   map[int]map[string]interface{}
+	err     error
+}
+
+func newSegmentView(seg *segment) *segmentView {
+	return &segmentView{seg: seg, columns: map[string][]interface{}{}, metas: map[int]map[string]interface{}{}}
+}
+
+func (v *segmentView) column(name string) []interface{} {
+	values, ok := v.columns[name]
+	if !ok {
+		var err error
+		if values, err = v.seg.readColumn(name); err != nil {
+			if v.err == nil {
+				v.err = err
+			}
+			values = make([]interface{}, v.seg.footer.Rows)
+		}
+		v.columns[name] = values
+	}
+	return values
+}
+
+// getter returns the field getter of row i
+func (v *segmentView) getter(i int) fieldGetter {
+	return func(field string) interface{} {
+		if !IsMetadataField(field) {
+			return v.column(field)[i]
+		}
+		meta, ok := v.metas[i]
+		if !ok {
+			meta = parseMetadata(v.column("metadata")[i])
+			v.metas[i] = meta
+		}
+		return metadataLookup(meta, field)
+	}
+}
+
+// row materializes row i
+func (v *segmentView) row(i int) map[string]interface{} {
+	row := make(map[string]interface{}, len(LogColumns))
+	for _, column := range LogColumns {
+		row[column] = v.column(column)[i]
+	}
+	return row
+}
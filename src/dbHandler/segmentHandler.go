@@ -0,0 +1,596 @@
+package dbhandler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentFilePrefix = "seg-"
+	walFilePrefix     = "wal-"
+	walFileSuffix     = ".ndjson"
+)
+
+// SegmentHandler stores logs in append-only, column compressed segment files, one or more per
+// time window. New logs are kept in memory and in a write-ahead log until enough have arrived
+// to write a segment. Small segments of the same window are compacted in the background.
+type SegmentHandler struct {
+	dir           string
+	window        time.Duration
+	layout        string
+	flushRows     int
+	flushInterval time.Duration
+	compactBelow  int // Segments with fewer rows are merged with their neighbours
+
+	mu       sync.RWMutex // Guards segments, held for reading while a query runs
+	segments []*segment
+	nextSeq  int
+
+	memMu    sync.Mutex // Guards the rows not yet in a segment and the write-ahead log
+	memtable []*memoryRow
+	flushing []*memoryRow // Rows being written to segments, still visible to queries
+	nextID   int64
+	wal      *os.File
+	walBuf   *bufio.Writer
+	walPaths []string // Write-ahead logs whose rows are not in segments yet
+	nextWAL  int
+	closed   bool
+
+	flushMu sync.Mutex // Serializes flushes and compactions
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// SegmentOption is a function that configures a SegmentHandler
+type SegmentOption func(*SegmentHandler)
+
+// WithFlushRows sets how many logs are buffered before they are written to a segment
+func WithFlushRows(n int) SegmentOption {
+	return func(h *SegmentHandler) {
+		h.flushRows = n
+	}
+}
+
+// WithFlushInterval sets how long logs may stay buffered before they are written to a segment
+func WithFlushInterval(d time.Duration) SegmentOption {
+	return func(h *SegmentHandler) {
+		h.flushInterval = d
+	}
+}
+
+// NewSegmentHandler opens the segment store in dir. window is "hour" or "day".
+func NewSegmentHandler(dir string, window string, opts ...SegmentOption) (*SegmentHandler, error) {
+	h := &SegmentHandler{
+		dir:           dir,
+		flushRows:     10_000,
+		flushInterval: 10 * time.Second,
+		compactBelow:  100_000,
+		nextID:        1,
+		kick:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	switch window {
+	case "", "hour":
+		h.window = time.Hour
+		h.layout = "2006-01-02T15"
+	case "day":
+		h.window = 24 * time.Hour
+		h.layout = "2006-01-02"
+	default:
+		return nil, fmt.Errorf("unsupported segment window %q (must be hour or day)", window)
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create segment directory: %w", err)
+	}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	// Write what the write-ahead logs recovered so the store starts from a clean state
+	if err := h.flush(); err != nil {
+		return nil, err
+	}
+
+	go h.run()
+	return h, nil
+}
+
+// load opens the existing segments and replays the write-ahead logs
+func (h *SegmentHandler) load() error {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list segments: %w", err)
+	}
+
+	walPaths := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasPrefix(name, segmentFilePrefix) && strings.HasSuffix(name, segmentFileSuffix):
+			seg, err := openSegment(filepath.Join(h.dir, name))
+			if err != nil {
+				return fmt.Errorf("failed to open segment: %w", err)
+			}
+			h.segments = append(h.segments, seg)
+			if seq := sequenceOf(name, segmentFileSuffix); seq >= h.nextSeq {
+				h.nextSeq = seq + 1
+			}
+			if seg.footer.MaxID >= h.nextID {
+				h.nextID = seg.footer.MaxID + 1
+			}
+		case strings.HasPrefix(name, walFilePrefix) && strings.HasSuffix(name, walFileSuffix):
+			walPaths = append(walPaths, filepath.Join(h.dir, name))
+			if seq := sequenceOf(name, walFileSuffix); seq >= h.nextWAL {
+				h.nextWAL = seq + 1
+			}
+		case strings.HasSuffix(name, ".tmp"):
+			// Left behind by a crash while writing a segment
+			os.Remove(filepath.Join(h.dir, name))
+		}
+	}
+	sort.Strings(walPaths)
+
+	for _, path := range walPaths {
+		if err := h.replay(path); err != nil {
+			return fmt.Errorf("failed to replay %s: %w", path, err)
+		}
+	}
+	h.walPaths = walPaths
+	return h.openWAL()
+}
+
+// sequenceOf reads the sequence number at the end of a file name such as seg-2024-01-02T15-000012.lls
+func sequenceOf(name, suffix string) int {
+	name = strings.TrimSuffix(name, suffix)
+	seq, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return seq
+}
+
+// replay adds the rows of a write-ahead log to the memtable. Entries that cannot be read, such as
+// a torn last line from a crash, are logged and skipped.
+func (h *SegmentHandler) replay(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		row, err := walRow(line)
+		if err != nil {
+			log.Printf("Skipping unreadable write-ahead log entry on line %d of %s: %v\n", i+1, path, err)
+			continue
+		}
+		id := row.values["id"].(int64)
+		h.memtable = append(h.memtable, row)
+		if id >= h.nextID {
+			h.nextID = id + 1
+		}
+	}
+	return nil
+}
+
+// walRow decodes a line of a write-ahead log
+func walRow(line []byte) (*memoryRow, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	number, _ := data["id"].(json.Number)
+	id, err := number.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid id %v", data["id"])
+	}
+	delete(data, "id")
+	row, err := newMemoryRow(data)
+	if err != nil {
+		return nil, err
+	}
+	row.values["id"] = id
+	return row, nil
+}
+
+func (h *SegmentHandler) openWAL() error {
+	path := filepath.Join(h.dir, fmt.Sprintf("%s%06d%s", walFilePrefix, h.nextWAL, walFileSuffix))
+	h.nextWAL++
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	h.wal = file
+	h.walBuf = bufio.NewWriter(file)
+	return nil
+}
+
+// Put buffers the log and records it in the write-ahead log
+func (h *SegmentHandler) Put(table string, data map[string]interface{}) error {
+	if table != "logs" {
+		return fmt.Errorf("failed to insert data into %s: no such table", table)
+	}
+	row, err := newMemoryRow(data)
+	if err != nil {
+		return fmt.Errorf("failed to insert data into %s: %w", table, err)
+	}
+
+	h.memMu.Lock()
+	defer h.memMu.Unlock()
+	if h.closed {
+		return fmt.Errorf("failed to insert data into %s: handler is closed", table)
+	}
+
+	row.values["id"] = h.nextID
+	line, err := json.Marshal(row.values)
+	if err != nil {
+		return fmt.Errorf("failed to encode log: %w", err)
+	}
+	h.walBuf.Write(line)
+	h.walBuf.WriteByte('\n')
+	if err := h.walBuf.Flush(); err != nil {
+		return fmt.Errorf("failed to write to write-ahead log: %w", err)
+	}
+	h.nextID++
+	h.memtable = append(h.memtable, row)
+
+	if len(h.memtable) >= h.flushRows {
+		select {
+		case h.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run flushes and compacts in the background until Close
+func (h *SegmentHandler) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		case <-h.kick:
+		}
+		if err := h.flush(); err != nil {
+			log.Printf("Error flushing segments: %v\n", err)
+			continue
+		}
+		if err := h.compact(); err != nil {
+			log.Printf("Error compacting segments: %v\n", err)
+		}
+	}
+}
+
+// flush writes the buffered rows to one segment per time window
+func (h *SegmentHandler) flush() error {
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+
+	// Swap in a fresh buffer and write-ahead log so Put is not blocked while segments are written
+	h.memMu.Lock()
+	if len(h.memtable) == 0 {
+		// Recovered write-ahead logs without rows have nothing to write
+		for _, path := range h.walPaths {
+			os.Remove(path)
+		}
+		h.walPaths = nil
+		h.memMu.Unlock()
+		return nil
+	}
+	h.flushing, h.memtable = h.memtable, nil
+	walPaths := append(h.walPaths, h.wal.Name())
+	h.walPaths = nil
+	h.wal.Close()
+	if err := h.openWAL(); err != nil {
+		h.memMu.Unlock()
+		return err
+	}
+	rows := h.flushing
+	h.memMu.Unlock()
+
+	byWindow := map[string][]map[string]interface{}{}
+	for _, row := range rows {
+		key := row.values["timestamp"].(time.Time).Truncate(h.window).Format(h.layout)
+		byWindow[key] = append(byWindow[key], row.values)
+	}
+
+	written := []*segment{}
+	for key, windowRows := range byWindow {
+		seg, err := writeSegment(h.segmentPath(key), key, windowRows)
+		if err != nil {
+			// Keep the rows buffered and their write-ahead logs, the next flush retries
+			for _, seg := range written {
+				os.Remove(seg.path)
+			}
+			h.memMu.Lock()
+			h.memtable = append(h.flushing, h.memtable...)
+			h.flushing = nil
+			h.walPaths = append(walPaths, h.walPaths...)
+			h.memMu.Unlock()
+			return err
+		}
+		written = append(written, seg)
+	}
+
+	h.mu.Lock()
+	h.segments = append(h.segments, written...)
+	h.memMu.Lock()
+	h.flushing = nil
+	h.memMu.Unlock()
+	h.mu.Unlock()
+
+	for _, path := range walPaths {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// segmentPath returns the path of a new segment for the window
+func (h *SegmentHandler) segmentPath(key string) string {
+	h.mu.Lock()
+	seq := h.nextSeq
+	h.nextSeq++
+	h.mu.Unlock()
+	return filepath.Join(h.dir, fmt.Sprintf("%s%s-%06d%s", segmentFilePrefix, key, seq, segmentFileSuffix))
+}
+
+// Get supports the same equality conditions, limit, offset and orderBy as the SQLite handler
+func (h *SegmentHandler) Get(table string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	return h.Query(queryFromConditions(table, conditions))
+}
+
+// mightMatch uses a segment's footer to rule it out without reading it
+func (seg *segment) mightMatch(q Query) bool {
+	if !q.Since.IsZero() && seg.maxTime().Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !seg.minTime().Before(q.Until) {
+		return false
+	}
+	if q.cursorRulesOut(seg.minTime(), seg.maxTime()) {
+		return false
+	}
+
+	for _, c := range q.Conditions {
+		if c.Negate {
+			continue
+		}
+		switch {
+		case (c.Field == "level" || c.Field == "source") && (c.Op == OpEq || c.Op == OpIn):
+			present := seg.footer.Levels
+			if c.Field == "source" {
+				present = seg.footer.Sources
+			}
+			values := []interface{}{c.Value}
+			if c.Op == OpIn {
+				values = c.Value.([]interface{})
+			}
+			if !containsAny(present, values) {
+				return false
+			}
+		case c.Field == "message" && c.Op == OpContains:
+			if !seg.footer.Bloom.mayContainText(fmt.Sprint(c.Value)) {
+				return false
+			}
+		case c.Field == "message" && c.Op == OpEq:
+			if s, ok := c.Value.(string); ok && !seg.footer.Bloom.mayContainText(s) {
+				return false
+			}
+		}
+	}
+	for _, term := range q.Text {
+		if !seg.footer.Bloom.mayContainText(term) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAny reports whether any of the values could equal one of the present strings.
+// Values that are not strings are never ruled out.
+func containsAny(present []string, values []interface{}) bool {
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return true
+		}
+		for _, p := range present {
+			if p == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// segmentHit is a matching row whose values are only read if it ends up on the page
+type segmentHit struct {
+	key  [2]interface{}
+	load func() map[string]interface{}
+}
+
+// Query runs a log query over the buffered rows and every segment its filters cannot rule out
+func (h *SegmentHandler) Query(q Query) ([]map[string]interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	if q.Before != nil {
+		q = keysetQuery(q)
+		rows, err := h.Query(q)
+		reverseRows(rows)
+		return rows, err
+	}
+	if q.Table != "" && q.Table != "logs" {
+		return nil, fmt.Errorf("failed to run query: no such table %s", q.Table)
+	}
+
+	// Holding mu keeps the buffered rows and the segments consistent while a flush finishes
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.memMu.Lock()
+	buffered := append(append([]*memoryRow{}, h.flushing...), h.memtable...)
+	h.memMu.Unlock()
+
+	segments := []*segment{}
+	for _, seg := range h.segments {
+		if seg.mightMatch(q) {
+			segments = append(segments, seg)
+		}
+	}
+
+	if q.IsAggregate() {
+		counter := newGroupCounter(q)
+		for _, row := range buffered {
+			if matchQuery(q, row.get) {
+				counter.add(row.get)
+			}
+		}
+		for _, seg := range segments {
+			view := newSegmentView(seg)
+			for i := 0; i < seg.footer.Rows; i++ {
+				if get := view.getter(i); matchQuery(q, get) {
+					counter.add(get)
+				}
+			}
+			if view.err != nil {
+				return nil, fmt.Errorf("failed to run query: %w", view.err)
+			}
+		}
+		return counter.rows(), nil
+	}
+
+	field, ascending := q.OrderBy, q.Ascending
+	if field == "" {
+		field, ascending = "id", true
+	}
+	need := 0
+	if q.Limit > 0 {
+		need = q.Offset + q.Limit
+	}
+
+	hits := []segmentHit{}
+	for _, row := range buffered {
+		if matchQuery(q, row.get) {
+			hits = append(hits, segmentHit{
+				key:  [2]interface{}{row.get(field), row.get("id")},
+				load: func() map[string]interface{} { return maps.Clone(row.values) },
+			})
+		}
+	}
+
+	// Visiting segments in time order lets a time ordered query stop once no later segment
+	// can hold a row that belongs on the page
+	timeOrdered := field == "timestamp"
+	if timeOrdered {
+		sort.Slice(segments, func(i, j int) bool {
+			if ascending {
+				return segments[i].footer.MinTime < segments[j].footer.MinTime
+			}
+			return segments[i].footer.MaxTime > segments[j].footer.MaxTime
+		})
+	}
+
+	for _, seg := range segments {
+		if timeOrdered && need > 0 && len(hits) >= need {
+			hits = bestHits(hits, need, ascending)
+			worst := hits[len(hits)-1].key[0].(time.Time)
+			if ascending && seg.minTime().After(worst) || !ascending && seg.maxTime().Before(worst) {
+				break
+			}
+		}
+
+		view := newSegmentView(seg)
+		for i := 0; i < seg.footer.Rows; i++ {
+			get := view.getter(i)
+			if !matchQuery(q, get) {
+				continue
+			}
+			hits = append(hits, segmentHit{
+				key:  [2]interface{}{get(field), get("id")},
+				load: func() map[string]interface{} { return view.row(i) },
+			})
+		}
+		if view.err != nil {
+			return nil, fmt.Errorf("failed to run query: %w", view.err)
+		}
+		if need > 0 && len(hits) > 4*need {
+			hits = bestHits(hits, need, ascending)
+		}
+	}
+
+	hits = bestHits(hits, len(hits), ascending)
+	results := []map[string]interface{}{}
+	for i := q.Offset; i < len(hits) && (need == 0 || i < need); i++ {
+		results = append(results, hits[i].load())
+	}
+	return results, nil
+}
+
+// bestHits sorts the hits and keeps the first n
+func bestHits(hits []segmentHit, n int, ascending bool) []segmentHit {
+	keys := make([][2]interface{}, len(hits))
+	for i, hit := range hits {
+		keys[i] = hit.key
+	}
+	order := sortedOrder(keys, ascending)
+	if n > len(order) {
+		n = len(order)
+	}
+	best := make([]segmentHit, n)
+	for i := range best {
+		best[i] = hits[order[i]]
+	}
+	return best
+}
+
+// Close stops the background work and writes the buffered logs to segments
+func (h *SegmentHandler) Close() error {
+	h.memMu.Lock()
+	if h.closed {
+		h.memMu.Unlock()
+		return nil
+	}
+	h.closed = true
+	h.memMu.Unlock()
+
+	close(h.stop)
+	<-h.done
+
+	if err := h.flush(); err != nil {
+		return fmt.Errorf("failed to flush segments: %w", err)
+	}
+
+	// The write-ahead log opened by the last flush is empty
+	h.memMu.Lock()
+	defer h.memMu.Unlock()
+	h.walBuf.Flush()
+	h.wal.Close()
+	if len(h.memtable) == 0 {
+		os.Remove(h.wal.Name())
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSegmentRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewSegmentHandler(dir, "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	putLogs(t, db, "INFO", "ERROR", "INFO", "ERROR")
+
+	check := func(stage string) {
+		t.Helper()
+		tests := []struct {
+			name string
+			q    Query
+			want string
+		}{
+			{"all", Query{OrderBy: "timestamp"}, "log 3,log 2,log 1,log 0"},
+			{"level", Query{Conditions: []Condition{{Field: "level", Op: OpEq, Value: "ERROR"}}, OrderBy: "timestamp"}, "log 3,log 1"},
+			{"metadata", Query{Conditions: []Condition{{Field: "metadata.n", Op: OpGte, Value: int64(2)}}, OrderBy: "timestamp"}, "log 3,log 2"},
+			{"text", Query{Text: []string{"log 2"}}, "log 2"},
+			{"since", Query{Since: testStart.Add(2 * time.Second), OrderBy: "timestamp", Ascending: true}, "log 2,log 3"},
+		}
+		for _, tt := range tests {
+			rows, err := db.Query(tt.q)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", stage, tt.name, err)
+			}
+			if got := messages(rows); got != tt.want {
+				t.Errorf("%s, %s: got %q, want %q", stage, tt.name, got, tt.want)
+			}
+		}
+		rows, err := db.Query(Query{Conditions: []Condition{{Field: "id", Op: OpEq, Value: int64(2)}}})
+		if err != nil || len(rows) != 1 {
+			t.Fatalf("%s: id 2 gave %v, error %v", stage, rows, err)
+		}
+		row := rows[0]
+		if row["level"] != "ERROR" || row["source"] != "api" || !row["timestamp"].(time.Time).Equal(testStart.Add(time.Second)) {
+			t.Errorf("%s: row %v", stage, row)
+		}
+	}
+
+	check("buffered")
+	if err := db.flush(); err != nil {
+		t.Fatal(err)
+	}
+	check("in a segment")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewSegmentHandler(dir, "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check("reopened")
+	if err := db.Put("logs", map[string]interface{}{"level": "INFO", "message": "log 4"}); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := db.Query(Query{Conditions: []Condition{{Field: "id", Op: OpEq, Value: int64(5)}}}); messages(rows) != "log 4" {
+		t.Errorf("ids do not continue after reopening: %v", rows)
+	}
+}
+
+func TestSegmentReplayWAL(t *testing.T) {
+	dir := t.TempDir()
+	// What a crash can leave behind: entries that cannot be read, and a torn last line
+	wal := `{"id":1,"timestamp":"2025-01-31T12:00:00Z","level":"INFO","message":"log 0"}
+{"id":"x","timestamp":"2025-01-31T12:00:01Z","level":"INFO","message":"bad id"}
+{"id":2,"timestamp":"2025-01-31T12:00:02Z","level":"INFO","message":"log 1","unknown":1}
+{"id":7,"timestamp":"2025-01-31T12:00:03Z","level":"WARN","message":"log 2"}
+{"id":8,"timestamp":"2025-01-31T12:00:04Z","lev`
+	path := filepath.Join(dir, walFilePrefix+"000003"+walFileSuffix)
+	if err := os.WriteFile(path, []byte(wal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewSegmentHandler(dir, "hour")
+	if err != nil {
+		t.Fatalf("replaying the write-ahead log: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(rows); got != "log 0,log 2" {
+		t.Errorf("replayed %q", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the replayed write-ahead log was kept: %v", err)
+	}
+	if len(db.segments) != 1 {
+		t.Errorf("replayed logs are in %d segments, want 1", len(db.segments))
+	}
+	if err := db.Put("logs", map[string]interface{}{"level": "INFO", "message": "log 3"}); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := db.Query(Query{Conditions: []Condition{{Field: "id", Op: OpEq, Value: int64(8)}}}); messages(rows) != "log 3" {
+		t.Errorf("ids do not continue after the replayed ones: %v", rows)
+	}
+}
+
+func TestSegmentCompaction(t *testing.T) {
+	db, err := NewSegmentHandler(t.TempDir(), "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Every flush writes a small segment of the same hour
+	for i := 0; i < compactMinSegments; i++ {
+		putLogs(t, db, "INFO", "ERROR")
+		if err := db.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.segments) != compactMinSegments {
+		t.Fatalf("%d segments before compaction", len(db.segments))
+	}
+	before, err := db.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.segments) != 1 || db.segments[0].footer.Rows != 2*compactMinSegments {
+		t.Fatalf("after compaction: %d segments", len(db.segments))
+	}
+	files, _ := filepath.Glob(filepath.Join(db.dir, segmentFilePrefix+"*"))
+	if len(files) != 1 {
+		t.Errorf("segment files left: %v", files)
+	}
+	after, err := db.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages(after) != messages(before) {
+		t.Errorf("compaction changed the logs from %q to %q", messages(before), messages(after))
+	}
+	if rows, _ := db.Query(Query{Count: true, GroupBy: []string{"level"}}); len(rows) != 2 {
+		t.Errorf("count by level after compaction: %v", rows)
+	}
+}
+
+// benchmarkHandlers opens the handlers compared by the benchmarks, empty
+var benchmarkHandlers = []struct {
+	name string
+	open func(b *testing.B) DBHandler
+}{
+	{"SQLite", func(b *testing.B) DBHandler {
+		h, err := NewSQLiteHandler(filepath.Join(b.TempDir(), "logs.db"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return h
+	}},
+	{"Segment", func(b *testing.B) DBHandler {
+		h, err := NewSegmentHandler(b.TempDir(), "hour")
+		if err != nil {
+			b.Fatal(err)
+		}
+		return h
+	}},
+}
+
+func benchmarkRow(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"timestamp": testStart.Add(time.Duration(i) * time.Second),
+		"level":     []string{"INFO", "INFO", "WARN", "ERROR"}[i%4],
+		"source":    []string{"api", "auth", "worker"}[i%3],
+		"message":   fmt.Sprintf("order %d shipped", i),
+		"metadata":  fmt.Sprintf(`{"status": %d}`, 200+i%4*100),
+	}
+}
+
+func BenchmarkPutBatch(b *testing.B) {
+	for _, handler := range benchmarkHandlers {
+		b.Run(handler.name, func(b *testing.B) {
+			db := handler.open(b)
+			defer db.Close()
+			batch := make([]map[string]interface{}, 100)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				for i := range batch {
+					batch[i] = benchmarkRow(n*len(batch) + i)
+				}
+				if err := PutBatch(db, "logs", batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	const rows = 20_000
+	queries := []struct {
+		name string
+		q    Query
+	}{
+		{"newest", Query{OrderBy: "timestamp", Limit: 50}},
+		{"level", Query{Conditions: []Condition{{Field: "level", Op: OpEq, Value: "ERROR"}}, OrderBy: "timestamp", Limit: 50}},
+		{"text", Query{Text: []string{"order 4242 "}, Limit: 50}},
+		{"metadata", Query{Conditions: []Condition{{Field: "metadata.status", Op: OpGte, Value: int64(500)}}, Count: true}},
+		{"count by level", Query{Count: true, GroupBy: []string{"level"}}},
+	}
+	for _, handler := range benchmarkHandlers {
+		db := handler.open(b)
+		batch := make([]map[string]interface{}, rows)
+		for i := range batch {
+			batch[i] = benchmarkRow(i)
+		}
+		if err := PutBatch(db, "logs", batch); err != nil {
+			b.Fatal(err)
+		}
+		if h, ok := db.(*SegmentHandler); ok {
+			if err := h.flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for _, query := range queries {
+			b.Run(handler.name+"/"+query.name, func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					if _, err := db.Query(query.q); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+		db.Close()
+	}
+}
+
+func TestSegmentPrune(t *testing.T) {
+	db, err := NewSegmentHandler(t.TempDir(), "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	hour := now.Truncate(time.Hour)
+	for i, at := range []time.Time{hour.Add(-72 * time.Hour), hour.Add(-72*time.Hour + time.Minute), now.Add(-25 * time.Hour), now} {
+		level := "INFO"
+		if i == 2 {
+			level = "ERROR"
+		}
+		if err := db.Put("logs", map[string]interface{}{"timestamp": at, "level": level, "message": "log " + string(rune('0'+i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.flush(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(db.dir, segmentFilePrefix+"*"))
+
+	// The oldest hour expires as a whole, the ERROR log is kept by its rule
+	policy := RetentionPolicy{MaxAge: 24 * time.Hour, Rules: []RetentionRule{{Level: "ERROR", MaxAge: 48 * time.Hour}}}
+	result, err := db.Prune(context.Background(), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query(Query{OrderBy: "timestamp", Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(rows); got != "log 2,log 3" || result.Deleted != 2 {
+		t.Errorf("kept %q and deleted %d", got, result.Deleted)
+	}
+	left, _ := filepath.Glob(filepath.Join(db.dir, segmentFilePrefix+"*"))
+	if len(left) != len(files)-1 || len(db.segments) != len(left) {
+		t.Errorf("%d segment files before and %d after, %d open", len(files), len(left), len(db.segments))
+	}
+}
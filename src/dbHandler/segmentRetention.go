@@ -0,0 +1,222 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// compactMinSegments is how many small segments a window needs before they are merged
+const compactMinSegments = 4
+
+// compact merges the small segments of each time window into one, so queries open fewer files
+// and compression works on larger blocks
+func (h *SegmentHandler) compact() error {
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+
+	h.mu.RLock()
+	byWindow := map[string][]*segment{}
+	for _, seg := range h.segments {
+		if seg.footer.Rows < h.compactBelow {
+			byWindow[seg.footer.Window] = append(byWindow[seg.footer.Window], seg)
+		}
+	}
+	h.mu.RUnlock()
+
+	for window, small := range byWindow {
+		if len(small) < compactMinSegments {
+			continue
+		}
+		if _, err := h.rewrite(window, small, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrite replaces segments of one window with a single segment holding their rows,
+// minus the ones drop returns true for. It returns the number of rows dropped.
+// The caller must hold flushMu.
+func (h *SegmentHandler) rewrite(window string, old []*segment, drop func(get fieldGetter) bool) (int64, error) {
+	rows := []map[string]interface{}{}
+	var dropped int64
+	for _, seg := range old {
+		view := newSegmentView(seg)
+		for i := 0; i < seg.footer.Rows; i++ {
+			if drop != nil && drop(view.getter(i)) {
+				dropped++
+				continue
+			}
+			rows = append(rows, view.row(i))
+		}
+		if view.err != nil {
+			return 0, view.err
+		}
+	}
+
+	var merged []*segment
+	if len(rows) > 0 {
+		seg, err := writeSegment(h.segmentPath(window), window, rows)
+		if err != nil {
+			return 0, err
+		}
+		merged = append(merged, seg)
+	}
+
+	// Queries hold mu for reading, so no query is reading the old files once the swap is done
+	h.mu.Lock()
+	replaced := map[*segment]bool{}
+	for _, seg := range old {
+		replaced[seg] = true
+	}
+	kept := merged
+	for _, seg := range h.segments {
+		if !replaced[seg] {
+			kept = append(kept, seg)
+		}
+	}
+	h.segments = kept
+	h.mu.Unlock()
+
+	for _, seg := range old {
+		os.Remove(seg.path)
+	}
+	return dropped, nil
+}
+
+// Prune removes expired logs by rewriting the segments that hold some and deleting the ones
+// that hold only expired logs. Row and size limits drop the oldest whole segments.
+// Logs that are still buffered are pruned once they are written to a segment.
+func (h *SegmentHandler) Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error) {
+	result := PruneResult{StartedAt: time.Now()}
+	before, _ := h.StorageStats()
+	err := h.prune(ctx, policy, &result)
+	if after, statsErr := h.StorageStats(); statsErr == nil {
+		result.ReclaimedBytes = before.Bytes - after.Bytes
+	}
+	result.Duration = time.Since(result.StartedAt)
+	return result, err
+}
+
+func (h *SegmentHandler) prune(ctx context.Context, policy RetentionPolicy, result *PruneResult) error {
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+
+	h.mu.RLock()
+	segments := append([]*segment{}, h.segments...)
+	h.mu.RUnlock()
+
+	now := time.Now().UTC()
+	if policy.MaxAge > 0 || len(policy.Rules) > 0 {
+		// No log newer than the shortest age can be expired, so newer segments are skipped
+		shortest := policy.MaxAge
+		for _, rule := range policy.Rules {
+			if shortest == 0 || rule.MaxAge < shortest {
+				shortest = rule.MaxAge
+			}
+		}
+		for _, seg := range segments {
+			if !seg.minTime().Before(now.Add(-shortest)) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			expired := func(get fieldGetter) bool {
+				return policy.expired(get("level"), get("source"), get("timestamp").(time.Time), now)
+			}
+			dropped, err := h.rewriteExpired(seg, expired)
+			result.Deleted += dropped
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if policy.MaxRows == 0 && policy.MaxBytes == 0 {
+		return nil
+	}
+
+	h.mu.RLock()
+	segments = append([]*segment{}, h.segments...)
+	h.mu.RUnlock()
+	sort.Slice(segments, func(i, j int) bool { return segments[i].footer.MaxTime < segments[j].footer.MaxTime })
+
+	var rows, bytes int64
+	for _, seg := range segments {
+		rows += int64(seg.footer.Rows)
+		bytes += seg.size
+	}
+	for len(segments) > 0 && (policy.MaxRows > 0 && rows > policy.MaxRows || policy.MaxBytes > 0 && bytes > policy.MaxBytes) {
+		seg := segments[0]
+		segments = segments[1:]
+		if _, err := h.rewrite(seg.footer.Window, []*segment{seg}, func(fieldGetter) bool { return true }); err != nil {
+			return err
+		}
+		rows -= int64(seg.footer.Rows)
+		bytes -= seg.size
+		result.Deleted += int64(seg.footer.Rows)
+	}
+	return nil
+}
+
+// rewriteExpired rewrites a segment without its expired rows, leaving it alone if none expired
+func (h *SegmentHandler) rewriteExpired(seg *segment, expired func(get fieldGetter) bool) (int64, error) {
+	view := newSegmentView(seg)
+	count := 0
+	for i := 0; i < seg.footer.Rows; i++ {
+		if expired(view.getter(i)) {
+			count++
+		}
+	}
+	if view.err != nil {
+		return 0, view.err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return h.rewrite(seg.footer.Window, []*segment{seg}, expired)
+}
+
+// StorageStats reports the logs held in segments and in the buffer, and the size of all files
+func (h *SegmentHandler) StorageStats() (StorageStats, error) {
+	var stats StorageStats
+	include := func(oldest, newest time.Time) {
+		if stats.Oldest.IsZero() || oldest.Before(stats.Oldest) {
+			stats.Oldest = oldest
+		}
+		if newest.After(stats.Newest) {
+			stats.Newest = newest
+		}
+	}
+
+	h.mu.RLock()
+	for _, seg := range h.segments {
+		stats.Rows += int64(seg.footer.Rows)
+		stats.Bytes += seg.size
+		include(seg.minTime(), seg.maxTime())
+	}
+	h.memMu.Lock()
+	for _, rows := range [][]*memoryRow{h.flushing, h.memtable} {
+		for _, row := range rows {
+			ts := row.values["timestamp"].(time.Time)
+			stats.Rows++
+			include(ts, ts)
+		}
+	}
+	walPaths := append(append([]string{}, h.walPaths...), h.wal.Name())
+	h.memMu.Unlock()
+	h.mu.RUnlock()
+
+	for _, path := range walPaths {
+		if info, err := os.Stat(path); err == nil {
+			stats.Bytes += info.Size()
+		} else if !os.IsNotExist(err) {
+			return stats, fmt.Errorf("failed to read write-ahead log size: %w", err)
+		}
+	}
+	return stats, nil
+}
@@ -1,6 +1,7 @@
 package dbhandler
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -11,11 +12,36 @@ import (
 )
 
 type SQLiteHandler struct {
-	db *sql.DB
+	db             *sql.DB
+	promotedFields []string
+	builder        sqliteQueryBuilder
+	wal            bool
+	rollups        bool
+	rollupCancel   context.CancelFunc
+	rollupDone     chan struct{}
+}
+
+// SQLiteOption is a function that configures a SQLiteHandler
+type SQLiteOption func(*SQLiteHandler)
+
+// WithPromotedFields makes the given metadata fields (e.g. "trace_id" or "metadata.trace_id")
+// generated, indexed columns so filtering and grouping on them is fast
+func WithPromotedFields(fields ...string) SQLiteOption {
+	return func(h *SQLiteHandler) {
+		h.promotedFields = fields
+	}
+}
+
+// WithWriteAheadLog switches the database to WAL journaling so readers, such as a running
+// backup, do not block inserts
+func WithWriteAheadLog() SQLiteOption {
+	return func(h *SQLiteHandler) {
+		h.wal = true
+	}
 }
 
 // NewSQLiteHandler initializes and returns a new SQLiteHandler
-func NewSQLiteHandler(dbFile string) (*SQLiteHandler, error) {
+func NewSQLiteHandler(dbFile string, opts ...SQLiteOption) (*SQLiteHandler, error) {
 	// Ensure the directory for the database file exists
 	dir := filepath.Dir(dbFile)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -24,66 +50,65 @@ func NewSQLiteHandler(dbFile string) (*SQLiteHandler, error) {
 		}
 	}
 
-	// Open the SQLite database
-	db, err := sql.Open("sqlite", dbFile)
+	// Open the SQLite database, waiting on locks held by the pruner instead of failing
+	db, err := sql.Open("sqlite", dbFile+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SQLite: %w", err)
 	}
 
 	// Create a new SQLiteHandler instance
 	handler := &SQLiteHandler{db: db}
+	for _, opt := range opts {
+		opt(handler)
+	}
 
 	// Initialize the database (create tables and indexes if necessary)
 	if err := Initialize(handler); err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	return handler, nil
-}
-
-// create the Log Table
-func Initialize(h *SQLiteHandler) error{
-	// SQL to create the logs table
-	createTableQuery := `
-	CREATE TABLE IF NOT EXISTS logs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		level TEXT NOT NULL,
-		message TEXT NOT NULL,
-		source TEXT,
-		method TEXT,
-		address TEXT,
-		length INTEGER,
-		metadata TEXT,
-		label TEXT
-	);
-	`
-
-	// Execute the table creation query
-	_, err := h.db.Exec(createTableQuery)
-	if err != nil {
-		return fmt.Errorf("failed to create logs table: %w", err)
+	if handler.wal {
+		if _, err := db.Exec("PRAGMA journal_mode = WAL;"); err != nil {
+			return nil, fmt.Errorf("failed to enable WAL: %w", err)
+		}
 	}
 
-	// Create indexes for faster querying
-	indexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level);",
-		"CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp);",
-		"CREATE INDEX IF NOT EXISTS idx_logs_source ON logs(source);",
+	// Add or drop the generated columns backing promoted metadata fields
+	if err := handler.syncPromotedFields(); err != nil {
+		return nil, fmt.Errorf("failed to promote metadata fields: %w", err)
 	}
 
-	for _, indexQuery := range indexes {
-		_, err := h.db.Exec(indexQuery)
-		if err != nil {
-			return fmt.Errorf("failed to create index: %w", err)
+	if handler.rollups {
+		if err := handler.enableRollups(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to enable rollups: %w", err)
 		}
+		ctx, cancel := context.WithCancel(context.Background())
+		handler.rollupCancel = cancel
+		handler.rollupDone = make(chan struct{})
+		go handler.runRollupCompaction(ctx)
 	}
 
-	return nil
+	return handler, nil
+}
+
+// Initialize brings the schema of the database up to date with sqliteMigrations
+func Initialize(h *SQLiteHandler) error{
+	// Only takes effect on new databases, it lets the pruner give space back to the file system.
+	// It has to run before the first table is created, outside of a transaction.
+	if _, err := h.db.Exec("PRAGMA auto_vacuum = INCREMENTAL;"); err != nil {
+		return fmt.Errorf("failed to set auto_vacuum: %w", err)
+	}
+
+	return migrate(context.Background(), sqliteMigrationStore{h.db}, sqliteMigrations)
 }
 
 // Put inserts data into the specified table
 func (h *SQLiteHandler) Put(table string, data map[string]interface{}) error {
+	data, err := storedRow(data)
+	if err != nil {
+		return err
+	}
+
 	// Build the INSERT query
 	columns := []string{}
 	values := []interface{}{}
@@ -102,8 +127,7 @@ func (h *SQLiteHandler) Put(table string, data map[string]interface{}) error {
 		strings.Join(placeholders, ", "),
 	)
 
-	_, err := h.db.Exec(query, values...)
-	if err != nil {
+	if _, err := h.db.Exec(query, values...); err != nil {
 		return fmt.Errorf("failed to insert data into %s: %w", table, err)
 	}
 
@@ -153,9 +177,49 @@ func (h *SQLiteHandler) Get(table string, conditions map[string]interface{}) ([]
 	}
 	defer rows.Close()
 
-	// parse the rows into a slice of maps
+	return scanRows(rows)
+}
+
+// Query runs a compiled log query against the database, counts come from the rollups when
+// they are enabled and can answer the query
+func (h *SQLiteHandler) Query(q Query) ([]map[string]interface{}, error) {
+	if h.rollups && rollupEligible(q) {
+		return h.queryRollups(q)
+	}
+	if q.Before != nil {
+		q = keysetQuery(q)
+		rows, err := h.queryLogs(q)
+		reverseRows(rows)
+		return rows, err
+	}
+	return h.queryLogs(q)
+}
+
+// queryLogs runs a query against the logs table
+func (h *SQLiteHandler) queryLogs(q Query) ([]map[string]interface{}, error) {
+	query, args, err := h.builder.build(q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	results, err := scanRows(rows)
+	parseBuckets(q, results)
+	return results, err
+}
+
+// scanRows parses the rows into a slice of maps keyed by column name
+func scanRows(rows *sql.Rows) ([]map[string]interface{}, error) {
 	var results []map[string]interface{}
-	columns, _ := rows.Columns()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w", err)
+	}
 
 	for rows.Next() {
 		rowData := make([]interface{}, len(columns))
@@ -177,11 +241,19 @@ func (h *SQLiteHandler) Get(table string, conditions map[string]interface{}) ([]
 		results = append(results, rowMap)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
 	return results, nil
 }
 
 
 func (h *SQLiteHandler) Close() error {
+	if h.rollupCancel != nil {
+		h.rollupCancel()
+		<-h.rollupDone
+	}
 	if h.db != nil {
 		if err := h.db.Close(); err != nil {
 			return fmt.Errorf("failed to close SQLite database: %w", err)
@@ -0,0 +1,145 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backup writes a compacted copy of the database to path with VACUUM INTO. The copy is
+// consistent as of the moment it starts, in WAL mode inserts keep going while it is written.
+func (h *SQLiteHandler) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	}
+
+	// Write next to the target and rename so a crash never leaves a half written backup behind
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if _, err := h.db.ExecContext(ctx, "VACUUM INTO ?", tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// ValidateSQLiteSnapshot checks that a file is an intact LogLite database this build can open
+func ValidateSQLiteSnapshot(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer db.Close()
+
+	var check string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check(1)").Scan(&check); err != nil {
+		return fmt.Errorf("%s is not a SQLite database: %w", path, err)
+	}
+	if check != "ok" {
+		return fmt.Errorf("%s is corrupt: %s", path, check)
+	}
+
+	var tables int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'logs'").Scan(&tables); err != nil {
+		return fmt.Errorf("failed to read the schema of %s: %w", path, err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("%s has no logs table", path)
+	}
+
+	// Older schemas are fine, they are migrated when LogLite opens the restored database
+	if _, err := migrationStatus(ctx, sqliteMigrationStore{db}, sqliteMigrations); err != nil {
+		return fmt.Errorf("%s cannot be restored: %w", path, err)
+	}
+	return nil
+}
+
+// RestoreSQLite replaces the database at target with a snapshot taken by Backup. LogLite
+// must not be running. The snapshot is validated first and the replaced database is kept
+// next to it as <target>.pre-restore-<time>, its path is returned ("" if there was none).
+func RestoreSQLite(ctx context.Context, snapshot, target string) (string, error) {
+	if err := ValidateSQLiteSnapshot(ctx, snapshot); err != nil {
+		return "", err
+	}
+
+	tmp := target + ".restore"
+	if err := copyFileSynced(snapshot, tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to copy snapshot: %w", err)
+	}
+
+	previous := ""
+	if _, err := os.Stat(target); err == nil {
+		if err := checkpointSQLite(ctx, target); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+		previous = target + ".pre-restore-" + time.Now().UTC().Format(backupTimeLayout)
+		if err := os.Rename(target, previous); err != nil {
+			os.Remove(tmp)
+			return "", fmt.Errorf("failed to move the current database aside: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		return previous, fmt.Errorf("failed to swap in the snapshot: %w", err)
+	}
+	return previous, nil
+}
+
+// checkpointSQLite folds the write-ahead log of a database back into the file so the file
+// can be moved on its own. The log is only removed by the last connection to close,
+// if it is still there the database is in use.
+func checkpointSQLite(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	_, err = db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint %s: %w", path, err)
+	}
+	if _, err := os.Stat(path + "-wal"); err == nil {
+		return errors.New(path + " is in use, stop LogLite before restoring")
+	}
+	return nil
+}
+
+// copyFileSynced copies src to dst and flushes it to disk
+func copyFileSynced(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
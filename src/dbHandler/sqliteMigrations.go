@@ -0,0 +1,137 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// sqliteMigrations are the versions of the SQLite logs schema, append new ones at the end
+var sqliteMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create the logs table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS logs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+				level TEXT NOT NULL,
+				message TEXT NOT NULL,
+				source TEXT,
+				method TEXT,
+				address TEXT,
+				length INTEGER,
+				metadata TEXT,
+				label TEXT
+			);`,
+			"CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level);",
+			"CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp);",
+			"CREATE INDEX IF NOT EXISTS idx_logs_source ON logs(source);",
+		},
+	},
+	{
+		Version:     2,
+		Description: "Index the label column",
+		Statements: []string{
+			"CREATE INDEX IF NOT EXISTS idx_logs_label ON logs(label);",
+		},
+	},
+	{
+		Version:     3,
+		Description: "Create the rollup tables",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS log_rollups (
+				resolution INTEGER NOT NULL,
+				start INTEGER NOT NULL,
+				level TEXT,
+				source TEXT,
+				label TEXT,
+				count INTEGER NOT NULL
+			);`,
+			// NULLs never conflict in a unique index, ifnull makes a missing source or label one group
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_log_rollups_key ON log_rollups(resolution, start, ifnull(level, char(0)), ifnull(source, char(0)), ifnull(label, char(0)));",
+			`CREATE TABLE IF NOT EXISTS rollup_state (
+				resolution INTEGER PRIMARY KEY,
+				complete_from INTEGER NOT NULL
+			);`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "Store timestamps with nanoseconds and add the received_at column",
+		Statements: []string{
+			"ALTER TABLE logs ADD COLUMN received_at DATETIME;",
+			// Timestamps are compared as text, so older ones get the zero fraction new ones are stored with
+			"UPDATE logs SET timestamp = timestamp || '.000000000' WHERE length(timestamp) = 19;",
+		},
+	},
+}
+
+// sqliteMigrationStore keeps the applied migrations of a SQLite database
+type sqliteMigrationStore struct {
+	db *sql.DB
+}
+
+func (s sqliteMigrationStore) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	applied := map[int]bool{}
+	var tables int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return applied, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (s sqliteMigrationStore) applyMigration(ctx context.Context, m Migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := append([]string{`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`}, m.Statements...)
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, description) VALUES (?, ?)", m.Version, m.Description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// planSQLiteMigrations reads the schema version of a database file without creating or changing it
+func planSQLiteMigrations(ctx context.Context, path string) (MigrationStatus, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return MigrationStatus{Database: path, Latest: len(sqliteMigrations), Pending: sqliteMigrations}, nil
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return MigrationStatus{Database: path}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer db.Close()
+
+	status, err := migrationStatus(ctx, sqliteMigrationStore{db}, sqliteMigrations)
+	status.Database = path
+	return status, err
+}
@@ -0,0 +1,120 @@
+package dbhandler
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+)
+
+// promotedColumnDefinition finds the generated meta_ columns in the schema of the logs table,
+// with the JSON path each one extracts
+var promotedColumnDefinition = regexp.MustCompile(`\b(meta_\w+) GENERATED ALWAYS AS \(json_extract\(metadata, '([^']*)'\)\)`)
+
+// NormalizeMetadataField accepts "trace_id" as well as "metadata.trace_id"
+func NormalizeMetadataField(field string) string {
+	if IsMetadataField(field) {
+		return field
+	}
+	return MetadataPrefix + field
+}
+
+// promotedColumn is the generated column name for a metadata field, e.g. meta_request_status
+func promotedColumn(field string) string {
+	return confighandler.PromotedColumn(field)
+}
+
+// existingPromotedColumns lists the generated meta_ columns already on the logs table, with the
+// JSON path each one extracts. SQLite keeps the columns added to a table in its CREATE statement.
+func (h *SQLiteHandler) existingPromotedColumns() (map[string]string, error) {
+	var schema string
+	if err := h.db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'logs'").Scan(&schema); err != nil {
+		return nil, err
+	}
+	columns := map[string]string{}
+	for _, match := range promotedColumnDefinition.FindAllStringSubmatch(schema, -1) {
+		columns[match[1]] = match[2]
+	}
+	return columns, nil
+}
+
+// syncPromotedFields makes the generated columns and indexes match the configured promoted fields
+func (h *SQLiteHandler) syncPromotedFields() error {
+	existing, err := h.existingPromotedColumns()
+	if err != nil {
+		return fmt.Errorf("failed to read logs columns: %w", err)
+	}
+
+	wanted := map[string]string{} // Column -> field
+	h.builder.promoted = map[string]string{}
+	for _, f := range h.promotedFields {
+		field := NormalizeMetadataField(f)
+		if err := ValidateField(field); err != nil {
+			return err
+		}
+		column := promotedColumn(field)
+		if other, ok := wanted[column]; ok {
+			if other == field {
+				return fmt.Errorf("promoted field %s is listed twice", field)
+			}
+			return fmt.Errorf("promoted fields %s and %s would share the column %s", other, field, column)
+		}
+		wanted[column] = field
+		h.builder.promoted[field] = column
+
+		path, ok := existing[column]
+		if ok && path == MetadataPath(field) {
+			continue
+		}
+		if ok {
+			// The column extracts another field with the same column name, e.g. request_status
+			// after request.status, it is added again for the new one
+			if err := h.dropPromotedColumn(column); err != nil {
+				return err
+			}
+			delete(existing, column)
+		}
+
+		// The JSON path is validated above, so it is safe to inline into the column definition
+		addColumn := fmt.Sprintf(
+			"ALTER TABLE logs ADD COLUMN %s GENERATED ALWAYS AS (json_extract(metadata, '%s')) VIRTUAL",
+			column, MetadataPath(field),
+		)
+		if _, err := h.db.Exec(addColumn); err != nil {
+			return fmt.Errorf("failed to add column for %s: %w", field, err)
+		}
+		log.Printf("Promoted metadata field %s to column %s\n", field, column)
+	}
+
+	for column := range wanted {
+		index := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_logs_%s ON logs(%s);", column, column)
+		if _, err := h.db.Exec(index); err != nil {
+			return fmt.Errorf("failed to index %s: %w", column, err)
+		}
+	}
+
+	// Drop columns for fields that are no longer promoted
+	for column := range existing {
+		if _, ok := wanted[column]; ok {
+			continue
+		}
+		if err := h.dropPromotedColumn(column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropPromotedColumn drops a generated meta_ column and its index
+func (h *SQLiteHandler) dropPromotedColumn(column string) error {
+	if _, err := h.db.Exec(fmt.Sprintf("DROP INDEX IF EXISTS idx_logs_%s;", column)); err != nil {
+		return fmt.Errorf("failed to drop index for %s: %w", column, err)
+	}
+	if _, err := h.db.Exec(fmt.Sprintf("ALTER TABLE logs DROP COLUMN %s", column)); err != nil {
+		return fmt.Errorf("failed to drop column %s: %w", column, err)
+	}
+	log.Printf("Dropped promoted metadata column %s\n", column)
+	return nil
+}
@@ -0,0 +1,77 @@
+package dbhandler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPromotedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		wantErr string
+	}{
+		{"distinct", []string{"trace_id", "request.status"}, ""},
+		{"same field twice", []string{"trace_id", "metadata.trace_id"}, "promoted field metadata.trace_id is listed twice"},
+		{"dot and underscore", []string{"request.status", "request_status"}, "would share the column meta_request_status"},
+		{"dash and underscore", []string{"request-status", "request_status"}, "would share the column meta_request_status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := NewSQLiteHandler(filepath.Join(t.TempDir(), "logs.db"), WithPromotedFields(tt.fields...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			err = db.Put("logs", map[string]interface{}{"level": "INFO", "message": "x", "metadata": `{"trace_id": "abc", "request": {"status": 500}}`})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := db.Query(Query{Conditions: []Condition{{Field: "metadata.request.status", Op: OpEq, Value: int64(500)}}})
+			if err != nil || len(rows) != 1 {
+				t.Errorf("query on a promoted field: %v rows, error %v", len(rows), err)
+			}
+		})
+	}
+}
+
+func TestPromotedFieldChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	db, err := NewSQLiteHandler(path, WithPromotedFields("request.status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Put("logs", map[string]interface{}{"level": "INFO", "message": "x", "metadata": `{"request": {"status": 500}, "request_status": 404}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// request_status has the same column name, which must now extract the other field
+	db, err = NewSQLiteHandler(path, WithPromotedFields("request_status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	columns, err := db.existingPromotedColumns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 1 || columns["meta_request_status"] != "$.request_status" {
+		t.Errorf("promoted columns %v", columns)
+	}
+	for status, want := range map[int64]int{404: 1, 500: 0} {
+		rows, err := db.Query(Query{Conditions: []Condition{{Field: "metadata.request_status", Op: OpEq, Value: status}}})
+		if err != nil || len(rows) != want {
+			t.Errorf("request_status=%d: %d rows, error %v, want %d", status, len(rows), err, want)
+		}
+	}
+}
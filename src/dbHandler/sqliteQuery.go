@@ -0,0 +1,194 @@
+package dbhandler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqliteQueryBuilder compiles Query values into statements against a SQLite logs table
+type sqliteQueryBuilder struct {
+	promoted map[string]string // Metadata field -> generated column holding it
+	from     string            // Overrides the table, e.g. with a UNION of attached partitions
+}
+
+// field returns the SQL expression for a field. Promoted metadata fields use their
+// indexed generated column, any other metadata path is extracted from the JSON.
+func (b sqliteQueryBuilder) field(field string) (string, []interface{}) {
+	if IsMetadataField(field) {
+		if column, ok := b.promoted[field]; ok {
+			return column, nil
+		}
+		return "json_extract(metadata, ?)", []interface{}{MetadataPath(field)}
+	}
+	return field, nil
+}
+
+// numeric returns the SQL expression for a field that is NULL unless the value is a number,
+// so aggregates skip text the same way for every backend
+func (b sqliteQueryBuilder) numeric(field string) (string, []interface{}) {
+	expr, args := b.field(field)
+	return fmt.Sprintf("CASE WHEN typeof(%s) IN ('integer', 'real') THEN %s END", expr, expr), append(args, args...)
+}
+
+// condition compiles a single condition to a WHERE fragment
+func (b sqliteQueryBuilder) condition(c Condition) (string, []interface{}, error) {
+	expr, args := b.field(c.Field)
+	var clause string
+	switch c.Op {
+	case OpEq, OpNotEq, OpGt, OpGte, OpLt, OpLte:
+		clause = fmt.Sprintf("%s %s ?", expr, c.Op)
+		args = append(args, c.Value)
+	case OpContains:
+		clause = fmt.Sprintf("%s LIKE ? ESCAPE '\\'", expr)
+		args = append(args, "%"+escapeLike(fmt.Sprint(c.Value))+"%")
+	case OpIn:
+		values := c.Value.([]interface{})
+		placeholders := make([]string, len(values))
+		for i := range values {
+			placeholders[i] = "?"
+		}
+		clause = fmt.Sprintf("%s IN (%s)", expr, strings.Join(placeholders, ", "))
+		args = append(args, values...)
+	default:
+		return "", nil, fmt.Errorf("unsupported operator: %s", c.Op)
+	}
+	if c.Negate {
+		// NULLs should count as "not matching" rather than dropping the row
+		clause = fmt.Sprintf("NOT coalesce(%s, 0)", clause)
+	}
+	return clause, args, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// where compiles the filtering part of a query
+func (b sqliteQueryBuilder) where(q Query) (string, []interface{}, error) {
+	clauses := []string{}
+	args := []interface{}{}
+
+	for _, c := range q.Conditions {
+		clause, condArgs, err := b.condition(c)
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, clause)
+		args = append(args, condArgs...)
+	}
+
+	for _, term := range q.Text {
+		clauses = append(clauses, "message LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(term)+"%")
+	}
+
+	if !q.Since.IsZero() {
+		clauses = append(clauses, "timestamp >= ?")
+		args = append(args, q.Since.UTC().Format(TimestampLayout))
+	}
+	if !q.Until.IsZero() {
+		clauses = append(clauses, "timestamp < ?")
+		args = append(args, q.Until.UTC().Format(TimestampLayout))
+	}
+	if q.After != nil {
+		clauses = append(clauses, fmt.Sprintf("(timestamp, id) %s (?, ?)", cursorOperator(q.Ascending)))
+		args = append(args, q.After.Timestamp.UTC().Format(TimestampLayout), q.After.ID)
+	}
+
+	if len(clauses) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args, nil
+}
+
+// BuildSQLiteQuery compiles a Query to a SQLite statement and its arguments
+func BuildSQLiteQuery(q Query) (string, []interface{}, error) {
+	return sqliteQueryBuilder{}.build(q)
+}
+
+func (b sqliteQueryBuilder) build(q Query) (string, []interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return "", nil, err
+	}
+	table := q.Table
+	if table == "" {
+		table = "logs"
+	}
+	if b.from != "" {
+		table = b.from
+	}
+
+	where, whereArgs, err := b.where(q)
+	if err != nil {
+		return "", nil, err
+	}
+
+	selectArgs := []interface{}{}
+	// Name the columns so generated metadata columns stay out of the results
+	selectList := strings.Join(LogColumns, ", ")
+	groupBy := ""
+	if q.IsAggregate() {
+		selects := []string{}
+		groups := []string{}
+		for i, g := range q.GroupBy {
+			expr, args := b.field(g)
+			selects = append(selects, fmt.Sprintf("%s AS %q", expr, g))
+			selectArgs = append(selectArgs, args...)
+			// Group by position so metadata paths are not bound twice
+			groups = append(groups, fmt.Sprint(i+1))
+		}
+		if q.Bucket > 0 {
+			// Timestamps are stored as UTC text, buckets are aligned to the Unix epoch
+			seconds := int64(q.Bucket / time.Second)
+			selects = append(selects, fmt.Sprintf("datetime(CAST(strftime('%%s', timestamp) AS INTEGER) / %d * %d, 'unixepoch') AS %s", seconds, seconds, BucketColumn))
+			groups = append(groups, fmt.Sprint(len(groups)+1))
+		}
+		selects = append(selects, "COUNT(*) AS count")
+		for _, a := range q.Aggregates {
+			expr, args := b.numeric(a.Field)
+			selects = append(selects, fmt.Sprintf("%s(%s) AS %q", strings.ToUpper(string(a.Func)), expr, a.Name()))
+			selectArgs = append(selectArgs, args...)
+		}
+		selectList = strings.Join(selects, ", ")
+		if len(groups) > 0 {
+			groupBy = " GROUP BY " + strings.Join(groups, ", ")
+		}
+	}
+
+	orderBy := ""
+	orderArgs := []interface{}{}
+	direction := "DESC"
+	if q.Ascending {
+		direction = "ASC"
+	}
+	switch {
+	case q.IsAggregate() && (q.OrderBy == "" || q.OrderBy == "count"):
+		if len(q.GroupBy) > 0 || q.Bucket > 0 {
+			orderBy = " ORDER BY count " + direction
+		}
+	case q.IsAggregate() && q.isResultColumn(q.OrderBy):
+		orderBy = fmt.Sprintf(" ORDER BY %q %s", q.OrderBy, direction)
+	case q.OrderBy != "":
+		expr, args := b.field(q.OrderBy)
+		orderBy = fmt.Sprintf(" ORDER BY %s %s", expr, direction)
+		orderArgs = append(orderArgs, args...)
+	}
+	if !q.IsAggregate() && q.OrderBy == "timestamp" {
+		// Logs with the same timestamp keep a stable order, which paging with cursors relies on
+		orderBy += ", id " + direction
+	}
+
+	limit := ""
+	if q.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", q.Limit)
+		if q.Offset > 0 {
+			limit += fmt.Sprintf(" OFFSET %d", q.Offset)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s%s%s%s", selectList, table, where, groupBy, orderBy, limit)
+	args := append(selectArgs, whereArgs...)
+	args = append(args, orderArgs...)
+	return query, args, nil
+}
@@ -0,0 +1,114 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// vacuumChunkPages is how many free pages a single incremental_vacuum step releases
+const vacuumChunkPages = 1000
+
+// deleteInBatches deletes the rows matching where, oldest first
+func (h *SQLiteHandler) deleteInBatches(ctx context.Context, where string, args []interface{}, batch int, limit int64) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM logs WHERE id IN (SELECT id FROM logs WHERE %s ORDER BY timestamp, id LIMIT ?)", where)
+	return batchDelete(ctx, batch, limit, func(size int64) (int64, error) {
+		res, err := h.db.ExecContext(ctx, query, append(append([]interface{}{}, args...), size)...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete batch: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to count deleted rows: %w", err)
+		}
+		return n, nil
+	})
+}
+
+// Prune deletes logs that fall outside the policy in small batches and then
+// returns freed pages to the file system with an incremental vacuum
+func (h *SQLiteHandler) Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error) {
+	result := PruneResult{StartedAt: time.Now()}
+	err := h.prune(ctx, policy, &result)
+	result.Duration = time.Since(result.StartedAt)
+	return result, err
+}
+
+func (h *SQLiteHandler) prune(ctx context.Context, policy RetentionPolicy, result *PruneResult) error {
+	if err := pruneRows(ctx, h, policy, result); err != nil {
+		return err
+	}
+
+	reclaimed, note, err := h.incrementalVacuum(ctx)
+	result.ReclaimedBytes = reclaimed
+	result.Note = note
+	return err
+}
+
+// incrementalVacuum releases free pages when the database uses incremental auto_vacuum
+func (h *SQLiteHandler) incrementalVacuum(ctx context.Context) (int64, string, error) {
+	var mode int
+	if err := h.db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return 0, "", fmt.Errorf("failed to read auto_vacuum mode: %w", err)
+	}
+	// 2 is INCREMENTAL, databases created before retention existed have it off
+	if mode != 2 {
+		return 0, "auto_vacuum is not incremental, run VACUUM once to let the pruner shrink the file", nil
+	}
+
+	before, err := h.StorageStats()
+	if err != nil {
+		return 0, "", err
+	}
+
+	for {
+		var free int64
+		if err := h.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free); err != nil {
+			return 0, "", fmt.Errorf("failed to read freelist: %w", err)
+		}
+		if free == 0 {
+			break
+		}
+		if _, err := h.db.ExecContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", vacuumChunkPages)); err != nil {
+			return 0, "", fmt.Errorf("failed to vacuum: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, "", ctx.Err()
+		case <-time.After(pruneBatchPause):
+		}
+	}
+
+	after, err := h.StorageStats()
+	if err != nil {
+		return 0, "", err
+	}
+	return before.Bytes - after.Bytes, "", nil
+}
+
+// StorageStats reports the number of rows, the file size and the time range held
+func (h *SQLiteHandler) StorageStats() (StorageStats, error) {
+	var stats StorageStats
+	var pageCount, pageSize, freePages int64
+	if err := h.db.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return stats, fmt.Errorf("failed to read page_count: %w", err)
+	}
+	if err := h.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return stats, fmt.Errorf("failed to read page_size: %w", err)
+	}
+	if err := h.db.QueryRow("PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return stats, fmt.Errorf("failed to read freelist_count: %w", err)
+	}
+	stats.Bytes = pageCount * pageSize
+	stats.FreeBytes = freePages * pageSize
+
+	var oldest, newest sql.NullString
+	row := h.db.QueryRow("SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM logs")
+	if err := row.Scan(&stats.Rows, &oldest, &newest); err != nil {
+		return stats, fmt.Errorf("failed to read logs range: %w", err)
+	}
+	stats.Oldest = parseStoredTimestamp(oldest.String)
+	stats.Newest = parseStoredTimestamp(newest.String)
+	return stats, nil
+}
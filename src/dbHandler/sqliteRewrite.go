@@ -0,0 +1,92 @@
+package dbhandler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// rewriteBatchSize is how many logs RewriteLogs changes per transaction
+const rewriteBatchSize = 500
+
+// RewriteLogs passes the columns of every log to rewrite in id order and updates the columns
+// it returns. Each batch is its own short transaction, so inserts are not blocked for long.
+func (h *SQLiteHandler) RewriteLogs(ctx context.Context, columns []string, rewrite func(values map[string]interface{}) (map[string]interface{}, error)) (int64, error) {
+	var changed, lastID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return changed, err
+		}
+		n, last, err := h.rewriteBatch(ctx, columns, lastID, rewrite)
+		changed += n
+		if err != nil || last == lastID {
+			return changed, err
+		}
+		lastID = last
+	}
+}
+
+// rewriteBatch rewrites the logs following lastID and returns how many changed and the id
+// it got to
+func (h *SQLiteHandler) rewriteBatch(ctx context.Context, columns []string, lastID int64, rewrite func(values map[string]interface{}) (map[string]interface{}, error)) (int64, int64, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, lastID, fmt.Errorf("failed to begin rewrite: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("SELECT id, %s FROM logs WHERE id > ? ORDER BY id LIMIT %d", strings.Join(columns, ", "), rewriteBatchSize)
+	rows, err := tx.QueryContext(ctx, query, lastID)
+	if err != nil {
+		return 0, lastID, fmt.Errorf("failed to read logs to rewrite: %w", err)
+	}
+	batch := []map[string]interface{}{}
+	for rows.Next() {
+		var id int64
+		values := make([]interface{}, len(columns))
+		pointers := []interface{}{&id}
+		for i := range values {
+			pointers = append(pointers, &values[i])
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			rows.Close()
+			return 0, lastID, fmt.Errorf("failed to read logs to rewrite: %w", err)
+		}
+		row := map[string]interface{}{"id": id}
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		batch = append(batch, row)
+		lastID = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, lastID, fmt.Errorf("failed to read logs to rewrite: %w", err)
+	}
+
+	var changed int64
+	for _, row := range batch {
+		updates, err := rewrite(row)
+		if err != nil {
+			return 0, lastID, err
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		sets := []string{}
+		args := []interface{}{}
+		for col, val := range updates {
+			sets = append(sets, col+" = ?")
+			args = append(args, val)
+		}
+		args = append(args, row["id"])
+		if _, err := tx.ExecContext(ctx, "UPDATE logs SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+			return 0, lastID, fmt.Errorf("failed to rewrite log %v: %w", row["id"], err)
+		}
+		changed++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, lastID, fmt.Errorf("failed to commit rewrite: %w", err)
+	}
+	return changed, lastID, nil
+}
@@ -0,0 +1,420 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+)
+
+// rollupResolution is the bucket size of one level of rollups and how long its rows are kept
+type rollupResolution struct {
+	seconds int64
+	keep    time.Duration // Zero keeps the rows as long as the logs they count
+}
+
+// rollupResolutions from coarse to fine. Minute rows are downsampled away after two weeks
+// and hour rows after about a year, from then on those logs are only counted per day.
+var rollupResolutions = []rollupResolution{
+	{seconds: 86400},
+	{seconds: 3600, keep: 400 * 24 * time.Hour},
+	{seconds: 60, keep: 14 * 24 * time.Hour},
+}
+
+// rollupFields are the fields the rollups count by, a query on anything else scans the logs
+var rollupFields = map[string]bool{"level": true, "source": true, "label": true}
+
+// rollupCompactInterval is how often expired and emptied rollup rows are removed
+const rollupCompactInterval = time.Hour
+
+// rollupKey matches the unique index of log_rollups, see sqliteMigrations
+const rollupKey = "resolution, start, ifnull(level, char(0)), ifnull(source, char(0)), ifnull(label, char(0))"
+
+// rollupStart is the SQL for the start of the rollup bucket of a timestamp
+const rollupStart = "CAST(strftime('%%s', %s) AS INTEGER) / resolution * resolution"
+
+// WithRollups keeps per minute, hour and day counts by level, source and label up to date
+// with triggers, and answers count queries that only need those from them
+func WithRollups() SQLiteOption {
+	return func(h *SQLiteHandler) {
+		h.rollups = true
+	}
+}
+
+// rollupResolutionsSQL selects every resolution as a one column table
+func rollupResolutionsSQL(resolutions []rollupResolution) string {
+	selects := make([]string, len(resolutions))
+	for i, r := range resolutions {
+		selects[i] = fmt.Sprintf("SELECT %d AS resolution", r.seconds)
+	}
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// rollupTriggers count every inserted log into each resolution and take deleted logs back
+// out, so retention and archiving keep the rollups in step with the logs table
+func rollupTriggers() []string {
+	resolutions := rollupResolutionsSQL(rollupResolutions)
+	// One update per resolution, with the bucket start a constant the unique index can find
+	updates := []string{}
+	for _, r := range rollupResolutions {
+		updates = append(updates, fmt.Sprintf(`UPDATE log_rollups SET count = count - 1
+			WHERE resolution = %d AND start = CAST(strftime('%%s', OLD.timestamp) AS INTEGER) / %d * %d
+				AND ifnull(level, char(0)) = ifnull(OLD.level, char(0))
+				AND ifnull(source, char(0)) = ifnull(OLD.source, char(0))
+				AND ifnull(label, char(0)) = ifnull(OLD.label, char(0));`, r.seconds, r.seconds, r.seconds))
+	}
+	return []string{
+		fmt.Sprintf(`CREATE TRIGGER logs_rollup_insert AFTER INSERT ON logs WHEN NEW.timestamp IS NOT NULL BEGIN
+			INSERT INTO log_rollups (resolution, start, level, source, label, count)
+			SELECT resolution, %s, NEW.level, NEW.source, NEW.label, 1 FROM (%s) WHERE true
+			ON CONFLICT (%s) DO UPDATE SET count = count + 1;
+		END`, fmt.Sprintf(rollupStart, "NEW.timestamp"), resolutions, rollupKey),
+		fmt.Sprintf("CREATE TRIGGER logs_rollup_delete AFTER DELETE ON logs WHEN OLD.timestamp IS NOT NULL BEGIN %s END", strings.Join(updates, " ")),
+	}
+}
+
+// enableRollups creates the rollup triggers on a database that does not have them yet. The
+// rollups then only hold logs from now on, rollup_state records where they become complete
+// so older buckets are counted from the logs until RebuildRollups fills them in.
+func (h *SQLiteHandler) enableRollups(ctx context.Context) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var triggers int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('logs_rollup_insert', 'logs_rollup_delete')").Scan(&triggers)
+	if err != nil {
+		return fmt.Errorf("failed to read triggers: %w", err)
+	}
+	if triggers == 2 {
+		return nil
+	}
+
+	for _, stmt := range []string{"DROP TRIGGER IF EXISTS logs_rollup_insert", "DROP TRIGGER IF EXISTS logs_rollup_delete", "DELETE FROM log_rollups", "DELETE FROM rollup_state"} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to reset rollups: %w", err)
+		}
+	}
+	for _, stmt := range rollupTriggers() {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create rollup trigger: %w", err)
+		}
+	}
+
+	var newest sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT MAX(timestamp) FROM logs").Scan(&newest); err != nil {
+		return fmt.Errorf("failed to read newest log: %w", err)
+	}
+	for _, r := range rollupResolutions {
+		var from int64
+		if newest.Valid {
+			// Logs of the newest bucket may be older than the triggers, so it is left to the scan
+			from = (parseStoredTimestamp(newest.String).Unix()/r.seconds + 1) * r.seconds
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO rollup_state (resolution, complete_from) VALUES (?, ?)", r.seconds, from); err != nil {
+			return fmt.Errorf("failed to record rollup state: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to enable rollups: %w", err)
+	}
+	if newest.Valid {
+		log.Println("Enabled rollups, run LogLite with -rebuild-rollups to roll up the existing logs")
+	}
+	return nil
+}
+
+// runRollupCompaction removes expired rollup rows every rollupCompactInterval until Close
+func (h *SQLiteHandler) runRollupCompaction(ctx context.Context) {
+	defer close(h.rollupDone)
+	ticker := time.NewTicker(rollupCompactInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.compactRollups(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error compacting rollups: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// compactRollups downsamples by dropping the rows of resolutions older than they are kept,
+// the coarser resolutions still count those logs, and drops groups whose logs were all deleted
+func (h *SQLiteHandler) compactRollups(ctx context.Context) error {
+	now := time.Now()
+	for _, r := range rollupResolutions {
+		if r.keep == 0 {
+			continue
+		}
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM log_rollups WHERE resolution = ? AND start < ?", r.seconds, now.Add(-r.keep).Unix()); err != nil {
+			return fmt.Errorf("failed to delete expired rollups: %w", err)
+		}
+	}
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM log_rollups WHERE count <= 0"); err != nil {
+		return fmt.Errorf("failed to delete empty rollups: %w", err)
+	}
+	return nil
+}
+
+// rollupEligible reports whether rollups can answer a query: a count from a start time on,
+// grouped and filtered by level, source and label only
+func rollupEligible(q Query) bool {
+	if !q.Count || q.Since.IsZero() || len(q.Text) > 0 || len(q.Aggregates) > 0 || (q.Table != "" && q.Table != "logs") {
+		return false
+	}
+	for _, field := range q.GroupBy {
+		if !rollupFields[field] {
+			return false
+		}
+	}
+	for _, c := range q.Conditions {
+		if !rollupFields[c.Field] {
+			return false
+		}
+	}
+	return true
+}
+
+// rollupPiece is a time range answered by one resolution of rollups, or by scanning the
+// logs when resolution is zero. A zero until is unbounded.
+type rollupPiece struct {
+	resolution   int64
+	since, until time.Time
+}
+
+// planRollups splits [since, until) into pieces, whole buckets of the coarsest resolution in
+// the middle and finer resolutions towards the edges, ending with scans of the logs for what
+// no bucket covers. from is where each resolution's rollups are complete.
+func planRollups(since, until, now time.Time, resolutions []rollupResolution, from map[int64]time.Time) []rollupPiece {
+	if !until.IsZero() && !since.Before(until) {
+		return nil
+	}
+	if len(resolutions) == 0 {
+		return []rollupPiece{{since: since, until: until}}
+	}
+
+	r, finer := resolutions[0], resolutions[1:]
+	size := time.Duration(r.seconds) * time.Second
+	start := BucketStart(since, size)
+	if start.Before(since) {
+		start = start.Add(size)
+	}
+	if start.Before(from[r.seconds]) {
+		start = from[r.seconds]
+	}
+	end := until
+	if end.IsZero() {
+		// The bucket in progress is read at a finer resolution, later logs from the table
+		end = now
+	}
+	stop := BucketStart(end, size)
+	if !start.Before(stop) {
+		return planRollups(since, until, now, finer, from)
+	}
+
+	pieces := planRollups(since, start, now, finer, from)
+	pieces = append(pieces, rollupPiece{resolution: r.seconds, since: start, until: stop})
+	return append(pieces, planRollups(stop, until, now, finer, from)...)
+}
+
+// rollupCompleteFrom returns where the rollups of each resolution begin to be complete,
+// either from enabling them on existing logs or from downsampling
+func (h *SQLiteHandler) rollupCompleteFrom(now time.Time) (map[int64]time.Time, error) {
+	rows, err := h.db.Query("SELECT resolution, complete_from FROM rollup_state")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rollup state: %w", err)
+	}
+	defer rows.Close()
+
+	from := map[int64]time.Time{}
+	for rows.Next() {
+		var resolution, completeFrom int64
+		if err := rows.Scan(&resolution, &completeFrom); err != nil {
+			return nil, fmt.Errorf("failed to read rollup state: %w", err)
+		}
+		from[resolution] = time.Unix(completeFrom, 0).UTC()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rollup state: %w", err)
+	}
+
+	for _, r := range rollupResolutions {
+		t, ok := from[r.seconds]
+		if !ok || r.keep == 0 {
+			continue
+		}
+		// Rows are only dropped once an hour, a whole bucket later on is surely still there
+		expired := now.Add(-r.keep).Add(rollupCompactInterval)
+		if t.Before(expired) {
+			from[r.seconds] = expired
+		}
+	}
+	return from, nil
+}
+
+// queryRollups answers an eligible count query from the rollups where they cover its time
+// range and from the logs table for the rest
+func (h *SQLiteHandler) queryRollups(q Query) ([]map[string]interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	now := time.Now()
+	from, err := h.rollupCompleteFrom(now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only resolutions that fit evenly into the buckets of the query can fill them
+	resolutions := []rollupResolution{}
+	for _, r := range rollupResolutions {
+		if _, ok := from[r.seconds]; ok && (q.Bucket == 0 || int64(q.Bucket/time.Second)%r.seconds == 0) {
+			resolutions = append(resolutions, r)
+		}
+	}
+
+	parts := [][]map[string]interface{}{}
+	for _, piece := range planRollups(q.Since.UTC(), q.Until.UTC(), now.UTC(), resolutions, from) {
+		var rows []map[string]interface{}
+		if piece.resolution == 0 {
+			scan := mergeableQuery(q)
+			scan.Since, scan.Until = piece.since, piece.until
+			rows, err = h.queryLogs(scan)
+		} else {
+			rows, err = h.queryRollupPiece(q, piece)
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, rows)
+	}
+	return mergeCounts(q, parts...), nil
+}
+
+// queryRollupPiece counts the logs of one piece from its resolution of rollups
+func (h *SQLiteHandler) queryRollupPiece(q Query, piece rollupPiece) ([]map[string]interface{}, error) {
+	selects := []string{}
+	groups := []string{}
+	for i, g := range q.GroupBy {
+		selects = append(selects, fmt.Sprintf("%s AS %q", g, g))
+		groups = append(groups, fmt.Sprint(i+1))
+	}
+	if q.Bucket > 0 {
+		seconds := int64(q.Bucket / time.Second)
+		selects = append(selects, fmt.Sprintf("datetime(start / %d * %d, 'unixepoch') AS %s", seconds, seconds, BucketColumn))
+		groups = append(groups, fmt.Sprint(len(selects)))
+	}
+	selects = append(selects, "SUM(count) AS count")
+
+	clauses := []string{"resolution = ?", "start >= ?", "start < ?"}
+	args := []interface{}{piece.resolution, piece.since.Unix(), piece.until.Unix()}
+	for _, c := range q.Conditions {
+		clause, condArgs, err := h.builder.condition(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build query: %w", err)
+		}
+		clauses = append(clauses, clause)
+		args = append(args, condArgs...)
+	}
+
+	query := "SELECT " + strings.Join(selects, ", ") + " FROM log_rollups WHERE " + strings.Join(clauses, " AND ")
+	if len(groups) > 0 {
+		query += " GROUP BY " + strings.Join(groups, ", ")
+	}
+	query += " HAVING SUM(count) > 0"
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	results, err := scanRows(rows)
+	parseBuckets(q, results)
+	return results, err
+}
+
+// RebuildRollups recounts the rollups from the logs one day at a time, so inserts only ever
+// wait for a day's worth of logs, and marks them complete. It returns the days rebuilt.
+func (h *SQLiteHandler) RebuildRollups(ctx context.Context) (int, error) {
+	if !h.rollups {
+		return 0, fmt.Errorf("rollups are not enabled")
+	}
+
+	var oldest, newest sql.NullString
+	if err := h.db.QueryRowContext(ctx, "SELECT MIN(timestamp), MAX(timestamp) FROM logs").Scan(&oldest, &newest); err != nil {
+		return 0, fmt.Errorf("failed to read time range of logs: %w", err)
+	}
+
+	days := 0
+	if oldest.Valid {
+		last := parseStoredTimestamp(newest.String)
+		for day := parseStoredTimestamp(oldest.String).Truncate(24 * time.Hour); !day.After(last); day = day.Add(24 * time.Hour) {
+			if err := h.rebuildRollupDay(ctx, day); err != nil {
+				return days, err
+			}
+			days++
+		}
+	}
+
+	if _, err := h.db.ExecContext(ctx, "UPDATE rollup_state SET complete_from = 0"); err != nil {
+		return days, fmt.Errorf("failed to record rollup state: %w", err)
+	}
+	return days, nil
+}
+
+// rebuildRollupDay replaces the rollups of one day with counts of its logs, leaving out the
+// resolutions that would already have been downsampled away
+func (h *SQLiteHandler) rebuildRollupDay(ctx context.Context, day time.Time) error {
+	resolutions := []rollupResolution{}
+	for _, r := range rollupResolutions {
+		if r.keep == 0 || day.Add(24*time.Hour).After(time.Now().Add(-r.keep)) {
+			resolutions = append(resolutions, r)
+		}
+	}
+	start, end := dayRange(day)
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM log_rollups WHERE start >= ? AND start < ?", day.Unix(), day.Add(24*time.Hour).Unix()); err != nil {
+		return fmt.Errorf("failed to clear rollups of %s: %w", day.Format(archiveDayLayout), err)
+	}
+	insert := fmt.Sprintf(`INSERT INTO log_rollups (resolution, start, level, source, label, count)
+		SELECT resolution, %s, level, source, label, COUNT(*) FROM logs, (%s)
+		WHERE timestamp >= ? AND timestamp < ?
+		GROUP BY 1, 2, 3, 4, 5`, fmt.Sprintf(rollupStart, "timestamp"), rollupResolutionsSQL(resolutions))
+	if _, err := tx.ExecContext(ctx, insert, start, end); err != nil {
+		return fmt.Errorf("failed to roll up %s: %w", day.Format(archiveDayLayout), err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to roll up %s: %w", day.Format(archiveDayLayout), err)
+	}
+	return nil
+}
+
+// RebuildRollups opens the configured SQLite database and recounts its rollups. It is safe
+// to run while LogLite is running.
+func RebuildRollups(config *confighandler.Config) (int, error) {
+	if config.Database.Type != "SQLite" {
+		return 0, fmt.Errorf("rollups are only kept for SQLite, not %s", config.Database.Type)
+	}
+	h, err := NewSQLiteHandler(config.Database.SQLiteFilepath, sqliteOptions(config)...)
+	if err != nil {
+		return 0, err
+	}
+	defer h.Close()
+	return h.RebuildRollups(context.Background())
+}
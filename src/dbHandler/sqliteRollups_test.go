@@ -0,0 +1,62 @@
+package dbhandler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// describePieces writes a plan as "<resolution> <since>-<until>" pieces, scans as "scan"
+func describePieces(pieces []rollupPiece) string {
+	names := map[int64]string{0: "scan", 60: "minute", 3600: "hour", 86400: "day"}
+	layout := "01-02 15:04:05"
+	parts := []string{}
+	for _, p := range pieces {
+		until := "open"
+		if !p.until.IsZero() {
+			until = p.until.Format(layout)
+		}
+		parts = append(parts, fmt.Sprintf("%s %s-%s", names[p.resolution], p.since.Format(layout), until))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestPlanRollups(t *testing.T) {
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2025, 1, day, hour, minute, second, 0, time.UTC)
+	}
+	now := at(31, 12, 34, 56)
+	tests := []struct {
+		name         string
+		since, until time.Time
+		resolutions  []rollupResolution
+		from         map[int64]time.Time
+		want         string
+	}{
+		{"within a minute", at(31, 10, 0, 10), at(31, 10, 0, 50), rollupResolutions, nil,
+			"scan 01-31 10:00:10-01-31 10:00:50"},
+		{"hours and minutes", at(31, 10, 0, 30), at(31, 12, 30, 0), rollupResolutions, nil,
+			"scan 01-31 10:00:30-01-31 10:01:00, minute 01-31 10:01:00-01-31 11:00:00, hour 01-31 11:00:00-01-31 12:00:00, minute 01-31 12:00:00-01-31 12:30:00"},
+		{"whole days", at(30, 23, 30, 0), at(32, 0, 30, 0), rollupResolutions, nil,
+			"minute 01-30 23:30:00-01-31 00:00:00, day 01-31 00:00:00-02-01 00:00:00, minute 02-01 00:00:00-02-01 00:30:00"},
+		{"until now", at(31, 10, 0, 0), time.Time{}, rollupResolutions, nil,
+			"hour 01-31 10:00:00-01-31 12:00:00, minute 01-31 12:00:00-01-31 12:34:00, scan 01-31 12:34:00-open"},
+		{"minutes complete later", at(31, 10, 0, 0), at(31, 10, 45, 0), rollupResolutions, map[int64]time.Time{60: at(31, 10, 30, 0)},
+			"scan 01-31 10:00:00-01-31 10:30:00, minute 01-31 10:30:00-01-31 10:45:00"},
+		{"hours downsampled", at(29, 6, 0, 0), at(31, 0, 0, 0), rollupResolutions[1:], map[int64]time.Time{3600: at(30, 0, 0, 0), 60: at(31, 0, 0, 0)},
+			"scan 01-29 06:00:00-01-30 00:00:00, hour 01-30 00:00:00-01-31 00:00:00"},
+		{"no resolutions", at(31, 10, 0, 0), at(31, 11, 0, 0), nil, nil,
+			"scan 01-31 10:00:00-01-31 11:00:00"},
+		{"empty range", at(31, 10, 0, 0), at(31, 10, 0, 0), rollupResolutions, nil, ""},
+		{"reversed range", at(31, 11, 0, 0), at(31, 10, 0, 0), rollupResolutions, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describePieces(planRollups(tt.since, tt.until, now, tt.resolutions, tt.from))
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
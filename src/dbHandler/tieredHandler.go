@@ -0,0 +1,369 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TieredHandler keeps recent logs in SQLite and moves logs older than a threshold into
+// compressed archive files, one or more per day. Queries read the archive files their
+// time range and filters need, so archived logs stay searchable, only slower.
+type TieredHandler struct {
+	hot      *SQLiteHandler
+	dir      string
+	after    time.Duration
+	interval time.Duration
+
+	mu       sync.RWMutex // Guards the manifest, held for reading while a query runs
+	manifest *archiveManifest
+
+	moveMu    sync.Mutex // Serializes the archiver and the pruner
+	prunedDay string     // Day the archive was last pruned by age
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// TieredOption is a function that configures a TieredHandler
+type TieredOption func(*TieredHandler)
+
+// WithArchiveInterval sets how often logs are moved to the archive
+func WithArchiveInterval(d time.Duration) TieredOption {
+	return func(h *TieredHandler) {
+		h.interval = d
+	}
+}
+
+// NewTieredHandler archives the logs of hot older than after into dir and starts the archiver
+func NewTieredHandler(hot *SQLiteHandler, dir string, after time.Duration, opts ...TieredOption) (*TieredHandler, error) {
+	if after <= 0 {
+		return nil, fmt.Errorf("archive threshold must be positive")
+	}
+	h := &TieredHandler{hot: hot, dir: dir, after: after, interval: time.Hour}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	manifest, err := loadArchiveManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	h.manifest = manifest
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	h.done = make(chan struct{})
+	go h.run(ctx)
+	return h, nil
+}
+
+// run moves old logs to the archive every interval until Close is called
+func (h *TieredHandler) run(ctx context.Context) {
+	defer close(h.done)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		moved, err := h.Archive(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error archiving logs: %v\n", err)
+		} else if moved > 0 {
+			log.Printf("Archived %d logs\n", moved)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Archive moves every whole day older than the threshold from SQLite to the archive and
+// returns the number of logs moved
+func (h *TieredHandler) Archive(ctx context.Context) (int64, error) {
+	h.moveMu.Lock()
+	defer h.moveMu.Unlock()
+
+	if err := h.removeArchived(ctx); err != nil {
+		return 0, err
+	}
+
+	// Logs inserted while the archiver runs get higher ids, bounding the ids keeps them out of it
+	var maxID sql.NullInt64
+	if err := h.hot.db.QueryRowContext(ctx, "SELECT MAX(id) FROM logs").Scan(&maxID); err != nil {
+		return 0, fmt.Errorf("failed to read newest id: %w", err)
+	}
+	cutoff := time.Now().UTC().Add(-h.after).Truncate(24 * time.Hour).Format(TimestampLayout)
+
+	var moved int64
+	for maxID.Valid {
+		var oldest sql.NullString
+		err := h.hot.db.QueryRowContext(ctx, "SELECT MIN(timestamp) FROM logs WHERE timestamp < ? AND id <= ?", cutoff, maxID.Int64).Scan(&oldest)
+		if err != nil {
+			return moved, fmt.Errorf("failed to find logs to archive: %w", err)
+		}
+		if !oldest.Valid {
+			break
+		}
+		ts := parseStoredTimestamp(oldest.String)
+		if ts.IsZero() {
+			return moved, fmt.Errorf("failed to archive logs: unreadable timestamp %q", oldest.String)
+		}
+
+		n, err := h.moveDay(ctx, ts.Truncate(24*time.Hour), maxID.Int64)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// dayRange returns the bounds of a day as stored timestamps
+func dayRange(day time.Time) (string, string) {
+	return day.Format(TimestampLayout), day.Add(24 * time.Hour).Format(TimestampLayout)
+}
+
+// moveDay writes the logs of one day with an id up to maxID to a new archive file, adds it
+// to the manifest and deletes the logs from SQLite
+func (h *TieredHandler) moveDay(ctx context.Context, day time.Time, maxID int64) (int64, error) {
+	start, end := dayRange(day)
+	rows, err := h.hot.db.QueryContext(ctx,
+		"SELECT "+strings.Join(LogColumns, ", ")+" FROM logs WHERE timestamp >= ? AND timestamp < ? AND id <= ? ORDER BY timestamp, id",
+		start, end, maxID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read logs to archive: %w", err)
+	}
+	defer rows.Close()
+
+	key := day.Format(archiveDayLayout)
+	h.mu.Lock()
+	name := h.manifest.newFileName(key)
+	h.mu.Unlock()
+	writer, err := newArchiveWriter(h.dir, name, key)
+	if err != nil {
+		return 0, err
+	}
+
+	values := make([]interface{}, len(LogColumns))
+	pointers := make([]interface{}, len(LogColumns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			writer.abort()
+			return 0, fmt.Errorf("failed to scan log to archive: %w", err)
+		}
+		row := make(map[string]interface{}, len(LogColumns))
+		for i, col := range LogColumns {
+			row[col] = values[i]
+		}
+		if err := writer.write(row); err != nil {
+			writer.abort()
+			return 0, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writer.abort()
+		return 0, fmt.Errorf("failed to read logs to archive: %w", err)
+	}
+	if writer.entry.Rows == 0 {
+		// MIN(timestamp) found the day, so its timestamps are stored in a format the range misses
+		writer.abort()
+		return 0, fmt.Errorf("failed to archive logs of %s: no logs in range %s to %s", key, start, end)
+	}
+	entry, err := writer.finish()
+	if err != nil {
+		return 0, err
+	}
+
+	// The manifest is saved before the rows are deleted. If the delete fails, removeArchived
+	// deletes them on the next run, until then queries hold the lock and never see both copies.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.manifest.Files = append(h.manifest.Files, entry)
+	if err := h.manifest.save(h.dir); err != nil {
+		h.manifest.Files = h.manifest.Files[:len(h.manifest.Files)-1]
+		os.Remove(filepath.Join(h.dir, entry.Name))
+		return 0, err
+	}
+	if _, err := h.hot.db.ExecContext(ctx, "DELETE FROM logs WHERE timestamp >= ? AND timestamp < ? AND id <= ?", start, end, maxID); err != nil {
+		return 0, fmt.Errorf("failed to delete archived logs: %w", err)
+	}
+	return entry.Rows, nil
+}
+
+// removeArchived deletes rows from SQLite that an earlier run archived but failed to delete
+func (h *TieredHandler) removeArchived(ctx context.Context) error {
+	var oldest sql.NullString
+	if err := h.hot.db.QueryRowContext(ctx, "SELECT MIN(timestamp) FROM logs").Scan(&oldest); err != nil {
+		return fmt.Errorf("failed to read oldest log: %w", err)
+	}
+	if !oldest.Valid {
+		return nil
+	}
+	oldestDay := parseStoredTimestamp(oldest.String).Format(archiveDayLayout)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, file := range h.manifest.Files {
+		if file.Day < oldestDay {
+			continue
+		}
+		day, _ := time.Parse(archiveDayLayout, file.Day)
+		start, end := dayRange(day)
+		_, err := h.hot.db.ExecContext(ctx, "DELETE FROM logs WHERE timestamp >= ? AND timestamp < ? AND id <= ?", start, end, file.MaxID)
+		if err != nil {
+			return fmt.Errorf("failed to delete archived logs: %w", err)
+		}
+	}
+	return nil
+}
+
+// Put writes to SQLite, logs only reach the archive once they are old enough
+func (h *TieredHandler) Put(table string, data map[string]interface{}) error {
+	return h.hot.Put(table, data)
+}
+
+// Backup snapshots the SQLite part only, archive files never change once written and can be
+// copied as they are. Logs archived after the snapshot are removed from it again on start.
+func (h *TieredHandler) Backup(ctx context.Context, path string) error {
+	return h.hot.Backup(ctx, path)
+}
+
+// RewriteLogs rewrites the logs in SQLite, archive files are never changed once written
+func (h *TieredHandler) RewriteLogs(ctx context.Context, columns []string, rewrite func(values map[string]interface{}) (map[string]interface{}, error)) (int64, error) {
+	return h.hot.RewriteLogs(ctx, columns, rewrite)
+}
+
+// Get supports the same equality conditions, limit, offset and orderBy as the SQLite handler
+func (h *TieredHandler) Get(table string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	return h.Query(queryFromConditions(table, conditions))
+}
+
+// Query runs the query on SQLite and on the archive files it cannot rule out, and merges the results
+func (h *TieredHandler) Query(q Query) ([]map[string]interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	if q.Before != nil {
+		q = keysetQuery(q)
+		rows, err := h.Query(q)
+		reverseRows(rows)
+		return rows, err
+	}
+
+	// Holding mu keeps a day from being seen both in SQLite and in the archive while it moves
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	files := []*archiveFile{}
+	for _, file := range h.manifest.Files {
+		if file.mightMatch(q) {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return h.hot.Query(q)
+	}
+
+	if q.IsAggregate() {
+		return h.queryCounts(q, files)
+	}
+
+	// SQLite returns enough rows to fill the page on its own, the page is cut after merging
+	hotQuery := q
+	if q.Limit > 0 {
+		hotQuery.Limit = q.Limit + q.Offset
+		hotQuery.Offset = 0
+	}
+	results, err := h.hot.Query(hotQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	field, ascending := q.OrderBy, q.Ascending
+	if field == "" {
+		field, ascending = "id", true
+	}
+
+	// Reading files in time order lets a time ordered query stop once no older (or newer)
+	// file can hold a row that belongs on the page
+	timeOrdered := field == "timestamp"
+	if timeOrdered {
+		sort.Slice(files, func(i, j int) bool {
+			if ascending {
+				return files[i].From.Before(files[j].From)
+			}
+			return files[i].To.After(files[j].To)
+		})
+	}
+
+	need := hotQuery.Limit
+	for _, file := range files {
+		if timeOrdered && need > 0 && len(results) >= need {
+			sortRows(results, field, ascending)
+			results = results[:need]
+			worst, _ := results[need-1]["timestamp"].(time.Time)
+			if ascending && file.From.After(worst) || !ascending && file.To.Before(worst) {
+				break
+			}
+		}
+
+		rows, err := readArchiveFile(h.dir, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run query: %w", err)
+		}
+		for _, row := range rows {
+			if matchQuery(q, row.get) {
+				results = append(results, row.values)
+			}
+		}
+	}
+
+	sortRows(results, field, ascending)
+	return pageRows(results, q.Limit, q.Offset), nil
+}
+
+// queryCounts adds the counts of the matching archived logs to the counts from SQLite
+func (h *TieredHandler) queryCounts(q Query, files []*archiveFile) ([]map[string]interface{}, error) {
+	hotCounts, err := h.hot.Query(mergeableQuery(q))
+	if err != nil {
+		return nil, err
+	}
+
+	counter := newGroupCounter(q)
+	for _, row := range hotCounts {
+		counter.addCounts(row)
+	}
+	for _, file := range files {
+		rows, err := readArchiveFile(h.dir, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run query: %w", err)
+		}
+		for _, row := range rows {
+			if matchQuery(q, row.get) {
+				counter.add(row.get)
+			}
+		}
+	}
+	return counter.rows(), nil
+}
+
+// Close stops the archiver and closes SQLite
+func (h *TieredHandler) Close() error {
+	h.cancel()
+	<-h.done
+	return h.hot.Close()
+}
@@ -0,0 +1,219 @@
+package dbhandler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Prune applies the policy to both tiers. Age limits delete rows in SQLite as usual and remove
+// archived logs once a day. Row and size limits count both tiers and drop the oldest archive
+// files before any row is deleted from SQLite.
+func (h *TieredHandler) Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error) {
+	result := PruneResult{StartedAt: time.Now()}
+	err := h.prune(ctx, policy, &result)
+	result.Duration = time.Since(result.StartedAt)
+	return result, err
+}
+
+func (h *TieredHandler) prune(ctx context.Context, policy RetentionPolicy, result *PruneResult) error {
+	h.moveMu.Lock()
+	defer h.moveMu.Unlock()
+
+	ages := RetentionPolicy{MaxAge: policy.MaxAge, Rules: policy.Rules, BatchSize: policy.BatchSize}
+	if !ages.IsEmpty() {
+		if err := h.pruneArchiveByAge(ages, result); err != nil {
+			return err
+		}
+		if err := h.pruneHot(ctx, ages, result); err != nil {
+			return err
+		}
+	}
+	if policy.MaxRows == 0 && policy.MaxBytes == 0 {
+		return nil
+	}
+
+	hot, err := h.hot.StorageStats()
+	if err != nil {
+		return err
+	}
+	rows, bytes := hot.Rows, hot.Bytes-hot.FreeBytes
+	h.mu.RLock()
+	for _, file := range h.manifest.Files {
+		rows += file.Rows
+		bytes += file.Bytes
+	}
+	oldest := append([]*archiveFile{}, h.manifest.Files...)
+	h.mu.RUnlock()
+
+	for len(oldest) > 0 && (policy.MaxRows > 0 && rows > policy.MaxRows || policy.MaxBytes > 0 && bytes > policy.MaxBytes) {
+		file := oldest[0]
+		if err := h.replaceArchiveFile(file, nil); err != nil {
+			return err
+		}
+		rows -= file.Rows
+		bytes -= file.Bytes
+		result.Deleted += file.Rows
+		result.ReclaimedBytes += file.Bytes
+		oldest = oldest[1:]
+	}
+	if len(oldest) > 0 {
+		return nil
+	}
+	// With the archive empty the limits apply to SQLite alone
+	limits := RetentionPolicy{MaxRows: policy.MaxRows, MaxBytes: policy.MaxBytes, BatchSize: policy.BatchSize}
+	return h.pruneHot(ctx, limits, result)
+}
+
+func (h *TieredHandler) pruneHot(ctx context.Context, policy RetentionPolicy, result *PruneResult) error {
+	r, err := h.hot.Prune(ctx, policy)
+	result.Deleted += r.Deleted
+	result.ReclaimedBytes += r.ReclaimedBytes
+	if r.Note != "" {
+		result.Note = r.Note
+	}
+	return err
+}
+
+// pruneArchiveByAge removes expired logs from the archive. Files entirely older than every
+// age limit are deleted, files holding some expired logs are rewritten without them.
+// It runs once a day since archived logs are kept in whole days anyway.
+func (h *TieredHandler) pruneArchiveByAge(policy RetentionPolicy, result *PruneResult) error {
+	now := time.Now().UTC()
+	today := now.Format(archiveDayLayout)
+	if h.prunedDay == today {
+		return nil
+	}
+
+	// Without a default max age, logs no rule matches are kept forever
+	longest, shortest := policy.MaxAge, policy.MaxAge
+	for _, rule := range policy.Rules {
+		if longest > 0 && rule.MaxAge > longest {
+			longest = rule.MaxAge
+		}
+		if shortest == 0 || rule.MaxAge < shortest {
+			shortest = rule.MaxAge
+		}
+	}
+
+	h.mu.RLock()
+	files := append([]*archiveFile{}, h.manifest.Files...)
+	h.mu.RUnlock()
+
+	for _, file := range files {
+		switch {
+		case longest > 0 && file.To.Before(now.Add(-longest)):
+			if err := h.replaceArchiveFile(file, nil); err != nil {
+				return err
+			}
+			result.Deleted += file.Rows
+			result.ReclaimedBytes += file.Bytes
+		case file.From.Before(now.Add(-shortest)):
+			if err := h.rewriteArchiveFile(file, policy, now, result); err != nil {
+				return err
+			}
+		}
+	}
+	h.prunedDay = today
+	return nil
+}
+
+// rewriteArchiveFile writes the logs of a file the policy keeps to a new file and drops the old one
+func (h *TieredHandler) rewriteArchiveFile(file *archiveFile, policy RetentionPolicy, now time.Time, result *PruneResult) error {
+	rows, err := readArchiveFile(h.dir, file)
+	if err != nil {
+		return err
+	}
+	kept := []*memoryRow{}
+	for _, row := range rows {
+		ts, _ := row.get("timestamp").(time.Time)
+		if !policy.expired(row.get("level"), row.get("source"), ts, now) {
+			kept = append(kept, row)
+		}
+	}
+	if len(kept) == len(rows) {
+		return nil
+	}
+
+	var replacement *archiveFile
+	if len(kept) > 0 {
+		h.mu.Lock()
+		name := h.manifest.newFileName(file.Day)
+		h.mu.Unlock()
+		writer, err := newArchiveWriter(h.dir, name, file.Day)
+		if err != nil {
+			return err
+		}
+		for _, row := range kept {
+			if err := writer.write(row.values); err != nil {
+				writer.abort()
+				return err
+			}
+		}
+		if replacement, err = writer.finish(); err != nil {
+			return err
+		}
+		// Later files of the same day still rely on the ids the old file covered
+		replacement.MaxID = file.MaxID
+	}
+
+	if err := h.replaceArchiveFile(file, replacement); err != nil {
+		if replacement != nil {
+			os.Remove(filepath.Join(h.dir, replacement.Name))
+		}
+		return err
+	}
+	result.Deleted += int64(len(rows) - len(kept))
+	result.ReclaimedBytes += file.Bytes
+	if replacement != nil {
+		result.ReclaimedBytes -= replacement.Bytes
+	}
+	return nil
+}
+
+// replaceArchiveFile swaps a file in the manifest for its replacement, or removes it when
+// replacement is nil, and deletes the old file once the manifest is saved
+func (h *TieredHandler) replaceArchiveFile(file, replacement *archiveFile) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	files := []*archiveFile{}
+	for _, f := range h.manifest.Files {
+		if f != file {
+			files = append(files, f)
+		}
+	}
+	if replacement != nil {
+		files = append(files, replacement)
+	}
+	previous := h.manifest.Files
+	h.manifest.Files = files
+	if err := h.manifest.save(h.dir); err != nil {
+		h.manifest.Files = previous
+		return err
+	}
+	os.Remove(filepath.Join(h.dir, file.Name))
+	return nil
+}
+
+// StorageStats adds the archive files to the usage of SQLite
+func (h *TieredHandler) StorageStats() (StorageStats, error) {
+	stats, err := h.hot.StorageStats()
+	if err != nil {
+		return stats, err
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, file := range h.manifest.Files {
+		stats.Rows += file.Rows
+		stats.Bytes += file.Bytes
+		if stats.Oldest.IsZero() || file.From.Before(stats.Oldest) {
+			stats.Oldest = file.From
+		}
+		if file.To.After(stats.Newest) {
+			stats.Newest = file.To
+		}
+	}
+	return stats, nil
+}
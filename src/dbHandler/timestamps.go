@@ -0,0 +1,56 @@
+package dbhandler
+
+import (
+	"fmt"
+	"time"
+)
+
+// isTimeColumn reports whether a column of the logs table holds a time
+func isTimeColumn(col string) bool {
+	return col == "timestamp" || col == "received_at"
+}
+
+// parseStoredTimestamp reads a timestamp as the logs table stores it or SQLite returns it from
+// an aggregate. Parsing without a fraction in the layout also reads one when it is there, so
+// timestamps stored before they had nanoseconds are read too.
+func parseStoredTimestamp(value string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// timeOf returns the time a value of a time column holds, either a time.Time or stored text
+func timeOf(value interface{}) (time.Time, bool) {
+	switch t := value.(type) {
+	case time.Time:
+		return t.UTC(), true
+	case string:
+		if parsed := parseStoredTimestamp(t); !parsed.IsZero() {
+			return parsed.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// storedRow returns data with its times as UTC text in TimestampLayout, which sorts in time
+// order, and the timestamp set to now when it is missing so it has nanoseconds too
+func storedRow(data map[string]interface{}) (map[string]interface{}, error) {
+	row := make(map[string]interface{}, len(data)+1)
+	for col, val := range data {
+		if isTimeColumn(col) && val != nil {
+			t, ok := timeOf(val)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s %v", col, val)
+			}
+			val = t.Format(TimestampLayout)
+		}
+		row[col] = val
+	}
+	if row["timestamp"] == nil {
+		row["timestamp"] = time.Now().UTC().Format(TimestampLayout)
+	}
+	return row, nil
+}
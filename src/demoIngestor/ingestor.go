@@ -5,11 +5,12 @@ import (
 	"log"
 	"time"
 
-	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
 	"golang.org/x/exp/rand"
 )
 
-func IngestDemoData(db dbhandler.DBHandler, rowsPerSecond int) {
+// IngestDemoData inserts random logs with put, which stores them in the current database as
+// the settings page may switch the storage
+func IngestDemoData(put func(table string, data map[string]interface{}) error, rowsPerSecond int) {
 	// Initialize random seed
 	rand.Seed(uint64(time.Now().UnixNano()))
 
@@ -40,7 +41,7 @@ func IngestDemoData(db dbhandler.DBHandler, rowsPerSecond int) {
 		}
 
 		// Insert the log entry into the database
-		err := db.Put("logs", data)
+		err := put("logs", data)
 		if err != nil {
 			log.Printf("Error inserting demo log: %v", err)
 			continue
@@ -1,45 +1,93 @@
 package ingestor
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"time"
 
 	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
 )
 
+// maxLogsBody limits the size of one POST /logs request
+const maxLogsBody = 10 << 20
+
 type HTTPIngestor struct {
 	Port      int
 	dbHandler dbhandler.DBHandler // Database handler to save data
+	server    *http.Server        // Running server, nil until Start
 }
 
+// Start listens on the port and serves in the background, the error is returned when the port
+// cannot be bound
 func (h *HTTPIngestor) Start() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 			fmt.Fprintf(w, "Hello from HTTP Ingestor!")
 	})
+	mux.HandleFunc("POST /logs", h.handleLogs)
 
-	server := &http.Server{
-			Addr:    fmt.Sprintf(":%d", h.Port),
-			Handler: mux,
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", h.Port))
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
 	}
+	server := &http.Server{Handler: mux}
+	h.server = server
 
 	log.Printf("HTTP server is running on port %d\n", h.Port)
 
 	go func() {
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-					log.Fatalf("HTTP server error: %v", err)
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+					log.Printf("HTTP server error: %v", err)
 			}
 	}()
 
 	return nil // This allows the Start function to return immediately
 }
 
+// Stop closes the port and waits up to 5 seconds for the requests being handled
 func (h *HTTPIngestor) Stop() error {
-	// Optional: Add logic to gracefully stop the HTTP server if needed
+	if h.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := h.server.Shutdown(ctx)
+	h.server = nil
+	if err != nil {
+		return fmt.Errorf("failed to stop HTTP server: %w", err)
+	}
+	log.Println("HTTP server stopped")
 	return nil
 }
 
 func (h *HTTPIngestor) SetDBHandler(dbHandler dbhandler.DBHandler) {
 	h.dbHandler = dbHandler
-}
\ No newline at end of file
+}
+
+// handleLogs stores the JSON log records in the body, a single object, an array or one object
+// per line. Either all of them are stored or, when one is invalid, none.
+func (h *HTTPIngestor) handleLogs(w http.ResponseWriter, req *http.Request) {
+	defaults := map[string]interface{}{"source": "http-ingestor", "address": req.RemoteAddr}
+	rows, err := decodeRecords(http.MaxBytesReader(w, req.Body, maxLogsBody), defaults, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.dbHandler == nil {
+		http.Error(w, "no database configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := dbhandler.PutBatch(h.dbHandler, "logs", rows); err != nil {
+		log.Printf("Error saving logs to database: %v", err)
+		http.Error(w, "failed to store logs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"accepted": len(rows)})
+}
@@ -0,0 +1,74 @@
+package ingestor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+func postLogs(h *HTTPIngestor, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/logs", strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+	h.handleLogs(w, req)
+	return w
+}
+
+func TestHTTPIngestorStoresLogs(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"object", `{"level":"warn","message":"slow query","duration_ms":812}`, 1},
+		{"array", `[{"message":"a"},{"message":"b"}]`, 2},
+		{"ndjson", "{\"message\":\"a\"}\n{\"message\":\"b\"}\n{\"message\":\"c\"}\n", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := dbhandler.NewMemoryHandler(10)
+			h := &HTTPIngestor{}
+			h.SetDBHandler(db)
+
+			w := postLogs(h, tt.body)
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			rows, err := db.Query(dbhandler.Query{OrderBy: "timestamp"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != tt.want {
+				t.Fatalf("stored %d logs, want %d", len(rows), tt.want)
+			}
+			for _, row := range rows {
+				if row["source"] != "http-ingestor" || row["address"] != "10.0.0.1:1234" || row["received_at"] == nil {
+					t.Errorf("defaults missing in %v", row)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPIngestorRejectsBatch(t *testing.T) {
+	db, _ := dbhandler.NewMemoryHandler(10)
+	h := &HTTPIngestor{}
+	h.SetDBHandler(db)
+
+	w := postLogs(h, `[{"message":"a"},{"level":"INFO"}]`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", w.Code)
+	}
+	if rows, _ := db.Query(dbhandler.Query{}); len(rows) != 0 {
+		t.Errorf("stored %d logs of an invalid batch", len(rows))
+	}
+}
+
+func TestHTTPIngestorWithoutDatabase(t *testing.T) {
+	if w := postLogs(&HTTPIngestor{}, `{"message":"a"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", w.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package ingestor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordFields are the keys of a JSON log record that map to columns. Any other key is kept
+// in the metadata.
+var recordFields = map[string]string{
+	"timestamp": "timestamp",
+	"time":      "timestamp",
+	"ts":        "timestamp",
+	"level":     "level",
+	"message":   "message",
+	"msg":       "message",
+	"source":    "source",
+	"method":    "method",
+	"address":   "address",
+	"label":     "label",
+	"metadata":  "metadata",
+}
+
+// ParseTimestamp reads the time of a log as clients send it: RFC3339 with any fraction and
+// offset, "2006-01-02 15:04:05" in UTC, or a Unix epoch number. The unit of an epoch is
+// inferred from its size, so seconds, milliseconds, microseconds and nanoseconds all work
+// for any date between 1973 and 5138.
+func ParseTimestamp(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return parseEpoch(v.String())
+	case float64:
+		return epochFloatTime(v), nil
+	case int64:
+		return epochTime(v), nil
+	case int:
+		return epochTime(int64(v)), nil
+	case string:
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return parseEpoch(v)
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid timestamp %q (use RFC3339 or a Unix epoch)", v)
+	default:
+		return time.Time{}, fmt.Errorf("invalid timestamp %v (use RFC3339 or a Unix epoch)", value)
+	}
+}
+
+// parseEpoch reads an epoch number from text. Whole numbers are parsed as integers so
+// nanoseconds are not rounded by a float.
+func parseEpoch(text string) (time.Time, error) {
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return epochTime(n), nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", text)
+	}
+	return epochFloatTime(f), nil
+}
+
+// epochTime converts a whole epoch number in an inferred unit to a time
+func epochTime(n int64) time.Time {
+	size := n
+	if size < 0 {
+		size = -size
+	}
+	switch {
+	case size < 1e11:
+		return time.Unix(n, 0).UTC()
+	case size < 1e14:
+		return time.UnixMilli(n).UTC()
+	case size < 1e17:
+		return time.UnixMicro(n).UTC()
+	default:
+		return time.Unix(0, n).UTC()
+	}
+}
+
+// epochFloatTime converts an epoch with a fraction, which only makes sense for seconds and
+// milliseconds
+func epochFloatTime(f float64) time.Time {
+	if f == math.Trunc(f) && math.Abs(f) < math.MaxInt64 {
+		return epochTime(int64(f))
+	}
+	if math.Abs(f) < 1e11 {
+		return time.Unix(0, int64(f*1e9)).UTC()
+	}
+	return time.Unix(0, int64(f*1e6)).UTC()
+}
+
+// decodeRecord turns one JSON log record into a row of the logs table. Values in defaults
+// fill the columns the record leaves out. The row keeps the client's timestamp, or the time
+// it was received when there is none, and always records when it was received.
+func decodeRecord(raw []byte, defaults map[string]interface{}, received time.Time) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var record map[string]interface{}
+	if err := decoder.Decode(&record); err != nil || record == nil {
+		return nil, errors.New("log record must be a JSON object")
+	}
+
+	row := map[string]interface{}{}
+	for col, val := range defaults {
+		row[col] = val
+	}
+	metadata := map[string]interface{}{}
+	for key, val := range record {
+		col, ok := recordFields[strings.ToLower(key)]
+		if !ok {
+			metadata[key] = val
+			continue
+		}
+		switch col {
+		case "timestamp":
+			t, err := ParseTimestamp(val)
+			if err != nil {
+				return nil, err
+			}
+			row["timestamp"] = t
+		case "metadata":
+			switch m := val.(type) {
+			case map[string]interface{}:
+				for k, v := range m {
+					metadata[k] = v
+				}
+			case string:
+				var parsed map[string]interface{}
+				if err := json.Unmarshal([]byte(m), &parsed); err != nil {
+					return nil, errors.New("metadata must be a JSON object")
+				}
+				for k, v := range parsed {
+					metadata[k] = v
+				}
+			case nil:
+			default:
+				return nil, errors.New("metadata must be a JSON object")
+			}
+		default:
+			if val == nil {
+				continue
+			}
+			text, ok := val.(string)
+			if !ok {
+				text = fmt.Sprint(val)
+			}
+			row[col] = text
+		}
+	}
+
+	message, _ := row["message"].(string)
+	if message == "" {
+		return nil, errors.New("log record has no message")
+	}
+	if level, ok := row["level"].(string); ok && level != "" {
+		row["level"] = strings.ToUpper(level)
+	} else {
+		row["level"] = "INFO"
+	}
+	if len(metadata) > 0 {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		row["metadata"] = string(encoded)
+	}
+	if _, ok := row["timestamp"]; !ok {
+		row["timestamp"] = received
+	}
+	row["received_at"] = received
+	row["length"] = len(raw)
+	return row, nil
+}
+
+// decodeRecords reads a request body holding one JSON record, an array of records or
+// newline delimited records
+func decodeRecords(body io.Reader, defaults map[string]interface{}, received time.Time) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(body)
+	rows := []map[string]interface{}{}
+	for n := 1; ; n++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: invalid JSON: %w", n, err)
+		}
+
+		records := []json.RawMessage{raw}
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			if err := json.Unmarshal(raw, &records); err != nil {
+				return nil, fmt.Errorf("record %d: invalid JSON: %w", n, err)
+			}
+		}
+		for i, record := range records {
+			row, err := decodeRecord(record, defaults, received)
+			if err != nil {
+				return nil, fmt.Errorf("record %d: %w", n+i, err)
+			}
+			rows = append(rows, row)
+		}
+		n += len(records) - 1
+	}
+}
@@ -0,0 +1,61 @@
+package ingestor
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value interface{}
+		want  time.Time
+	}{
+		{"RFC3339", "2025-01-31T12:00:00Z", want},
+		{"RFC3339 offset", "2025-01-31T14:00:00+02:00", want},
+		{"RFC3339 fraction", "2025-01-31T12:00:00.123456789Z", want.Add(123456789)},
+		{"no zone", "2025-01-31T12:00:00", want},
+		{"space", "2025-01-31 12:00:00", want},
+		{"seconds", json.Number("1738324800"), want},
+		{"milliseconds", json.Number("1738324800123"), want.Add(123 * time.Millisecond)},
+		{"microseconds", json.Number("1738324800123456"), want.Add(123456 * time.Microsecond)},
+		{"nanoseconds", json.Number("1738324800123456789"), want.Add(123456789)},
+		{"fractional seconds", json.Number("1738324800.5"), want.Add(500 * time.Millisecond)},
+		{"fractional milliseconds", json.Number("100000000000.5"), time.UnixMilli(1e11).UTC().Add(500 * time.Microsecond)},
+		{"epoch text", "1738324800", want},
+		{"float", float64(1738324800), want},
+		{"int64", int64(1738324800000), want},
+		{"int", 1738324800, want},
+		{"before 1970", json.Number("-86400"), time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTimestamp(tt.value)
+			if err != nil {
+				t.Fatalf("ParseTimestamp(%v): %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("ParseTimestamp(%v) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimestampInvalid(t *testing.T) {
+	for _, value := range []interface{}{
+		"yesterday",
+		"2025-01-31",
+		"31/01/2025 12:00:00",
+		"",
+		json.Number("1e999"),
+		true,
+		nil,
+		map[string]interface{}{"seconds": 1},
+	} {
+		if got, err := ParseTimestamp(value); err == nil {
+			t.Errorf("ParseTimestamp(%v) = %s, want an error", value, got)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package ingestor
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"sync"
+	"time"
 
 	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
 )
@@ -87,6 +90,8 @@ func (u *UDPIngestor) handleRequest(pc net.PacketConn, addr net.Addr, buf []byte
 	message := string(buf)
 	log.Printf("Received %d bytes from %s: %s\n", len(buf), addr.String(), message)
 
+	received := time.Now().UTC()
+
 	// Prepare the log data for insertion
 	logData := map[string]interface{}{
 		"level":    "INFO",          // Example: You can set a default level
@@ -96,6 +101,18 @@ func (u *UDPIngestor) handleRequest(pc net.PacketConn, addr net.Addr, buf []byte
 		"address":  addr.String(),   // The client's address
 		"length":   len(buf),        // The length of the UDP message
 		"metadata": nil,             // Add any extra metadata if needed, or leave it NULL
+		"timestamp": received,
+		"received_at": received,
+	}
+
+	// JSON records carry their own level, timestamp and fields, anything else is stored as text
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '{' {
+		defaults := map[string]interface{}{"source": "udp-ingestor", "address": addr.String()}
+		if record, err := decodeRecord(trimmed, defaults, received); err == nil {
+			logData = record
+		} else {
+			log.Printf("Storing invalid JSON record from %s as text: %v", addr.String(), err)
+		}
 	}
 
 	// Insert the log into the database
@@ -121,7 +138,9 @@ func (u *UDPIngestor) Stop() error {
 		close(u.stopChan)
 
 		// Close the listener to unblock ReadFrom
-		if err := u.listener.Close(); err != nil {
+		err := u.listener.Close()
+		u.listener = nil // Stopping twice does nothing
+		if err != nil {
 			return fmt.Errorf("failed to close UDP server: %w", err)
 		}
 		log.Println("UDP server stopped")
@@ -134,8 +153,7 @@ func (u *UDPIngestor) Stop() error {
 
 // Helper function to check if an error is due to the listener being closed
 func isNetClosedError(err error) bool {
-	// This is a common way to detect "use of closed network connection"
-	return err != nil && (err.Error() == "use of closed network connection")
+	return errors.Is(err, net.ErrClosed)
 }
 
 func (u *UDPIngestor) SetDBHandler(dbHandler dbhandler.DBHandler) {
@@ -0,0 +1,157 @@
+package livetail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replaySize is how many of the newest entries are kept for viewers that reconnect
+const replaySize = 10000
+
+// Entry is a log as it was stored, numbered in the order logs were published. Row is shared
+// by every subscriber and must not be changed.
+type Entry struct {
+	Seq uint64
+	Row map[string]interface{}
+}
+
+// Broadcaster pushes every stored log to the live tail subscribers once it is stored. A
+// subscriber that falls behind loses entries instead of slowing down ingestion. The newest
+// entries are kept, so a viewer that reconnects can catch up on what it missed.
+type Broadcaster struct {
+	mu     sync.Mutex
+	epoch  string // Tells positions of this process from those of an earlier one
+	seq    uint64
+	subs   map[*Subscription]struct{}
+	replay []Entry // Ring of the newest entries, entry n is at (n-1) % replaySize
+}
+
+// Subscription receives the entries published after it was created
+type Subscription struct {
+	C     <-chan Entry
+	Start uint64 // Seq of the last entry published before the subscription, every log up to it is stored
+
+	ch          chan Entry
+	dropped     atomic.Uint64
+	broadcaster *Broadcaster
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		subs:   map[*Subscription]struct{}{},
+		replay: make([]Entry, 0, replaySize),
+	}
+}
+
+// Store runs store, which writes rows to the database, and publishes the rows when it
+// succeeds. Rows are only published once stored, so a log published before a subscription
+// started is in the database when the subscriber reads it.
+func (b *Broadcaster) Store(rows []map[string]interface{}, store func() error) error {
+	if err := store(); err != nil {
+		return err
+	}
+	b.publish(rows)
+	return nil
+}
+
+// publish hands the rows to every subscriber without waiting for any of them
+func (b *Broadcaster) publish(rows []map[string]interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, row := range rows {
+		b.seq++
+		entry := Entry{Seq: b.seq, Row: row}
+		if len(b.replay) < replaySize {
+			b.replay = append(b.replay, entry)
+		} else {
+			b.replay[(b.seq-1)%replaySize] = entry
+		}
+		for sub := range b.subs {
+			select {
+			case sub.ch <- entry:
+			default:
+				sub.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Subscribe registers a subscriber that buffers up to size entries. Logs the subscriber reads
+// from the database afterwards include everything published up to Start. Logs stored while
+// it reads can be in both, the subscriber drops those repeats.
+func (b *Broadcaster) Subscribe(size int) *Subscription {
+	ch := make(chan Entry, size)
+	sub := &Subscription{C: ch, ch: ch, broadcaster: b}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.Start = b.seq
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Replay returns the kept entries published after seq up to the subscription's Start, the ones
+// a viewer missed before it subscribed again. It returns false when some of them are no longer
+// kept.
+func (s *Subscription) Replay(seq uint64) ([]Entry, bool) {
+	b := s.broadcaster
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if seq > s.Start || b.seq-seq > uint64(len(b.replay)) {
+		return nil, false
+	}
+	entries := make([]Entry, 0, s.Start-seq)
+	for n := seq + 1; n <= s.Start; n++ {
+		entries = append(entries, b.replay[(n-1)%replaySize])
+	}
+	return entries, true
+}
+
+// Position returns seq as text a viewer can hand back after reconnecting
+func (b *Broadcaster) Position(seq uint64) string {
+	return b.epoch + ":" + strconv.FormatUint(seq, 10)
+}
+
+// ParsePosition reads a position returned by Position. Positions from before LogLite
+// restarted are rejected, their entries are gone.
+func (b *Broadcaster) ParsePosition(text string) (uint64, error) {
+	epoch, seqText, ok := strings.Cut(text, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid live tail position: %q", text)
+	}
+	if epoch != b.epoch {
+		return 0, fmt.Errorf("live tail position %q is from before a restart", text)
+	}
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid live tail position: %w", err)
+	}
+	return seq, nil
+}
+
+// Subscribers returns how many subscriptions are open
+func (b *Broadcaster) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Dropped returns how many entries were dropped because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription and closes C
+func (s *Subscription) Close() {
+	b := s.broadcaster
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,143 @@
+package livetail
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func storeLogs(t *testing.T, b *Broadcaster, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := b.Store([]map[string]interface{}{{"message": "log"}}, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func seqs(entries []Entry) []uint64 {
+	var out []uint64
+	for _, e := range entries {
+		out = append(out, e.Seq)
+	}
+	return out
+}
+
+func TestBroadcasterPublishes(t *testing.T) {
+	b := NewBroadcaster()
+	storeLogs(t, b, 2)
+	sub := b.Subscribe(2)
+	defer sub.Close()
+	if sub.Start != 2 {
+		t.Errorf("Start %d, want 2", sub.Start)
+	}
+
+	storeLogs(t, b, 3)
+	if got := seqs([]Entry{<-sub.C, <-sub.C}); got[0] != 3 || got[1] != 4 {
+		t.Errorf("received %v, want 3 and 4", got)
+	}
+	if sub.Dropped() != 1 {
+		t.Errorf("dropped %d, want 1 over the buffer", sub.Dropped())
+	}
+
+	// A failed store publishes nothing
+	failed := errors.New("disk full")
+	if err := b.Store([]map[string]interface{}{{"message": "lost"}}, func() error { return failed }); err != failed {
+		t.Errorf("Store returned %v", err)
+	}
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("C still open after Close")
+	}
+	if b.Subscribers() != 0 {
+		t.Errorf("%d subscribers after Close", b.Subscribers())
+	}
+}
+
+func TestBroadcasterReplay(t *testing.T) {
+	b := NewBroadcaster()
+	storeLogs(t, b, 5)
+	sub := b.Subscribe(10)
+	defer sub.Close()
+	storeLogs(t, b, 2) // Received on C, not replayed
+
+	tests := []struct {
+		since uint64
+		want  []uint64
+		ok    bool
+	}{
+		{0, []uint64{1, 2, 3, 4, 5}, true},
+		{3, []uint64{4, 5}, true},
+		{5, nil, true},
+		{6, nil, false},
+	}
+	for _, tt := range tests {
+		entries, ok := sub.Replay(tt.since)
+		got := seqs(entries)
+		if ok != tt.ok || len(got) != len(tt.want) || len(got) > 0 && (got[0] != tt.want[0] || got[len(got)-1] != tt.want[len(tt.want)-1]) {
+			t.Errorf("Replay(%d) = %v, %v, want %v, %v", tt.since, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// Entries that fell out of the kept ones leave a gap, the viewer has to start over
+func TestBroadcasterReplayGap(t *testing.T) {
+	b := NewBroadcaster()
+	storeLogs(t, b, replaySize+10)
+	sub := b.Subscribe(1)
+	defer sub.Close()
+
+	if _, ok := sub.Replay(5); ok {
+		t.Error("replayed entries that are no longer kept")
+	}
+	entries, ok := sub.Replay(10)
+	if !ok || len(entries) != replaySize || entries[0].Seq != 11 || entries[replaySize-1].Seq != replaySize+10 {
+		t.Errorf("Replay(10) = %d entries from %v, %v", len(entries), seqs(entries[:1]), ok)
+	}
+}
+
+// Subscribing does not wait for a store in progress, the subscriber receives its logs once stored
+func TestBroadcasterSubscribeDuringStore(t *testing.T) {
+	b := NewBroadcaster()
+	storing, release := make(chan struct{}), make(chan struct{})
+	stored := make(chan error)
+	go func() {
+		stored <- b.Store([]map[string]interface{}{{"message": "slow"}}, func() error {
+			close(storing)
+			<-release
+			return nil
+		})
+	}()
+	<-storing
+
+	subscribed := make(chan *Subscription)
+	go func() { subscribed <- b.Subscribe(1) }()
+	var sub *Subscription
+	select {
+	case sub = <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe waited for the store")
+	}
+	defer sub.Close()
+
+	close(release)
+	if err := <-stored; err != nil {
+		t.Fatal(err)
+	}
+	if entry := <-sub.C; entry.Seq != 1 || entry.Row["message"] != "slow" {
+		t.Errorf("received %+v", entry)
+	}
+}
+
+func TestBroadcasterPosition(t *testing.T) {
+	b := NewBroadcaster()
+	seq, err := b.ParsePosition(b.Position(42))
+	if err != nil || seq != 42 {
+		t.Errorf("round trip: %d, %v", seq, err)
+	}
+	for _, text := range []string{"", "42", b.Position(1) + "x", "earlier:42"} {
+		if _, err := b.ParsePosition(text); err == nil {
+			t.Errorf("ParsePosition(%q) succeeded", text)
+		}
+	}
+}
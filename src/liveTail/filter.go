@@ -0,0 +1,30 @@
+package livetail
+
+import (
+	"regexp"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+// Filter selects the logs a live tail viewer is shown
+type Filter struct {
+	Query   dbhandler.Query // Conditions and text terms, the rest of the query is not used
+	Pattern *regexp.Regexp  // Matched against the message when set
+}
+
+// Active reports whether the filter leaves out any logs
+func (f Filter) Active() bool {
+	return len(f.Query.Conditions) > 0 || len(f.Query.Text) > 0 || f.Pattern != nil
+}
+
+// Match reports whether a published log passes the filter
+func (f Filter) Match(row map[string]interface{}) bool {
+	if !dbhandler.Matches(dbhandler.Query{Conditions: f.Query.Conditions, Text: f.Query.Text}, row) {
+		return false
+	}
+	if f.Pattern != nil {
+		message, _ := row["message"].(string)
+		return f.Pattern.MatchString(message)
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package livetail
+
+import (
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+// PublishingHandler publishes every log stored through it to a Broadcaster
+type PublishingHandler struct {
+	inner       dbhandler.DBHandler
+	broadcaster *Broadcaster
+}
+
+// NewPublishingHandler publishes the logs stored in inner to broadcaster
+func NewPublishingHandler(inner dbhandler.DBHandler, broadcaster *Broadcaster) *PublishingHandler {
+	return &PublishingHandler{inner: inner, broadcaster: broadcaster}
+}
+
+// Unwrap returns the handler the logs are stored in
+func (h *PublishingHandler) Unwrap() dbhandler.DBHandler {
+	return h.inner
+}
+
+// published returns a copy of a row as subscribers see it. The timestamp is fixed here, so
+// the database stores the same one instead of its own default.
+func published(data map[string]interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(data)+1)
+	for col, val := range data {
+		row[col] = val
+	}
+	if row["timestamp"] == nil {
+		row["timestamp"] = time.Now().UTC()
+	}
+	return row
+}
+
+func (h *PublishingHandler) Put(table string, data map[string]interface{}) error {
+	if table != "logs" {
+		return h.inner.Put(table, data)
+	}
+	row := published(data)
+	return h.broadcaster.Store([]map[string]interface{}{row}, func() error {
+		return h.inner.Put(table, row)
+	})
+}
+
+func (h *PublishingHandler) PutBatch(table string, rows []map[string]interface{}) error {
+	if table != "logs" {
+		return dbhandler.PutBatch(h.inner, table, rows)
+	}
+	batch := make([]map[string]interface{}, len(rows))
+	for i, data := range rows {
+		batch[i] = published(data)
+	}
+	return h.broadcaster.Store(batch, func() error {
+		return dbhandler.PutBatch(h.inner, table, batch)
+	})
+}
+
+func (h *PublishingHandler) Get(table string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	return h.inner.Get(table, conditions)
+}
+
+func (h *PublishingHandler) Query(q dbhandler.Query) ([]map[string]interface{}, error) {
+	return h.inner.Query(q)
+}
+
+func (h *PublishingHandler) Close() error {
+	return h.inner.Close()
+}
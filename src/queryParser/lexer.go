@@ -0,0 +1,163 @@
+package queryparser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokPipe
+	tokComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of query"
+	case tokWord:
+		return "word"
+	case tokString:
+		return "quoted string"
+	case tokOp:
+		return "operator"
+	case tokPipe:
+		return "'|'"
+	case tokComma:
+		return "','"
+	}
+	return "unknown token"
+}
+
+type token struct {
+	kind tokenKind
+	text string // Unquoted text for strings
+	pos  int    // Byte offset of the first character in the input
+	end  int    // Byte offset just after the token
+}
+
+// SyntaxError describes a problem with a query and where in the input it is
+type SyntaxError struct {
+	Query string
+	Pos   int // Byte offset into Query
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Column(), e.Msg)
+}
+
+// Column is the 1-based character column of the error
+func (e *SyntaxError) Column() int {
+	if e.Pos > len(e.Query) {
+		return utf8.RuneCountInString(e.Query) + 1
+	}
+	return utf8.RuneCountInString(e.Query[:e.Pos]) + 1
+}
+
+// Caret returns the query with a marker line pointing at the error
+func (e *SyntaxError) Caret() string {
+	return e.Query + "\n" + strings.Repeat(" ", e.Column()-1) + "^"
+}
+
+func isOpChar(r rune) bool {
+	return r == ':' || r == '=' || r == '!' || r == '<' || r == '>'
+}
+
+// lex splits the input into tokens. Right after an operator it reads a value,
+// which may itself contain operator characters (e.g. "since:2024-01-02T10:00:00Z").
+func lex(input string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+	afterOp := false
+	afterValue := false
+
+	for i < len(input) {
+		r, size := utf8.DecodeRuneInString(input[i:])
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			afterOp = false
+			afterValue = false
+			continue
+
+		case r == '"':
+			start := i
+			var sb strings.Builder
+			i += size
+			closed := false
+			for i < len(input) {
+				c, csize := utf8.DecodeRuneInString(input[i:])
+				if c == '\\' && i+csize < len(input) {
+					next, nsize := utf8.DecodeRuneInString(input[i+csize:])
+					sb.WriteRune(next)
+					i += csize + nsize
+					continue
+				}
+				i += csize
+				if c == '"' {
+					closed = true
+					break
+				}
+				sb.WriteRune(c)
+			}
+			if !closed {
+				return nil, &SyntaxError{Query: input, Pos: start, Msg: "unterminated quoted string"}
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start, end: i})
+
+		case r == '|':
+			tokens = append(tokens, token{kind: tokPipe, text: "|", pos: i, end: i + size})
+			i += size
+
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i, end: i + size})
+			i += size
+
+		case isOpChar(r) && !afterOp:
+			start := i
+			op := string(r)
+			i += size
+			if i < len(input) && input[i] == '=' && r != ':' && r != '=' {
+				op += "="
+				i++
+			}
+			if op == "!" {
+				return nil, &SyntaxError{Query: input, Pos: start, Msg: "expected '!=' but found '!'"}
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start, end: i})
+			afterOp = true
+			continue
+
+		default:
+			start := i
+			for i < len(input) {
+				c, csize := utf8.DecodeRuneInString(input[i:])
+				if unicode.IsSpace(c) || c == '"' || c == '|' || c == ',' || (!afterOp && isOpChar(c)) {
+					break
+				}
+				i += csize
+			}
+			tokens = append(tokens, token{kind: tokWord, text: input[start:i], pos: start, end: i})
+		}
+
+		// A comma directly after a value continues the value list, anything else ends it
+		if tokens[len(tokens)-1].kind == tokComma {
+			afterOp = afterValue
+		} else {
+			afterValue = afterOp
+			afterOp = false
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, pos: len(input), end: len(input)})
+	return tokens, nil
+}
@@ -0,0 +1,263 @@
+package queryparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Value is a literal on the right hand side of a field term
+type Value struct {
+	Text   string
+	Quoted bool
+	Pos    int
+}
+
+// Term is a single filter in the query, either a field comparison or free text
+type Term struct {
+	Field    string // Empty for free text terms
+	FieldPos int
+	Op       string // ":", "=", "!=", ">", ">=", "<", "<="
+	Values   []Value
+	Negate   bool
+	Pos      int
+}
+
+// StageKind identifies a pipeline stage after a '|'
+type StageKind string
+
+const (
+	StageCount StageKind = "count"
+	StageSort  StageKind = "sort"
+	StageLimit StageKind = "limit"
+)
+
+// Stage is a single '|' step, e.g. "count by source" or "limit 20"
+type Stage struct {
+	Kind      StageKind
+	Fields    []Value // Group fields for count, sort field for sort
+	Ascending bool
+	N         int
+	Pos       int
+}
+
+// AST is the parsed form of a query before it is planned
+type AST struct {
+	Input  string
+	Terms  []Term
+	Stages []Stage
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	pos    int
+}
+
+// Parse turns the query text into an AST, reporting the position of the first syntax error
+func Parse(input string) (*AST, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens}
+	return p.parseQuery()
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Query: p.input, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(t token) string {
+	if t.kind == tokEOF || t.kind == tokPipe || t.kind == tokComma {
+		return t.kind.String()
+	}
+	return fmt.Sprintf("%s %q", t.kind, t.text)
+}
+
+func (p *parser) parseQuery() (*AST, error) {
+	ast := &AST{Input: p.input}
+
+	for {
+		t := p.peek()
+		if t.kind == tokEOF || t.kind == tokPipe {
+			break
+		}
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		ast.Terms = append(ast.Terms, term)
+	}
+
+	for p.peek().kind == tokPipe {
+		p.next()
+		stage, err := p.parseStage()
+		if err != nil {
+			return nil, err
+		}
+		ast.Stages = append(ast.Stages, stage)
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t.pos, "unexpected %s", describe(t))
+	}
+	return ast, nil
+}
+
+func (p *parser) parseTerm() (Term, error) {
+	t := p.next()
+	term := Term{Pos: t.pos}
+
+	switch t.kind {
+	case tokString:
+		term.Values = []Value{{Text: t.text, Quoted: true, Pos: t.pos}}
+		return term, nil
+
+	case tokWord:
+		text := t.text
+		textPos := t.pos
+		if strings.HasPrefix(text, "-") {
+			term.Negate = true
+			text = text[1:]
+			textPos++
+			// A lone "-" negates a directly following quoted string
+			if text == "" {
+				next := p.peek()
+				if next.kind != tokString || next.pos != t.end {
+					return term, p.errorf(t.pos, "expected a field or quoted text after '-'")
+				}
+				p.next()
+				term.Values = []Value{{Text: next.text, Quoted: true, Pos: next.pos}}
+				return term, nil
+			}
+		}
+
+		if p.peek().kind != tokOp {
+			term.Values = []Value{{Text: text, Pos: textPos}}
+			return term, nil
+		}
+
+		op := p.next()
+		term.Field = text
+		term.FieldPos = textPos
+		term.Op = op.text
+		values, err := p.parseValues(op)
+		if err != nil {
+			return term, err
+		}
+		term.Values = values
+		return term, nil
+
+	case tokOp:
+		return term, p.errorf(t.pos, "missing field name before %q", t.text)
+	case tokComma:
+		return term, p.errorf(t.pos, "unexpected ',' outside of a value list")
+	}
+	return term, p.errorf(t.pos, "unexpected %s", describe(t))
+}
+
+func (p *parser) parseValues(op token) ([]Value, error) {
+	values := []Value{}
+	for {
+		t := p.peek()
+		if t.kind != tokWord && t.kind != tokString {
+			if len(values) == 0 {
+				return nil, p.errorf(t.pos, "expected a value after %q but found %s", op.text, describe(t))
+			}
+			return nil, p.errorf(t.pos, "expected a value after ',' but found %s", describe(t))
+		}
+		p.next()
+		values = append(values, Value{Text: t.text, Quoted: t.kind == tokString, Pos: t.pos})
+
+		if p.peek().kind != tokComma {
+			return values, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseStage() (Stage, error) {
+	t := p.next()
+	if t.kind != tokWord {
+		return Stage{}, p.errorf(t.pos, "expected a stage (count, sort or limit) after '|' but found %s", describe(t))
+	}
+	stage := Stage{Pos: t.pos}
+
+	switch strings.ToLower(t.text) {
+	case "count":
+		stage.Kind = StageCount
+		if next := p.peek(); next.kind == tokWord && strings.ToLower(next.text) == "by" {
+			p.next()
+			fields, err := p.parseFieldList()
+			if err != nil {
+				return stage, err
+			}
+			stage.Fields = fields
+		}
+
+	case "sort":
+		stage.Kind = StageSort
+		field := p.next()
+		if field.kind != tokWord {
+			return stage, p.errorf(field.pos, "expected a field to sort by but found %s", describe(field))
+		}
+		stage.Fields = []Value{{Text: field.text, Pos: field.pos}}
+		if dir := p.peek(); dir.kind == tokWord {
+			switch strings.ToLower(dir.text) {
+			case "asc":
+				stage.Ascending = true
+				p.next()
+			case "desc":
+				p.next()
+			}
+		}
+
+	case "limit", "head":
+		stage.Kind = StageLimit
+		n := p.next()
+		if n.kind != tokWord {
+			return stage, p.errorf(n.pos, "expected a number after %q but found %s", t.text, describe(n))
+		}
+		limit, err := strconv.Atoi(n.text)
+		if err != nil || limit <= 0 {
+			return stage, p.errorf(n.pos, "limit must be a positive number, got %q", n.text)
+		}
+		stage.N = limit
+
+	default:
+		return stage, p.errorf(t.pos, "unknown stage %q (expected count, sort or limit)", t.text)
+	}
+
+	if next := p.peek(); next.kind != tokEOF && next.kind != tokPipe {
+		return stage, p.errorf(next.pos, "unexpected %s after %s stage", describe(next), stage.Kind)
+	}
+	return stage, nil
+}
+
+func (p *parser) parseFieldList() ([]Value, error) {
+	fields := []Value{}
+	for {
+		t := p.next()
+		if t.kind != tokWord {
+			return nil, p.errorf(t.pos, "expected a field name but found %s", describe(t))
+		}
+		fields = append(fields, Value{Text: t.text, Pos: t.pos})
+		if p.peek().kind != tokComma {
+			return fields, nil
+		}
+		p.next()
+	}
+}
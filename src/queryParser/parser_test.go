@@ -0,0 +1,80 @@
+package queryparser
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	ast, err := Parse(`level:ERROR,WARN -source:api "timed out" -"retry" | count by source, level | sort count asc | head 5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTerms := []Term{
+		{Field: "level", FieldPos: 0, Op: ":", Values: []Value{{Text: "ERROR", Pos: 6}, {Text: "WARN", Pos: 12}}, Pos: 0},
+		{Field: "source", FieldPos: 18, Op: ":", Values: []Value{{Text: "api", Pos: 25}}, Negate: true, Pos: 17},
+		{Values: []Value{{Text: "timed out", Quoted: true, Pos: 29}}, Pos: 29},
+		{Values: []Value{{Text: "retry", Quoted: true, Pos: 42}}, Negate: true, Pos: 41},
+	}
+	if !reflect.DeepEqual(ast.Terms, wantTerms) {
+		t.Errorf("terms\ngot  %+v\nwant %+v", ast.Terms, wantTerms)
+	}
+
+	wantStages := []Stage{
+		{Kind: StageCount, Fields: []Value{{Text: "source", Pos: 61}, {Text: "level", Pos: 69}}, Pos: 52},
+		{Kind: StageSort, Fields: []Value{{Text: "count", Pos: 82}}, Ascending: true, Pos: 77},
+		{Kind: StageLimit, N: 5, Pos: 94},
+	}
+	if !reflect.DeepEqual(ast.Stages, wantStages) {
+		t.Errorf("stages\ngot  %+v\nwant %+v", ast.Stages, wantStages)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		column int
+		msg    string
+	}{
+		{`level:`, 7, `expected a value after ":" but found end of query`},
+		{`level:ERROR,`, 13, `expected a value after ',' but found end of query`},
+		{`:x`, 1, `missing field name before ":"`},
+		{`a , b`, 3, `unexpected ',' outside of a value list`},
+		{`- "x"`, 1, `expected a field or quoted text after '-'`},
+		{`"open`, 1, `unterminated quoted string`},
+		{`level:ERROR | `, 15, `expected a stage (count, sort or limit) after '|' but found end of query`},
+		{`a | bogus`, 5, `unknown stage "bogus" (expected count, sort or limit)`},
+		{`é | bogus`, 5, `unknown stage "bogus" (expected count, sort or limit)`},
+		{`a | sort`, 9, `expected a field to sort by but found end of query`},
+		{`a | count by`, 13, `expected a field name but found end of query`},
+		{`a | count by source x`, 21, `unexpected word "x" after count stage`},
+		{`a | limit x`, 11, `limit must be a positive number, got "x"`},
+		{`a | limit 0`, 11, `limit must be a positive number, got "0"`},
+		{`a | limit 5 x`, 13, `unexpected word "x" after limit stage`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q): got %v, want a syntax error", tt.query, err)
+			continue
+		}
+		if syntaxErr.Column() != tt.column || syntaxErr.Msg != tt.msg {
+			t.Errorf("Parse(%q): got column %d %q, want column %d %q", tt.query, syntaxErr.Column(), syntaxErr.Msg, tt.column, tt.msg)
+		}
+	}
+}
+
+func TestSyntaxErrorCaret(t *testing.T) {
+	_, err := Parse(`level:ERROR | limit -1`)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("got %v", err)
+	}
+	want := "level:ERROR | limit -1\n" + "                    ^"
+	if got := syntaxErr.Caret(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
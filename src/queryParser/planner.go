@@ -0,0 +1,263 @@
+package queryparser
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+// DefaultLimit is used for row queries that do not set a limit
+const DefaultLimit = 100
+
+// MaxLimit caps how many rows a single query may return
+const MaxLimit = 10000
+
+// fieldAliases lets users type the short names they are used to
+var fieldAliases = map[string]string{
+	"msg":  "message",
+	"lvl":  "level",
+	"time": "timestamp",
+	"ts":   "timestamp",
+	"meta": "metadata",
+}
+
+// numericFields compare as numbers rather than text
+var numericFields = map[string]bool{"id": true, "length": true}
+
+// Compile parses and plans a query in one step
+func Compile(input string, now time.Time) (dbhandler.Query, error) {
+	ast, err := Parse(input)
+	if err != nil {
+		return dbhandler.Query{}, err
+	}
+	return Plan(ast, now)
+}
+
+// Plan turns an AST into the DB layer's query model. Relative times are resolved against now.
+func Plan(ast *AST, now time.Time) (dbhandler.Query, error) {
+	q := dbhandler.Query{Table: "logs", OrderBy: "timestamp"}
+	pl := &planner{input: ast.Input, now: now}
+
+	for _, term := range ast.Terms {
+		if err := pl.planTerm(&q, term); err != nil {
+			return dbhandler.Query{}, err
+		}
+	}
+
+	for _, stage := range ast.Stages {
+		if err := pl.planStage(&q, stage); err != nil {
+			return dbhandler.Query{}, err
+		}
+	}
+
+	if !q.Count && q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Count && q.OrderBy != "count" && !slices.Contains(q.GroupBy, q.OrderBy) {
+		// Counts are only sorted when asked to, and only by what they return
+		if pl.sort != nil {
+			return dbhandler.Query{}, pl.errorf(pl.sort.Pos, "a count can only be sorted by count or a field it counts by, not "+q.OrderBy)
+		}
+		q.OrderBy = ""
+	}
+	return q, nil
+}
+
+type planner struct {
+	input string
+	now   time.Time
+	sort  *Value // Field of the sort stage, nil without one
+}
+
+func (pl *planner) errorf(pos int, msg string) error {
+	return &SyntaxError{Query: pl.input, Pos: pos, Msg: msg}
+}
+
+// NormalizeField resolves aliases such as "lvl" or "meta.user_id" and checks that the field exists
+func NormalizeField(name string) (string, error) {
+	field := strings.ToLower(name)
+	if alias, ok := fieldAliases[field]; ok {
+		field = alias
+	}
+	// Metadata paths keep the user's casing since JSON keys are case sensitive
+	if strings.HasPrefix(field, "meta.") || strings.HasPrefix(field, dbhandler.MetadataPrefix) {
+		field = dbhandler.MetadataPrefix + name[strings.Index(name, ".")+1:]
+	}
+	return field, dbhandler.ValidateField(field)
+}
+
+// field normalises a field name, reporting unknown fields at their position
+func (pl *planner) field(name string, pos int) (string, error) {
+	field, err := NormalizeField(name)
+	if err != nil {
+		return "", pl.errorf(pos, err.Error())
+	}
+	return field, nil
+}
+
+func (pl *planner) planTerm(q *dbhandler.Query, term Term) error {
+	// Free text searches the message
+	if term.Field == "" {
+		text := term.Values[0].Text
+		if term.Negate {
+			q.Conditions = append(q.Conditions, dbhandler.Condition{Field: "message", Op: dbhandler.OpContains, Value: text, Negate: true})
+		} else {
+			q.Text = append(q.Text, text)
+		}
+		return nil
+	}
+
+	switch strings.ToLower(term.Field) {
+	case "since", "until":
+		return pl.planTimeBound(q, term)
+	}
+
+	field, err := pl.field(term.Field, term.FieldPos)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(term.Values))
+	for i, v := range term.Values {
+		values[i] = typedValue(field, v)
+	}
+
+	cond := dbhandler.Condition{Field: field, Negate: term.Negate}
+	switch term.Op {
+	case ":", "=", "!=":
+		if term.Op == "!=" {
+			cond.Negate = !cond.Negate
+		}
+		switch {
+		case len(values) > 1:
+			cond.Op = dbhandler.OpIn
+			cond.Value = values
+		case term.Op == ":" && field == "message":
+			cond.Op = dbhandler.OpContains
+			cond.Value = values[0]
+		default:
+			cond.Op = dbhandler.OpEq
+			cond.Value = values[0]
+		}
+	case ">", ">=", "<", "<=":
+		if len(values) > 1 {
+			return pl.errorf(term.Values[1].Pos, "operator "+term.Op+" takes a single value")
+		}
+		cond.Op = dbhandler.Operator(term.Op)
+		cond.Value = values[0]
+	default:
+		return pl.errorf(term.Pos, "unsupported operator "+term.Op)
+	}
+
+	q.Conditions = append(q.Conditions, cond)
+	return nil
+}
+
+// typedValue converts unquoted numbers to numbers where the field compares numerically
+func typedValue(field string, v Value) interface{} {
+	if field == "level" {
+		return strings.ToUpper(v.Text)
+	}
+	if v.Quoted || !(numericFields[field] || dbhandler.IsMetadataField(field)) {
+		return v.Text
+	}
+	if i, err := strconv.ParseInt(v.Text, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(v.Text, 64); err == nil {
+		return f
+	}
+	switch v.Text {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return v.Text
+}
+
+func (pl *planner) planTimeBound(q *dbhandler.Query, term Term) error {
+	name := strings.ToLower(term.Field)
+	if term.Negate {
+		return pl.errorf(term.Pos, name+" cannot be negated")
+	}
+	if term.Op != ":" && term.Op != "=" {
+		return pl.errorf(term.Pos, name+" only supports ':'")
+	}
+	if len(term.Values) != 1 {
+		return pl.errorf(term.Values[1].Pos, name+" takes a single value")
+	}
+
+	t, err := ParseTime(term.Values[0].Text, pl.now)
+	if err != nil {
+		return pl.errorf(term.Values[0].Pos, err.Error())
+	}
+	if name == "since" {
+		q.Since = t
+	} else {
+		q.Until = t
+	}
+	return nil
+}
+
+func (pl *planner) planStage(q *dbhandler.Query, stage Stage) error {
+	switch stage.Kind {
+	case StageCount:
+		q.Count = true
+		q.GroupBy = nil
+		for _, f := range stage.Fields {
+			field, err := pl.field(f.Text, f.Pos)
+			if err != nil {
+				return err
+			}
+			q.GroupBy = append(q.GroupBy, field)
+		}
+	case StageSort:
+		f := stage.Fields[0]
+		pl.sort = &f
+		if q.Count && strings.ToLower(f.Text) == "count" {
+			q.OrderBy = "count"
+		} else {
+			field, err := pl.field(f.Text, f.Pos)
+			if err != nil {
+				return err
+			}
+			q.OrderBy = field
+		}
+		q.Ascending = stage.Ascending
+	case StageLimit:
+		if stage.N > MaxLimit {
+			return pl.errorf(stage.Pos, "limit may not exceed "+strconv.Itoa(MaxLimit))
+		}
+		q.Limit = stage.N
+	}
+	return nil
+}
+
+// ParseTime understands relative durations such as "15m", "2h" or "7d" (meaning that long ago)
+// as well as absolute RFC3339 timestamps and plain dates
+func ParseTime(text string, now time.Time) (time.Time, error) {
+	if d, err := confighandler.ParseDuration(text); err == nil {
+		return now.Add(-d), nil
+	}
+	// A layout without a fraction still reads one, so this covers stored timestamps too
+	layouts := []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, &timeError{text}
+}
+
+type timeError struct {
+	text string
+}
+
+func (e *timeError) Error() string {
+	return "invalid time " + strconv.Quote(e.text) + " (use a duration like 15m, 2h, 7d or a date like 2024-01-02)"
+}
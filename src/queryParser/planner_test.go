@@ -0,0 +1,136 @@
+package queryparser
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+var planNow = time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		query string
+		want  dbhandler.Query
+	}{
+		{``, dbhandler.Query{OrderBy: "timestamp", Limit: DefaultLimit}},
+		{`lvl:error,warn msg:timeout -source:api`, dbhandler.Query{Conditions: []dbhandler.Condition{
+			{Field: "level", Op: dbhandler.OpIn, Value: []interface{}{"ERROR", "WARN"}},
+			{Field: "message", Op: dbhandler.OpContains, Value: "timeout"},
+			{Field: "source", Op: dbhandler.OpEq, Value: "api", Negate: true},
+		}, OrderBy: "timestamp", Limit: DefaultLimit}},
+		{`source!=api length>=500 meta.User.id=42`, dbhandler.Query{Conditions: []dbhandler.Condition{
+			{Field: "source", Op: dbhandler.OpEq, Value: "api", Negate: true},
+			{Field: "length", Op: dbhandler.OpGte, Value: int64(500)},
+			{Field: "metadata.User.id", Op: dbhandler.OpEq, Value: int64(42)},
+		}, OrderBy: "timestamp", Limit: DefaultLimit}},
+		{`"timed out" -retry since:2h until:2025-01-31`, dbhandler.Query{
+			Text:       []string{"timed out"},
+			Conditions: []dbhandler.Condition{{Field: "message", Op: dbhandler.OpContains, Value: "retry", Negate: true}},
+			Since:      planNow.Add(-2 * time.Hour),
+			Until:      time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+			OrderBy:    "timestamp", Limit: DefaultLimit,
+		}},
+		{`| sort level asc | limit 5`, dbhandler.Query{OrderBy: "level", Ascending: true, Limit: 5}},
+		// Counts return every group and are not sorted unless asked to
+		{`| count by source, lvl`, dbhandler.Query{Count: true, GroupBy: []string{"source", "level"}}},
+		{`| count by source | sort count asc | limit 3`, dbhandler.Query{Count: true, GroupBy: []string{"source"}, OrderBy: "count", Ascending: true, Limit: 3}},
+		{`| count by source | sort source`, dbhandler.Query{Count: true, GroupBy: []string{"source"}, OrderBy: "source"}},
+		// Quoted text with a colon is text, not a field
+		{`"http://api/orders"`, dbhandler.Query{Text: []string{"http://api/orders"}, OrderBy: "timestamp", Limit: DefaultLimit}},
+	}
+	for _, tt := range tests {
+		tt.want.Table = "logs"
+		q, err := Compile(tt.query, planNow)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(q, tt.want) {
+			t.Errorf("Compile(%q)\ngot  %+v\nwant %+v", tt.query, q, tt.want)
+		}
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		column int
+		msg    string
+	}{
+		{`colour:red`, 1, `unknown field: colour`},
+		// A word with a colon reads as a field, which is why URLs need quotes
+		{`http://api/orders`, 1, `unknown field: http`},
+		{`length>1,2`, 10, `operator > takes a single value`},
+		{`-since:1h`, 1, `since cannot be negated`},
+		{`since>1h`, 1, `since only supports ':'`},
+		{`until:1h,2h`, 10, `until takes a single value`},
+		{`since:yesterday`, 7, `invalid time "yesterday" (use a duration like 15m, 2h, 7d or a date like 2024-01-02)`},
+		{`| limit 10001`, 3, `limit may not exceed 10000`},
+		{`| count by colour`, 12, `unknown field: colour`},
+		{`| count | sort timestamp`, 16, `a count can only be sorted by count or a field it counts by, not timestamp`},
+		{`| count by source | sort level`, 26, `a count can only be sorted by count or a field it counts by, not level`},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.query, planNow)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Compile(%q): got %v, want a syntax error", tt.query, err)
+			continue
+		}
+		if syntaxErr.Column() != tt.column || syntaxErr.Msg != tt.msg {
+			t.Errorf("Compile(%q): got column %d %q, want column %d %q", tt.query, syntaxErr.Column(), syntaxErr.Msg, tt.column, tt.msg)
+		}
+	}
+}
+
+func TestTypedValue(t *testing.T) {
+	tests := []struct {
+		field string
+		value Value
+		want  interface{}
+	}{
+		{"level", Value{Text: "warn"}, "WARN"},
+		{"source", Value{Text: "42"}, "42"},
+		{"length", Value{Text: "42"}, int64(42)},
+		{"id", Value{Text: "42", Quoted: true}, "42"},
+		{"metadata.ms", Value{Text: "1.5"}, 1.5},
+		{"metadata.ok", Value{Text: "true"}, true},
+		{"metadata.ok", Value{Text: "false"}, false},
+		{"metadata.ok", Value{Text: "yes"}, "yes"},
+		{"metadata.code", Value{Text: "007", Quoted: true}, "007"},
+	}
+	for _, tt := range tests {
+		if got := typedValue(tt.field, tt.value); got != tt.want {
+			t.Errorf("typedValue(%s, %+v) = %#v, want %#v", tt.field, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Time
+	}{
+		{"15m", planNow.Add(-15 * time.Minute)},
+		{"7d", planNow.Add(-7 * 24 * time.Hour)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-02 03:04:05", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2024-01-02T03:04:05.25", time.Date(2024, 1, 2, 3, 4, 5, 250_000_000, time.UTC)},
+		{"2024-01-02T03:04:05+02:00", time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.text, planNow)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %s, %v, want %s", tt.text, got, err, tt.want)
+		}
+	}
+	for _, text := range []string{"", "soon", "15 minutes", "2024-13-01"} {
+		if _, err := ParseTime(text, planNow); err == nil {
+			t.Errorf("ParseTime(%q) gave no error", text)
+		}
+	}
+}
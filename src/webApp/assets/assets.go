@@ -0,0 +1,105 @@
+// Package assets bundles the images, scripts and stylesheets of the web UI into the binary, so
+// LogLite serves them from any working directory and without internet access.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Prefix is the URL path the assets are served under
+const Prefix = "/asset/"
+
+// Vendored are the third-party files every page loads, there is no CDN to fall back on
+var Vendored = []string{"vendor/htmx.min.js", "vendor/ws.js", "vendor/app.css"}
+
+//go:generate sh ../../../scripts/build-assets.sh
+
+// files are the images and, in vendor, the third-party files scripts/build-assets.sh fetches and builds
+//
+//go:embed *.png vendor
+var files embed.FS
+
+// asset is an embedded file and the name it is served under with its content hash
+type asset struct {
+	name   string
+	hashed string
+	hash   string
+	data   []byte
+}
+
+var (
+	byName   = map[string]*asset{}
+	byHashed = map[string]*asset{}
+)
+
+func init() {
+	err := fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := files.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		a := &asset{name: name, hash: hex.EncodeToString(sum[:5]), data: data}
+		ext := path.Ext(name)
+		a.hashed = strings.TrimSuffix(name, ext) + "." + a.hash + ext
+		byName[a.name] = a
+		byHashed[a.hashed] = a
+		return nil
+	})
+	if err != nil {
+		panic("assets: " + err.Error())
+	}
+}
+
+// Has reports whether a file is bundled, e.g. "vendor/htmx.min.js"
+func Has(name string) bool {
+	return byName[name] != nil
+}
+
+// Missing lists the vendored files that are not bundled, the web UI does not work without them
+func Missing() []string {
+	var missing []string
+	for _, name := range Vendored {
+		if !Has(name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Path is the URL of a bundled file with its content hash, so browsers can cache it forever
+// and still load the new file after an upgrade. Unknown files get their plain URL.
+func Path(name string) string {
+	if a := byName[name]; a != nil {
+		return Prefix + a.hashed
+	}
+	return Prefix + name
+}
+
+// Serve serves the bundled files. Hashed URLs never change and are cached for a year, plain
+// URLs are revalidated with their ETag.
+func Serve(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+	a := byHashed[name]
+	if a != nil {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else if a = byName[name]; a != nil {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", `"`+a.hash+`"`)
+	http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(a.data))
+}
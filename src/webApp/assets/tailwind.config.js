@@ -0,0 +1,9 @@
+// Builds vendor/app.css from the classes used in the templates and the Go code rendering them,
+// see scripts/build-assets.sh
+module.exports = {
+  content: ["./src/webApp/**/*.templ", "./src/webApp/**/*.go"],
+  plugins: [require("daisyui")],
+  daisyui: {
+    themes: true,
+  },
+};
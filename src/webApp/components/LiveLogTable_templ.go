@@ -29,7 +29,7 @@ func LiveLogTable() templ.Component {
 			templ_7745c5c3_Var1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"overflow-x-auto px-10\"><h2 class=\"text-2xl font-bold mb-4\">Live Logs</h2><div class=\"h-full rounded-md border border-solid flex flex-col p-2\"><table class=\"table table-xs\" id=\"live-logtable\" hx-ext=\"ws\" ws-connect=\"/livelogs\"><thead class=\"sticky top-0\"><tr><th>Timestamp</th><th>Level</th><th>Message</th><th>Source</th><th>Method</th><th>Address</th><th>Length</th><th>Metadata</th></tr></thead></table><div class=\"overflow-y-scroll h-[30dvh]\"><table class=\"w-full table-fixed\"><tbody id=\"live-log-rows\"><!-- Rows will be dynamically loaded here --></tbody></table></div></div></div>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"overflow-x-auto px-10\" hx-ext=\"ws\" ws-connect=\"/livelogs\"><div class=\"flex items-baseline gap-4 mb-4\"><h2 class=\"text-2xl font-bold\">Live Logs</h2><span id=\"live-log-controls\"></span> <span id=\"live-log-dropped\"></span></div><!-- Sent over the socket whenever it changes and when the socket (re)connects, the server only pushes matching logs.\n         After a reconnect, since makes the server send only the logs missed in between --><form class=\"flex flex-wrap items-center gap-2 mb-2\" ws-send hx-trigger=\"submit, change, htmx:wsOpen from:body\"><input type=\"text\" name=\"level\" class=\"input input-bordered input-sm w-36\" placeholder=\"Levels, e.g. ERROR,WARN\"> <input type=\"text\" name=\"source\" class=\"input input-bordered input-sm w-40\" placeholder=\"Source\"> <input type=\"text\" name=\"label\" class=\"input input-bordered input-sm w-32\" placeholder=\"Label\"> <input type=\"text\" name=\"text\" class=\"input input-bordered input-sm flex-grow\" placeholder=\"Message contains\"> <label class=\"label cursor-pointer gap-1\"><input type=\"checkbox\" name=\"regex\" class=\"checkbox checkbox-sm\"> <span class=\"label-text\">Regex</span></label> <input type=\"text\" name=\"metadata\" class=\"input input-bordered input-sm w-48 font-mono\" placeholder=\"metadata.user_id=42\"> <input type=\"hidden\" id=\"live-log-since\" name=\"since\"> <button class=\"btn btn-primary btn-sm\">Filter</button></form><span id=\"live-log-filter-error\"></span><div class=\"h-full rounded-md border border-solid flex flex-col p-2\"><table class=\"table table-xs\" id=\"live-logtable\"><thead class=\"sticky top-0\"><tr><th>Timestamp</th><th>Level</th><th>Message</th><th>Source</th><th>Method</th><th>Address</th><th>Length</th><th>Metadata</th></tr></thead></table><div class=\"overflow-y-scroll h-[30dvh]\"><table class=\"w-full table-fixed\"><tbody id=\"live-log-rows\"><!-- Rows will be dynamically loaded here --></tbody></table></div></div></div>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	queryparser "github.com/lauritsbonde/LogLite/src/queryParser"
+)
+
+const (
+	// DefaultRange is the time range aggregated when the query has no since:
+	DefaultRange = 24 * time.Hour
+	// DefaultBuckets is how many buckets an automatically sized bucket splits the range into
+	DefaultBuckets = 60
+	// maxBuckets caps the buckets of one request, so a tiny bucket over a long range is refused
+	maxBuckets = 10000
+	// defaultSeries is how many groups are returned when the request does not set a limit
+	defaultSeries = 20
+)
+
+// Series is the counts and aggregates of one group, one value per bucket
+type Series struct {
+	Group      map[string]interface{}   `json:"group"`
+	Total      int64                    `json:"total"`
+	Counts     []int64                  `json:"counts"`
+	Aggregates map[string][]interface{} `json:"aggregates,omitempty"` // nil where a bucket had no numeric values
+}
+
+// TimeSeries is the result of an aggregation, every bucket of the range including empty ones
+type TimeSeries struct {
+	Since         time.Time   `json:"since"`
+	Until         time.Time   `json:"until"`
+	Bucket        string      `json:"bucket"`
+	BucketSeconds int64       `json:"bucket_seconds"`
+	GroupBy       []string    `json:"group_by"`
+	Aggregates    []string    `json:"aggregates"`
+	Times         []time.Time `json:"times"`   // Start of every bucket
+	Series        []Series    `json:"series"`  // Largest total first
+	Omitted       int         `json:"omitted"` // Groups left out by the limit
+}
+
+// ParseAggregateRequest builds the count query for an aggregation from its URL parameters:
+// q filters like the search bar, by lists the fields to group by, agg the aggregates such as
+// avg(length), and bucket is a duration or "auto" to split the range into buckets buckets.
+// It also returns how many series to keep.
+func ParseAggregateRequest(params url.Values, now time.Time) (dbhandler.Query, int, error) {
+	q, err := queryparser.Compile(params.Get("q"), now)
+	if err != nil {
+		return q, 0, err
+	}
+	q.Count = true
+	q.Limit, q.Offset, q.OrderBy, q.Ascending = 0, 0, "", false
+	if q.Since.IsZero() {
+		q.Since = now.Add(-DefaultRange)
+	}
+	if q.Until.IsZero() {
+		q.Until = now
+	}
+	q.Since, q.Until = q.Since.UTC(), q.Until.UTC()
+	if !q.Since.Before(q.Until) {
+		return q, 0, fmt.Errorf("since must be before until")
+	}
+
+	if by := params.Get("by"); by != "" {
+		q.GroupBy = nil
+		for _, name := range strings.Split(by, ",") {
+			field, err := queryparser.NormalizeField(strings.TrimSpace(name))
+			if err != nil {
+				return q, 0, err
+			}
+			q.GroupBy = append(q.GroupBy, field)
+		}
+	}
+
+	for _, text := range params["agg"] {
+		for _, part := range splitAggregates(text) {
+			a, err := dbhandler.ParseAggregate(part)
+			if err != nil {
+				return q, 0, err
+			}
+			if field, err := queryparser.NormalizeField(a.Field); err == nil {
+				a.Field = field
+			}
+			q.Aggregates = append(q.Aggregates, a)
+		}
+	}
+
+	buckets, err := intParam(params, "buckets", DefaultBuckets)
+	if err != nil {
+		return q, 0, err
+	}
+	switch bucket := params.Get("bucket"); bucket {
+	case "", "auto":
+		q.Bucket = dbhandler.BucketFor(q.Until.Sub(q.Since), buckets)
+	default:
+		if q.Bucket, err = confighandler.ParseDuration(bucket); err != nil || q.Bucket < time.Second || q.Bucket%time.Second != 0 {
+			return q, 0, fmt.Errorf("invalid bucket %q (use a whole number of seconds like 30s, 5m or 1h, or auto)", bucket)
+		}
+	}
+	if n := q.Until.Sub(dbhandler.BucketStart(q.Since, q.Bucket)) / q.Bucket; n >= maxBuckets {
+		return q, 0, fmt.Errorf("%s buckets over this range would be more than %d, use a larger bucket", q.Bucket, maxBuckets)
+	}
+
+	limit, err := intParam(params, "limit", defaultSeries)
+	if err != nil {
+		return q, 0, err
+	}
+	return q, limit, q.Validate()
+}
+
+// splitAggregates splits "avg(length),max(metadata.ms)" at the commas between aggregates
+func splitAggregates(text string) []string {
+	parts := []string{}
+	for _, part := range strings.SplitAfter(text, ")") {
+		if part = strings.Trim(part, ", "); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// intParam reads a positive number parameter
+func intParam(params url.Values, name string, fallback int) (int, error) {
+	text := params.Get(name)
+	if text == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil || n <= 0 || n > maxBuckets {
+		return 0, fmt.Errorf("%s must be a number between 1 and %d", name, maxBuckets)
+	}
+	return n, nil
+}
+
+// BuildTimeSeries lays the rows of a bucketed count query out as one series per group, with
+// a value for every bucket of the query's range. Only the limit largest series are kept.
+func BuildTimeSeries(q dbhandler.Query, rows []map[string]interface{}, limit int) TimeSeries {
+	ts := TimeSeries{
+		Since:         q.Since,
+		Until:         q.Until,
+		Bucket:        q.Bucket.String(),
+		BucketSeconds: int64(q.Bucket / time.Second),
+		GroupBy:       append([]string{}, q.GroupBy...),
+		Aggregates:    []string{},
+		Times:         []time.Time{},
+		Series:        []Series{},
+	}
+	for _, a := range q.Aggregates {
+		ts.Aggregates = append(ts.Aggregates, a.Name())
+	}
+
+	index := map[int64]int{}
+	for t := dbhandler.BucketStart(q.Since, q.Bucket); t.Before(q.Until); t = t.Add(q.Bucket) {
+		index[t.Unix()] = len(ts.Times)
+		ts.Times = append(ts.Times, t)
+	}
+
+	series := map[string]*Series{}
+	keys := []string{}
+	for _, row := range rows {
+		bucket, ok := row[dbhandler.BucketColumn].(time.Time)
+		if !ok {
+			continue
+		}
+		i, ok := index[bucket.Unix()]
+		if !ok {
+			continue
+		}
+
+		keyParts := make([]string, len(q.GroupBy))
+		for j, field := range q.GroupBy {
+			keyParts[j] = fmt.Sprintf("%T:%v", row[field], row[field])
+		}
+		key := strings.Join(keyParts, "\x00")
+		s, ok := series[key]
+		if !ok {
+			s = &Series{Group: map[string]interface{}{}, Counts: make([]int64, len(ts.Times))}
+			for _, field := range q.GroupBy {
+				s.Group[field] = row[field]
+			}
+			if len(q.Aggregates) > 0 {
+				s.Aggregates = map[string][]interface{}{}
+				for _, name := range ts.Aggregates {
+					s.Aggregates[name] = make([]interface{}, len(ts.Times))
+				}
+			}
+			series[key] = s
+			keys = append(keys, key)
+		}
+
+		count, _ := row["count"].(int64)
+		s.Counts[i] += count
+		s.Total += count
+		for _, name := range ts.Aggregates {
+			s.Aggregates[name][i] = row[name]
+		}
+	}
+
+	sort.SliceStable(keys, func(a, b int) bool {
+		if series[keys[a]].Total != series[keys[b]].Total {
+			return series[keys[a]].Total > series[keys[b]].Total
+		}
+		return keys[a] < keys[b]
+	})
+	for i, key := range keys {
+		if limit > 0 && i == limit {
+			ts.Omitted = len(keys) - limit
+			break
+		}
+		ts.Series = append(ts.Series, *series[key])
+	}
+	return ts
+}
+
+// AggregateAPI serves GET /api/aggregate as JSON, see ParseAggregateRequest for the parameters
+func AggregateAPI(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q, limit, err := ParseAggregateRequest(r.URL.Query(), time.Now())
+	if err != nil {
+		writeQueryError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(q)
+	if err != nil {
+		writeQueryError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(BuildTimeSeries(q, rows, limit)); err != nil {
+		log.Printf("Error writing aggregate response: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+// DownloadBackup serves GET /backup/download, a fresh snapshot of the database as a file
+func DownloadBackup(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	backupper, ok := dbhandler.Capability[dbhandler.Backupper](db)
+	if !ok {
+		http.Error(w, "The configured database cannot be backed up", http.StatusNotImplemented)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "loglite-backup-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	name := fmt.Sprintf("loglite-%s.db", time.Now().UTC().Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+	if err := backupper.Backup(r.Context(), path); err != nil {
+		log.Printf("Error creating backup for download: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
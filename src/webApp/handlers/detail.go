@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-h/templ"
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	"github.com/lauritsbonde/LogLite/src/webApp/components"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+const (
+	defaultContext = 5  // Logs shown on each side of a log when the request does not say
+	maxContext     = 50 // Most logs that can be asked for on each side
+)
+
+// errLogNotFound is returned when the log a detail view asks for does not exist (any more)
+var errLogNotFound = errors.New("the log was not found, it may have been removed by retention")
+
+// findLog reads the log stored with an id
+func findLog(db dbhandler.DBHandler, id int) (interfaces.LogEntry, error) {
+	rows, err := db.Query(dbhandler.Query{Table: "logs", Conditions: []dbhandler.Condition{{Field: "id", Op: dbhandler.OpEq, Value: id}}, Limit: 1})
+	if err != nil {
+		return interfaces.LogEntry{}, err
+	}
+	entries, err := ConvertToLogEntries(rows)
+	if err != nil {
+		return interfaces.LogEntry{}, err
+	}
+	if len(entries) == 0 {
+		return interfaces.LogEntry{}, errLogNotFound
+	}
+	return entries[0], nil
+}
+
+// errLogAmbiguous is returned when a live row matches several logs and none can be told apart
+var errLogAmbiguous = errors.New("several logs were stored at this time, find this one on the search page")
+
+// findLogAt reads the log stored at a timestamp with a message hashed to messageHash, for rows
+// of the live table, which are shown before their id is known. PostgreSQL keeps microseconds,
+// so a log stored there matches the timestamp cut to the microsecond. Logs with the same time
+// and message are the same to the viewer, the first one stored is shown. Without a hash the
+// timestamp must match a single log.
+func findLogAt(db dbhandler.DBHandler, at time.Time, messageHash string) (interfaces.LogEntry, error) {
+	since := at.Truncate(time.Microsecond)
+	rows, err := db.Query(dbhandler.Query{Table: "logs", Since: since, Until: since.Add(time.Microsecond), OrderBy: "timestamp", Ascending: true, Limit: 1000})
+	if err != nil {
+		return interfaces.LogEntry{}, err
+	}
+	entries, err := ConvertToLogEntries(rows)
+	if err != nil {
+		return interfaces.LogEntry{}, err
+	}
+
+	var matches []interfaces.LogEntry
+	for _, entry := range entries {
+		if !entry.Timestamp.Equal(at) && !entry.Timestamp.Equal(since) {
+			continue
+		}
+		if messageHash != "" && entry.MessageHash() != messageHash {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	switch {
+	case len(matches) == 0:
+		return interfaces.LogEntry{}, errLogNotFound
+	case len(matches) > 1 && messageHash == "":
+		return interfaces.LogEntry{}, errLogAmbiguous
+	}
+	return matches[0], nil
+}
+
+// logDetail prepares the detail panel of a log
+func logDetail(entry interfaces.LogEntry) interfaces.LogDetail {
+	d := interfaces.LogDetail{Entry: entry}
+	if entry.Metadata != nil {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(*entry.Metadata)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&d.Metadata); err != nil {
+			d.Metadata = nil
+		}
+	}
+	// Metadata is copied as an object rather than the text it is stored as
+	encoded, _ := json.MarshalIndent(struct {
+		interfaces.LogEntry
+		Metadata interface{} `json:"metadata"`
+	}{entry, d.Metadata}, "", "  ")
+	d.JSON = string(encoded)
+	return d
+}
+
+// renderDetail shows the detail panel of a log, or why it could not be read
+func renderDetail(w http.ResponseWriter, r *http.Request, entry interfaces.LogEntry, err error) {
+	if err != nil {
+		templ.Handler(components.LogDetailError(err.Error())).ServeHTTP(w, r)
+		return
+	}
+	templ.Handler(components.LogDetail(logDetail(entry))).ServeHTTP(w, r)
+}
+
+// LogDetail serves GET /logs/{id}, the detail panel of a stored log
+func LogDetail(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderDetail(w, r, interfaces.LogEntry{}, fmt.Errorf("invalid log id %q", r.PathValue("id")))
+		return
+	}
+	entry, err := findLog(db, id)
+	renderDetail(w, r, entry, err)
+}
+
+// LogDetailAt serves GET /logs/at?timestamp=...&message=..., the detail panel of the log stored
+// at a timestamp given in Unix nanoseconds with a message hashed as LogEntry.MessageHash
+func LogDetailAt(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	text := r.URL.Query().Get("timestamp")
+	nanos, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		renderDetail(w, r, interfaces.LogEntry{}, fmt.Errorf("invalid timestamp %q", text))
+		return
+	}
+	entry, err := findLogAt(db, time.Unix(0, nanos).UTC(), r.URL.Query().Get("message"))
+	renderDetail(w, r, entry, err)
+}
+
+// LogContext serves GET /logs/{id}/context?by=source&n=5, the n logs before and after a log
+// that have the same source (or address)
+func LogContext(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	fail := func(err error) {
+		templ.Handler(components.QueryError(err.Error(), "")).ServeHTTP(w, r)
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		fail(fmt.Errorf("invalid log id %q", r.PathValue("id")))
+		return
+	}
+	params := r.URL.Query()
+	c := interfaces.LogContext{By: params.Get("by"), N: defaultContext}
+	if c.By != "source" && c.By != "address" {
+		fail(fmt.Errorf("context is by source or address, not %q", c.By))
+		return
+	}
+	if text := params.Get("n"); text != "" {
+		if c.N, err = strconv.Atoi(text); err != nil || c.N < 1 || c.N > maxContext {
+			fail(fmt.Errorf("n must be a number from 1 to %d", maxContext))
+			return
+		}
+	}
+	if c.Entry, err = findLog(db, id); err != nil {
+		fail(err)
+		return
+	}
+
+	value := c.Entry.Source
+	if c.By == "address" {
+		value = c.Entry.Address
+	}
+	if value == nil {
+		fail(fmt.Errorf("the log has no %s", c.By))
+		return
+	}
+	cursor := EntryCursor(c.Entry)
+	q := dbhandler.Query{Table: "logs", Conditions: []dbhandler.Condition{{Field: c.By, Op: dbhandler.OpEq, Value: *value}}, OrderBy: "timestamp", Limit: c.N}
+
+	// Newest first, so the logs before the cursor in that order are the newer ones
+	newer := q
+	newer.Before = &cursor
+	older := q
+	older.After = &cursor
+	for _, side := range []struct {
+		q    dbhandler.Query
+		into *[]interfaces.LogEntry
+	}{{newer, &c.Newer}, {older, &c.Older}} {
+		rows, err := db.Query(side.q)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if *side.into, err = ConvertToLogEntries(rows); err != nil {
+			fail(err)
+			return
+		}
+	}
+	templ.Handler(components.LogContext(c)).ServeHTTP(w, r)
+}
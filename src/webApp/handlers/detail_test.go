@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+func TestFindLogAt(t *testing.T) {
+	at := time.Date(2025, 1, 31, 12, 0, 0, 123456789, time.UTC)
+	db, _ := dbhandler.NewMemoryHandler(10)
+	for _, row := range []struct {
+		ts      time.Time
+		message string
+	}{
+		{at, "first"},
+		{at, "second"},
+		{at.Add(-5), "same microsecond"},
+		{at.Truncate(time.Microsecond).Add(-time.Microsecond), "microsecond before"},
+	} {
+		if err := db.Put("logs", map[string]interface{}{"timestamp": row.ts, "level": "INFO", "message": row.message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(message string) string { return interfaces.LogEntry{Message: message}.MessageHash() }
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		hash    string
+		want    string
+		wantErr error
+	}{
+		{"by message", at, hash("second"), "second", nil},
+		{"other message", at, hash("first"), "first", nil},
+		{"unknown message", at, hash("third"), "", errLogNotFound},
+		{"no exact time", at.Add(1), hash("first"), "", errLogNotFound},
+		{"same microsecond", at.Add(-5), "", "same microsecond", nil},
+		{"several without a message", at, "", "", errLogAmbiguous},
+		{"nothing stored", at.Add(time.Hour), "", "", errLogNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := findLogAt(db, tt.at, tt.hash)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if entry.Message != tt.want {
+				t.Errorf("got %q, want %q", entry.Message, tt.want)
+			}
+		})
+	}
+}
+
+// PostgreSQL stores microseconds, a live row still finds its log there
+func TestFindLogAtMicroseconds(t *testing.T) {
+	at := time.Date(2025, 1, 31, 12, 0, 0, 123456789, time.UTC)
+	db, _ := dbhandler.NewMemoryHandler(10)
+	db.Put("logs", map[string]interface{}{"timestamp": at.Truncate(time.Microsecond), "level": "INFO", "message": "stored"})
+
+	entry, err := findLogAt(db, at, interfaces.LogEntry{Message: "stored"}.MessageHash())
+	if err != nil || entry.Message != "stored" {
+		t.Errorf("got %q, %v", entry.Message, err)
+	}
+}
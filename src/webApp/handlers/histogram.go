@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/a-h/templ"
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	"github.com/lauritsbonde/LogLite/src/webApp/components"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+// histogramBuckets is about how many bars the histogram of the search page has
+const histogramBuckets = 80
+
+// BuildHistogram counts the logs matching a search per time bucket and level. Without a start
+// the range begins at the oldest matching log, without an end it stops now.
+func BuildHistogram(db dbhandler.DBHandler, form interfaces.SearchForm, now time.Time) (interfaces.Histogram, error) {
+	var h interfaces.Histogram
+	q, err := searchQuery(form, "", now)
+	if err != nil {
+		return h, err
+	}
+	q.Count, q.GroupBy, q.Aggregates = true, []string{"level"}, nil
+	q.Limit, q.Offset, q.OrderBy, q.Ascending = 0, 0, "", false
+	if q.Until.IsZero() || q.Until.After(now) {
+		q.Until = now
+	}
+	if q.Since.IsZero() {
+		oldest, err := db.Query(dbhandler.Query{Table: "logs", Conditions: q.Conditions, Text: q.Text, Until: q.Until, OrderBy: "timestamp", Ascending: true, Limit: 1})
+		if err != nil {
+			return h, err
+		}
+		if len(oldest) == 0 {
+			return h, nil
+		}
+		entries, err := ConvertToLogEntries(oldest)
+		if err != nil {
+			return h, err
+		}
+		q.Since = entries[0].Timestamp
+	}
+	q.Since, q.Until = q.Since.UTC(), q.Until.UTC()
+	if !q.Since.Before(q.Until) {
+		return h, errors.New("the time range is empty")
+	}
+	q.Bucket = dbhandler.BucketFor(q.Until.Sub(q.Since), histogramBuckets)
+
+	rows, err := db.Query(q)
+	if err != nil {
+		return h, err
+	}
+	ts := BuildTimeSeries(q, rows, 0)
+	h.Bucket, h.Times = q.Bucket, ts.Times
+	if len(h.Times) == 0 {
+		return h, nil
+	}
+	h.Since, h.Until = h.Times[0], h.Times[len(h.Times)-1].Add(q.Bucket)
+
+	totals := make([]int64, len(h.Times))
+	for _, s := range ts.Series {
+		level, _ := s.Group["level"].(string)
+		if level == "" {
+			level = "(none)"
+		}
+		h.Levels = append(h.Levels, level)
+		h.Counts = append(h.Counts, s.Counts)
+		for i, count := range s.Counts {
+			totals[i] += count
+		}
+	}
+	for _, total := range totals {
+		if total > h.Max {
+			h.Max = total
+		}
+	}
+	return h, nil
+}
+
+// SearchHistogram serves GET /search/histogram with the parameters of the search page, the
+// number of matching logs over time as an SVG chart
+func SearchHistogram(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	h, err := BuildHistogram(db, ParseSearchForm(r.URL.Query()), time.Now())
+	if err != nil {
+		h = interfaces.Histogram{Error: err.Error()}
+	}
+	templ.Handler(components.Histogram(h)).ServeHTTP(w, r)
+}
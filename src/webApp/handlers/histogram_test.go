@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+func TestBuildHistogram(t *testing.T) {
+	db := newSearchDB(t, 30)
+	tests := []struct {
+		name   string
+		params url.Values
+		since  time.Time // Start of the range before it is aligned to buckets
+		levels string
+		total  int64
+	}{
+		{"last hour", url.Values{"range": {"1h"}}, searchNow.Add(-time.Hour), "INFO,ERROR", 30},
+		{"all time starts at the oldest log", url.Values{"range": {""}}, searchNow.Add(-30 * time.Minute), "INFO,ERROR", 30},
+		{"filtered", url.Values{"range": {"1h"}, "level": {"ERROR"}}, searchNow.Add(-time.Hour), "ERROR", 10},
+		{"end in the future stops now", url.Values{"from": {"2025-01-31T11:40"}, "to": {"2025-02-01T00:00"}}, searchNow.Add(-20 * time.Minute), "INFO,ERROR", 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := BuildHistogram(db, ParseSearchForm(tt.params), searchNow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := dbhandler.BucketFor(searchNow.Sub(tt.since), histogramBuckets); h.Bucket != want {
+				t.Errorf("bucket %s, want %s", h.Bucket, want)
+			}
+			if !h.Since.Equal(dbhandler.BucketStart(tt.since, h.Bucket)) || h.Until.Before(searchNow) || !h.Until.Add(-h.Bucket).Before(searchNow) {
+				t.Errorf("range %s to %s in buckets of %s", h.Since, h.Until, h.Bucket)
+			}
+			if n := len(h.Times); n > histogramBuckets+1 || !h.Times[n-1].Equal(h.Until.Add(-h.Bucket)) {
+				t.Errorf("%d buckets from %s to %s", n, h.Times[0], h.Times[n-1])
+			}
+			if got := strings.Join(h.Levels, ","); got != tt.levels {
+				t.Errorf("levels %s, want %s", got, tt.levels)
+			}
+
+			// Every log is counted once, and the tallest bar is the fullest bucket
+			var total, max int64
+			for i := range h.Times {
+				var bucket int64
+				for _, counts := range h.Counts {
+					if len(counts) != len(h.Times) {
+						t.Fatalf("%d counts for %d buckets", len(counts), len(h.Times))
+					}
+					bucket += counts[i]
+				}
+				total += bucket
+				if bucket > max {
+					max = bucket
+				}
+			}
+			if total != tt.total || h.Max != max {
+				t.Errorf("%d logs with at most %d in a bucket, want %d and %d", total, h.Max, tt.total, max)
+			}
+		})
+	}
+}
+
+func TestBuildHistogramEmpty(t *testing.T) {
+	db := newSearchDB(t, 30)
+
+	// All time without matching logs has no range to draw
+	h, err := BuildHistogram(db, ParseSearchForm(url.Values{"range": {""}, "level": {"DEBUG"}}), searchNow)
+	if err != nil || len(h.Times) != 0 || h.Max != 0 {
+		t.Errorf("no matching logs: %+v, error %v", h, err)
+	}
+
+	// A range with no logs still has its buckets
+	h, err = BuildHistogram(db, ParseSearchForm(url.Values{"range": {"1h"}, "level": {"DEBUG"}}), searchNow)
+	if err != nil || len(h.Times) == 0 || len(h.Levels) != 0 || h.Max != 0 {
+		t.Errorf("empty hour: %d buckets, levels %v, error %v", len(h.Times), h.Levels, err)
+	}
+
+	_, err = BuildHistogram(db, ParseSearchForm(url.Values{"from": {"2025-01-31T11:50"}, "to": {"2025-01-31T11:40"}}), searchNow)
+	if err == nil || err.Error() != "the time range is empty" {
+		t.Errorf("a range that ends before it starts gave %v", err)
+	}
+}
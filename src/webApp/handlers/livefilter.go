@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
+	queryparser "github.com/lauritsbonde/LogLite/src/queryParser"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+// liveHistoryScan is how many of the newest logs are searched for the history of a live table
+// that filters with a regular expression, which the database cannot evaluate
+const liveHistoryScan = 1000
+
+// formValues reads a form field sent by the htmx ws extension, a string or a list of strings
+// for fields with several values. Values are also split on commas and blank ones dropped.
+type formValues []string
+
+func (v *formValues) UnmarshalJSON(data []byte) error {
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		values = []string{value}
+	}
+	*v = nil
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				*v = append(*v, part)
+			}
+		}
+	}
+	return nil
+}
+
+// liveSubscription is the filter form of the live table, sent over its WebSocket as JSON
+// whenever it changes
+type liveSubscription struct {
+	Level    formValues `json:"level"`
+	Source   string     `json:"source"`
+	Label    string     `json:"label"`
+	Text     string     `json:"text"`
+	Regex    string     `json:"regex"`    // "on" when the text is a regular expression
+	Metadata string     `json:"metadata"` // Conditions on metadata paths in the query language
+}
+
+// liveMessage is what the live table sends over its WebSocket: its filter form, which also
+// holds the position of the last log shown, or a pause or resume action
+type liveMessage struct {
+	liveSubscription
+	Since  string `json:"since"`
+	Action string `json:"action"` // "pause" or "resume", empty for the filter form
+}
+
+// parseLiveMessage decodes a message of the live table
+func parseLiveMessage(data []byte) (liveMessage, error) {
+	var m liveMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid live table message: %w", err)
+	}
+	m.Source, m.Label = strings.TrimSpace(m.Source), strings.TrimSpace(m.Label)
+	m.Metadata = strings.TrimSpace(m.Metadata)
+	return m, nil
+}
+
+// key identifies the subscription, so a form sent again unchanged can be ignored
+func (s liveSubscription) key() string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
+
+// filter compiles the subscription into the filter logs are checked against
+func (s liveSubscription) filter(now time.Time) (livetail.Filter, error) {
+	var f livetail.Filter
+	if len(s.Level) > 0 {
+		levels := make([]interface{}, len(s.Level))
+		for i, level := range s.Level {
+			levels[i] = strings.ToUpper(level)
+		}
+		f.Query.Conditions = append(f.Query.Conditions, dbhandler.Condition{Field: "level", Op: dbhandler.OpIn, Value: levels})
+	}
+	if s.Source != "" {
+		f.Query.Conditions = append(f.Query.Conditions, dbhandler.Condition{Field: "source", Op: dbhandler.OpEq, Value: s.Source})
+	}
+	if s.Label != "" {
+		f.Query.Conditions = append(f.Query.Conditions, dbhandler.Condition{Field: "label", Op: dbhandler.OpEq, Value: s.Label})
+	}
+	if s.Text != "" {
+		if s.Regex == "on" {
+			pattern, err := regexp.Compile(s.Text)
+			if err != nil {
+				return f, fmt.Errorf("invalid regular expression: %w", err)
+			}
+			f.Pattern = pattern
+		} else {
+			f.Query.Text = []string{s.Text}
+		}
+	}
+	if s.Metadata != "" {
+		q, err := queryparser.Compile(s.Metadata, now)
+		if err != nil {
+			return f, fmt.Errorf("invalid metadata filter: %w", err)
+		}
+		if len(q.Text) > 0 || q.IsAggregate() || !q.Since.IsZero() || !q.Until.IsZero() {
+			return f, errors.New("the metadata filter only takes metadata.<path> conditions, e.g. metadata.user_id=42")
+		}
+		for _, c := range q.Conditions {
+			if !dbhandler.IsMetadataField(c.Field) {
+				return f, fmt.Errorf("the metadata filter only takes metadata.<path> conditions, not %s", c.Field)
+			}
+		}
+		f.Query.Conditions = append(f.Query.Conditions, q.Conditions...)
+	}
+	return f, nil
+}
+
+// liveHistory reads the newest logs that pass the filter, for the live table to start with.
+// Without a filter the table loads older logs as it is scrolled, next is where they continue.
+func liveHistory(db dbhandler.DBHandler, filter livetail.Filter) (logs []interfaces.LogEntry, next string, err error) {
+	if !filter.Active() {
+		logs, err = GetLogs(db, nil, false, queryparser.DefaultLimit)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(logs) == queryparser.DefaultLimit {
+			next = "/query?" + url.Values{"after": {EntryCursor(logs[len(logs)-1]).String()}}.Encode()
+		}
+		return logs, next, nil
+	}
+
+	q := dbhandler.Query{Table: "logs", Conditions: filter.Query.Conditions, Text: filter.Query.Text, OrderBy: "timestamp", Limit: queryparser.DefaultLimit}
+	if filter.Pattern != nil {
+		q.Limit = liveHistoryScan
+	}
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, "", err
+	}
+	if filter.Pattern != nil {
+		matched := rows[:0]
+		for _, row := range rows {
+			if message, ok := row["message"].(string); ok && filter.Pattern.MatchString(message) && len(matched) < queryparser.DefaultLimit {
+				matched = append(matched, row)
+			}
+		}
+		rows = matched
+	}
+	logs, err = ConvertToLogEntries(rows)
+	return logs, "", err
+}
+
+// historyKeys are the logs a subscriber read from the database after subscribing. Logs stored
+// while it read can be in the history and arrive on the subscription too. Live logs have no id,
+// so they are recognized by their timestamp, to the microsecond every backend keeps, and message.
+type historyKeys map[string]bool
+
+func historyKey(timestamp time.Time, message string) string {
+	return fmt.Sprintf("%d/%s", timestamp.Truncate(time.Microsecond).UnixNano(), message)
+}
+
+func newHistoryKeys(logs []interfaces.LogEntry) historyKeys {
+	keys := make(historyKeys, len(logs))
+	for _, l := range logs {
+		keys[historyKey(l.Timestamp, l.Message)] = true
+	}
+	return keys
+}
+
+// repeated reports whether a published log is one of the history, each only once
+func (k historyKeys) repeated(entry livetail.Entry) bool {
+	if len(k) == 0 {
+		return false
+	}
+	timestamp, err := parseTimestamp(entry.Row["timestamp"])
+	message, _ := entry.Row["message"].(string)
+	if err != nil {
+		return false
+	}
+	key := historyKey(timestamp, message)
+	if !k[key] {
+		return false
+	}
+	delete(k, key)
+	return true
+}
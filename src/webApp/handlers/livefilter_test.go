@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+// Logs stored while a subscriber read its history arrive again on the subscription
+func TestHistoryKeys(t *testing.T) {
+	at := time.Date(2025, 1, 31, 12, 0, 0, 123456789, time.UTC)
+	history := newHistoryKeys([]interfaces.LogEntry{
+		{Timestamp: at.Truncate(time.Microsecond), Message: "stored meanwhile"}, // As PostgreSQL keeps it
+		{Timestamp: at, Message: "twice"},
+	})
+	live := func(ts interface{}, message string) livetail.Entry {
+		return livetail.Entry{Row: map[string]interface{}{"timestamp": ts, "message": message}}
+	}
+
+	tests := []struct {
+		name  string
+		entry livetail.Entry
+		want  bool
+	}{
+		{"other message", live(at, "new"), false},
+		{"other time", live(at.Add(time.Microsecond), "twice"), false},
+		{"truncated in the history", live(at, "stored meanwhile"), true},
+		{"only once", live(at, "stored meanwhile"), false},
+		{"stored text", live("2025-01-31 12:00:00.123456789", "twice"), true},
+		{"no timestamp", live(nil, "new"), false},
+	}
+	for _, tt := range tests {
+		if got := history.repeated(tt.entry); got != tt.want {
+			t.Errorf("%s: repeated = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if historyKeys(nil).repeated(live(at, "twice")) {
+		t.Error("an empty history repeated a log")
+	}
+}
+
+func TestParseLiveMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		level []string
+		want  liveMessage
+	}{
+		{"one level", `{"level": "error", "source": " api "}`, []string{"error"}, liveMessage{liveSubscription: liveSubscription{Source: "api"}}},
+		{"checked levels", `{"level": ["INFO", "WARN"]}`, []string{"INFO", "WARN"}, liveMessage{}},
+		{"typed levels", `{"level": "INFO, ,warn,"}`, []string{"INFO", "warn"}, liveMessage{}},
+		{"position", `{"text": "timeout", "since": "5-10"}`, nil, liveMessage{liveSubscription: liveSubscription{Text: "timeout"}, Since: "5-10"}},
+		{"action", `{"action": "pause", "HEADERS": {}}`, nil, liveMessage{Action: "pause"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseLiveMessage([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(m.Level, "|") != strings.Join(tt.level, "|") {
+				t.Errorf("levels %q, want %q", m.Level, tt.level)
+			}
+			m.Level, tt.want.Level = nil, nil
+			if m.key() != tt.want.key() || m.Since != tt.want.Since || m.Action != tt.want.Action {
+				t.Errorf("got %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseLiveMessage([]byte(`{"level": 3}`)); err == nil {
+		t.Error("a level that is a number was accepted")
+	}
+}
+
+func TestLiveSubscriptionFilter(t *testing.T) {
+	row := func(level, source, message, metadata string) map[string]interface{} {
+		return map[string]interface{}{"level": level, "source": source, "label": "prod", "message": message, "metadata": metadata}
+	}
+	payment := row("ERROR", "payment_service", "card declined: timeout", `{"user_id": 42, "region": "eu"}`)
+	api := row("INFO", "api", "GET /orders took 12ms", `{"user_id": 7}`)
+
+	tests := []struct {
+		name    string
+		sub     liveSubscription
+		match   []bool // payment, api
+		wantErr string
+	}{
+		{"everything", liveSubscription{}, []bool{true, true}, ""},
+		{"levels in any case", liveSubscription{Level: formValues{"error", "warn"}}, []bool{true, false}, ""},
+		{"source", liveSubscription{Source: "payment_service"}, []bool{true, false}, ""},
+		{"label", liveSubscription{Label: "staging"}, []bool{false, false}, ""},
+		{"text", liveSubscription{Text: "TIMEOUT"}, []bool{true, false}, ""},
+		{"regex", liveSubscription{Text: `took \d+ms$`, Regex: "on"}, []bool{false, true}, ""},
+		{"text that is not a regex", liveSubscription{Text: `took \d+ms$`}, []bool{false, false}, ""},
+		{"metadata", liveSubscription{Metadata: "metadata.user_id>=10 metadata.region=eu"}, []bool{true, false}, ""},
+		{"metadata without prefix", liveSubscription{Metadata: "user_id=7"}, nil, "unknown field: user_id"},
+		{"all together", liveSubscription{Level: formValues{"ERROR"}, Source: "payment_service", Text: "declined", Metadata: "metadata.user_id=42"}, []bool{true, false}, ""},
+		{"invalid regex", liveSubscription{Text: "(", Regex: "on"}, nil, "invalid regular expression"},
+		{"invalid metadata", liveSubscription{Metadata: "metadata.user_id>"}, nil, "invalid metadata filter"},
+		{"text in metadata", liveSubscription{Metadata: "metadata.user_id=42 timeout"}, nil, "only takes metadata.<path> conditions"},
+		{"time in metadata", liveSubscription{Metadata: "since:15m"}, nil, "only takes metadata.<path> conditions"},
+		{"count in metadata", liveSubscription{Metadata: "metadata.user_id=42 | count"}, nil, "only takes metadata.<path> conditions"},
+		{"column in metadata", liveSubscription{Metadata: "level:ERROR"}, nil, "not level"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tt.sub.filter(time.Now())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := []bool{f.Match(payment), f.Match(api)}; got[0] != tt.match[0] || got[1] != tt.match[1] {
+				t.Errorf("matches %v, want %v", got, tt.match)
+			}
+			if f.Active() != (tt.sub.key() != liveSubscription{}.key()) {
+				t.Errorf("active = %v", f.Active())
+			}
+		})
+	}
+}
+
+func TestLiveHistory(t *testing.T) {
+	db, _ := dbhandler.NewMemoryHandler(100)
+	start := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	for i, message := range []string{"took 5ms", "failed", "took 12ms", "took slow"} {
+		err := db.Put("logs", map[string]interface{}{"timestamp": start.Add(time.Duration(i) * time.Second), "level": "INFO", "message": message})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	history := func(sub liveSubscription) string {
+		t.Helper()
+		f, err := sub.filter(time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs, _, err := liveHistory(db, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range logs {
+			got = append(got, l.Message)
+		}
+		return strings.Join(got, ",")
+	}
+	if got := history(liveSubscription{}); got != "took slow,took 12ms,failed,took 5ms" {
+		t.Errorf("without a filter: %q", got)
+	}
+	if got := history(liveSubscription{Text: "took"}); got != "took slow,took 12ms,took 5ms" {
+		t.Errorf("text: %q", got)
+	}
+	// The database cannot evaluate a regular expression, the newest logs are searched instead
+	if got := history(liveSubscription{Text: `^took \d+ms$`, Regex: "on"}); got != "took 12ms,took 5ms" {
+		t.Errorf("regex: %q", got)
+	}
+}
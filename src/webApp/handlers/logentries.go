@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
 	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
 )
 
@@ -34,23 +36,21 @@ func ConvertToLogEntries(results []map[string]interface{}) ([]interfaces.LogEntr
 
 		// Parse timestamp
 		if timestampValue, ok := row["timestamp"]; ok {
-			switch t := timestampValue.(type) {
-			case time.Time:
-					// If it's already a time.Time object, use it directly
-					entry.Timestamp = t
-			case string:
-					// If it's a string, parse it
-					timestamp, err := time.Parse("2006-01-02 15:04:05", t)
-					if err != nil {
-							return nil, fmt.Errorf("invalid timestamp format: %v", err)
-					}
-					entry.Timestamp = timestamp
-			default:
-					return nil, fmt.Errorf("unsupported timestamp type: %T", t)
+			timestamp, err := parseTimestamp(timestampValue)
+			if err != nil {
+				return nil, err
 			}
+			entry.Timestamp = timestamp
 		} else {
 				return nil, errors.New("missing or invalid field: timestamp")
 		}
+		if receivedValue := row["received_at"]; receivedValue != nil {
+			receivedAt, err := parseTimestamp(receivedValue)
+			if err != nil {
+				return nil, err
+			}
+			entry.ReceivedAt = &receivedAt
+		}
 
 		// Parse level
 		if level, ok := row["level"].(string); ok {
@@ -79,6 +79,9 @@ func ConvertToLogEntries(results []map[string]interface{}) ([]interfaces.LogEntr
 		if length, ok := row["length"].(int); ok {
 				entry.Length = &length
 		}
+		if label, ok := row["label"].(string); ok {
+				entry.Label = &label
+		}
 
 		// Parse metadata as JSON
 		if metadataStr, ok := row["metadata"].(string); ok {
@@ -101,12 +104,48 @@ func ConvertToLogEntries(results []map[string]interface{}) ([]interfaces.LogEntr
 	return logEntries, nil
 }
 
-func GetLogs(dbhandler dbhandler.DBHandler) ([]interfaces.LogEntry, error) {
-	// Fetch data from the database
-	var m map[string]interface{} = make(map[string]interface{})
-	m["limit"] = 1
-	m["orderBy"] = "timestamp"
-	dbRes, err := dbhandler.Get("logs", m)
+// parseTimestamp reads a time column as the backends return it, a time.Time or stored text.
+// Stored text has nanoseconds, older rows have none; a layout without a fraction reads both.
+func parseTimestamp(value interface{}) (time.Time, error) {
+	switch t := value.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano} {
+			if timestamp, err := time.Parse(layout, t); err == nil {
+				return timestamp, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid timestamp format: %q", t)
+	default:
+		return time.Time{}, fmt.Errorf("unsupported timestamp type: %T", t)
+	}
+}
+
+// LiveLogEntries converts logs pushed by the live tail. Handlers do not report the id they
+// stored a log under, so the ID of these entries is 0 and left out of their JSON. Logs that
+// cannot be shown are skipped.
+func LiveLogEntries(entries []livetail.Entry) []interfaces.LogEntry {
+	logEntries := make([]interfaces.LogEntry, 0, len(entries))
+	for _, e := range entries {
+		row := make(map[string]interface{}, len(e.Row)+1)
+		for col, val := range e.Row {
+			row[col] = val
+		}
+		row["id"] = 0
+		converted, err := ConvertToLogEntries([]map[string]interface{}{row})
+		if err != nil {
+			log.Printf("Error converting live log %d: %v", e.Seq, err)
+			continue
+		}
+		logEntries = append(logEntries, converted...)
+	}
+	return logEntries
+}
+
+// GetLogs returns up to limit logs ordered by timestamp, the ones after the cursor when one is given
+func GetLogs(db dbhandler.DBHandler, after *dbhandler.Cursor, ascending bool, limit int) ([]interfaces.LogEntry, error) {
+	dbRes, err := db.Query(dbhandler.Query{Table: "logs", OrderBy: "timestamp", Ascending: ascending, Limit: limit, After: after})
 	if err != nil {
 			return nil, fmt.Errorf("error getting logs from database: %w", err)
 	}
@@ -120,6 +159,11 @@ func GetLogs(dbhandler dbhandler.DBHandler) ([]interfaces.LogEntry, error) {
 	return entries, nil
 }
 
+// EntryCursor is the position of a log entry, for fetching the logs after it
+func EntryCursor(entry interfaces.LogEntry) dbhandler.Cursor {
+	return dbhandler.Cursor{Timestamp: entry.Timestamp.UTC(), ID: int64(entry.ID)}
+}
+
 func optionalString(strPtr *string) string {
 	if strPtr != nil {
 		return *strPtr
@@ -136,7 +180,7 @@ func optionalInt(intPtr *int) int {
 
 func PrintLgos(logEntries []interfaces.LogEntry) {
 	for _, entry := range logEntries {
-		fmt.Printf("Timestamp: %s, Level: %s, Message: %s, Source: %s, Method: %s, Address: %s, Length: %d, Metadata: %s\n",
+		fmt.Printf("Timestamp: %s, Level: %s, Message: %s, Source: %s, Method: %s, Address: %s, Length: %d, Metadata: %s, Label: %s\n",
 			entry.Timestamp.String(),
 			entry.Level,
 			entry.Message,
@@ -145,6 +189,7 @@ func PrintLgos(logEntries []interfaces.LogEntry) {
 			optionalString(entry.Address),
 			optionalInt(entry.Length),
 			optionalString(entry.Metadata),
+			optionalString(entry.Label),
 		)
 	}
 }
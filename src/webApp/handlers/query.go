@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/a-h/templ"
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	queryparser "github.com/lauritsbonde/LogLite/src/queryParser"
+	"github.com/lauritsbonde/LogLite/src/webApp/components"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+// queryErrorResponse is returned by the query API when a query cannot be run
+type queryErrorResponse struct {
+	Error  string `json:"error"`
+	Column int    `json:"column,omitempty"`
+}
+
+// CompileQuery compiles the q parameter of a request and applies its after or before cursor,
+// so a listing can continue from the last log of the previous page
+func CompileQuery(params url.Values, now time.Time) (dbhandler.Query, error) {
+	q, err := queryparser.Compile(params.Get("q"), now)
+	if err != nil {
+		return q, err
+	}
+	for _, name := range []string{"after", "before"} {
+		text := params.Get(name)
+		if text == "" {
+			continue
+		}
+		cursor, err := dbhandler.ParseCursor(text)
+		if err != nil {
+			return q, err
+		}
+		if name == "after" {
+			q.After = &cursor
+		} else {
+			q.Before = &cursor
+		}
+	}
+	return q, q.Validate()
+}
+
+// RunQuery compiles the query of a request and runs it against the database
+func RunQuery(db dbhandler.DBHandler, params url.Values) (dbhandler.Query, []map[string]interface{}, error) {
+	q, err := CompileQuery(params, time.Now())
+	if err != nil {
+		return q, nil, err
+	}
+	rows, err := db.Query(q)
+	if err != nil {
+		return q, nil, err
+	}
+	return q, rows, nil
+}
+
+// writeQueryError responds with the error as JSON, pointing at the column of a syntax error
+func writeQueryError(w http.ResponseWriter, err error, status int) {
+	resp := queryErrorResponse{Error: err.Error()}
+	var syntaxErr *queryparser.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		resp.Column = syntaxErr.Column()
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// QueryAPI serves GET /api/query?q=... as JSON. Log rows come with next and prev cursors, pass
+// one back as after or before to get the logs following the page or preceding it.
+func QueryAPI(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q, err := CompileQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		writeQueryError(w, err, http.StatusBadRequest)
+		return
+	}
+	rows, err := db.Query(q)
+	if err != nil {
+		writeQueryError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"results": rows}
+	if !q.IsAggregate() {
+		entries, err := ConvertToLogEntries(rows)
+		if err != nil {
+			writeQueryError(w, err, http.StatusInternalServerError)
+			return
+		}
+		resp["results"] = entries
+		if len(entries) > 0 {
+			resp["prev"] = EntryCursor(entries[0]).String()
+			resp["next"] = EntryCursor(entries[len(entries)-1]).String()
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error writing query response: %v", err)
+	}
+}
+
+// nextPageURL is where the search results continue after the given entries, empty when the
+// page was not full and there is nothing more to load
+func nextPageURL(params url.Values, q dbhandler.Query, entries []interfaces.LogEntry) string {
+	if q.Limit == 0 || len(entries) < q.Limit {
+		return ""
+	}
+	next := url.Values{"q": {params.Get("q")}, "after": {EntryCursor(entries[len(entries)-1]).String()}}
+	return "/query?" + next.Encode()
+}
+
+// QueryLogs serves GET /query?q=... as an HTML fragment for the search bar. With a cursor it
+// only renders the rows of the page, for the infinite scroll to append.
+func QueryLogs(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+	params := r.URL.Query()
+	q, rows, err := RunQuery(db, params)
+	if err != nil {
+		caret := ""
+		var syntaxErr *queryparser.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			caret = syntaxErr.Caret()
+		}
+		templ.Handler(components.QueryError(err.Error(), caret)).ServeHTTP(w, r)
+		return
+	}
+
+	if q.IsAggregate() {
+		columns := append(append([]string{}, q.GroupBy...), "count")
+		templ.Handler(components.QueryCounts(columns, rows)).ServeHTTP(w, r)
+		return
+	}
+
+	entries, err := ConvertToLogEntries(rows)
+	if err != nil {
+		templ.Handler(components.QueryError(err.Error(), "")).ServeHTTP(w, r)
+		return
+	}
+	next := nextPageURL(params, q, entries)
+	if q.After != nil || q.Before != nil {
+		templ.Handler(components.QueryResultRows(entries, next)).ServeHTTP(w, r)
+		return
+	}
+	templ.Handler(components.QueryResults(entries, next)).ServeHTTP(w, r)
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	queryparser "github.com/lauritsbonde/LogLite/src/queryParser"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+const (
+	searchPageSize = 50 // Logs per page of search results
+	searchFacetTop = 10 // Values listed per facet
+)
+
+// searchFacets are the fields the search page counts values of, with the form field that
+// selects them
+var searchFacets = []struct{ field, name string }{
+	{"level", "level"},
+	{"source", "source"},
+	{"label", "label"},
+}
+
+// ParseSearchForm reads the search form from request parameters
+func ParseSearchForm(params url.Values) interfaces.SearchForm {
+	form := interfaces.SearchForm{
+		Query:     params.Get("q"),
+		Levels:    params["level"],
+		Sources:   params["source"],
+		Labels:    params["label"],
+		Range:     params.Get("range"),
+		From:      params.Get("from"),
+		To:        params.Get("to"),
+		Sort:      params.Get("sort"),
+		Ascending: params.Get("order") == "asc",
+	}
+	form.TZ, _ = strconv.Atoi(params.Get("tz"))
+	if _, ok := params["range"]; !ok {
+		// A new search looks at the last day, "All time" sends an empty range
+		form.Range = "24h"
+	}
+	return form
+}
+
+// SearchParams turns a search form back into request parameters
+func SearchParams(form interfaces.SearchForm) url.Values {
+	params := url.Values{
+		"q":     {form.Query},
+		"range": {form.Range},
+		"from":  {form.From},
+		"to":    {form.To},
+		"tz":    {strconv.Itoa(form.TZ)},
+	}
+	for _, facet := range searchFacets {
+		if values := selected(form, facet.name); len(values) > 0 {
+			params[facet.name] = values
+		}
+	}
+	return params
+}
+
+// selected returns the values of a facet chosen in the form
+func selected(form interfaces.SearchForm, name string) []string {
+	switch name {
+	case "level":
+		return form.Levels
+	case "source":
+		return form.Sources
+	case "label":
+		return form.Labels
+	}
+	return nil
+}
+
+// searchQuery compiles the query of the form and adds the selected facets except the one
+// named skip, and the time range. Absolute times are read in the viewer's timezone.
+func searchQuery(form interfaces.SearchForm, skip string, now time.Time) (dbhandler.Query, error) {
+	q, err := queryparser.Compile(form.Query, now)
+	if err != nil {
+		return q, err
+	}
+	for _, facet := range searchFacets {
+		values := selected(form, facet.name)
+		if facet.name == skip || len(values) == 0 {
+			continue
+		}
+		in := make([]interface{}, len(values))
+		for i, v := range values {
+			in[i] = v
+		}
+		q.Conditions = append(q.Conditions, dbhandler.Condition{Field: facet.field, Op: dbhandler.OpIn, Value: in})
+	}
+
+	zone := time.FixedZone("", form.TZ*60)
+	var since, until time.Time
+	if form.From != "" || form.To != "" {
+		if form.From != "" {
+			if since, err = parseFormTime(form.From, zone); err != nil {
+				return q, fmt.Errorf("invalid start of the time range: %q", form.From)
+			}
+		}
+		if form.To != "" {
+			if until, err = parseFormTime(form.To, zone); err != nil {
+				return q, fmt.Errorf("invalid end of the time range: %q", form.To)
+			}
+		}
+	} else if form.Range != "" {
+		if since, err = queryparser.ParseTime(form.Range, now); err != nil {
+			return q, err
+		}
+	}
+	// The range narrows a since: or until: in the query, it does not widen it
+	if !since.IsZero() && since.After(q.Since) {
+		q.Since = since
+	}
+	if !until.IsZero() && (q.Until.IsZero() || until.Before(q.Until)) {
+		q.Until = until
+	}
+	return q, nil
+}
+
+// parseFormTime reads a datetime-local input, which leaves out the seconds when they are zero
+func parseFormTime(text string, zone *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", text, zone)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02T15:04", text, zone)
+	}
+	return t, err
+}
+
+// RunSearch runs the search form against the database: a page of logs in the chosen order,
+// or the counts of an aggregate query, and the facets of the matching logs. after, before and
+// page pick the page, cursors for the timestamp sort and page numbers for other sorts.
+func RunSearch(db dbhandler.DBHandler, params url.Values, now time.Time) interfaces.SearchResults {
+	res := interfaces.SearchResults{Form: ParseSearchForm(params), Page: 1}
+	res.Chart = "/search/histogram?" + SearchParams(res.Form).Encode()
+	q, err := searchQuery(res.Form, "", now)
+	if err != nil {
+		return searchError(res, err)
+	}
+
+	if q.IsAggregate() {
+		rows, err := db.Query(q)
+		if err != nil {
+			return searchError(res, err)
+		}
+		res.Columns = append(append([]string{}, q.GroupBy...), "count")
+		res.Counts = rows
+		return res
+	}
+
+	if res.Form.Sort != "" {
+		if err := dbhandler.ValidateField(res.Form.Sort); err != nil {
+			return searchError(res, err)
+		}
+		q.OrderBy, q.Ascending = res.Form.Sort, res.Form.Ascending
+	}
+	res.Form.Sort, res.Form.Ascending = q.OrderBy, q.Ascending
+	q.Limit = searchPageSize
+	if res.Page, err = strconv.Atoi(params.Get("page")); err != nil || res.Page < 1 {
+		res.Page = 1
+	}
+	keyset := q.OrderBy == "timestamp"
+	if keyset {
+		for _, name := range []string{"after", "before"} {
+			if text := params.Get(name); text != "" {
+				cursor, err := dbhandler.ParseCursor(text)
+				if err != nil {
+					return searchError(res, err)
+				}
+				if name == "after" {
+					q.After = &cursor
+				} else {
+					q.Before = &cursor
+				}
+			}
+		}
+	} else {
+		q.Offset = (res.Page - 1) * searchPageSize
+	}
+	if err := q.Validate(); err != nil {
+		return searchError(res, err)
+	}
+
+	rows, err := db.Query(q)
+	if err != nil {
+		return searchError(res, err)
+	}
+	if res.Entries, err = ConvertToLogEntries(rows); err != nil {
+		return searchError(res, err)
+	}
+	full := len(res.Entries) == searchPageSize
+	switch {
+	case !keyset:
+		if res.Page > 1 {
+			res.Prev = map[string]string{"page": strconv.Itoa(res.Page - 1)}
+		}
+		if full {
+			res.Next = map[string]string{"page": strconv.Itoa(res.Page + 1)}
+		}
+	case len(res.Entries) > 0:
+		first, last := EntryCursor(res.Entries[0]).String(), EntryCursor(res.Entries[len(res.Entries)-1]).String()
+		// A page before a cursor is only partly full when it reached the first page
+		if res.Page > 1 && (q.Before == nil || full) {
+			res.Prev = map[string]string{"before": first, "page": strconv.Itoa(res.Page - 1)}
+		}
+		if q.Before != nil || full {
+			res.Next = map[string]string{"after": last, "page": strconv.Itoa(res.Page + 1)}
+		}
+	}
+
+	if res.Facets, err = searchFacetCounts(db, res.Form, now); err != nil {
+		return searchError(res, err)
+	}
+	return res
+}
+
+// searchFacetCounts counts the most common values of each facet among the matching logs. The
+// selection of a facet is left out of its own counts, so the other values stay listed.
+func searchFacetCounts(db dbhandler.DBHandler, form interfaces.SearchForm, now time.Time) ([]interfaces.Facet, error) {
+	facets := make([]interfaces.Facet, 0, len(searchFacets))
+	for _, f := range searchFacets {
+		q, err := searchQuery(form, f.name, now)
+		if err != nil {
+			return nil, err
+		}
+		q.Count, q.GroupBy, q.OrderBy, q.Ascending, q.Limit = true, []string{f.field}, "count", false, searchFacetTop
+		rows, err := db.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s values: %w", f.field, err)
+		}
+
+		facet := interfaces.Facet{Field: f.field, Name: f.name}
+		chosen := map[string]bool{}
+		for _, v := range selected(form, f.name) {
+			chosen[v] = true
+		}
+		for _, row := range rows {
+			value, ok := row[f.field].(string)
+			if !ok {
+				continue
+			}
+			count, _ := row["count"].(int64)
+			facet.Values = append(facet.Values, interfaces.FacetValue{Value: value, Count: count, Selected: chosen[value]})
+			delete(chosen, value)
+		}
+		// Selected values outside the most common ones stay listed so they can be cleared
+		for _, v := range selected(form, f.name) {
+			if chosen[v] {
+				facet.Values = append(facet.Values, interfaces.FacetValue{Value: v, Selected: true})
+			}
+		}
+		facets = append(facets, facet)
+	}
+	return facets, nil
+}
+
+// searchError returns the results with the error shown in place of the logs
+func searchError(res interfaces.SearchResults, err error) interfaces.SearchResults {
+	res.Error = err.Error()
+	var syntaxErr *queryparser.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		res.Caret = syntaxErr.Caret()
+	}
+	return res
+}
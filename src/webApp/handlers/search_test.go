@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+// searchNow is the time the search tests run at
+var searchNow = time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+
+// newSearchDB returns a memory database with n logs, "log 0" a minute before searchNow and
+// every next one a minute older. Every third is an ERROR, sources take turns.
+func newSearchDB(t *testing.T, n int) dbhandler.DBHandler {
+	t.Helper()
+	db, err := dbhandler.NewMemoryHandler(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		level := "INFO"
+		if i%3 == 0 {
+			level = "ERROR"
+		}
+		err := db.Put("logs", map[string]interface{}{
+			"timestamp": searchNow.Add(-time.Duration(i+1) * time.Minute),
+			"level":     level,
+			"source":    []string{"api", "auth"}[i%2],
+			"label":     "web",
+			"message":   fmt.Sprintf("log %d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// entryMessages lists the messages of the results, separated by commas
+func entryMessages(entries []interfaces.LogEntry) string {
+	messages := make([]string, len(entries))
+	for i, e := range entries {
+		messages[i] = e.Message
+	}
+	return strings.Join(messages, ",")
+}
+
+// facetValues describes a facet as value=count pairs, selected values marked with a star
+func facetValues(res interfaces.SearchResults, name string) string {
+	values := []string{}
+	for _, f := range res.Facets {
+		if f.Name != name {
+			continue
+		}
+		for _, v := range f.Values {
+			star := ""
+			if v.Selected {
+				star = "*"
+			}
+			values = append(values, fmt.Sprintf("%s%s=%d", v.Value, star, v.Count))
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+func TestSearchFacets(t *testing.T) {
+	db := newSearchDB(t, 30)
+
+	res := RunSearch(db, url.Values{}, searchNow)
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	if got := facetValues(res, "level"); got != "INFO=20 ERROR=10" {
+		t.Errorf("level facet %q", got)
+	}
+
+	// The selected level narrows the other facets but not its own
+	res = RunSearch(db, url.Values{"level": {"ERROR"}, "source": {"api"}}, searchNow)
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	if got := entryMessages(res.Entries); got != "log 0,log 6,log 12,log 18,log 24" {
+		t.Errorf("ERROR logs from api: %q", got)
+	}
+	if got := facetValues(res, "level"); got != "INFO=10 ERROR*=5" {
+		t.Errorf("level facet %q", got)
+	}
+	if got := facetValues(res, "source"); got != "api*=5 auth=5" {
+		t.Errorf("source facet %q", got)
+	}
+	if got := facetValues(res, "label"); got != "web=5" {
+		t.Errorf("label facet %q", got)
+	}
+
+	// A selected value without matching logs stays listed so it can be cleared
+	res = RunSearch(db, url.Values{"level": {"DEBUG"}}, searchNow)
+	if len(res.Entries) != 0 || facetValues(res, "level") != "INFO=20 ERROR=10 DEBUG*=0" {
+		t.Errorf("DEBUG gave %d logs and level facet %q", len(res.Entries), facetValues(res, "level"))
+	}
+	if facetValues(res, "source") != "" {
+		t.Errorf("source facet without matching logs %q", facetValues(res, "source"))
+	}
+}
+
+func TestSearchTimeRange(t *testing.T) {
+	db := newSearchDB(t, 30)
+	tests := []struct {
+		name   string
+		params url.Values
+		want   string
+	}{
+		{"range", url.Values{"range": {"5m"}}, "log 0,log 1,log 2,log 3,log 4"},
+		{"range narrows the query", url.Values{"q": {"since:10m"}, "range": {"3m"}}, "log 0,log 1,log 2"},
+		{"query narrows the range", url.Values{"q": {"since:3m"}, "range": {"10m"}}, "log 0,log 1,log 2"},
+		// 12:50 in UTC+1 is 11:50 UTC, 10 minutes before searchNow
+		{"viewer's timezone", url.Values{"from": {"2025-01-31T12:50"}, "to": {"2025-01-31T12:53:30"}, "tz": {"60"}}, "log 6,log 7,log 8,log 9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := RunSearch(db, tt.params, searchNow)
+			if res.Error != "" {
+				t.Fatal(res.Error)
+			}
+			if got := entryMessages(res.Entries); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if res := RunSearch(db, url.Values{"from": {"yesterday"}}, searchNow); !strings.Contains(res.Error, "invalid start of the time range") {
+		t.Errorf("invalid start gave %q", res.Error)
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	db := newSearchDB(t, 2*searchPageSize+20)
+	run := func(params map[string]string) interfaces.SearchResults {
+		t.Helper()
+		values := url.Values{}
+		for k, v := range params {
+			values.Set(k, v)
+		}
+		res := RunSearch(db, values, searchNow)
+		if res.Error != "" {
+			t.Fatal(res.Error)
+		}
+		return res
+	}
+	page := func(first, n int) string {
+		messages := []string{}
+		for i := first; i < first+n; i++ {
+			messages = append(messages, fmt.Sprintf("log %d", i))
+		}
+		return strings.Join(messages, ",")
+	}
+
+	// The timestamp sort pages with cursors from the newest logs
+	first := run(nil)
+	if entryMessages(first.Entries) != page(0, searchPageSize) || first.Prev != nil || first.Next["page"] != "2" {
+		t.Fatalf("first page: %d logs, prev %v, next %v", len(first.Entries), first.Prev, first.Next)
+	}
+	second := run(first.Next)
+	if entryMessages(second.Entries) != page(searchPageSize, searchPageSize) || second.Page != 2 {
+		t.Errorf("second page: %q", entryMessages(second.Entries))
+	}
+	last := run(second.Next)
+	if entryMessages(last.Entries) != page(2*searchPageSize, 20) || last.Next != nil || last.Prev["page"] != "2" {
+		t.Errorf("last page: %d logs, prev %v, next %v", len(last.Entries), last.Prev, last.Next)
+	}
+	back := run(last.Prev)
+	if entryMessages(back.Entries) != entryMessages(second.Entries) || back.Next == nil || back.Prev["page"] != "1" {
+		t.Errorf("back to the second page: %d logs, prev %v, next %v", len(back.Entries), back.Prev, back.Next)
+	}
+	if again := run(back.Prev); entryMessages(again.Entries) != entryMessages(first.Entries) || again.Page != 1 || again.Prev != nil {
+		t.Errorf("back to the first page: page %d, prev %v", again.Page, again.Prev)
+	}
+
+	// Other sorts page by number
+	byMessage := run(map[string]string{"sort": "message", "order": "asc", "page": "3"})
+	if len(byMessage.Entries) != 20 || byMessage.Next != nil || byMessage.Prev["page"] != "2" {
+		t.Errorf("third page by message: %d logs, prev %v, next %v", len(byMessage.Entries), byMessage.Prev, byMessage.Next)
+	}
+	// Messages sort as text: log 0, log 1, log 10, log 100, ...
+	if len(byMessage.Entries) > 0 && byMessage.Entries[0].Message != "log 81" {
+		t.Errorf("third page by message starts at %q", byMessage.Entries[0].Message)
+	}
+
+	if res := RunSearch(db, url.Values{"after": {"nonsense"}}, searchNow); res.Error == "" {
+		t.Error("an invalid cursor gave no error")
+	}
+}
+
+func TestSearchCounts(t *testing.T) {
+	db := newSearchDB(t, 30)
+	res := RunSearch(db, url.Values{"q": {"| count by level"}}, searchNow)
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	if strings.Join(res.Columns, ",") != "level,count" || len(res.Counts) != 2 || len(res.Entries) != 0 {
+		t.Errorf("columns %v, counts %v", res.Columns, res.Counts)
+	}
+
+	res = RunSearch(db, url.Values{"q": {"level:ERROR | bogus"}}, searchNow)
+	if res.Error == "" || res.Caret == "" {
+		t.Errorf("syntax error %q with caret %q", res.Error, res.Caret)
+	}
+}
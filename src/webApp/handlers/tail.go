@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
+	queryparser "github.com/lauritsbonde/LogLite/src/queryParser"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+const (
+	tailKeepAlive   = 15 * time.Second // How often an idle stream writes, so proxies keep it open
+	tailMaxBackfill = 1000             // Most logs n may ask for
+)
+
+// tailEvent is a log as the tail streams send it, with the id to resume after it
+type tailEvent struct {
+	EventID string `json:"event_id"`
+	interfaces.LogEntry
+}
+
+// tailWriter writes a tail stream as Server-Sent Events or as NDJSON
+type tailWriter struct {
+	w      io.Writer
+	rc     *http.ResponseController
+	ndjson bool
+}
+
+// log writes one log. As an event it is named log and carries the event id.
+func (t tailWriter) log(event tailEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode log: %w", err)
+	}
+	if t.ndjson {
+		_, err = fmt.Fprintf(t.w, "%s\n", data)
+	} else {
+		_, err = fmt.Fprintf(t.w, "id: %s\nevent: log\ndata: %s\n\n", event.EventID, data)
+	}
+	return err
+}
+
+// notice writes an event that is not a log, such as logs being dropped. NDJSON streams only
+// hold logs and leave notices out.
+func (t tailWriter) notice(name string, data interface{}) error {
+	if t.ndjson {
+		return nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	_, err = fmt.Fprintf(t.w, "event: %s\ndata: %s\n\n", name, encoded)
+	return err
+}
+
+// keepAlive writes something the clients ignore, an SSE comment or an empty line
+func (t tailWriter) keepAlive() error {
+	text := ": keep-alive\n\n"
+	if t.ndjson {
+		text = "\n"
+	}
+	_, err := io.WriteString(t.w, text)
+	return err
+}
+
+// tailFilter compiles the q parameter into the filter of a tail, the time range only limits
+// the logs sent first
+func tailFilter(params url.Values, now time.Time) (livetail.Filter, dbhandler.Query, error) {
+	q, err := queryparser.Compile(params.Get("q"), now)
+	if err != nil {
+		return livetail.Filter{}, q, err
+	}
+	if q.IsAggregate() || !q.Until.IsZero() {
+		return livetail.Filter{}, q, errors.New("a tail streams logs as they arrive, it cannot count them or stop at until:")
+	}
+	return livetail.Filter{Query: dbhandler.Query{Conditions: q.Conditions, Text: q.Text}}, q, nil
+}
+
+// TailAPI serves GET /api/tail?q=..., a stream of the logs stored from now on that pass the
+// filters of the query. It is sent as Server-Sent Events, or as NDJSON with format=ndjson or
+// an Accept of application/x-ndjson. Every log carries an event id, a client that reconnects
+// with it in Last-Event-ID (or last_event_id) first receives the logs it missed. n sends the
+// newest n matching logs before the new ones.
+func TailAPI(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler, tail *livetail.Broadcaster) {
+	params := r.URL.Query()
+	filter, q, err := tailFilter(params, time.Now())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeQueryError(w, err, http.StatusBadRequest)
+		return
+	}
+	backfill := 0
+	if text := params.Get("n"); text != "" {
+		if backfill, err = strconv.Atoi(text); err != nil || backfill < 0 || backfill > tailMaxBackfill {
+			w.Header().Set("Content-Type", "application/json")
+			writeQueryError(w, fmt.Errorf("n must be a number from 0 to %d", tailMaxBackfill), http.StatusBadRequest)
+			return
+		}
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = params.Get("last_event_id")
+	}
+
+	// Subscribing before reading what is sent first means no log stored meanwhile is missed,
+	// the ones that are also in the backfill are dropped from the stream
+	sub := tail.Subscribe(liveBuffer)
+	defer sub.Close()
+	var first []interfaces.LogEntry
+	var missed []livetail.Entry
+	var gap error
+	if lastEventID != "" {
+		since, err := tail.ParsePosition(lastEventID)
+		complete := false
+		if err != nil {
+			gap = err
+		} else if missed, complete = sub.Replay(since); !complete {
+			gap = fmt.Errorf("the logs after event %s are no longer kept", lastEventID)
+		}
+	} else if backfill > 0 {
+		rows, err := db.Query(dbhandler.Query{Table: "logs", Conditions: q.Conditions, Text: q.Text, Since: q.Since, OrderBy: "timestamp", Limit: backfill})
+		if err == nil {
+			// Newest first from the database, oldest first in the stream
+			for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+				rows[i], rows[j] = rows[j], rows[i]
+			}
+			first, err = ConvertToLogEntries(rows)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			writeQueryError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	history := newHistoryKeys(first)
+
+	out := tailWriter{w: w, rc: http.NewResponseController(w)}
+	out.ndjson = params.Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	if out.ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keeps nginx from holding events back
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeTailStart(out, tail, sub, first, missed, filter, gap); err != nil {
+		log.Println("Error writing tail:", err)
+		return
+	}
+
+	var dropped uint64
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var batch []livetail.Entry
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if err := out.keepAlive(); err != nil {
+				return
+			}
+			if err := out.rc.Flush(); err != nil {
+				return
+			}
+			continue
+		case entry, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			batch = append(batch, entry)
+		}
+		batch = receiveWaiting(sub.C, batch)
+
+		if n := sub.Dropped(); n != dropped {
+			if err := out.notice("dropped", map[string]uint64{"dropped": n - dropped}); err != nil {
+				return
+			}
+			dropped = n
+		}
+		if err := writeTailEntries(out, tail, batch, filter, history); err != nil {
+			return
+		}
+		if err := out.rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeTailStart writes the logs sent before the new ones: the newest matching logs, or the
+// ones a reconnecting client missed. A client that cannot catch up is told with a gap event.
+func writeTailStart(out tailWriter, tail *livetail.Broadcaster, sub *livetail.Subscription, first []interfaces.LogEntry, missed []livetail.Entry, filter livetail.Filter, gap error) error {
+	if gap != nil {
+		if err := out.notice("gap", map[string]string{"error": gap.Error()}); err != nil {
+			return err
+		}
+	}
+	// Logs read from the database precede the subscription, resuming after them starts there
+	start := tail.Position(sub.Start)
+	for _, entry := range first {
+		if err := out.log(tailEvent{EventID: start, LogEntry: entry}); err != nil {
+			return err
+		}
+	}
+	if err := writeTailEntries(out, tail, missed, filter, nil); err != nil {
+		return err
+	}
+	return out.rc.Flush()
+}
+
+// writeTailEntries writes the published logs that pass the filter, leaving out the ones
+// already sent from history
+func writeTailEntries(out tailWriter, tail *livetail.Broadcaster, entries []livetail.Entry, filter livetail.Filter, history historyKeys) error {
+	for _, entry := range entries {
+		if !filter.Match(entry.Row) || history.repeated(entry) {
+			continue
+		}
+		for _, logEntry := range LiveLogEntries([]livetail.Entry{entry}) {
+			if err := out.log(tailEvent{EventID: tail.Position(entry.Seq), LogEntry: logEntry}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
+)
+
+type tailTest struct {
+	db     dbhandler.DBHandler
+	tail   *livetail.Broadcaster
+	server *httptest.Server
+}
+
+// newTailTest serves TailAPI over a memory database holding logs "log 1" to "log 3"
+func newTailTest(t *testing.T) *tailTest {
+	t.Helper()
+	mem, _ := dbhandler.NewMemoryHandler(100)
+	tt := &tailTest{tail: livetail.NewBroadcaster()}
+	tt.db = livetail.NewPublishingHandler(mem, tt.tail)
+	tt.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TailAPI(w, r, tt.db, tt.tail)
+	}))
+	t.Cleanup(tt.server.Close)
+	for i := 1; i <= 3; i++ {
+		tt.put(t, "log "+string(rune('0'+i)), "INFO")
+	}
+	return tt
+}
+
+func (tt *tailTest) put(t *testing.T, message, level string) {
+	t.Helper()
+	err := tt.db.Put("logs", map[string]interface{}{"timestamp": time.Now().UTC(), "level": level, "message": message})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// open starts a stream and returns its lines without the blank ones and keep-alives
+func (tt *tailTest) open(t *testing.T, params url.Values, header http.Header) (*http.Response, <-chan string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", tt.server.URL+"?"+params.Encode(), nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" && !strings.HasPrefix(line, ":") {
+				lines <- line
+			}
+		}
+	}()
+	return res, lines
+}
+
+func next(t *testing.T, lines <-chan string) string {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("no line from the stream")
+		return ""
+	}
+}
+
+// nextLog reads an NDJSON log as a map, so a missing field can be told from a zero one
+func nextLog(t *testing.T, lines <-chan string) map[string]interface{} {
+	t.Helper()
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(next(t, lines)), &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestTailAPIBackfillAndLive(t *testing.T) {
+	tt := newTailTest(t)
+	res, lines := tt.open(t, url.Values{"n": {"2"}, "format": {"ndjson"}}, nil)
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type %s", ct)
+	}
+
+	// The newest n logs first, oldest first, with their ids
+	for _, want := range []struct {
+		message string
+		id      float64
+	}{{"log 2", 2}, {"log 3", 3}} {
+		event := nextLog(t, lines)
+		if event["message"] != want.message || event["id"] != want.id {
+			t.Errorf("backfill %v, want %s with id %v", event, want.message, want.id)
+		}
+	}
+
+	// Then the logs stored from now on, without an id the database did not report
+	tt.put(t, "log 4", "INFO")
+	event := nextLog(t, lines)
+	if event["message"] != "log 4" || event["event_id"] != tt.tail.Position(4) {
+		t.Errorf("live log %v", event)
+	}
+	if _, ok := event["id"]; ok {
+		t.Errorf("live log has an id: %v", event)
+	}
+}
+
+func TestTailAPIFilter(t *testing.T) {
+	tt := newTailTest(t)
+	_, lines := tt.open(t, url.Values{"q": {"level:ERROR"}, "format": {"ndjson"}}, nil)
+
+	// Wait for the stream to subscribe before storing
+	for deadline := time.Now().Add(2 * time.Second); tt.tail.Subscribers() == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	tt.put(t, "skipped", "INFO")
+	tt.put(t, "failed", "ERROR")
+	if event := nextLog(t, lines); event["message"] != "failed" {
+		t.Errorf("got %v", event)
+	}
+}
+
+func TestTailAPILastEventID(t *testing.T) {
+	tt := newTailTest(t)
+	tests := []struct {
+		name string
+		id   string
+		want []string
+	}{
+		{"missed", tt.tail.Position(1), []string{
+			"id: " + tt.tail.Position(2), "event: log", `data: {"event_id":"` + tt.tail.Position(2),
+			"id: " + tt.tail.Position(3), "event: log", `data: {"event_id":"` + tt.tail.Position(3),
+		}},
+		{"before a restart", "earlier:1", []string{"event: gap", `data: {"error":"live tail position \"earlier:1\" is from before a restart"}`}},
+		{"ahead", tt.tail.Position(9), []string{"event: gap", `data: {"error":"the logs after event ` + tt.tail.Position(9) + ` are no longer kept"}`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, lines := tt.open(t, nil, http.Header{"Last-Event-ID": {test.id}})
+			if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type %s", ct)
+			}
+			for _, want := range test.want {
+				if line := next(t, lines); !strings.HasPrefix(line, want) {
+					t.Errorf("got %s, want %s", line, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTailAPIInvalid(t *testing.T) {
+	tt := newTailTest(t)
+	for _, params := range []url.Values{
+		{"n": {"many"}},
+		{"n": {"1001"}},
+		{"n": {"-1"}},
+		{"q": {"level:ERROR | count"}},
+		{"q": {"until:1h"}},
+		{"q": {"level:"}},
+	} {
+		res, err := http.Get(tt.server.URL + "?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: status %d, want 400", params, res.StatusCode)
+		}
+	}
+}
@@ -2,19 +2,51 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/a-h/templ"
 	"github.com/gorilla/websocket"
 	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
 	"github.com/lauritsbonde/LogLite/src/webApp/components"
 )
 
 var upgrader = websocket.Upgrader{}
 
-func LiveLogs(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
-	println("LiveLogs")
+const (
+	liveBuffer  = 1024            // Logs buffered per connection before newer ones are dropped
+	liveBatch   = 200             // Most logs sent in one message
+	liveWaiting = 5000            // Most logs held back while the table is paused
+	liveOpen    = 2 * time.Second // How long to wait for the filter form before showing every log
+)
+
+// liveTable is the state of one connected live table
+type liveTable struct {
+	ctx  context.Context
+	conn *websocket.Conn
+	db   dbhandler.DBHandler
+	tail *livetail.Broadcaster
+
+	filter  livetail.Filter
+	current string // Key of the subscription the filter was made from
+	sub     *livetail.Subscription
+	history historyKeys // Logs the table started with, which can arrive again from sub
+	seen    uint64      // Seq of the last log received, shown or not
+	dropped uint64
+
+	paused   bool
+	waiting  []livetail.Entry // Matching logs received while paused
+	overflow bool             // More than liveWaiting logs came in while paused
+}
+
+// LiveLogs pushes the newest logs and every log stored after them to the live table. The table
+// sends its filter form when it connects and whenever the form changes, which restarts the
+// table with the logs that pass it. A table that reconnects sends the position of the last log
+// it showed and only receives the logs it missed. While paused, logs are held back and counted.
+func LiveLogs(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler, tail *livetail.Broadcaster) {
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("websocket upgrader: ", err)
@@ -22,35 +54,260 @@ func LiveLogs(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
 	}
 	defer c.Close()
 
-	// Continuously fetch logs and send to the client
+	messages := make(chan liveMessage)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go readLiveMessages(c, messages, closed, done)
+
+	t := &liveTable{ctx: r.Context(), conn: c, db: db, tail: tail}
+	defer func() {
+		if t.sub != nil {
+			t.sub.Close()
+		}
+	}()
+
+	// The form is sent as soon as the socket opens, a table without it shows every log
+	var first liveMessage
+	select {
+	case <-closed:
+		return
+	case <-t.ctx.Done():
+		return
+	case first = <-messages:
+	case <-time.After(liveOpen):
+	}
+	if err := t.open(first); err != nil {
+		log.Println("Error starting live logs:", err)
+		return
+	}
+
 	for {
-		println("getting logs")
-		logs, err := GetLogs(db)
+		var batch []livetail.Entry
+		select {
+		case <-closed:
+			return
+		case <-t.ctx.Done():
+			// The database is being replaced, the table reconnects and catches up
+			c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseServiceRestart, "reconfigured"), time.Now().Add(time.Second))
+			return
+		case m := <-messages:
+			if err := t.handle(m); err != nil {
+				log.Println("Error updating live logs:", err)
+				return
+			}
+			continue
+		case entry, ok := <-t.sub.C:
+			if !ok {
+				return
+			}
+			batch = append(batch, entry)
+		}
+		if err := t.receive(receiveWaiting(t.sub.C, batch)); err != nil {
+			log.Println("WebSocket write error:", err)
+			return
+		}
+	}
+}
+
+// readLiveMessages passes the messages the client sends to messages until done is closed,
+// and closes closed when the client goes away
+func readLiveMessages(c *websocket.Conn, messages chan<- liveMessage, closed chan<- struct{}, done <-chan struct{}) {
+	defer close(closed)
+	for {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		m, err := parseLiveMessage(data)
 		if err != nil {
-			log.Println("Error getting logs from DB:", err)
-			time.Sleep(1 * time.Second) // Avoid tight looping on error
+			log.Println("Error reading live table message:", err)
 			continue
 		}
+		select {
+		case messages <- m:
+		case <-done:
+			return
+		}
+	}
+}
+
+// open starts the table with the first filter form. A filter that cannot be applied shows
+// every log rather than none, along with the error.
+func (t *liveTable) open(m liveMessage) error {
+	filter, filterErr := m.filter(time.Now())
+	if filterErr != nil {
+		filter, m.liveSubscription = livetail.Filter{}, liveSubscription{}
+	}
+	t.filter, t.current = filter, m.key()
+	if err := t.catchUp(m.Since); err != nil {
+		return err
+	}
+	if filterErr != nil {
+		return t.write(components.LiveLogFilterError(filterErr.Error()))
+	}
+	return nil
+}
+
+// catchUp sends the logs stored after the position of the last log a previous connection
+// showed, the rows of the table stay. Without a position, or when too much was missed, the
+// table starts over.
+func (t *liveTable) catchUp(position string) error {
+	if position == "" {
+		return t.restart()
+	}
+	since, err := t.tail.ParsePosition(position)
+	if err != nil {
+		return t.restart()
+	}
+
+	sub := t.tail.Subscribe(liveBuffer)
+	missed, complete := sub.Replay(since)
+	if !complete {
+		sub.Close()
+		return t.restart()
+	}
+	t.sub, t.seen, t.history = sub, sub.Start, nil
+	return t.send(t.matching(missed), components.LiveLogDropped(0), components.LiveLogControls(false, 0, false))
+}
+
+// restart subscribes to the logs that pass the filter and replaces the rows of the table with
+// the newest of them
+func (t *liveTable) restart() error {
+	if t.sub != nil {
+		// Logs waiting on the old subscription are in the new history if they pass the filter
+		t.sub.Close()
+		t.sub = nil
+	}
+	t.dropped, t.paused, t.waiting, t.overflow = 0, false, nil, false
+
+	// Subscribing first means no log stored meanwhile is missed, repeats are dropped by history
+	sub := t.tail.Subscribe(liveBuffer)
+	logs, next, err := liveHistory(t.db, t.filter)
+	if err != nil {
+		sub.Close()
+		t.write(components.LiveLogFilterError("Failed to read logs: " + err.Error()))
+		return err
+	}
+	t.sub, t.seen, t.history = sub, sub.Start, newHistoryKeys(logs)
+	return t.write(
+		components.LiveLogHistory(logs, next),
+		components.LivePosition(t.tail.Position(t.seen)),
+		components.LiveLogDropped(0),
+		components.LiveLogFilterError(""),
+		components.LiveLogControls(false, 0, false),
+	)
+}
+
+// handle applies a message of the client: pause, resume or a changed filter
+func (t *liveTable) handle(m liveMessage) error {
+	switch m.Action {
+	case "pause":
+		if t.paused {
+			return nil
+		}
+		t.paused = true
+		return t.write(components.LiveLogControls(true, 0, false))
+	case "resume":
+		if !t.paused {
+			return nil
+		}
+		if t.overflow {
+			return t.restart()
+		}
+		waiting := t.waiting
+		t.paused, t.waiting = false, nil
+		return t.send(waiting, components.LiveLogControls(false, 0, false))
+	}
 
-		// Render log entries into HTML
-		var buffer bytes.Buffer
-		for _, logEntry := range logs {
-			component := components.LiveLogEntry(logEntry)
-			err := component.Render(r.Context(), &buffer)
-			if err != nil {
-				log.Println("Error rendering log entry:", err)
-				continue // Skip this entry and continue with others
+	if m.key() == t.current {
+		return nil
+	}
+	filter, err := m.filter(time.Now())
+	if err != nil {
+		return t.write(components.LiveLogFilterError(err.Error()))
+	}
+	t.filter, t.current = filter, m.key()
+	return t.restart()
+}
+
+// receive shows the logs that pass the filter, or holds them back while paused
+func (t *liveTable) receive(batch []livetail.Entry) error {
+	var extra []templ.Component
+	if n := t.sub.Dropped(); n != t.dropped {
+		t.dropped = n
+		extra = append(extra, components.LiveLogDropped(n))
+	}
+	matched := t.matching(batch)
+	// The position moves past logs left out by the filter too, they need no catching up on
+	t.seen = batch[len(batch)-1].Seq
+
+	if t.paused {
+		if !t.overflow {
+			t.waiting = append(t.waiting, matched...)
+			if len(t.waiting) > liveWaiting {
+				// Too many to show on resume, the table starts over instead
+				t.waiting, t.overflow = nil, true
 			}
 		}
+		if len(matched) == 0 && len(extra) == 0 {
+			return nil
+		}
+		return t.write(append(extra, components.LiveLogControls(true, len(t.waiting), t.overflow))...)
+	}
+	return t.send(matched, extra...)
+}
 
-		// Send rendered logs to the WebSocket client
-		err = c.WriteMessage(websocket.TextMessage, buffer.Bytes())
-		if err != nil {
-			log.Println("WebSocket write error:", err)
-			break
+// matching returns the entries that pass the filter and are not in the history already
+func (t *liveTable) matching(entries []livetail.Entry) []livetail.Entry {
+	var matched []livetail.Entry
+	for _, entry := range entries {
+		if t.filter.Match(entry.Row) && !t.history.repeated(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// send puts the entries on top of the table, newest first, and records the position of the
+// last log received for catching up after a reconnect. The extra components are sent in the
+// same message. Nothing is sent without either, catching up from an older position only
+// repeats logs the filter leaves out.
+func (t *liveTable) send(entries []livetail.Entry, extra ...templ.Component) error {
+	if len(entries) == 0 && len(extra) == 0 {
+		return nil
+	}
+	parts := make([]templ.Component, 0, len(entries)+len(extra)+1)
+	for _, logEntry := range LiveLogEntries(entries) {
+		parts = append(parts, components.LiveLogEntry(logEntry))
+	}
+	parts = append(parts, components.LivePosition(t.tail.Position(t.seen)))
+	return t.write(append(parts, extra...)...)
+}
+
+// write renders the components into one message
+func (t *liveTable) write(parts ...templ.Component) error {
+	var buffer bytes.Buffer
+	for _, part := range parts {
+		if err := part.Render(t.ctx, &buffer); err != nil {
+			log.Println("Error rendering live logs:", err)
 		}
+	}
+	return t.conn.WriteMessage(websocket.TextMessage, buffer.Bytes())
+}
 
-		// Sleep for some time before fetching the next batch
-		time.Sleep(1 * time.Second)
+// receiveWaiting adds the entries already waiting on ch to batch, up to liveBatch of them
+func receiveWaiting(ch <-chan livetail.Entry, batch []livetail.Entry) []livetail.Entry {
+	for len(batch) < liveBatch {
+		select {
+		case entry, ok := <-ch:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, entry)
+		default:
+			return batch
+		}
 	}
-}
\ No newline at end of file
+	return batch
+}
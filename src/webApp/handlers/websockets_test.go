@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// liveSince reads the position the live table keeps in its form
+var liveSince = regexp.MustCompile(`id="live-log-since" name="since" value="([^"]*)"`)
+
+type liveTest struct {
+	*tailTest
+	url string
+}
+
+// newLiveTest serves LiveLogs over the database of a tail test, holding logs "log 1" to "log 3"
+func newLiveTest(t *testing.T) *liveTest {
+	t.Helper()
+	tt := newTailTest(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LiveLogs(w, r, tt.db, tt.tail)
+	}))
+	t.Cleanup(server.Close)
+	return &liveTest{tailTest: tt, url: "ws" + strings.TrimPrefix(server.URL, "http")}
+}
+
+// connect opens a live table that sends form as its first message
+func (lt *liveTest) connect(t *testing.T, form string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(lt.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	lt.send(t, conn, form)
+	return conn
+}
+
+func (lt *liveTest) send(t *testing.T, conn *websocket.Conn, message string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive reads the next message of the table
+func receive(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("no message from the live table: %v", err)
+	}
+	return string(data)
+}
+
+// receiveUntil reads messages until one contains want
+func receiveUntil(t *testing.T, conn *websocket.Conn, want string) string {
+	t.Helper()
+	for {
+		if message := receive(t, conn); strings.Contains(message, want) {
+			return message
+		}
+	}
+}
+
+// shows returns the log messages that are cells of the message
+func shows(message string, messages ...string) []string {
+	shown := []string{}
+	for _, m := range messages {
+		if strings.Contains(message, ">"+m+"<") {
+			shown = append(shown, m)
+		}
+	}
+	return shown
+}
+
+func TestLiveLogsFilter(t *testing.T) {
+	lt := newLiveTest(t)
+	conn := lt.connect(t, `{}`)
+
+	// The table starts with the newest logs and the position to continue from
+	first := receive(t, conn)
+	if !strings.Contains(first, `hx-swap-oob="innerHTML:#live-log-rows"`) || len(shows(first, "log 1", "log 2", "log 3")) != 3 {
+		t.Fatalf("first message: %s", first)
+	}
+	if !liveSince.MatchString(first) {
+		t.Errorf("no position in %s", first)
+	}
+
+	// A changed form starts over with the logs that pass it
+	lt.put(t, "failed", "ERROR")
+	receiveUntil(t, conn, ">failed<")
+	lt.send(t, conn, `{"level": "ERROR"}`)
+	restarted := receiveUntil(t, conn, "innerHTML:#live-log-rows")
+	if got := shows(restarted, "log 1", "failed"); len(got) != 1 || got[0] != "failed" {
+		t.Errorf("restarted with %v", got)
+	}
+	lt.put(t, "log 4", "INFO")
+	lt.put(t, "failed again", "ERROR")
+	if message := receive(t, conn); len(shows(message, "log 4", "failed again")) != 1 || !strings.Contains(message, ">failed again<") {
+		t.Errorf("after the filter: %s", message)
+	}
+
+	// A form that cannot be applied keeps the filter and says why
+	lt.send(t, conn, `{"text": "(", "regex": "on"}`)
+	if message := receiveUntil(t, conn, "live-log-filter-error"); !strings.Contains(message, "invalid regular expression") {
+		t.Errorf("invalid filter: %s", message)
+	}
+}
+
+func TestLiveLogsPauseResume(t *testing.T) {
+	lt := newLiveTest(t)
+	conn := lt.connect(t, `{}`)
+	receive(t, conn)
+
+	lt.send(t, conn, `{"action": "pause"}`)
+	receiveUntil(t, conn, ">Resume<")
+	lt.put(t, "log 4", "INFO")
+	lt.put(t, "log 5", "INFO")
+	waiting := receiveUntil(t, conn, "2 new entries")
+	if got := shows(waiting, "log 4", "log 5"); len(got) != 0 {
+		t.Errorf("paused table showed %v", got)
+	}
+
+	// Resuming shows what was held back, each row goes on top so the oldest is sent first
+	lt.send(t, conn, `{"action": "resume"}`)
+	resumed := receiveUntil(t, conn, ">Pause<")
+	if got := shows(resumed, "log 4", "log 5"); len(got) != 2 || strings.Index(resumed, ">log 4<") > strings.Index(resumed, ">log 5<") {
+		t.Errorf("resumed with %v: %s", got, resumed)
+	}
+	lt.put(t, "log 6", "INFO")
+	if message := receive(t, conn); len(shows(message, "log 6")) != 1 {
+		t.Errorf("after resuming: %s", message)
+	}
+}
+
+func TestLiveLogsReconnect(t *testing.T) {
+	lt := newLiveTest(t)
+	conn := lt.connect(t, `{"level": "INFO"}`)
+	receive(t, conn)
+	lt.put(t, "log 4", "INFO")
+	position := liveSince.FindStringSubmatch(receiveUntil(t, conn, ">log 4<"))[1]
+	conn.Close()
+
+	// Logs stored while the table was gone, it comes back with the position it had
+	lt.put(t, "log 5", "INFO")
+	lt.put(t, "failed", "ERROR")
+	lt.put(t, "log 6", "INFO")
+	conn = lt.connect(t, `{"level": "INFO", "since": "`+position+`"}`)
+	caughtUp := receive(t, conn)
+	if strings.Contains(caughtUp, "innerHTML:#live-log-rows") {
+		t.Errorf("the table started over instead of catching up: %s", caughtUp)
+	}
+	if got := shows(caughtUp, "log 4", "log 5", "failed", "log 6"); strings.Join(got, ",") != "log 5,log 6" {
+		t.Errorf("caught up with %v", got)
+	}
+
+	// A position it cannot continue from starts the table over
+	conn = lt.connect(t, `{"since": "unknown"}`)
+	if message := receive(t, conn); !strings.Contains(message, "innerHTML:#live-log-rows") || len(shows(message, "log 6", "failed")) != 2 {
+		t.Errorf("unknown position: %s", message)
+	}
+}
@@ -0,0 +1,17 @@
+package interfaces
+
+// LogDetail is what the detail panel shows about a log
+type LogDetail struct {
+	Entry    LogEntry
+	Metadata interface{} // Parsed metadata, nil when the log has none or it is not JSON
+	JSON     string      // The log as indented JSON, for copying
+}
+
+// LogContext is the logs around a log that share its source or address, newest first
+type LogContext struct {
+	Entry LogEntry
+	By    string // The field the logs share
+	N     int    // Logs asked for on each side
+	Newer []LogEntry
+	Older []LogEntry
+}
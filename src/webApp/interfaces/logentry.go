@@ -1,15 +1,29 @@
 package interfaces
 
-import "time"
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+)
 
 type LogEntry struct {
-	ID        int       `db:"id"`         // Maps to PRIMARY KEY
-	Timestamp time.Time `db:"timestamp"`  // Maps to timestamp
-	Level     string    `db:"level"`      // Maps to level
-	Message   string    `db:"message"`    // Maps to message
-	Source    *string   `db:"source"`     // Maps to source (nullable)
-	Method    *string   `db:"method"`     // Maps to method (nullable)
-	Address   *string   `db:"address"`    // Maps to address (nullable)
-	Length    *int      `db:"length"`     // Maps to length (nullable)
-	Metadata  *string   `db:"metadata"`   // Maps to metadata (nullable)
-}
\ No newline at end of file
+	ID         int        `db:"id" json:"id,omitempty"`         // Maps to PRIMARY KEY, 0 for live logs that do not know it
+	Timestamp  time.Time  `db:"timestamp" json:"timestamp"`     // Maps to timestamp
+	Level      string     `db:"level" json:"level"`             // Maps to level
+	Message    string     `db:"message" json:"message"`         // Maps to message
+	Source     *string    `db:"source" json:"source"`           // Maps to source (nullable)
+	Method     *string    `db:"method" json:"method"`           // Maps to method (nullable)
+	Address    *string    `db:"address" json:"address"`         // Maps to address (nullable)
+	Length     *int       `db:"length" json:"length"`           // Maps to length (nullable)
+	Metadata   *string    `db:"metadata" json:"metadata"`       // Maps to metadata (nullable)
+	Label      *string    `db:"label" json:"label"`             // Maps to label (nullable)
+	ReceivedAt *time.Time `db:"received_at" json:"received_at"` // When LogLite received the log (nullable)
+}
+
+// MessageHash is a short hash of the message. Live rows have no id yet, the timestamp and
+// this hash find them in the database.
+func (e LogEntry) MessageHash() string {
+	h := fnv.New32a()
+	h.Write([]byte(e.Message))
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
@@ -0,0 +1,58 @@
+package interfaces
+
+import "time"
+
+// SearchForm is what the search page was asked for, it fills the form back in
+type SearchForm struct {
+	Query     string
+	Levels    []string // Selected facet values, a log must have one of them
+	Sources   []string
+	Labels    []string
+	Range     string // Relative range such as "15m", From and To take its place when set
+	From      string // Absolute range as datetime-local values in the viewer's timezone
+	To        string
+	TZ        int // Viewer's offset from UTC in minutes
+	Sort      string
+	Ascending bool
+}
+
+// FacetValue is a value of a field with the number of matching logs that have it
+type FacetValue struct {
+	Value    string
+	Count    int64
+	Selected bool
+}
+
+// Facet lists the most common values of a field among the matching logs
+type Facet struct {
+	Field  string
+	Name   string // Form field the values are selected with
+	Values []FacetValue
+}
+
+// SearchResults is a page of the search page's results, or its counts for aggregate queries
+type SearchResults struct {
+	Form    SearchForm
+	Entries []LogEntry
+	Columns []string // Columns of Counts
+	Counts  []map[string]interface{}
+	Facets  []Facet
+	Prev    map[string]string // Parameters that load the neighbouring pages, nil when there is none
+	Next    map[string]string
+	Page    int // Number of the page, counted from the newest logs for the timestamp sort
+	Chart   string // Where the histogram of the matching logs loads from
+	Error   string
+	Caret   string // Points at the position of a syntax error
+}
+
+// Histogram is the number of matching logs per time bucket, split by level
+type Histogram struct {
+	Since  time.Time // Start of the first bucket
+	Until  time.Time // End of the last bucket
+	Bucket time.Duration
+	Times  []time.Time // Start of every bucket
+	Levels []string    // Stacked from the bottom, the level with the most logs first
+	Counts [][]int64   // Logs per level and bucket
+	Max    int64       // Logs in the fullest bucket
+	Error  string
+}
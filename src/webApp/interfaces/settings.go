@@ -0,0 +1,13 @@
+package interfaces
+
+import confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+
+// SettingsForm is the settings page's form of the running configuration
+type SettingsForm struct {
+	Values    map[string]string              // The settings as shown in the form, keyed like the config file
+	Errors    confighandler.ValidationErrors // Problems, shown next to their setting
+	Changes   []confighandler.Change         // What the form changes in the running configuration
+	Previewed bool                           // The changes were listed and can be applied
+	Message   string                         // Confirms what happened
+	Error     string                         // Why applying failed
+}
@@ -0,0 +1,71 @@
+package interfaces
+
+// SetupSteps are the titles of the setup wizard's steps, the last one tests the answers
+var SetupSteps = []string{"Collect logs", "Database", "Logging", "Test and apply"}
+
+// SetupKeys are every setting the setup wizard can ask for, keyed like the config file
+var SetupKeys = []string{
+	"log_handler.mode", "log_handler.send.protocol", "log_handler.send.port", "log_handler.scrape.type",
+	"database.type", "database.sqlite_filepath", "database.partition_dir", "database.partition_by",
+	"database.postgres_url", "database.memory_capacity", "database.segment_dir", "database.segment_window",
+	"log_level", "log_file",
+}
+
+// SetupCheck is a setting tried out on this machine before the configuration is applied
+type SetupCheck struct {
+	Name   string
+	Detail string // What was checked, or why it failed
+	OK     bool
+}
+
+// SetupForm is a step of the setup wizard. Values hold the answers of every step, the other
+// steps' answers travel along as hidden inputs.
+type SetupForm struct {
+	SettingsForm
+	Step   int
+	Checks []SetupCheck // Results of the test step
+	Done   bool         // The configuration runs
+}
+
+// Fields are the settings a step asks for, which depend on the answers given so far
+func (f SetupForm) Fields(step int) []string {
+	switch step {
+	case 0:
+		if f.Values["log_handler.mode"] == "scrape" {
+			return []string{"log_handler.mode", "log_handler.scrape.type"}
+		}
+		return []string{"log_handler.mode", "log_handler.send.protocol", "log_handler.send.port"}
+	case 1:
+		switch f.Values["database.type"] {
+		case "SQLite":
+			return []string{"database.type", "database.sqlite_filepath"}
+		case "PartitionedSQLite":
+			return []string{"database.type", "database.partition_dir", "database.partition_by"}
+		case "PostgreSQL":
+			return []string{"database.type", "database.postgres_url"}
+		case "Memory":
+			return []string{"database.type", "database.memory_capacity"}
+		case "Segment":
+			return []string{"database.type", "database.segment_dir", "database.segment_window"}
+		}
+		return []string{"database.type"}
+	case 2:
+		return []string{"log_level", "log_file"}
+	}
+	return nil
+}
+
+// Shown reports whether the current step asks for a setting
+func (f SetupForm) Shown(key string) bool {
+	for _, field := range f.Fields(f.Step) {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Last reports whether the form is at the test step
+func (f SetupForm) Last() bool {
+	return f.Step == len(SetupSteps)-1
+}
@@ -0,0 +1,25 @@
+package interfaces
+
+import (
+	"time"
+
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+// StorageStatus is what the settings page shows about database usage and retention
+type StorageStatus struct {
+	Supported bool // False when the database backend does not support retention
+	Stats     dbhandler.StorageStats
+	StatsErr  error
+	Policy    dbhandler.RetentionPolicy
+	LastPrune *dbhandler.PruneResult
+}
+
+// BackupStatus is what the settings page shows about database snapshots
+type BackupStatus struct {
+	Supported  bool // False when the database backend cannot be backed up
+	Interval   time.Duration
+	Files      []dbhandler.BackupFile
+	FilesErr   error
+	LastBackup *dbhandler.BackupResult
+}
@@ -0,0 +1,101 @@
+package webapp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/a-h/templ"
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+	"github.com/lauritsbonde/LogLite/src/webApp/components"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+// settingsValues shows a configuration in the settings form, with the PostgreSQL password hidden
+func settingsValues(config confighandler.Config) map[string]string {
+	values := map[string]string{}
+	for _, s := range confighandler.Settings(config) {
+		values[s.Key] = s.Value
+	}
+	values["database.postgres_url"] = confighandler.RedactURL(config.Database.PostgresURL)
+	return values
+}
+
+// settingsForm reads the posted settings form onto the running configuration
+func (app *WebApp) settingsForm(r *http.Request) (confighandler.Config, interfaces.SettingsForm, error) {
+	form := interfaces.SettingsForm{Values: map[string]string{}}
+	if err := r.ParseForm(); err != nil {
+		return confighandler.Config{}, form, err
+	}
+	running := *app.State().Configuration
+	for _, s := range confighandler.Settings(running) {
+		if value, ok := r.PostForm[s.Key]; ok {
+			form.Values[s.Key] = strings.Join(value, ",")
+		}
+	}
+	config, err := confighandler.ApplySettings(running, form.Values)
+	if err != nil {
+		form.Errors, _ = err.(confighandler.ValidationErrors)
+		return config, form, nil
+	}
+	form.Changes = confighandler.Diff(running, config)
+	if len(form.Changes) == 0 {
+		form.Message = "Nothing changed."
+	}
+	return config, form, nil
+}
+
+// settingsPreviewHandler validates the settings form and lists what it would change
+func (app *WebApp) settingsPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	_, form, err := app.settingsForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form.Previewed = len(form.Errors) == 0 && len(form.Changes) > 0
+	templ.Handler(components.SettingsForm(form)).ServeHTTP(w, r)
+}
+
+// settingsApplyHandler applies the settings form through the main thread, like the setup
+// page, and saves the configuration once it runs
+func (app *WebApp) settingsApplyHandler(w http.ResponseWriter, r *http.Request) {
+	config, form, err := app.settingsForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(form.Errors) > 0 || len(form.Changes) == 0 {
+		templ.Handler(components.SettingsForm(form)).ServeHTTP(w, r)
+		return
+	}
+
+	if err := app.applyConfig(&config); err != nil {
+		form.Error = err.Error()
+		templ.Handler(components.SettingsForm(form)).ServeHTTP(w, r)
+		return
+	}
+
+	applied := interfaces.SettingsForm{Values: settingsValues(config), Changes: form.Changes}
+	if err := confighandler.SaveConfig(config, app.ConfigPath); err != nil {
+		log.Printf("error saving new config %v \n", err)
+		applied.Error = fmt.Sprintf("The settings are applied but could not be saved, they are lost on restart: %v", err)
+	} else {
+		applied.Message = "Applied and saved to " + app.ConfigPath + "."
+	}
+	templ.Handler(components.SettingsForm(applied)).ServeHTTP(w, r)
+}
+
+// applyConfig hands a configuration to the main thread and waits until it runs
+func (app *WebApp) applyConfig(config *confighandler.Config) error {
+	responseCh := make(chan string)
+	app.SettingsChan <- ConfigMessage{
+		NewConfig:  config,
+		ResponseCh: responseCh,
+	}
+	response := <-responseCh
+	if strings.HasPrefix(response, "Error") {
+		return fmt.Errorf("%s", response)
+	}
+	return nil
+}
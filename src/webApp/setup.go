@@ -0,0 +1,227 @@
+package webapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/jackc/pgx/v5"
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+	"github.com/lauritsbonde/LogLite/src/webApp/components"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+// newSetupForm starts the setup wizard with the default configuration
+func newSetupForm() interfaces.SetupForm {
+	defaults := settingsValues(confighandler.DefaultConfig())
+	values := map[string]string{}
+	for _, key := range interfaces.SetupKeys {
+		values[key] = defaults[key]
+	}
+	return interfaces.SetupForm{SettingsForm: interfaces.SettingsForm{Values: values}}
+}
+
+// setupConfig is the default configuration with the wizard's answers
+func setupConfig(values map[string]string) (confighandler.Config, confighandler.ValidationErrors) {
+	config, err := confighandler.ApplySettings(confighandler.DefaultConfig(), values)
+	errs, _ := err.(confighandler.ValidationErrors)
+	if config.LogHandler.Mode == "scrape" {
+		errs = append(errs, confighandler.FieldError{Field: "log_handler.mode", Msg: "scraping logs is not supported yet"})
+	}
+	return config, errs
+}
+
+// stepErrors are the problems with the settings a step asks for
+func stepErrors(form interfaces.SetupForm, errs confighandler.ValidationErrors, step int) confighandler.ValidationErrors {
+	var found confighandler.ValidationErrors
+	for _, field := range form.Fields(step) {
+		for _, fe := range errs {
+			if fe.Field == field {
+				found = append(found, fe)
+			}
+		}
+	}
+	return found
+}
+
+// setupHandler runs the setup wizard. Every step is validated before the next one, the last
+// step tries the port and storage out, and applying saves the configuration only once the
+// main thread runs it.
+func (app *WebApp) setupHandler(w http.ResponseWriter, r *http.Request) {
+	if app.State().Configuration.Version != "" {
+		http.Error(w, "LogLite is already set up, change its configuration on the settings page", http.StatusConflict)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := newSetupForm()
+	for _, key := range interfaces.SetupKeys {
+		if value, ok := r.PostForm[key]; ok {
+			form.Values[key] = value[0]
+		}
+	}
+	form.Step, _ = strconv.Atoi(r.PostForm.Get("step"))
+	form.Step = max(0, min(form.Step, len(interfaces.SetupSteps)-1))
+	config, errs := setupConfig(form.Values)
+
+	switch r.PostForm.Get("action") {
+	case "back":
+		form.Step = max(0, form.Step-1)
+	case "next":
+		if form.Errors = stepErrors(form, errs, form.Step); len(form.Errors) == 0 && !form.Last() {
+			form.Step++
+			if form.Last() {
+				form.Checks = setupChecks(config)
+			}
+		}
+	case "test":
+		form.Checks = setupChecks(config)
+	case "apply":
+		app.applySetup(&form, config, errs)
+	}
+	templ.Handler(components.SetupWizard(form)).ServeHTTP(w, r)
+}
+
+// applySetup validates every step again, since the hidden answers can be changed, tests them
+// and applies the configuration through the main thread before saving it
+func (app *WebApp) applySetup(form *interfaces.SetupForm, config confighandler.Config, errs confighandler.ValidationErrors) {
+	for step := range interfaces.SetupSteps {
+		if form.Errors = stepErrors(*form, errs, step); len(form.Errors) > 0 {
+			form.Step = step
+			return
+		}
+	}
+	form.Step = len(interfaces.SetupSteps) - 1
+	form.Checks = setupChecks(config)
+	for _, check := range form.Checks {
+		if !check.OK {
+			return
+		}
+	}
+
+	if err := app.applyConfig(&config); err != nil {
+		form.Error = err.Error()
+		return
+	}
+	form.Done = true
+	if err := confighandler.SaveConfig(config, app.ConfigPath); err != nil {
+		log.Printf("error saving new config %v \n", err)
+		form.Error = fmt.Sprintf("LogLite runs with the new configuration, but it could not be saved and the setup starts over after a restart: %v", err)
+		return
+	}
+	form.Message = "Saved to " + app.ConfigPath + "."
+}
+
+// setupChecks tries out the port and the storage of a configuration on this machine
+func setupChecks(config confighandler.Config) []interfaces.SetupCheck {
+	var checks []interfaces.SetupCheck
+	if config.LogHandler.Mode == "send" {
+		send := config.LogHandler.Send
+		checks = append(checks, setupCheck(fmt.Sprintf("%s port %d", send.Protocol, send.Port), "can be listened on", bindPort(send)))
+	}
+	db := config.Database
+	switch db.Type {
+	case "SQLite":
+		checks = append(checks, setupCheck("SQLite file "+db.SQLiteFilepath, "can be written", writableFile(db.SQLiteFilepath)))
+	case "PartitionedSQLite":
+		checks = append(checks, setupCheck("Partition directory "+db.PartitionDir, "can be written", writableDir(db.PartitionDir)))
+	case "Segment":
+		checks = append(checks, setupCheck("Segment directory "+db.SegmentDir, "can be written", writableDir(db.SegmentDir)))
+	case "PostgreSQL":
+		checks = append(checks, setupCheck("PostgreSQL server", "answers", pingPostgres(db.PostgresURL)))
+	case "Memory":
+		checks = append(checks, setupCheck("Memory", "nothing is written to disk", nil))
+	}
+	return checks
+}
+
+func setupCheck(name, passed string, err error) interfaces.SetupCheck {
+	if err != nil {
+		return interfaces.SetupCheck{Name: name, Detail: err.Error()}
+	}
+	return interfaces.SetupCheck{Name: name, Detail: passed, OK: true}
+}
+
+// bindPort checks that the ingestor can listen on its port by briefly listening on it
+func bindPort(send confighandler.Send) error {
+	address := fmt.Sprintf(":%d", send.Port)
+	if send.Protocol == "HTTP" {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		return listener.Close()
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// writableDir checks that files can be created in dir, or in the closest parent that exists
+// since the storage creates the missing directories. Nothing is left behind.
+func writableDir(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if !os.IsNotExist(err) || parent == existing {
+			return err
+		}
+		existing = parent
+	}
+	file, err := os.CreateTemp(existing, ".loglite-check-*")
+	if err != nil {
+		return fmt.Errorf("cannot write to %s: %w", existing, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// writableFile checks that a file can be opened for writing, or created when it does not exist
+func writableFile(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return writableDir(filepath.Dir(path))
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// pingPostgres connects to the PostgreSQL server once, waiting at most 5 seconds
+func pingPostgres(url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return fmt.Errorf("could not connect: %w", err)
+	}
+	defer conn.Close(ctx)
+	return conn.Ping(ctx)
+}
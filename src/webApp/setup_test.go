@@ -0,0 +1,199 @@
+package webapp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
+)
+
+func TestSetupStepErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		step   int
+		want   []string // Settings the step reports a problem with
+	}{
+		{"defaults", map[string]string{}, 0, nil},
+		{"port", map[string]string{"log_handler.send.port": "high"}, 0, []string{"log_handler.send.port"}},
+		{"scraping", map[string]string{"log_handler.mode": "scrape"}, 0, []string{"log_handler.mode"}},
+		{"later steps wait", map[string]string{"log_level": "LOUD"}, 0, nil},
+		{"chosen database", map[string]string{"database.type": "Memory", "database.memory_capacity": "some"}, 1, []string{"database.memory_capacity"}},
+		{"other databases", map[string]string{"database.type": "SQLite", "database.memory_capacity": "some"}, 1, nil},
+		{"logging", map[string]string{"log_level": "LOUD"}, 2, []string{"log_level"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := newSetupForm()
+			for key, value := range tt.values {
+				form.Values[key] = value
+			}
+			_, errs := setupConfig(form.Values)
+			var got []string
+			for _, fe := range stepErrors(form, errs, tt.step) {
+				got = append(got, fe.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("step %d reports %v, want %v", tt.step, got, tt.want)
+			}
+		})
+	}
+}
+
+// freePort returns a port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestSetupChecks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+	}{
+		{"missing directories", writableDir(filepath.Join(dir, "a", "b")), true},
+		{"file as directory", writableDir(filepath.Join(file, "a")), false},
+		{"new file", writableFile(filepath.Join(dir, "a", "logs.db")), true},
+		{"existing file", writableFile(file), true},
+		{"directory as file", writableFile(dir), false},
+		{"free port", bindPort(confighandler.Send{Protocol: "HTTP", Port: freePort(t)}), true},
+		{"taken port", bindPort(confighandler.Send{Protocol: "HTTP", Port: taken.Addr().(*net.TCPAddr).Port}), false},
+	}
+	for _, tt := range tests {
+		if (tt.err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, tt.err)
+		}
+	}
+	// Checking leaves nothing behind
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d entries left in the checked directory", len(entries))
+	}
+}
+
+// setupStep reads the step the wizard shows
+var setupStep = regexp.MustCompile(`name="step" value="(\d+)"`)
+
+type setupTest struct {
+	app     *WebApp
+	applied []*confighandler.Config
+}
+
+// newSetupTest runs a web app without a configuration, whose main thread applies every
+// configuration sent to it
+func newSetupTest(t *testing.T) *setupTest {
+	st := &setupTest{app: &WebApp{SettingsChan: make(chan ConfigMessage), ConfigPath: filepath.Join(t.TempDir(), "config.yaml")}}
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case msg := <-st.app.SettingsChan:
+				st.applied = append(st.applied, msg.NewConfig)
+				msg.ResponseCh <- "Configuration applied successfully"
+			case <-done:
+				return
+			}
+		}
+	}()
+	return st
+}
+
+// post sends the wizard's form and returns the step it shows, -1 once it is done, and the page
+func (st *setupTest) post(t *testing.T, values url.Values) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/setup", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	st.app.setupHandler(rec, req)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, body)
+	}
+	match := setupStep.FindStringSubmatch(body)
+	if match == nil {
+		return -1, body
+	}
+	step, _ := strconv.Atoi(match[1])
+	return step, body
+}
+
+func TestSetupHandler(t *testing.T) {
+	st := newSetupTest(t)
+	answers := url.Values{
+		"log_handler.mode":          {"send"},
+		"log_handler.send.protocol": {"HTTP"},
+		"log_handler.send.port":     {"high"},
+		"database.type":             {"Memory"},
+		"database.memory_capacity":  {"1000"},
+		"log_level":                 {"ERROR"},
+		"log_file":                  {"stdout"},
+	}
+	with := func(step int, action string) url.Values {
+		values := url.Values{"step": {strconv.Itoa(step)}, "action": {action}}
+		for key, value := range answers {
+			values[key] = value
+		}
+		return values
+	}
+
+	// An invalid answer keeps the wizard on its step and says why
+	if step, body := st.post(t, with(0, "next")); step != 0 || !strings.Contains(body, "must be a whole number") {
+		t.Errorf("invalid port went to step %d", step)
+	}
+	answers.Set("log_handler.send.port", strconv.Itoa(freePort(t)))
+	for step := 0; step < len(interfaces.SetupSteps)-1; step++ {
+		if got, _ := st.post(t, with(step, "next")); got != step+1 {
+			t.Fatalf("next from step %d went to %d", step, got)
+		}
+	}
+	if step, _ := st.post(t, with(2, "back")); step != 1 {
+		t.Errorf("back from step 2 went to %d", step)
+	}
+
+	// Applying checks every step again, a changed hidden answer goes back to its step
+	answers.Set("log_level", "LOUD")
+	if step, _ := st.post(t, with(3, "apply")); step != 2 || len(st.applied) != 0 {
+		t.Errorf("invalid hidden answer went to step %d and applied %d configurations", step, len(st.applied))
+	}
+	if _, err := os.Stat(st.app.ConfigPath); !os.IsNotExist(err) {
+		t.Errorf("an invalid configuration was saved: %v", err)
+	}
+
+	answers.Set("log_level", "ERROR")
+	if step, body := st.post(t, with(3, "apply")); step != -1 || !strings.Contains(body, "Saved to "+st.app.ConfigPath) {
+		t.Errorf("applying: %s", body)
+	}
+	if len(st.applied) != 1 || st.applied[0].Database.Type != "Memory" || st.applied[0].LogLevel != "ERROR" {
+		t.Fatalf("applied %v", st.applied)
+	}
+	saved, err := confighandler.LoadConfig(st.app.ConfigPath)
+	if err != nil || saved.Database.MemoryCapacity != 1000 {
+		t.Errorf("saved %+v, error %v", saved, err)
+	}
+}
@@ -0,0 +1,107 @@
+package webapp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
+	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+)
+
+// State is what the web app serves from. Applying a configuration replaces it as a whole, so a
+// request sees either the old or the new configuration and database, never a mix.
+type State struct {
+	Configuration *confighandler.Config
+	DBHandler     dbhandler.DBHandler
+	Retention     *dbhandler.RetentionManager
+	Backups       *dbhandler.BackupManager
+}
+
+// served is a State and the requests using it
+type served struct {
+	State
+	users  sync.WaitGroup
+	ctx    context.Context // Cancelled when a newer State replaces this one
+	retire context.CancelFunc
+}
+
+func newServed(state State) *served {
+	if state.Configuration == nil {
+		state.Configuration = &confighandler.Config{}
+	}
+	s := &served{State: state}
+	s.ctx, s.retire = context.WithCancel(context.Background())
+	return s
+}
+
+// State returns what the web app currently serves from. Only use its database through Acquire,
+// the database may be closed once it is replaced.
+func (app *WebApp) State() State {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.state == nil {
+		app.state = newServed(State{})
+	}
+	return app.state.State
+}
+
+// Acquire returns the current State and keeps its database open until release is called
+func (app *WebApp) Acquire() (state State, release func()) {
+	s := app.acquire()
+	return s.State, s.users.Done
+}
+
+func (app *WebApp) acquire() *served {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.state == nil {
+		app.state = newServed(State{})
+	}
+	app.state.users.Add(1)
+	return app.state
+}
+
+// SetState makes the web app serve from state. Live tables and tail streams on the previous
+// state are ended, their clients reconnect and continue with the new one. It returns the
+// previous state once no request uses it anymore, or after timeout with drained false while
+// some still do.
+func (app *WebApp) SetState(state State, timeout time.Duration) (previous State, drained bool) {
+	app.mu.Lock()
+	old := app.state
+	app.state = newServed(state)
+	app.mu.Unlock()
+
+	if old == nil {
+		return State{}, true
+	}
+	old.retire()
+
+	idle := make(chan struct{})
+	go func() {
+		old.users.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return old.State, true
+	case <-time.After(timeout):
+		return old.State, false
+	}
+}
+
+// withState runs a request with the current State, ending its context when the State is
+// replaced so streams do not keep using a database that is about to close
+func (app *WebApp) withState(handler func(http.ResponseWriter, *http.Request, State)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := app.acquire()
+		defer s.users.Done()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(s.ctx, cancel)
+		defer stop()
+		handler(w, r.WithContext(ctx), s.State)
+	}
+}
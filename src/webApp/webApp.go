@@ -1,23 +1,32 @@
 package webapp
 
 import (
+	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/a-h/templ"
 	confighandler "github.com/lauritsbonde/LogLite/src/configHandler"
 	dbhandler "github.com/lauritsbonde/LogLite/src/dbHandler"
+	livetail "github.com/lauritsbonde/LogLite/src/liveTail"
+	"github.com/lauritsbonde/LogLite/src/webApp/assets"
+	"github.com/lauritsbonde/LogLite/src/webApp/components"
 	"github.com/lauritsbonde/LogLite/src/webApp/handlers"
+	"github.com/lauritsbonde/LogLite/src/webApp/interfaces"
 	"github.com/lauritsbonde/LogLite/src/webApp/views"
 )
 
 type WebApp struct {
-	DBHandler dbhandler.DBHandler
+	Tail      *livetail.Broadcaster // Pushes every stored log to the live table
 	
 	SettingsChan chan ConfigMessage
-	Configuration *confighandler.Config
+	ConfigPath    string // File the settings page saves the configuration to
+
+	mu    sync.Mutex
+	state *served // Configuration and database, see SetState
 }
 
 type ConfigMessage struct {
@@ -28,116 +37,63 @@ type ConfigMessage struct {
 
 func (app *WebApp) indexHandler(w http.ResponseWriter, r *http.Request) {
 	// Render logs with templ.Handler - if ther version is empty, then there is no config
-	templ.Handler(views.Index(app.Configuration.Version == "")).ServeHTTP(w, r)
+	templ.Handler(views.Index(app.State().Configuration.Version == "")).ServeHTTP(w, r)
 }
 
-func (app *WebApp) settingsHandler(w http.ResponseWriter, r *http.Request) {
+func (app *WebApp) settingsHandler(w http.ResponseWriter, r *http.Request, state State) {
 	// Render logs with templ.Handler - if ther version is empty, then there is no config
-	templ.Handler(views.Settings()).ServeHTTP(w, r)
-}
-
-func (app *WebApp) setupHandler(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if state.Configuration.Version == "" {
+		templ.Handler(views.Setup(newSetupForm())).ServeHTTP(w, r)
 		return
 	}
+	settings := interfaces.SettingsForm{Values: settingsValues(*state.Configuration)}
+	templ.Handler(views.Settings(storageStatus(state), backupStatus(state), settings)).ServeHTTP(w, r)
+}
 
-	// Parse form values
-	collectType := r.FormValue("collect-type") // send || scrape
-	var sendConfig confighandler.Send
-	var scrapeConfig confighandler.Scrape
-
-	switch collectType {
-	case "send":
-		protocol := r.FormValue("endpoint-type") // http || udp
-		port := r.FormValue("ingest-port")
-		portParsed, err := strconv.Atoi(port)
-		if err != nil {
-			log.Printf("Error parsing port: %v\n", err) // Log the error but continue execution
-		} 
-		sendConfig = confighandler.Send{
-			Protocol: protocol,
-			Port: portParsed,
-		}
-	case "scrape":
-		scrapeType := r.FormValue("scrape-type") // pure_docker || docker_swarm || kubernetes
-		scrapeConfig = confighandler.Scrape{
-			Type: scrapeType,
-		}
-	default:
-		http.Error(w, "Invalid collect-type value. Must be 'send' or 'scrape'.", http.StatusBadRequest)
-		return
+// searchHandler renders the search page. Requests from the page itself only get the results,
+// unless htmx restores a page from the history.
+func (app *WebApp) searchHandler(w http.ResponseWriter, r *http.Request, state State) {
+	var res interfaces.SearchResults
+	if state.DBHandler == nil {
+		res = interfaces.SearchResults{Form: handlers.ParseSearchForm(r.URL.Query()), Page: 1, Error: "No database configured. Please configure the database."}
+	} else {
+		res = handlers.RunSearch(state.DBHandler, r.URL.Query(), time.Now())
 	}
-
-	db := r.FormValue("database-type") // Required
-	file := r.FormValue("sqlite-path")
-	if db == "SQLite" && file == "" {
-		http.Error(w, "sqlite-path is required when database-type is SQLite", http.StatusBadRequest)
+	if r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-History-Restore-Request") != "true" {
+		templ.Handler(components.SearchResults(res)).ServeHTTP(w, r)
 		return
 	}
+	templ.Handler(views.Search(res)).ServeHTTP(w, r)
+}
 
-	logLevel := r.FormValue("log-level")
-	logFile := r.FormValue("logfile-path")
-
-	// Create a new config object
-	newConfig := confighandler.Config{
-		Version:        "1.0.0",
-		LogLevel:       logLevel,
-		LogFile:        logFile,
-		MaxConnections: 100, // Example default value
-		LogHandler: confighandler.LogHandler{
-			Mode:   collectType,
-			Send:   sendConfig,
-			Scrape: scrapeConfig,
-		},
-		Database: confighandler.Database{
-			Type:           db,
-			SQLiteFilepath: file,
-		},
+// storageStatus collects the database usage and last prune result for the settings page
+func storageStatus(state State) interfaces.StorageStatus {
+	if state.Retention == nil {
+		return interfaces.StorageStatus{}
 	}
-
-	err = confighandler.ValidateConfig(newConfig)
-	if err != nil {
-		log.Printf("error validating new config %v \n", err)
-		return
+	stats, err := state.Retention.Stats()
+	return interfaces.StorageStatus{
+		Supported: true,
+		Stats:     stats,
+		StatsErr:  err,
+		Policy:    state.Retention.Policy(),
+		LastPrune: state.Retention.LastResult(),
 	}
+}
 
-	// save the config to a file
-	err = confighandler.SaveConfig(newConfig, "./etc/config.yaml")
-	if err != nil {
-		log.Printf("error saving new config %v \n", err)
-		return
-	}
-
-
-	// Send newConfig to the main thread
-	responseCh := make(chan string)
-	app.SettingsChan <- ConfigMessage{
-		NewConfig:  &newConfig,
-		ResponseCh: responseCh,
+// backupStatus lists the snapshots and the last backup result for the settings page
+func backupStatus(state State) interfaces.BackupStatus {
+	if state.Backups == nil {
+		return interfaces.BackupStatus{}
 	}
-
-	// Wait for the response
-	response := <-responseCh
-	if strings.HasPrefix(response, "Error") {
-		http.Error(w, response, http.StatusInternalServerError)
-		return
+	files, err := state.Backups.List()
+	return interfaces.BackupStatus{
+		Supported:  true,
+		Interval:   state.Backups.Interval(),
+		Files:      files,
+		FilesErr:   err,
+		LastBackup: state.Backups.LastResult(),
 	}
-
-	// Respond with success
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Configuration setup successfully: " + response))
-}
-
-func assetHandler(w http.ResponseWriter, r *http.Request) {
-		filepath := strings.TrimPrefix(r.URL.Path, "/asset/")
-
-		assetsDir := "./src/webApp/assets/"
-
-		fullPath := assetsDir + filepath
-
-		http.ServeFile(w, r, fullPath)
 }
 
 func middleware(next http.Handler) http.Handler {
@@ -156,27 +112,69 @@ func middlewareFunc(next http.HandlerFunc) http.Handler {
 	return middleware(http.HandlerFunc(next))
 }
 
+// withDB hands the current DBHandler to a handler, failing while no database is configured
+func (app *WebApp) withDB(handler func(http.ResponseWriter, *http.Request, dbhandler.DBHandler)) http.HandlerFunc {
+	return app.withState(func(w http.ResponseWriter, r *http.Request, state State) {
+		if state.DBHandler == nil {
+			http.Error(w, "No database configured. Please configure the database.", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r, state.DBHandler)
+	})
+}
+
+// withAssets serves a page only when the scripts and stylesheet it loads are bundled. Without
+// them the page would load but do nothing, so it names the missing files instead.
+func withAssets(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if missing := assets.Missing(); len(missing) > 0 {
+			msg := fmt.Sprintf("The web UI assets %s are not bundled into this build. Run go generate ./src/webApp/assets and rebuild LogLite.", strings.Join(missing, ", "))
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 // Modify RunWebApp to apply middleware
 func (app *WebApp) RunWebApp() error {
+	if missing := assets.Missing(); len(missing) > 0 {
+		log.Printf("WARNING: web UI assets %s are not bundled, pages are unavailable until go generate ./src/webApp/assets is run and LogLite rebuilt. Ingestion and the API keep working.\n", strings.Join(missing, ", "))
+	}
+
 	// Wrap routes with middleware
-	http.Handle("/{$}", middleware(http.HandlerFunc(app.indexHandler)))
-	http.Handle("/asset/", middlewareFunc(assetHandler))
-	http.Handle("/ingest-options", middlewareFunc(handlers.CollectType))
-	http.Handle("/db-options", middlewareFunc(handlers.DBType))
-	http.Handle("/setup", middleware(http.HandlerFunc(app.setupHandler)))
-	http.Handle("/settings", middlewareFunc(http.HandlerFunc(app.settingsHandler)))
+	http.Handle("/{$}", middleware(withAssets(app.indexHandler)))
+	http.Handle(assets.Prefix, middlewareFunc(assets.Serve))
+	http.Handle("POST /setup", middlewareFunc(app.setupHandler))
+	http.Handle("/settings", middlewareFunc(withAssets(app.withState(app.settingsHandler))))
+	http.Handle("POST /settings/preview", middlewareFunc(app.settingsPreviewHandler))
+	http.Handle("POST /settings/apply", middlewareFunc(app.settingsApplyHandler))
+	http.Handle("GET /search", middlewareFunc(withAssets(app.withState(app.searchHandler))))
+	http.Handle("GET /search/histogram", middlewareFunc(app.withDB(handlers.SearchHistogram)))
 
 	// Register the "/livelogs" route
-	http.HandleFunc("GET /livelogs", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("GET /livelogs", app.withDB(func(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
 		log.Print("livelogs")
-		if app.DBHandler == nil {
-			log.Print("No database configured")
-			http.Error(w, "No database configured. Please configure the database.", http.StatusServiceUnavailable)
-			return
-		}
-
-		handlers.LiveLogs(w, r, app.DBHandler)
-	})
+		handlers.LiveLogs(w, r, db, app.Tail)
+	}))
+
+	// Query language endpoints, HTML for the search bar and JSON for the API
+	http.Handle("GET /query", middlewareFunc(app.withDB(handlers.QueryLogs)))
+	http.Handle("GET /api/query", middlewareFunc(app.withDB(handlers.QueryAPI)))
+	http.Handle("GET /api/aggregate", middlewareFunc(app.withDB(handlers.AggregateAPI)))
+
+	// The detail panel of a log, live rows that do not know their id yet look it up by timestamp
+	http.Handle("GET /logs/{id}", middlewareFunc(app.withDB(handlers.LogDetail)))
+	http.Handle("GET /logs/at", middlewareFunc(app.withDB(handlers.LogDetailAt)))
+	http.Handle("GET /logs/{id}/context", middlewareFunc(app.withDB(handlers.LogContext)))
+
+	// Logs streamed as they are stored, as Server-Sent Events or NDJSON for scripts and terminals
+	http.Handle("GET /api/tail", middlewareFunc(app.withDB(func(w http.ResponseWriter, r *http.Request, db dbhandler.DBHandler) {
+		handlers.TailAPI(w, r, db, app.Tail)
+	})))
+
+	// A snapshot of the database taken on request, for the download button on the settings page
+	http.Handle("GET /backup/download", middlewareFunc(app.withDB(handlers.DownloadBackup)))
 
 	// Start the HTTP server
 	server := &http.Server{